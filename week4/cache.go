@@ -0,0 +1,153 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// CacheOptions - ограничения кэша ответов SearchClient
+type CacheOptions struct {
+	// сколько ответ считается свежим и отдаётся без похода во внешнюю систему.
+	// протухший ответ с ETag перепроверяется через If-None-Match
+	TTL time.Duration
+	// максимум ответов в кэше, самые давно использованные вытесняются. 0 - без ограничения
+	MaxEntries int
+}
+
+// CacheStats - счётчики работы кэша
+type CacheStats struct {
+	// ответы из кэша: свежие и подтверждённые сервером через 304
+	Hits int64
+	// ответы, которые пришлось скачать целиком
+	Misses int64
+	// условные запросы с If-None-Match
+	Revalidations int64
+}
+
+type cacheEntry struct {
+	key        string
+	body       []byte
+	nextCursor string
+	etag       string
+	expires    time.Time
+}
+
+// ResponseCache - LRU-кэш ответов FindUsers, ключ - адрес внешней системы, токен и нормализованный SearchRequest
+type ResponseCache struct {
+	opts CacheOptions
+
+	mu    sync.Mutex
+	lru   *list.List
+	items map[string]*list.Element
+
+	hits          int64
+	misses        int64
+	revalidations int64
+}
+
+func NewResponseCache(opts CacheOptions) *ResponseCache {
+	return &ResponseCache{
+		opts:  opts,
+		lru:   list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// Stats возвращает текущие значения счётчиков
+func (c *ResponseCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadInt64(&c.hits),
+		Misses:        atomic.LoadInt64(&c.misses),
+		Revalidations: atomic.LoadInt64(&c.revalidations),
+	}
+}
+
+// Purge очищает кэш, счётчики остаются
+func (c *ResponseCache) Purge() {
+	c.mu.Lock()
+	c.lru.Init()
+	c.items = make(map[string]*list.Element)
+	c.mu.Unlock()
+}
+
+// lookup ищет ответ по ключу. fresh - ответ можно отдавать как есть,
+// иначе непустой entry надо перепроверить по его ETag
+func (c *ResponseCache) lookup(key string) (entry *cacheEntry, fresh bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := *el.Value.(*cacheEntry)
+	if time.Now().Before(e.expires) {
+		c.lru.MoveToFront(el)
+		atomic.AddInt64(&c.hits, 1)
+		return &e, true
+	}
+	if e.etag == "" {
+		c.remove(el)
+		return nil, false
+	}
+	atomic.AddInt64(&c.revalidations, 1)
+	return &e, false
+}
+
+// refresh продлевает ответ, который сервер подтвердил через 304
+func (c *ResponseCache) refresh(key string) {
+	atomic.AddInt64(&c.hits, 1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheEntry).expires = time.Now().Add(c.opts.TTL)
+		c.lru.MoveToFront(el)
+	}
+}
+
+// store кладёт свежескачанный ответ. без TTL и ETag хранить его смысла нет
+func (c *ResponseCache) store(key string, body []byte, etag, nextCursor string) {
+	atomic.AddInt64(&c.misses, 1)
+	if c.opts.TTL <= 0 && etag == "" {
+		return
+	}
+
+	entry := &cacheEntry{
+		key:        key,
+		body:       body,
+		nextCursor: nextCursor,
+		etag:       etag,
+		expires:    time.Now().Add(c.opts.TTL),
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = entry
+		c.lru.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.lru.PushFront(entry)
+	for c.opts.MaxEntries > 0 && c.lru.Len() > c.opts.MaxEntries {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *ResponseCache) remove(el *list.Element) {
+	c.lru.Remove(el)
+	delete(c.items, el.Value.(*cacheEntry).key)
+}
+
+// cacheKey - ключ ответа в кэше. в нём адрес и токен: один кэш могут делить клиенты разных систем,
+// а с чужим или неверным токеном ответ из кэша не получить. токен хранится только хэшем.
+// параметры берутся ровно те, что уходят на сервер: Encode сортирует их по имени
+func cacheKey(searcherURL, token string, params url.Values) string {
+	sum := sha256.Sum256([]byte(token))
+	return searcherURL + " " + hex.EncodeToString(sum[:]) + " " + params.Encode()
+}
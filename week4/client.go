@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -28,11 +30,15 @@ type User struct {
 	Age    int
 	About  string
 	Gender string
+	// релевантность запросу, приходит только при SearchRequest.Rank
+	Score float64 `json:",omitempty"`
 }
 
 type SearchResponse struct {
 	Users    []User
 	NextPage bool
+	// курсор на следующую страницу, только если запрос был с Cursor
+	NextCursor string
 }
 
 type SearchErrorResponse struct {
@@ -45,15 +51,34 @@ const (
 	OrderByDesc = 1
 
 	ErrorBadOrderField = `OrderField invalid`
+
+	// CursorFirst - значение Cursor для первой страницы при постраничке через курсоры
+	CursorFirst = "*"
 )
 
 type SearchRequest struct {
-	Limit      int
-	Offset     int    // Можно учесть после сортировки
-	Query      string // подстрока в 1 из полей
+	Limit  int
+	Offset int // Можно учесть после сортировки
+	// подстрока в 1 из полей. можно искать по конкретному полю: `boris name:wolf about:"lorem ipsum"`
+	Query      string
 	OrderField string
 	// -1 по убыванию, 0 как встретилось, 1 по возрастанию
 	OrderBy int
+	// сортировка по нескольким полям, например {{"Age", -1}, {"Name", 1}}.
+	// если задана - OrderField и OrderBy не используются
+	Order []SearchOrder
+	// упорядочить по релевантности словам из Query, Order решает только при равной релевантности
+	Rank bool
+	// постраничка через курсоры вместо Offset: CursorFirst для первой страницы,
+	// дальше - NextCursor из предыдущего ответа
+	Cursor string
+}
+
+// SearchOrder - одно поле в сортировке по нескольким полям
+type SearchOrder struct {
+	Field string
+	// -1 по убыванию, 1 по возрастанию
+	By int
 }
 
 type SearchClient struct {
@@ -61,10 +86,17 @@ type SearchClient struct {
 	AccessToken string
 	// урл внешней системы, куда идти
 	URL string
+	// кэш ответов, nil - ходим во внешнюю систему каждый раз
+	Cache *ResponseCache
 }
 
 // FindUsers отправляет запрос во внешнюю систему, которая непосредственно ищет пользоваталей
 func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
+	return srv.FindUsersContext(context.Background(), req)
+}
+
+// FindUsersContext - то же что FindUsers, но запрос прерывается при отмене ctx
+func (srv *SearchClient) FindUsersContext(ctx context.Context, req SearchRequest) (*SearchResponse, error) {
 
 	searcherParams := url.Values{}
 
@@ -77,21 +109,60 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	if req.Offset < 0 {
 		return nil, fmt.Errorf("offset must be > 0")
 	}
-
-	//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
-	req.Limit++
+	if req.Cursor != "" {
+		if req.Offset > 0 {
+			return nil, fmt.Errorf("offset cant be used with cursor")
+		}
+		if req.Limit == 0 {
+			return nil, fmt.Errorf("limit must be > 0")
+		}
+	} else {
+		//нужно для получения следующей записи, на основе которой мы скажем - можно показать переключатель следующей страницы или нет
+		//с курсором сервер сам говорит, есть ли следующая страница
+		req.Limit++
+	}
 
 	searcherParams.Add("limit", strconv.Itoa(req.Limit))
 	searcherParams.Add("offset", strconv.Itoa(req.Offset))
 	searcherParams.Add("query", req.Query)
 	searcherParams.Add("order_field", req.OrderField)
 	searcherParams.Add("order_by", strconv.Itoa(req.OrderBy))
+	if len(req.Order) > 0 {
+		searcherParams.Add("order", encodeOrder(req.Order))
+	}
+	if req.Rank {
+		searcherParams.Add("rank", "1")
+	}
+	if req.Cursor != "" {
+		searcherParams.Add("cursor", req.Cursor)
+	}
+
+	var cached *cacheEntry
+	key := ""
+	if srv.Cache != nil {
+		key = cacheKey(srv.URL, srv.AccessToken, searcherParams)
+		entry, fresh := srv.Cache.lookup(key)
+		if fresh {
+			return parseUsers(req, entry.body, entry.nextCursor)
+		}
+		cached = entry
+	}
 
 	searcherReq, err := http.NewRequest("GET", srv.URL+"?"+searcherParams.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %s", err)
+	}
+	searcherReq = searcherReq.WithContext(ctx)
 	searcherReq.Header.Add("AccessToken", srv.AccessToken)
+	if cached != nil {
+		searcherReq.Header.Set("If-None-Match", cached.etag)
+	}
 
 	resp, err := client.Do(searcherReq)
 	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		if err, ok := err.(net.Error); ok && err.Timeout() {
 			return nil, fmt.Errorf("timeout for %s", searcherParams.Encode())
 		}
@@ -101,6 +172,12 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 	body, err := ioutil.ReadAll(resp.Body)
 
 	switch resp.StatusCode {
+	case http.StatusNotModified:
+		if cached == nil {
+			return nil, fmt.Errorf("unexpected not modified response")
+		}
+		srv.Cache.refresh(key)
+		return parseUsers(req, cached.body, cached.nextCursor)
 	case http.StatusUnauthorized:
 		return nil, fmt.Errorf("Bad AccessToken")
 	case http.StatusInternalServerError:
@@ -112,19 +189,39 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 			return nil, fmt.Errorf("cant unpack error json: %s", err)
 		}
 		if errResp.Error == "ErrorBadOrderField" {
+			if len(req.Order) > 0 {
+				return nil, fmt.Errorf("Order %s invalid", encodeOrder(req.Order))
+			}
 			return nil, fmt.Errorf("OrderFeld %s invalid", req.OrderField)
 		}
+		if errResp.Error == "ErrorBadCursor" {
+			return nil, fmt.Errorf("Cursor %s invalid", req.Cursor)
+		}
 		return nil, fmt.Errorf("unknown bad request error: %s", errResp.Error)
 	}
 
+	nextCursor := resp.Header.Get("X-Next-Cursor")
+	result, err := parseUsers(req, body, nextCursor)
+	if err == nil && srv.Cache != nil {
+		srv.Cache.store(key, body, resp.Header.Get("ETag"), nextCursor)
+	}
+	return result, err
+}
+
+// parseUsers разбирает тело ответа внешней системы. req - уже с увеличенным Limit
+func parseUsers(req SearchRequest, body []byte, nextCursor string) (*SearchResponse, error) {
 	data := []User{}
-	err = json.Unmarshal(body, &data)
+	err := json.Unmarshal(body, &data)
 	if err != nil {
 		return nil, fmt.Errorf("cant unpack result json: %s", err)
 	}
 
 	result := SearchResponse{}
-	if len(data) == req.Limit {
+	if req.Cursor != "" {
+		result.Users = data
+		result.NextCursor = nextCursor
+		result.NextPage = result.NextCursor != ""
+	} else if len(data) == req.Limit {
 		result.NextPage = true
 		result.Users = data[0 : len(data)-1]
 	} else {
@@ -133,3 +230,12 @@ func (srv *SearchClient) FindUsers(req SearchRequest) (*SearchResponse, error) {
 
 	return &result, err
 }
+
+// encodeOrder превращает сортировку в параметр order: "Age:-1,Name:1"
+func encodeOrder(order []SearchOrder) string {
+	parts := make([]string, 0, len(order))
+	for _, o := range order {
+		parts = append(parts, o.Field+":"+strconv.Itoa(o.By))
+	}
+	return strings.Join(parts, ",")
+}
@@ -1,38 +1,21 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"encoding/xml"
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
-	"os"
-	"sort"
-	"strconv"
 	"strings"
 	"testing"
 	"time"
+
+	"week4/searchserver"
 )
 
 const filePath string = "dataset.xml"
 const AccessToken = "1234567asdfg"
 
-type UserXML struct {
-	XMLName   xml.Name `xml:"row" json:"-"`
-	Id        int      `xml:"id" json:"id"`
-	FirstName string   `xml:"first_name" json:"first_name"`
-	LastName  string   `xml:"last_name" json:"last_name"`
-	Age       int      `xml:"age" json:"age"`
-	About     string   `xml:"about" json:"about"`
-	Gender    string   `xml:"gender" json:"gender"`
-}
-
-type Users struct {
-	XMLName xml.Name  `xml:"root"`
-	Users   []UserXML `xml:"row"`
-}
-
 func sendError(w http.ResponseWriter, error string, code int) {
 	js, err := json.Marshal(SearchErrorResponse{error})
 	if err != nil {
@@ -45,140 +28,6 @@ func sendError(w http.ResponseWriter, error string, code int) {
 	fmt.Fprintln(w, string(js))
 }
 
-func MustAtoi(s string) int {
-	res, err := strconv.Atoi(s)
-	if err != nil {
-		panic(err)
-	}
-	return res
-}
-
-func handler(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("AccessToken") != AccessToken {
-		http.Error(w, "Invalid access token", http.StatusUnauthorized)
-		return
-	}
-
-	q := r.URL.Query()
-	sr := SearchRequest{
-		Limit:      MustAtoi(q["limit"][0]),
-		Offset:     MustAtoi(q["offset"][0]),
-		Query:      strings.ToLower(q["query"][0]),
-		OrderField: q["order_field"][0],
-		OrderBy:    MustAtoi(q["order_by"][0]),
-	}
-	fmt.Printf("%+v\n", sr)
-
-	xmlFile, err := os.Open(filePath)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer xmlFile.Close()
-	data, err := ioutil.ReadAll(xmlFile)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	var users Users
-	result := []User{}
-
-	err = xml.Unmarshal(data, &users)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	// fmt.Println(users)
-	for i := 0; i < len(users.Users); i++ {
-		if sr.Query != "" {
-			str := strings.ToLower(users.Users[i].FirstName + " " + users.Users[i].LastName + " " + users.Users[i].About + " " + users.Users[i].Gender)
-			if strings.Contains(str, sr.Query) {
-				result = append(result, User{
-					Id:     users.Users[i].Id,
-					Name:   users.Users[i].FirstName + " " + users.Users[i].LastName,
-					Age:    users.Users[i].Age,
-					About:  users.Users[i].About,
-					Gender: users.Users[i].Gender,
-				})
-			}
-
-		} else {
-			// fmt.Println("here", i)
-			result = append(result, User{
-				Id:     users.Users[i].Id,
-				Name:   users.Users[i].FirstName + " " + users.Users[i].LastName,
-				Age:    users.Users[i].Age,
-				About:  users.Users[i].About,
-				Gender: users.Users[i].Gender,
-			})
-		}
-	}
-	if !(sr.OrderBy == -1 || sr.OrderBy == 0 || sr.OrderBy == 1) {
-		sendError(w, "ErrorBadOrderField", http.StatusBadRequest)
-		return
-	}
-	if !(sr.OrderField == "" || sr.OrderField == "Name" || sr.OrderField == "Age" || sr.OrderField == "Id") {
-		sendError(w, "ErrorBadOrderField", http.StatusBadRequest)
-		return
-	}
-	if sr.OrderBy != 0 {
-		if sr.OrderField == "Id" {
-			sort.Slice(result[:], func(i, j int) bool {
-				if sr.OrderBy == -1 {
-					return result[i].Id > result[j].Id
-				}
-				return result[i].Id < result[j].Id
-
-			})
-		} else if sr.OrderField == "Age" {
-			sort.Slice(result[:], func(i, j int) bool {
-				if sr.OrderBy == -1 {
-					return result[i].Age > result[j].Age
-				}
-				return result[i].Age < result[j].Age
-			})
-		} else {
-			sort.Slice(result[:], func(i, j int) bool {
-				if sr.OrderBy == -1 {
-					return result[i].Name[0] < result[j].Name[0]
-				}
-				return result[i].Name[0] < result[j].Name[0]
-			})
-		}
-	}
-	if sr.Offset > len(result) {
-		result = []User{}
-	} else {
-		if sr.Offset+sr.Limit > len(result) {
-			result = result[sr.Offset:]
-		} else {
-			result = result[sr.Offset : sr.Offset+sr.Limit]
-		}
-	}
-
-	// for i := 0; i < len(result); i++ {
-	// 	fmt.Println("User Id: ", result[i].Id)
-	// 	fmt.Println("User Name: " + result[i].Name)
-	// 	fmt.Println("User Age: ", result[i].Age)
-	// 	fmt.Print("User About: " + result[i].About)
-	// 	fmt.Println("User Gender: " + result[i].Gender)
-	// }
-	// fmt.Println(len(result))
-
-	// // header := r.Header
-	// fmt.Println(reflect.TypeOf(q))
-	// fmt.Println(q)
-	b, err := json.Marshal(result)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	w.Header().Set("Content-Type", "application/json")
-	// w.Write(b)
-	fmt.Fprintln(w, string(b))
-
-}
-
 type TestCase struct {
 	Request *SearchRequest
 	Result  *SearchResponse
@@ -194,9 +43,17 @@ func (ts *TestServer) Close() {
 	ts.server.Close()
 }
 
+func newSearchServer() *searchserver.Server {
+	srv, err := searchserver.NewServer(filePath, AccessToken)
+	if err != nil {
+		panic(err)
+	}
+	return srv
+}
+
 func newTestServer(token string) TestServer {
-	server := httptest.NewServer(http.HandlerFunc(handler))
-	client := SearchClient{token, server.URL}
+	server := httptest.NewServer(newSearchServer())
+	client := SearchClient{AccessToken: token, URL: server.URL}
 
 	return TestServer{server, client}
 }
@@ -332,7 +189,7 @@ func TestFatalError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Fatal Error", http.StatusInternalServerError)
 	}))
-	client := SearchClient{AccessToken, server.URL}
+	client := SearchClient{AccessToken: AccessToken, URL: server.URL}
 	defer server.Close()
 
 	_, err := client.FindUsers(SearchRequest{})
@@ -348,7 +205,7 @@ func TestCantUnpackError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Some Error", http.StatusBadRequest)
 	}))
-	client := SearchClient{AccessToken, server.URL}
+	client := SearchClient{AccessToken: AccessToken, URL: server.URL}
 	defer server.Close()
 
 	_, err := client.FindUsers(SearchRequest{})
@@ -364,7 +221,7 @@ func TestUnknownBadRequestError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sendError(w, "Unknown Error", http.StatusBadRequest)
 	}))
-	client := SearchClient{AccessToken, server.URL}
+	client := SearchClient{AccessToken: AccessToken, URL: server.URL}
 	defer server.Close()
 
 	_, err := client.FindUsers(SearchRequest{})
@@ -380,7 +237,7 @@ func TestCantUnpackResultError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		fmt.Fprintln(w, "None")
 	}))
-	client := SearchClient{AccessToken, server.URL}
+	client := SearchClient{AccessToken: AccessToken, URL: server.URL}
 	defer server.Close()
 
 	_, err := client.FindUsers(SearchRequest{})
@@ -396,7 +253,7 @@ func TestTimeout(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		time.Sleep(2 * time.Second)
 	}))
-	client := SearchClient{AccessToken, server.URL}
+	client := SearchClient{AccessToken: AccessToken, URL: server.URL}
 	defer server.Close()
 
 	_, err := client.FindUsers(SearchRequest{})
@@ -408,7 +265,7 @@ func TestTimeout(t *testing.T) {
 }
 
 func TestUnknownError(t *testing.T) {
-	client := SearchClient{AccessToken, "error"}
+	client := SearchClient{AccessToken: AccessToken, URL: "error"}
 
 	_, err := client.FindUsers(SearchRequest{})
 
@@ -418,3 +275,374 @@ func TestUnknownError(t *testing.T) {
 		t.Errorf("Invalid error: %v", err.Error())
 	}
 }
+
+func collectUsers(it *UserIterator) []User {
+	users := []User{}
+	for it.Next() {
+		users = append(users, it.User())
+	}
+	return users
+}
+
+func TestIteratorAllPages(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	it := ts.Search.Iterate(context.Background(), SearchRequest{}, IteratorOptions{PageSize: 10})
+	defer it.Close()
+	users := collectUsers(it)
+
+	if it.Err() != nil {
+		t.Errorf("Unexpected error: %v", it.Err())
+	}
+	if len(users) != 35 {
+		t.Errorf("Invalid number of users: %d", len(users))
+	}
+	for i, user := range users {
+		if user.Id != i {
+			t.Errorf("Invalid user at position %d: %v", i, user)
+			return
+		}
+	}
+}
+
+func TestIteratorMaxItems(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	for _, prefetch := range []bool{false, true} {
+		it := ts.Search.Iterate(context.Background(), SearchRequest{Offset: 2}, IteratorOptions{
+			PageSize: 4,
+			MaxItems: 7,
+			Prefetch: prefetch,
+		})
+		users := collectUsers(it)
+
+		if it.Err() != nil {
+			t.Errorf("Unexpected error: %v", it.Err())
+		}
+		if len(users) != 7 {
+			t.Errorf("Invalid number of users: %d", len(users))
+			continue
+		}
+		if users[0].Name != "Brooks Aguilar" || users[6].Id != 8 {
+			t.Errorf("Invalid users: %v", users)
+		}
+	}
+}
+
+func TestIteratorPrefetch(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	it := ts.Search.Iterate(context.Background(), SearchRequest{}, IteratorOptions{Prefetch: true})
+	defer it.Close()
+	users := collectUsers(it)
+
+	if it.Err() != nil {
+		t.Errorf("Unexpected error: %v", it.Err())
+	}
+	if len(users) != 35 {
+		t.Errorf("Invalid number of users: %d", len(users))
+	}
+}
+
+func TestIteratorCancel(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	it := ts.Search.Iterate(ctx, SearchRequest{}, IteratorOptions{PageSize: 5, Prefetch: true})
+	defer it.Close()
+
+	if !it.Next() {
+		t.Fatalf("Empty iterator: %v", it.Err())
+	}
+	cancel()
+	if it.Next() {
+		t.Errorf("Iterator continued after cancel")
+	}
+	if it.Err() != context.Canceled {
+		t.Errorf("Invalid error: %v", it.Err())
+	}
+}
+
+func TestIteratorMidStreamError(t *testing.T) {
+	calls := 0
+	srv := newSearchServer()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls > 1 {
+			http.Error(w, "Fatal Error", http.StatusInternalServerError)
+			return
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	client := SearchClient{AccessToken: AccessToken, URL: server.URL}
+	defer server.Close()
+
+	it := client.Iterate(context.Background(), SearchRequest{}, IteratorOptions{PageSize: 5})
+	users := collectUsers(it)
+
+	if len(users) != 5 {
+		t.Errorf("Invalid number of users: %d", len(users))
+	}
+	if it.Err() == nil {
+		t.Errorf("Empty error")
+	} else if it.Err().Error() != "SearchServer fatal error" {
+		t.Errorf("Invalid error: %v", it.Err().Error())
+	}
+}
+
+func TestMultiOrder(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	response, err := ts.Search.FindUsers(SearchRequest{
+		Limit: 25,
+		Query: "gender:male",
+		Order: []SearchOrder{{"Age", -1}, {"Id", 1}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	for i := 1; i < len(response.Users); i++ {
+		prev, user := response.Users[i-1], response.Users[i]
+		if prev.Age < user.Age || prev.Age == user.Age && prev.Id > user.Id {
+			t.Fatalf("Users not sorted at %d: %v %v", i, prev, user)
+		}
+	}
+
+	_, err = ts.Search.FindUsers(SearchRequest{
+		Order: []SearchOrder{{"Foo", 1}},
+	})
+	if err == nil {
+		t.Errorf("Empty error")
+	} else if err.Error() != "Order Foo:1 invalid" {
+		t.Errorf("Invalid error: %v", err.Error())
+	}
+}
+
+func TestRank(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	response, err := ts.Search.FindUsers(SearchRequest{
+		Limit: 5,
+		Query: "annie",
+		Rank:  true,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Users) != 1 || response.Users[0].Name != "Annie Osborn" || response.Users[0].Score <= 0 {
+		t.Errorf("Invalid users: %v", response.Users)
+	}
+}
+
+func TestCursorPages(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	req := SearchRequest{
+		Limit:  10,
+		Order:  []SearchOrder{{"Age", -1}},
+		Cursor: CursorFirst,
+	}
+	seen := map[int]bool{}
+	pages := 0
+	for {
+		response, err := ts.Search.FindUsers(req)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		pages++
+		for _, user := range response.Users {
+			if seen[user.Id] {
+				t.Errorf("Duplicated user: %v", user)
+			}
+			seen[user.Id] = true
+		}
+		if !response.NextPage {
+			if response.NextCursor != "" {
+				t.Errorf("Cursor on last page: %s", response.NextCursor)
+			}
+			break
+		}
+		req.Cursor = response.NextCursor
+	}
+	if len(seen) != 35 || pages != 4 {
+		t.Errorf("Invalid number of users or pages: %d %d", len(seen), pages)
+	}
+}
+
+func TestCursorErrors(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	_, err := ts.Search.FindUsers(SearchRequest{Limit: 5, Cursor: "garbage"})
+	if err == nil {
+		t.Errorf("Empty error")
+	} else if err.Error() != "Cursor garbage invalid" {
+		t.Errorf("Invalid error: %v", err.Error())
+	}
+
+	_, err = ts.Search.FindUsers(SearchRequest{Limit: 5, Offset: 5, Cursor: CursorFirst})
+	if err == nil {
+		t.Errorf("Empty error")
+	} else if err.Error() != "offset cant be used with cursor" {
+		t.Errorf("Invalid error: %v", err.Error())
+	}
+
+	_, err = ts.Search.FindUsers(SearchRequest{Cursor: CursorFirst})
+	if err == nil {
+		t.Errorf("Empty error")
+	} else if err.Error() != "limit must be > 0" {
+		t.Errorf("Invalid error: %v", err.Error())
+	}
+
+	// курсор от одного запроса не подходит к другому
+	response, _ := ts.Search.FindUsers(SearchRequest{Limit: 5, Cursor: CursorFirst})
+	_, err = ts.Search.FindUsers(SearchRequest{Limit: 5, Query: "annie", Cursor: response.NextCursor})
+	if err == nil {
+		t.Errorf("Empty error")
+	}
+}
+
+func TestIteratorCursor(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	it := ts.Search.Iterate(context.Background(), SearchRequest{Query: "lorem"}, IteratorOptions{
+		PageSize:  3,
+		UseCursor: true,
+		Prefetch:  true,
+	})
+	defer it.Close()
+	users := collectUsers(it)
+
+	if it.Err() != nil {
+		t.Errorf("Unexpected error: %v", it.Err())
+	}
+	expected, _ := ts.Search.FindUsers(SearchRequest{Query: "lorem", Limit: 25})
+	if len(expected.Users) == 0 || len(users) != len(expected.Users) {
+		t.Errorf("Invalid number of users: %d, expected %d", len(users), len(expected.Users))
+	}
+}
+
+func TestCache(t *testing.T) {
+	srv := newSearchServer()
+	requests, notModified := 0, 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") != "" {
+			notModified++
+		}
+		srv.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	cache := NewResponseCache(CacheOptions{TTL: time.Hour})
+	client := SearchClient{AccessToken: AccessToken, URL: server.URL, Cache: cache}
+
+	first, err := client.FindUsers(SearchRequest{Limit: 5, Query: "Annie"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	second, err := client.FindUsers(SearchRequest{Limit: 5, Query: "Annie"})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requests != 1 {
+		t.Errorf("Expected 1 request, got %d", requests)
+	}
+	if len(second.Users) != 1 || second.Users[0].Id != first.Users[0].Id {
+		t.Errorf("Invalid cached users: %v", second.Users)
+	}
+	// другой регистр - другой запрос, что с ним сделает сервер, клиент не знает
+	if _, err := client.FindUsers(SearchRequest{Limit: 5, Query: " annie "}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("Expected 2 requests, got %d", requests)
+	}
+
+	// протухший ответ перепроверяется по ETag
+	cache.opts.TTL = 0
+	cache.Purge()
+	client.FindUsers(SearchRequest{Limit: 5})
+	response, err := client.FindUsers(SearchRequest{Limit: 5})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(response.Users) != 5 || !response.NextPage {
+		t.Errorf("Invalid revalidated response: %v", response)
+	}
+	if requests != 4 || notModified != 1 {
+		t.Errorf("Expected 4 requests with 1 conditional, got %d %d", requests, notModified)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 2 || stats.Misses != 3 || stats.Revalidations != 1 {
+		t.Errorf("Invalid stats: %+v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+	ts := newTestServer(AccessToken)
+	defer ts.Close()
+
+	cache := NewResponseCache(CacheOptions{TTL: time.Hour, MaxEntries: 2})
+	ts.Search.Cache = cache
+
+	for _, offset := range []int{0, 1, 2, 0} {
+		if _, err := ts.Search.FindUsers(SearchRequest{Limit: 1, Offset: offset}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	stats := cache.Stats()
+	if stats.Hits != 0 || stats.Misses != 4 {
+		t.Errorf("Invalid stats: %+v", stats)
+	}
+	if cache.lru.Len() != 2 {
+		t.Errorf("Invalid cache size: %d", cache.lru.Len())
+	}
+}
+
+func TestCacheShared(t *testing.T) {
+	first := newTestServer(AccessToken)
+	defer first.Close()
+	cache := NewResponseCache(CacheOptions{TTL: time.Hour})
+	first.Search.Cache = cache
+
+	if _, err := first.Search.FindUsers(SearchRequest{Limit: 5}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// с неверным токеном из кэша ничего не отдаётся
+	bad := SearchClient{AccessToken: "bad", URL: first.server.URL, Cache: cache}
+	if _, err := bad.FindUsers(SearchRequest{Limit: 5}); err == nil || err.Error() != "Bad AccessToken" {
+		t.Errorf("Expected Bad AccessToken, got %v", err)
+	}
+
+	// у другой системы - свой ответ, а не чужой из кэша
+	requests := 0
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Write([]byte(`[{"Id": 100500, "Name": "other"}]`))
+	}))
+	defer other.Close()
+	otherClient := SearchClient{AccessToken: AccessToken, URL: other.URL, Cache: cache}
+	response, err := otherClient.FindUsers(SearchRequest{Limit: 5})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if requests != 1 || len(response.Users) != 1 || response.Users[0].Id != 100500 {
+		t.Errorf("Expected response of other server, got %d requests %v", requests, response.Users)
+	}
+
+	stats := cache.Stats()
+	if stats.Hits != 0 || stats.Misses != 2 {
+		t.Errorf("Invalid stats: %+v", stats)
+	}
+}
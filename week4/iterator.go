@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+)
+
+const maxPageSize = 25
+
+// IteratorOptions настраивает обход всех страниц выдачи
+type IteratorOptions struct {
+	// сколько пользователей запрашивать за раз, по умолчанию и максимум - 25
+	PageSize int
+	// запрашивать следующую страницу в фоне, пока вызывающий разбирает текущую
+	Prefetch bool
+	// жёсткий лимит на общее количество пользователей, 0 - без лимита
+	MaxItems int
+	// ходить по страницам через курсоры, а не offset.
+	// не даёт дублей и пропусков, если данные меняются во время обхода
+	UseCursor bool
+}
+
+type pageResult struct {
+	resp *SearchResponse
+	err  error
+}
+
+// UserIterator лениво обходит все страницы выдачи FindUsers.
+// Использование как у sql.Rows:
+//
+//	it := client.Iterate(ctx, req, IteratorOptions{})
+//	defer it.Close()
+//	for it.Next() {
+//		user := it.User()
+//	}
+//	if err := it.Err(); err != nil {
+//	}
+type UserIterator struct {
+	srv    *SearchClient
+	ctx    context.Context
+	cancel context.CancelFunc
+	req    SearchRequest
+	opts   IteratorOptions
+
+	page    []User
+	pos     int
+	seen    int
+	current User
+	// сервер сказал что страниц больше нет, либо дошли до MaxItems
+	last bool
+	// следующая страница, которую запросили заранее
+	pending chan pageResult
+	err     error
+	closed  bool
+}
+
+// Iterate возвращает итератор по всем пользователям, подходящим под req, начиная с req.Offset
+// (или req.Cursor, если ходим через курсоры).
+// req.Limit игнорируется, размер страницы задаётся через opts.PageSize
+func (srv *SearchClient) Iterate(ctx context.Context, req SearchRequest, opts IteratorOptions) *UserIterator {
+	if opts.PageSize <= 0 || opts.PageSize > maxPageSize {
+		opts.PageSize = maxPageSize
+	}
+	if opts.UseCursor && req.Cursor == "" {
+		req.Cursor = CursorFirst
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	return &UserIterator{
+		srv:    srv,
+		ctx:    ctx,
+		cancel: cancel,
+		req:    req,
+		opts:   opts,
+	}
+}
+
+// Next переходит к следующему пользователю, при необходимости подгружая страницу.
+// Возвращает false, когда пользователи закончились или произошла ошибка - её вернёт Err
+func (it *UserIterator) Next() bool {
+	if it.closed {
+		return false
+	}
+	if err := it.ctx.Err(); err != nil {
+		it.fail(err)
+		return false
+	}
+	if it.opts.MaxItems > 0 && it.seen >= it.opts.MaxItems {
+		it.Close()
+		return false
+	}
+	for it.pos >= len(it.page) {
+		if it.last {
+			it.Close()
+			return false
+		}
+		if !it.fetch() {
+			return false
+		}
+	}
+
+	it.current = it.page[it.pos]
+	it.pos++
+	it.seen++
+	return true
+}
+
+// User возвращает пользователя, на котором стоит итератор
+func (it *UserIterator) User() User {
+	return it.current
+}
+
+// Err возвращает ошибку, на которой остановился обход.
+// При отмене контекста это ctx.Err()
+func (it *UserIterator) Err() error {
+	return it.err
+}
+
+// Close прекращает обход и отменяет запрос за следующей страницей, если он есть
+func (it *UserIterator) Close() {
+	if it.closed {
+		return
+	}
+	it.closed = true
+	it.cancel()
+}
+
+func (it *UserIterator) fail(err error) {
+	it.err = err
+	it.Close()
+}
+
+func (it *UserIterator) fetch() bool {
+	var res pageResult
+	if it.pending != nil {
+		select {
+		case res = <-it.pending:
+		case <-it.ctx.Done():
+			res.err = it.ctx.Err()
+		}
+		it.pending = nil
+	} else {
+		res = it.load(it.nextRequest())
+	}
+	if res.err != nil {
+		it.fail(res.err)
+		return false
+	}
+
+	it.page = res.resp.Users
+	it.pos = 0
+	if it.opts.UseCursor {
+		it.req.Cursor = res.resp.NextCursor
+	} else {
+		it.req.Offset += len(it.page)
+	}
+	it.last = !res.resp.NextPage || len(it.page) == 0
+	if it.opts.MaxItems > 0 && it.seen+len(it.page) >= it.opts.MaxItems {
+		it.last = true
+	}
+
+	if !it.last && it.opts.Prefetch {
+		// канал буферизованный, чтобы горутина не зависла, если итератор закроют раньше
+		pending := make(chan pageResult, 1)
+		req := it.nextRequest()
+		go func() {
+			pending <- it.load(req)
+		}()
+		it.pending = pending
+	}
+	return true
+}
+
+// nextRequest собирает запрос за следующей страницей с учётом MaxItems
+func (it *UserIterator) nextRequest() SearchRequest {
+	req := it.req
+	req.Limit = it.opts.PageSize
+	if it.opts.MaxItems > 0 {
+		// при prefetch текущая страница ещё не просмотрена, но уже запрошена
+		left := it.opts.MaxItems - it.seen - (len(it.page) - it.pos)
+		if left < req.Limit {
+			req.Limit = left
+		}
+	}
+	return req
+}
+
+func (it *UserIterator) load(req SearchRequest) pageResult {
+	resp, err := it.srv.FindUsersContext(it.ctx, req)
+	return pageResult{resp, err}
+}
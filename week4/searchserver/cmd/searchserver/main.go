@@ -0,0 +1,36 @@
+// searchserver поднимает SearchServer поверх dataset.xml:
+//
+//	go run ./searchserver/cmd/searchserver -data dataset.xml -token 1234567asdfg
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"week4/searchserver"
+)
+
+func main() {
+	addr := flag.String("addr", ":8080", "адрес, который слушает сервер")
+	data := flag.String("data", "dataset.xml", "путь до xml с пользователями")
+	token := flag.String("token", "", "AccessToken, который должен присылать клиент, пустой - без авторизации")
+	watch := flag.Duration("watch", time.Second, "как часто проверять изменение файла, 0 - не проверять")
+	flag.Parse()
+
+	srv, err := searchserver.NewServer(*data, *token)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *watch > 0 {
+		go srv.Watch(context.Background(), *watch, func(err error) {
+			log.Println("reload error:", err)
+		})
+	}
+
+	log.Printf("loaded %d users from %s, starting server at %s", len(srv.Users()), *data, *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv))
+}
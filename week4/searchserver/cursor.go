@@ -0,0 +1,83 @@
+package searchserver
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	// CursorFirst - значение cursor, с которого начинается обход в режиме курсоров
+	CursorFirst = "*"
+	// NextCursorHeader - хедер, в котором приходит курсор на следующую страницу
+	NextCursorHeader = "X-Next-Cursor"
+	// ErrorBadCursor - курсор не разобрался или выдан для другого запроса
+	ErrorBadCursor = "ErrorBadCursor"
+)
+
+// cursor - значения ключей сортировки последнего отданного пользователя.
+// Следующая страница начинается строго после него, поэтому добавленные или удалённые
+// между запросами пользователи не приводят к дублям и пропускам
+type cursor struct {
+	Sig   string  `json:"s"`
+	Id    int     `json:"i"`
+	Age   int     `json:"a,omitempty"`
+	Name  string  `json:"n,omitempty"`
+	Score float64 `json:"r,omitempty"`
+
+	// CursorFirst - курсор до первого пользователя
+	first bool
+}
+
+func newCursor(sig string, u *User) cursor {
+	return cursor{
+		Sig:   sig,
+		Id:    u.Id,
+		Age:   u.Age,
+		Name:  u.Name,
+		Score: u.Score,
+	}
+}
+
+// user восстанавливает пользователя с теми же ключами сортировки, для сравнения
+func (c *cursor) user() User {
+	return User{
+		Id:    c.Id,
+		Age:   c.Age,
+		Name:  c.Name,
+		Score: c.Score,
+	}
+}
+
+func encodeCursor(c cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s, sig string) (*cursor, error) {
+	if s == CursorFirst {
+		return &cursor{Sig: sig, first: true}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, badParamError(ErrorBadCursor)
+	}
+	c := &cursor{}
+	if err := json.Unmarshal(data, c); err != nil || c.Sig != sig {
+		return nil, badParamError(ErrorBadCursor)
+	}
+	return c, nil
+}
+
+// querySignature - отпечаток условий выдачи, от которых зависит смысл курсора
+func querySignature(query string, order []orderKey, rank bool) string {
+	h := sha1.New()
+	h.Write([]byte(query))
+	for _, key := range order {
+		h.Write([]byte("\x00" + key.field + ":" + strconv.FormatBool(key.desc)))
+	}
+	h.Write([]byte("\x00rank:" + strconv.FormatBool(rank)))
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
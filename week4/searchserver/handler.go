@@ -0,0 +1,339 @@
+package searchserver
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrorBadOrderField - этот текст ошибки SearchClient распознаёт отдельно
+const ErrorBadOrderField = "ErrorBadOrderField"
+
+const (
+	// значения order_by, как описано в SearchRequest
+	orderDesc = -1
+	orderAsIs = 0
+	orderAsc  = 1
+
+	defaultLimit = 25
+)
+
+// ErrorResponse - тело ответа с ошибкой, на клиенте это SearchErrorResponse
+type ErrorResponse struct {
+	Error string
+}
+
+// функции сравнения "по возрастанию" для допустимых order_field
+var orderFields = map[string]func(a, b *User) bool{
+	"Id":   func(a, b *User) bool { return a.Id < b.Id },
+	"Age":  func(a, b *User) bool { return a.Age < b.Age },
+	"Name": func(a, b *User) bool { return a.Name < b.Name },
+}
+
+type searchParams struct {
+	Limit  int
+	Offset int
+	Query  query
+	Order  []orderKey
+	Rank   bool
+	// nil - постраничка через offset
+	Cursor *cursor
+	// отпечаток query и сортировки, курсор от другого запроса не принимаем
+	sig string
+}
+
+type orderKey struct {
+	field string
+	desc  bool
+}
+
+// badParamError - ошибка в параметрах запроса, отдаётся клиенту как 400
+type badParamError string
+
+func (e badParamError) Error() string {
+	return string(e)
+}
+
+func sendError(w http.ResponseWriter, error string, code int) {
+	js, err := json.Marshal(ErrorResponse{error})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	fmt.Fprintln(w, string(js))
+}
+
+// ServeHTTP отвечает на GET ?limit=&offset=&query=&order_field=&order_by=
+// json-массивом пользователей.
+// Вместо order_field/order_by можно передать order=Age:-1,Name:1, а rank=1 включает ранжирование по релевантности.
+// С параметром cursor (для первой страницы - CursorFirst) offset не используется,
+// а курсор на следующую страницу приходит в хедере NextCursorHeader.
+// ETag ответа зависит от версии данных и параметров, If-None-Match с ним даёт 304
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if srv.AccessToken != "" && r.Header.Get("AccessToken") != srv.AccessToken {
+		http.Error(w, "Invalid access token", http.StatusUnauthorized)
+		return
+	}
+
+	params, err := parseParams(r.URL.Query())
+	if err != nil {
+		sendError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data := srv.dataset()
+	etag := data.etag(r.URL.Query())
+	w.Header().Set("ETag", etag)
+	if etagMatch(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	result := data.search(params)
+	if params.Cursor != nil {
+		var next string
+		result, next = params.cursorPage(result)
+		if next != "" {
+			w.Header().Set(NextCursorHeader, next)
+		}
+	} else {
+		result = params.offsetPage(result)
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+func parseParams(q url.Values) (searchParams, error) {
+	params := searchParams{
+		Limit: defaultLimit,
+	}
+
+	var err error
+	if v := q.Get("limit"); v != "" {
+		params.Limit, err = strconv.Atoi(v)
+		if err != nil || params.Limit <= 0 {
+			return params, badParamError("limit must be int > 0")
+		}
+	}
+	if v := q.Get("offset"); v != "" {
+		params.Offset, err = strconv.Atoi(v)
+		if err != nil || params.Offset < 0 {
+			return params, badParamError("offset must be int >= 0")
+		}
+	}
+	if v := q.Get("rank"); v != "" {
+		params.Rank, err = strconv.ParseBool(v)
+		if err != nil {
+			return params, badParamError("rank must be bool")
+		}
+	}
+
+	params.Query, err = parseQuery(q.Get("query"))
+	if err != nil {
+		return params, err
+	}
+
+	if v := q.Get("order"); v != "" {
+		params.Order, err = parseOrder(v)
+	} else {
+		params.Order, err = parseLegacyOrder(q.Get("order_field"), q.Get("order_by"))
+	}
+	if err != nil {
+		return params, err
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		if params.Offset != 0 {
+			return params, badParamError("offset cant be used with cursor")
+		}
+		// курсор указывает на конкретного пользователя, поэтому порядок должен быть однозначным
+		params.Order = append(params.Order, orderKey{field: "Id"})
+		params.sig = querySignature(q.Get("query"), params.Order, params.Rank)
+		params.Cursor, err = decodeCursor(v, params.sig)
+		if err != nil {
+			return params, err
+		}
+	}
+	return params, nil
+}
+
+// parseLegacyOrder - старый вариант сортировки: одно поле order_field и направление order_by.
+// на неверный order_by, как и раньше, ErrorBadOrderField - его распознают старые клиенты
+func parseLegacyOrder(orderField, v string) ([]orderKey, error) {
+	orderBy := orderAsIs
+	if v != "" {
+		var err error
+		orderBy, err = strconv.Atoi(v)
+		if err != nil || orderBy < orderDesc || orderBy > orderAsc {
+			return nil, badParamError(ErrorBadOrderField)
+		}
+	}
+	if orderField == "" {
+		orderField = "Name"
+	}
+	if _, ok := orderFields[orderField]; !ok {
+		return nil, badParamError(ErrorBadOrderField)
+	}
+	if orderBy == orderAsIs {
+		return nil, nil
+	}
+	return []orderKey{{orderField, orderBy == orderDesc}}, nil
+}
+
+// parseOrder разбирает сортировку по нескольким полям: "Age:-1,Name:1".
+// направление можно не указывать, тогда по возрастанию
+func parseOrder(v string) ([]orderKey, error) {
+	keys := []orderKey{}
+	for _, part := range strings.Split(v, ",") {
+		field, dir := part, ""
+		if i := strings.IndexByte(part, ':'); i >= 0 {
+			field, dir = part[:i], part[i+1:]
+		}
+		if _, ok := orderFields[field]; !ok {
+			return nil, badParamError(ErrorBadOrderField)
+		}
+		key := orderKey{field: field}
+		switch dir {
+		case "", "1":
+		case "-1":
+			key.desc = true
+		default:
+			return nil, badParamError("order direction must be -1 or 1")
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// lessByKeys сравнивает пользователей по очереди по каждому ключу сортировки
+func lessByKeys(keys []orderKey, a, b *User) bool {
+	for _, key := range keys {
+		less := orderFields[key.field]
+		switch {
+		case less(a, b):
+			return !key.desc
+		case less(b, a):
+			return key.desc
+		}
+	}
+	return false
+}
+
+func (params *searchParams) ranked() bool {
+	return params.Rank && len(params.Query.terms) > 0
+}
+
+// less задаёт порядок выдачи: релевантность в режиме rank, потом ключи сортировки
+func (params *searchParams) less(a, b *User) bool {
+	if params.ranked() && a.Score != b.Score {
+		return a.Score > b.Score
+	}
+	return lessByKeys(params.Order, a, b)
+}
+
+// search фильтрует пользователей по query и сортирует их.
+// В режиме rank пользователи отбираются и упорядочиваются по релевантности словам из query,
+// а сортировка из order_field/order решает только при равной релевантности
+func (d *dataset) search(params searchParams) []User {
+	ranked := params.ranked()
+	var scores map[int]float64
+	if ranked {
+		scores = d.index.score(params.Query.terms)
+	}
+
+	result := []User{}
+	for i := range d.users {
+		user := &d.users[i]
+		if ranked {
+			if _, ok := scores[i]; !ok {
+				continue
+			}
+		} else if !params.Query.matchTerms(user) {
+			continue
+		}
+		if !params.Query.matchFields(user) {
+			continue
+		}
+		result = append(result, *user)
+		if ranked {
+			result[len(result)-1].Score = scores[i]
+		}
+	}
+
+	if ranked || len(params.Order) > 0 {
+		sort.SliceStable(result, func(i, j int) bool {
+			return params.less(&result[i], &result[j])
+		})
+	}
+	return result
+}
+
+// offsetPage отрезает страницу по offset и limit
+func (params *searchParams) offsetPage(users []User) []User {
+	if params.Offset >= len(users) {
+		return []User{}
+	}
+	users = users[params.Offset:]
+	if params.Limit < len(users) {
+		users = users[:params.Limit]
+	}
+	return users
+}
+
+// cursorPage отдаёт limit пользователей строго после курсора
+// и курсор на следующую страницу, если она есть
+func (params *searchParams) cursorPage(users []User) ([]User, string) {
+	start := 0
+	if !params.Cursor.first {
+		after := params.Cursor.user()
+		start = sort.Search(len(users), func(i int) bool {
+			return params.less(&after, &users[i])
+		})
+	}
+	users = users[start:]
+	if len(users) <= params.Limit {
+		return users, ""
+	}
+	users = users[:params.Limit]
+	return users, encodeCursor(newCursor(params.sig, &users[len(users)-1]))
+}
+
+// etag - версия данных плюс каноничные параметры запроса.
+// регистр и пробелы по краям query на выдачу не влияют, поэтому на ETag тоже
+func (d *dataset) etag(q url.Values) string {
+	canonical := url.Values{}
+	for k, v := range q {
+		canonical[k] = v
+	}
+	canonical.Set("query", strings.ToLower(strings.TrimSpace(q.Get("query"))))
+
+	h := sha1.New()
+	h.Write([]byte(d.version + "\x00" + canonical.Encode()))
+	return `"` + hex.EncodeToString(h.Sum(nil)[:8]) + `"`
+}
+
+// etagMatch проверяет If-None-Match: там может быть список через запятую или *
+func etagMatch(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
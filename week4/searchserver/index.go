@@ -0,0 +1,87 @@
+package searchserver
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+const (
+	// параметры BM25
+	bm25K1 = 1.2
+	bm25B  = 0.75
+	// совпадение в имени весит больше, чем в описании
+	nameBoost = 2
+)
+
+type posting struct {
+	doc int
+	tf  float64
+}
+
+// invertedIndex - обратный индекс по словам из Name и About для режима rank
+type invertedIndex struct {
+	postings map[string][]posting
+	docLen   []float64
+	avgLen   float64
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func buildIndex(users []User) *invertedIndex {
+	idx := &invertedIndex{
+		postings: make(map[string][]posting),
+		docLen:   make([]float64, len(users)),
+	}
+	total := 0.0
+	for doc, user := range users {
+		tf := make(map[string]float64)
+		for _, token := range tokenize(user.Name) {
+			tf[token] += nameBoost
+		}
+		for _, token := range tokenize(user.About) {
+			tf[token]++
+		}
+		for token, freq := range tf {
+			idx.postings[token] = append(idx.postings[token], posting{doc, freq})
+			idx.docLen[doc] += freq
+		}
+		total += idx.docLen[doc]
+	}
+	if len(users) > 0 {
+		idx.avgLen = total / float64(len(users))
+	}
+	return idx
+}
+
+// score считает BM25 для каждого документа, где встретилось хотя бы одно слово из terms.
+// ключ - индекс пользователя в dataset.users
+func (idx *invertedIndex) score(terms []string) map[int]float64 {
+	scores := make(map[int]float64)
+	n := float64(len(idx.docLen))
+	seen := make(map[string]bool)
+	for _, term := range terms {
+		for _, token := range tokenize(term) {
+			if seen[token] {
+				continue
+			}
+			seen[token] = true
+
+			postings := idx.postings[token]
+			if len(postings) == 0 {
+				continue
+			}
+			df := float64(len(postings))
+			idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+			for _, p := range postings {
+				norm := 1 - bm25B + bm25B*idx.docLen[p.doc]/idx.avgLen
+				scores[p.doc] += idf * p.tf * (bm25K1 + 1) / (p.tf + bm25K1*norm)
+			}
+		}
+	}
+	return scores
+}
@@ -0,0 +1,107 @@
+package searchserver
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// query - разобранный параметр query.
+// Пример: `boris name:wolf about:"lorem ipsum" age:22`.
+// Слова без поля ищутся подстрокой в Name или About,
+// слова с полем - только в этом поле. Все условия должны выполняться одновременно
+type query struct {
+	terms  []string
+	fields []fieldTerm
+}
+
+type fieldTerm struct {
+	field string
+	value string
+	num   int
+}
+
+// фильтры по полям, доступные в query. значение уже в нижнем регистре
+var queryFields = map[string]func(u *User, t *fieldTerm) bool{
+	"name":   func(u *User, t *fieldTerm) bool { return strings.Contains(strings.ToLower(u.Name), t.value) },
+	"about":  func(u *User, t *fieldTerm) bool { return strings.Contains(strings.ToLower(u.About), t.value) },
+	"gender": func(u *User, t *fieldTerm) bool { return strings.ToLower(u.Gender) == t.value },
+	"id":     func(u *User, t *fieldTerm) bool { return u.Id == t.num },
+	"age":    func(u *User, t *fieldTerm) bool { return u.Age == t.num },
+}
+
+var numericFields = map[string]bool{"id": true, "age": true}
+
+func parseQuery(s string) (query, error) {
+	q := query{}
+	for s = strings.TrimSpace(s); s != ""; s = strings.TrimSpace(s) {
+		field := ""
+		// поле - это всё до двоеточия, если двоеточие встретилось раньше пробела и кавычки
+		if i := strings.IndexFunc(s, func(r rune) bool { return r == ':' || r == '"' || unicode.IsSpace(r) }); i > 0 && s[i] == ':' {
+			field, s = strings.ToLower(s[:i]), s[i+1:]
+		}
+
+		value := ""
+		if strings.HasPrefix(s, `"`) {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return q, badParamError("unclosed quote in query")
+			}
+			value, s = s[1:end+1], s[end+2:]
+		} else {
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			value, s = s[:end], s[end:]
+		}
+		value = strings.ToLower(value)
+
+		if field == "" {
+			if value != "" {
+				q.terms = append(q.terms, value)
+			}
+			continue
+		}
+		if _, ok := queryFields[field]; !ok {
+			return q, badParamError("unknown query field " + field)
+		}
+		if value == "" {
+			return q, badParamError("empty value for query field " + field)
+		}
+		term := fieldTerm{field: field, value: value}
+		if numericFields[field] {
+			num, err := strconv.Atoi(value)
+			if err != nil {
+				return q, badParamError("query field " + field + " must be int")
+			}
+			term.num = num
+		}
+		q.fields = append(q.fields, term)
+	}
+	return q, nil
+}
+
+// matchFields проверяет условия по полям
+func (q *query) matchFields(u *User) bool {
+	for i := range q.fields {
+		if !queryFields[q.fields[i].field](u, &q.fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTerms проверяет что каждое слово без поля есть в Name или About
+func (q *query) matchTerms(u *User) bool {
+	if len(q.terms) == 0 {
+		return true
+	}
+	name, about := strings.ToLower(u.Name), strings.ToLower(u.About)
+	for _, term := range q.terms {
+		if !strings.Contains(name, term) && !strings.Contains(about, term) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,163 @@
+// Package searchserver - внешняя система поиска пользователей для SearchClient.
+// Данные один раз читаются из dataset.xml и держатся в памяти,
+// файл можно перечитать через Reload или следить за ним через Watch.
+package searchserver
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// User - пользователь в том виде, в котором его ждёт SearchClient
+type User struct {
+	Id     int
+	Name   string
+	Age    int
+	About  string
+	Gender string
+	// релевантность запросу, заполняется только в режиме rank
+	Score float64 `json:",omitempty"`
+}
+
+type userXML struct {
+	Id        int    `xml:"id"`
+	FirstName string `xml:"first_name"`
+	LastName  string `xml:"last_name"`
+	Age       int    `xml:"age"`
+	About     string `xml:"about"`
+	Gender    string `xml:"gender"`
+}
+
+type datasetXML struct {
+	Rows []userXML `xml:"row"`
+}
+
+// Server отвечает на поисковые запросы по данным из xml-файла
+type Server struct {
+	// токен, который клиент должен прислать в хедере AccessToken. пустой - пускаем всех
+	AccessToken string
+
+	path    string
+	mu      sync.RWMutex
+	data    *dataset
+	modTime time.Time
+}
+
+// dataset - загруженные пользователи вместе с построенным по ним индексом
+type dataset struct {
+	users []User
+	index *invertedIndex
+	// хеш содержимого файла, меняется только если поменялись данные
+	version string
+}
+
+// NewServer загружает датасет из path и возвращает готовый к работе сервер
+func NewServer(path, accessToken string) (*Server, error) {
+	srv := &Server{
+		AccessToken: accessToken,
+		path:        path,
+	}
+	if err := srv.Reload(); err != nil {
+		return nil, err
+	}
+	return srv, nil
+}
+
+// Reload перечитывает датасет. Если файл битый - остаются старые данные
+func (srv *Server) Reload() error {
+	info, err := os.Stat(srv.path)
+	if err != nil {
+		return err
+	}
+	users, version, err := loadUsers(srv.path)
+	if err != nil {
+		return err
+	}
+
+	data := &dataset{
+		users:   users,
+		index:   buildIndex(users),
+		version: version,
+	}
+
+	srv.mu.Lock()
+	srv.data = data
+	srv.modTime = info.ModTime()
+	srv.mu.Unlock()
+	return nil
+}
+
+// ReloadIfChanged перечитывает датасет, только если файл изменился с прошлой загрузки
+func (srv *Server) ReloadIfChanged() (bool, error) {
+	info, err := os.Stat(srv.path)
+	if err != nil {
+		return false, err
+	}
+	srv.mu.RLock()
+	changed := !info.ModTime().Equal(srv.modTime)
+	srv.mu.RUnlock()
+	if !changed {
+		return false, nil
+	}
+	return true, srv.Reload()
+}
+
+// Watch раз в interval проверяет файл и перечитывает его при изменении, пока не отменят ctx.
+// Ошибки перечитывания отдаются в onError, если он задан
+func (srv *Server) Watch(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := srv.ReloadIfChanged(); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+// Users возвращает текущий срез данных. Менять его нельзя
+func (srv *Server) Users() []User {
+	return srv.dataset().users
+}
+
+func (srv *Server) dataset() *dataset {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+	return srv.data
+}
+
+func loadUsers(path string) ([]User, string, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	data := datasetXML{}
+	if err := xml.Unmarshal(raw, &data); err != nil {
+		return nil, "", fmt.Errorf("cant parse %s: %s", path, err)
+	}
+
+	users := make([]User, 0, len(data.Rows))
+	for _, row := range data.Rows {
+		users = append(users, User{
+			Id:     row.Id,
+			Name:   row.FirstName + " " + row.LastName,
+			Age:    row.Age,
+			About:  row.About,
+			Gender: row.Gender,
+		})
+	}
+	sum := sha1.Sum(raw)
+	return users, hex.EncodeToString(sum[:8]), nil
+}
@@ -0,0 +1,322 @@
+package searchserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const datasetPath = "../dataset.xml"
+
+func doSearch(t *testing.T, srv *Server, query string) (int, []User, ErrorResponse) {
+	t.Helper()
+	req := httptest.NewRequest("GET", "/?"+query, nil)
+	req.Header.Set("AccessToken", srv.AccessToken)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+
+	users := []User{}
+	errResp := ErrorResponse{}
+	if w.Code == http.StatusOK {
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("cant unpack result json: %v", err)
+		}
+	} else if w.Code == http.StatusBadRequest {
+		if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil {
+			t.Fatalf("cant unpack error json: %v", err)
+		}
+	}
+	return w.Code, users, errResp
+}
+
+func TestBadParams(t *testing.T) {
+	srv, err := NewServer(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]string{
+		"limit=abc":         "limit must be int > 0",
+		"limit=0":           "limit must be int > 0",
+		"offset=-1":         "offset must be int >= 0",
+		"order_by=2":        ErrorBadOrderField,
+		"order_by=x":        ErrorBadOrderField,
+		"order_field=About": ErrorBadOrderField,
+	}
+	for query, expected := range cases {
+		code, _, errResp := doSearch(t, srv, query)
+		if code != http.StatusBadRequest {
+			t.Errorf("[%s] expected 400, got %d", query, code)
+			continue
+		}
+		if errResp.Error != expected {
+			t.Errorf("[%s] invalid error: %s", query, errResp.Error)
+		}
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	srv, err := NewServer(datasetPath, "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, req)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestQueryAndOrder(t *testing.T) {
+	srv, err := NewServer(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, users, _ := doSearch(t, srv, "query=annie&limit=100")
+	if len(users) != 1 || users[0].Name != "Annie Osborn" {
+		t.Errorf("invalid query result: %v", users)
+	}
+
+	_, users, _ = doSearch(t, srv, "order_field=Age&order_by=-1&limit=100")
+	for i := 1; i < len(users); i++ {
+		if users[i-1].Age < users[i].Age {
+			t.Fatalf("users not sorted by age desc at %d: %v", i, users[i-1:i+1])
+		}
+	}
+
+	_, users, _ = doSearch(t, srv, "order_by=1&limit=100")
+	for i := 1; i < len(users); i++ {
+		if users[i-1].Name > users[i].Name {
+			t.Fatalf("users not sorted by name at %d: %v", i, users[i-1:i+1])
+		}
+	}
+
+	_, users, _ = doSearch(t, srv, "offset=1000")
+	if len(users) != 0 {
+		t.Errorf("expected empty result, got %d users", len(users))
+	}
+}
+
+func TestReloadIfChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "searchserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dataset.xml")
+
+	writeDataset := func(body string, modTime time.Time) {
+		data := `<?xml version="1.0" encoding="UTF-8" ?><root>` + body + `</root>`
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	now := time.Now()
+	writeDataset(`<row><id>1</id><first_name>Boyd</first_name><last_name>Wolf</last_name></row>`, now)
+	srv, err := NewServer(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err := srv.ReloadIfChanged()
+	if changed || err != nil {
+		t.Errorf("unexpected reload: %v %v", changed, err)
+	}
+
+	writeDataset(`<row><id>1</id></row><row><id>2</id></row>`, now.Add(time.Second))
+	changed, err = srv.ReloadIfChanged()
+	if !changed || err != nil {
+		t.Errorf("expected reload: %v %v", changed, err)
+	}
+	if len(srv.Users()) != 2 {
+		t.Errorf("expected 2 users after reload, got %d", len(srv.Users()))
+	}
+
+	writeDataset(`<row>`, now.Add(2*time.Second))
+	if _, err = srv.ReloadIfChanged(); err == nil {
+		t.Errorf("expected parse error")
+	}
+	if len(srv.Users()) != 2 {
+		t.Errorf("broken file must keep old data, got %d users", len(srv.Users()))
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	q, err := parseQuery(`boris  name:Wolf about:"Lorem Ipsum" age:22`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(q.terms) != 1 || q.terms[0] != "boris" {
+		t.Errorf("invalid terms: %v", q.terms)
+	}
+	expected := []fieldTerm{
+		{field: "name", value: "wolf"},
+		{field: "about", value: "lorem ipsum"},
+		{field: "age", value: "22", num: 22},
+	}
+	if len(q.fields) != len(expected) {
+		t.Fatalf("invalid fields: %v", q.fields)
+	}
+	for i := range expected {
+		if q.fields[i] != expected[i] {
+			t.Errorf("invalid field %d: %+v", i, q.fields[i])
+		}
+	}
+
+	for _, bad := range []string{`foo:bar`, `name:`, `age:old`, `about:"lorem`} {
+		if _, err := parseQuery(bad); err == nil {
+			t.Errorf("[%s] expected error", bad)
+		}
+	}
+}
+
+func TestFieldQueryAndMultiOrder(t *testing.T) {
+	srv, err := NewServer(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, users, _ := doSearch(t, srv, "query=gender:female&order=Age:-1,Name:1&limit=100")
+	if len(users) == 0 {
+		t.Fatal("empty result")
+	}
+	for i, user := range users {
+		if user.Gender != "female" {
+			t.Errorf("invalid gender at %d: %v", i, user)
+		}
+		if i == 0 {
+			continue
+		}
+		prev := users[i-1]
+		if prev.Age < user.Age || prev.Age == user.Age && prev.Name > user.Name {
+			t.Fatalf("users not sorted by age desc, name asc at %d: %v %v", i, prev, user)
+		}
+	}
+
+	code, _, errResp := doSearch(t, srv, "order=Age:-1,About:1")
+	if code != http.StatusBadRequest || errResp.Error != ErrorBadOrderField {
+		t.Errorf("expected bad order field, got %d %s", code, errResp.Error)
+	}
+}
+
+func TestRank(t *testing.T) {
+	srv, err := NewServer(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, users, _ := doSearch(t, srv, "query=boyd+lorem&rank=1&limit=100")
+	if len(users) == 0 {
+		t.Fatal("empty result")
+	}
+	if users[0].Name != "Boyd Wolf" {
+		t.Errorf("name match must rank first, got %v", users[0])
+	}
+	for i := 1; i < len(users); i++ {
+		if users[i].Score <= 0 || users[i-1].Score < users[i].Score {
+			t.Fatalf("users not ranked at %d: %v %v", i, users[i-1].Score, users[i].Score)
+		}
+	}
+
+	_, users, _ = doSearch(t, srv, "query=nosuchword&rank=1")
+	if len(users) != 0 {
+		t.Errorf("expected empty result, got %d users", len(users))
+	}
+}
+
+func TestCursorSurvivesDatasetChange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "searchserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dataset.xml")
+
+	writeDataset := func(ids []int, modTime time.Time) {
+		data := `<root>`
+		for _, id := range ids {
+			data += fmt.Sprintf("<row><id>%d</id><age>%d</age></row>", id, 100-id)
+		}
+		if err := ioutil.WriteFile(path, []byte(data+`</root>`), 0644); err != nil {
+			t.Fatal(err)
+		}
+		os.Chtimes(path, modTime, modTime)
+	}
+
+	now := time.Now()
+	writeDataset([]int{1, 2, 3, 4, 5, 6}, now)
+	srv, err := NewServer(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page := func(cursor string) ([]User, string) {
+		req := httptest.NewRequest("GET", "/?limit=3&order=Age:-1&cursor="+cursor, nil)
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		users := []User{}
+		if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+			t.Fatalf("cant unpack result json: %v %s", err, w.Body.String())
+		}
+		return users, w.Header().Get(NextCursorHeader)
+	}
+
+	users, next := page(CursorFirst)
+	if len(users) != 3 || users[2].Id != 3 || next == "" {
+		t.Fatalf("invalid first page: %v %q", users, next)
+	}
+
+	// между страницами удалили уже отданного пользователя и добавили нового в начало выдачи
+	writeDataset([]int{0, 2, 3, 4, 5, 6}, now.Add(time.Second))
+	if _, err := srv.ReloadIfChanged(); err != nil {
+		t.Fatal(err)
+	}
+
+	users, next = page(next)
+	if len(users) != 3 || users[0].Id != 4 || users[2].Id != 6 || next != "" {
+		t.Errorf("invalid second page: %v %q", users, next)
+	}
+}
+
+func TestETag(t *testing.T) {
+	srv, err := NewServer(datasetPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(query, etag string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/?"+query, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		srv.ServeHTTP(w, req)
+		return w
+	}
+
+	etag := get("query=Annie&limit=5", "").Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("empty ETag")
+	}
+	if w := get("query=annie&limit=5", etag); w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("expected 304, got %d", w.Code)
+	}
+	if w := get("query=annie&limit=6", etag); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for other query, got %d", w.Code)
+	}
+
+	srv.data = &dataset{users: srv.data.users, index: srv.data.index, version: "changed"}
+	if w := get("query=annie&limit=5", etag); w.Code != http.StatusOK {
+		t.Errorf("expected 200 for new dataset version, got %d", w.Code)
+	}
+}
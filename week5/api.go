@@ -1,10 +1,21 @@
 package main
 
+//go:generate go run ./handlers_gen -grpc
+
 import (
 	"context"
+	"crypto/md5"
+	"encoding/hex"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"week5/apigen"
 )
 
 // вы можете использовать ApiError в коде, который получается в результате генерации
@@ -29,8 +40,12 @@ const (
 type MyApi struct {
 	statuses map[string]int
 	users    map[string]*User
+	infos    map[string]*UserInfo
+	avatars  map[string]*avatar
 	nextID   uint64
 	mu       *sync.RWMutex
+	// сколько отвечает хранилище для поиска
+	searchDelay time.Duration
 }
 
 func NewMyApi() *MyApi {
@@ -48,31 +63,33 @@ func NewMyApi() *MyApi {
 				Status:   statusAdmin,
 			},
 		},
-		nextID: 43,
-		mu:     &sync.RWMutex{},
+		infos:   map[string]*UserInfo{},
+		avatars: map[string]*avatar{},
+		nextID:  43,
+		mu:      &sync.RWMutex{},
 	}
 }
 
 type ProfileParams struct {
-	Login string `apivalidator:"required"`
+	Login string `apivalidator:"required" proto:"1"`
 }
 
 type CreateParams struct {
-	Login  string `apivalidator:"required,min=10"`
-	Name   string `apivalidator:"paramname=full_name"`
-	Status string `apivalidator:"enum=user|moderator|admin,default=user"`
-	Age    int    `apivalidator:"min=0,max=128"`
+	Login  string `apivalidator:"required,min=10" proto:"1"`
+	Name   string `apivalidator:"paramname=full_name" proto:"2"`
+	Status string `apivalidator:"enum=user|moderator|admin,default=user" proto:"3"`
+	Age    int    `apivalidator:"min=0,max=128" proto:"4"`
 }
 
 type User struct {
-	ID       uint64 `json:"id"`
-	Login    string `json:"login"`
-	FullName string `json:"full_name"`
-	Status   int    `json:"status"`
+	ID       uint64 `json:"id" proto:"1"`
+	Login    string `json:"login" proto:"2"`
+	FullName string `json:"full_name" proto:"3"`
+	Status   int    `json:"status" proto:"4"`
 }
 
 type NewUser struct {
-	ID uint64 `json:"id"`
+	ID uint64 `json:"id" proto:"1"`
 }
 
 // apigen:api {"url": "/user/profile", "auth": false}
@@ -118,6 +135,325 @@ func (srv *MyApi) Create(ctx context.Context, in CreateParams) (*NewUser, error)
 	return &NewUser{id}, nil
 }
 
+type MeParams struct {
+}
+
+// пользователь, от имени которого пришёл запрос, лежит в контексте
+// apigen:api {"url": "/user/me", "auth": true}
+func (srv *MyApi) Me(ctx context.Context, in MeParams) (*User, error) {
+	principal, ok := apigen.PrincipalFrom(ctx)
+	if !ok {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("unauthorized")}
+	}
+
+	srv.mu.RLock()
+	user, exist := srv.users[principal.ID]
+	srv.mu.RUnlock()
+	if !exist {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}
+	}
+
+	return user, nil
+}
+
+type StatusParams struct {
+	Login  string `apivalidator:"required" proto:"1"`
+	Status string `apivalidator:"enum=user|moderator|admin,default=user" proto:"2"`
+}
+
+// менять статус может только админ
+// apigen:api {"url": "/user/status", "auth": true, "method": "POST", "roles": ["admin"]}
+func (srv *MyApi) SetStatus(ctx context.Context, in StatusParams) (*User, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	user, exist := srv.users[in.Login]
+	if !exist {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}
+	}
+	user.Status = srv.statuses[in.Status]
+
+	result := *user
+	return &result, nil
+}
+
+type Address struct {
+	City   string `json:"city" proto:"1"`
+	Street string `json:"street" proto:"2"`
+}
+
+// параметры всех типов, которые умеет генератор.
+// указатель - необязательный параметр: если его не передали, старое значение не трогаем
+type InfoParams struct {
+	Login    string    `apivalidator:"required" proto:"1"`
+	Age      *int      `apivalidator:"min=0,max=128" proto:"2"`
+	Rating   float64   `apivalidator:"min=0,max=5" proto:"3"`
+	Visits   uint32    `apivalidator:"max=100000" proto:"4"`
+	Verified *bool     `apivalidator:"paramname=verified" proto:"5"`
+	Birthday time.Time `apivalidator:"paramname=birthday" proto:"6"`
+	Tags     []string  `apivalidator:"max=3" proto:"7"`
+	Address  *Address  `apivalidator:"paramname=address" proto:"8"`
+}
+
+type UserInfo struct {
+	Login    string     `json:"login" proto:"1"`
+	Age      int        `json:"age" proto:"2"`
+	Rating   float64    `json:"rating" proto:"3"`
+	Visits   uint32     `json:"visits" proto:"4"`
+	Verified bool       `json:"verified" proto:"5"`
+	Birthday *time.Time `json:"birthday,omitempty" proto:"6"`
+	Tags     []string   `json:"tags" proto:"7"`
+	Address  *Address   `json:"address,omitempty" proto:"8"`
+}
+
+// параметры можно передать формой или json-телом
+// apigen:api {"url": "/user/info", "auth": true, "method": "POST"}
+func (srv *MyApi) SetInfo(ctx context.Context, in InfoParams) (*UserInfo, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if _, exist := srv.users[in.Login]; !exist {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}
+	}
+
+	info, exist := srv.infos[in.Login]
+	if !exist {
+		info = &UserInfo{Login: in.Login}
+		srv.infos[in.Login] = info
+	}
+	if in.Age != nil {
+		info.Age = *in.Age
+	}
+	if in.Verified != nil {
+		info.Verified = *in.Verified
+	}
+	if in.Address != nil {
+		info.Address = in.Address
+	}
+	if !in.Birthday.IsZero() {
+		info.Birthday = &in.Birthday
+	}
+	info.Rating = in.Rating
+	info.Visits = in.Visits
+	info.Tags = in.Tags
+
+	result := *info
+	return &result, nil
+}
+
+type InfoRequest struct {
+	Login string `apivalidator:"required" proto:"1"`
+}
+
+// логин берётся из пути
+// apigen:api {"url": "/user/{login}/info", "method": "GET"}
+func (srv *MyApi) Info(ctx context.Context, in InfoRequest) (*UserInfo, error) {
+	srv.mu.RLock()
+	defer srv.mu.RUnlock()
+
+	if _, exist := srv.users[in.Login]; !exist {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("user not exist")}
+	}
+	info, exist := srv.infos[in.Login]
+	if !exist {
+		return &UserInfo{Login: in.Login}, nil
+	}
+
+	result := *info
+	return &result, nil
+}
+
+type SignupParams struct {
+	Login           string `apivalidator:"required,regexp=^[a-z][a-z0-9_.]{2,15}$" proto:"1"`
+	Email           string `apivalidator:"required,email" proto:"2"`
+	Site            string `apivalidator:"url" proto:"3"`
+	Password        string `apivalidator:"required,validate=strong_password,password==password_confirm" proto:"4"`
+	PasswordConfirm string `apivalidator:"paramname=password_confirm,required" proto:"5"`
+	Level           int    `apivalidator:"oneof=1|2|3,default=1" proto:"6"`
+	Invite          string `apivalidator:"len=8" proto:"7"`
+}
+
+// apigen:validator strong_password
+func strongPassword(password string) error {
+	if len(password) < 8 {
+		return fmt.Errorf("must be at least 8 chars")
+	}
+	if !strings.ContainsAny(password, "0123456789") {
+		return fmt.Errorf("must contain digit")
+	}
+	return nil
+}
+
+// регистрация, все нарушения правил приходят одним ответом
+// apigen:api {"url": "/user/signup", "method": "POST"}
+func (srv *MyApi) Signup(ctx context.Context, in SignupParams) (*NewUser, error) {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	if _, exist := srv.users[in.Login]; exist {
+		return nil, ApiError{http.StatusConflict, fmt.Errorf("user %s exist", in.Login)}
+	}
+
+	id := srv.nextID
+	srv.nextID++
+	srv.users[in.Login] = &User{
+		ID:     id,
+		Login:  in.Login,
+		Status: statusUser,
+	}
+
+	return &NewUser{id}, nil
+}
+
+type AvatarParams struct {
+	Avatar *apigen.File `apivalidator:"required,maxsize=1048576"`
+}
+
+type avatar struct {
+	name        string
+	contentType string
+	data        []byte
+}
+
+type UploadedFile struct {
+	Name string `json:"name"`
+	MD5  string `json:"md5"`
+	Size int64  `json:"size"`
+}
+
+// загрузка файла формой, как в week6lec/rabbit/form.go, только форму разбирает сгенерированный код
+// apigen:api {"url": "/user/avatar", "auth": true, "method": "POST", "max_body": 2097152}
+func (srv *MyApi) UploadAvatar(ctx context.Context, in AvatarParams) (*UploadedFile, error) {
+	principal, ok := apigen.PrincipalFrom(ctx)
+	if !ok {
+		return nil, ApiError{http.StatusForbidden, fmt.Errorf("unauthorized")}
+	}
+
+	hasher := md5.New()
+	data, err := ioutil.ReadAll(io.TeeReader(in.Avatar, hasher))
+	if err != nil {
+		return nil, err
+	}
+	contentType := in.Avatar.ContentType
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(data)
+	}
+
+	srv.mu.Lock()
+	srv.avatars[principal.ID] = &avatar{name: in.Avatar.Name, contentType: contentType, data: data}
+	srv.mu.Unlock()
+
+	return &UploadedFile{
+		Name: in.Avatar.Name,
+		MD5:  hex.EncodeToString(hasher.Sum(nil)),
+		Size: int64(len(data)),
+	}, nil
+}
+
+type AvatarRequest struct {
+	Login string `apivalidator:"required"`
+}
+
+// картинка отдаётся как есть, со своим Content-Type
+// apigen:api {"url": "/user/{login}/avatar", "method": "GET"}
+func (srv *MyApi) Avatar(ctx context.Context, in AvatarRequest) (*apigen.Blob, error) {
+	srv.mu.RLock()
+	a, exist := srv.avatars[in.Login]
+	srv.mu.RUnlock()
+	if !exist {
+		return nil, ApiError{http.StatusNotFound, fmt.Errorf("no avatar")}
+	}
+
+	blob := apigen.NewBlob(a.contentType, a.data)
+	blob.Name = a.name
+	return blob, nil
+}
+
+type ListParams struct {
+	Status *string `apivalidator:"enum=user|moderator|admin" proto:"1"`
+}
+
+// все пользователи по логину, по одному на строку
+// apigen:api {"url": "/user/list", "method": "GET"}
+func (srv *MyApi) List(ctx context.Context, in ListParams) (<-chan *User, error) {
+	srv.mu.RLock()
+	var users []*User
+	for _, user := range srv.users {
+		if in.Status == nil || user.Status == srv.statuses[*in.Status] {
+			copied := *user
+			users = append(users, &copied)
+		}
+	}
+	srv.mu.RUnlock()
+	sort.Slice(users, func(i, j int) bool { return users[i].Login < users[j].Login })
+
+	result := make(chan *User)
+	go func() {
+		defer close(result)
+		for _, user := range users {
+			result <- user
+		}
+	}()
+	return result, nil
+}
+
+type LoginsParams struct {
+	Prefix string `apivalidator:"paramname=prefix" proto:"1"`
+}
+
+// логины по одному, по умолчанию как server-sent events
+// apigen:api {"url": "/user/logins", "method": "GET", "stream": "sse"}
+func (srv *MyApi) Logins(ctx context.Context, in LoginsParams) (func(yield func(string) bool), error) {
+	srv.mu.RLock()
+	var logins []string
+	for login := range srv.users {
+		if strings.HasPrefix(login, in.Prefix) {
+			logins = append(logins, login)
+		}
+	}
+	srv.mu.RUnlock()
+	sort.Strings(logins)
+
+	return func(yield func(string) bool) {
+		for _, login := range logins {
+			if !yield(login) {
+				return
+			}
+		}
+	}, nil
+}
+
+type SearchParams struct {
+	Query string `apivalidator:"required" proto:"1"`
+}
+
+type SearchResult struct {
+	RequestID string   `json:"request_id" proto:"1"`
+	Logins    []string `json:"logins" proto:"2"`
+}
+
+// поиск ходит в медленное хранилище, поэтому у него свой таймаут
+// apigen:api {"url": "/user/search", "method": "GET", "timeout": "200ms"}
+func (srv *MyApi) Search(ctx context.Context, in SearchParams) (*SearchResult, error) {
+	select {
+	case <-time.After(srv.searchDelay):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	srv.mu.RLock()
+	result := &SearchResult{RequestID: apigen.RequestIDFrom(ctx), Logins: []string{}}
+	for login := range srv.users {
+		if strings.Contains(login, in.Query) {
+			result.Logins = append(result.Logins, login)
+		}
+	}
+	srv.mu.RUnlock()
+	sort.Strings(result.Logins)
+
+	return result, nil
+}
+
 // 2-я часть
 // это похожая структура, с теми же методами, но у них другие параметры!
 // код, созданный вашим кодогенератором работает с конкретной струткурой, про другие ничего не знает
@@ -131,17 +467,17 @@ func NewOtherApi() *OtherApi {
 }
 
 type OtherCreateParams struct {
-	Username string `apivalidator:"required,min=3"`
-	Name     string `apivalidator:"paramname=account_name"`
-	Class    string `apivalidator:"enum=warrior|sorcerer|rouge,default=warrior"`
-	Level    int    `apivalidator:"min=1,max=50"`
+	Username string `apivalidator:"required,min=3" proto:"1"`
+	Name     string `apivalidator:"paramname=account_name" proto:"2"`
+	Class    string `apivalidator:"enum=warrior|sorcerer|rouge,default=warrior" proto:"3"`
+	Level    int    `apivalidator:"min=1,max=50" proto:"4"`
 }
 
 type OtherUser struct {
-	ID       uint64 `json:"id"`
-	Login    string `json:"login"`
-	FullName string `json:"full_name"`
-	Level    int    `json:"level"`
+	ID       uint64 `json:"id" proto:"1"`
+	Login    string `json:"login" proto:"2"`
+	FullName string `json:"full_name" proto:"3"`
+	Level    int    `json:"level" proto:"4"`
 }
 
 // apigen:api {"url": "/user/create", "auth": true, "method": "POST"}
@@ -0,0 +1,1113 @@
+// Code generated by handlers_gen. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"week5/apigen"
+	"week5/apigen/apigrpc"
+)
+
+// grpcError переводит ошибку метода в статус gRPC по тем же правилам, по которым
+// http-хендлер выбирает код ответа
+func grpcError(ctx context.Context, err error) error {
+	if status, msg, ok := apigen.ContextError(ctx, err); ok {
+		return apigrpc.Error(status, msg)
+	}
+	if err, ok := err.(ApiError); ok {
+		return apigrpc.Error(err.HTTPStatus, err.Error())
+	}
+	return apigrpc.Error(http.StatusInternalServerError, err.Error())
+}
+
+// MyApiGRPC - методы MyApi по gRPC, сервис main.MyApi из api.proto.
+// Параметры проверяются теми же правилами apivalidator, что и в MyApiHandler
+type MyApiGRPC struct {
+	srv  *MyApi
+	opts apigen.Options
+}
+
+func NewMyApiGRPC(srv *MyApi, opts apigen.Options) *MyApiGRPC {
+	return &MyApiGRPC{
+		srv:  srv,
+		opts: opts,
+	}
+}
+
+// Register добавляет сервис на сервер gRPC. Клиент со структурами из этого пакета
+// подключается с apigrpc.DialOption, клиент из protoc - если сервер создан с apigrpc.ServerOption
+func (g *MyApiGRPC) Register(s grpc.ServiceRegistrar) {
+	s.RegisterService(&grpcDescMyApi, g)
+}
+
+var grpcDescMyApi = grpc.ServiceDesc{
+	ServiceName: "main.MyApi",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Profile",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &ProfileParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callProfile(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/Profile"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callProfile(ctx, req.(*ProfileParams))
+				})
+			},
+		},
+		{
+			MethodName: "Create",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &CreateParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callCreate(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/Create"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callCreate(ctx, req.(*CreateParams))
+				})
+			},
+		},
+		{
+			MethodName: "Me",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &MeParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callMe(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/Me"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callMe(ctx, req.(*MeParams))
+				})
+			},
+		},
+		{
+			MethodName: "SetStatus",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &StatusParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callSetStatus(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/SetStatus"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callSetStatus(ctx, req.(*StatusParams))
+				})
+			},
+		},
+		{
+			MethodName: "SetInfo",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &InfoParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callSetInfo(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/SetInfo"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callSetInfo(ctx, req.(*InfoParams))
+				})
+			},
+		},
+		{
+			MethodName: "Info",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &InfoRequest{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callInfo(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/Info"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callInfo(ctx, req.(*InfoRequest))
+				})
+			},
+		},
+		{
+			MethodName: "Signup",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &SignupParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callSignup(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/Signup"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callSignup(ctx, req.(*SignupParams))
+				})
+			},
+		},
+		{
+			MethodName: "Search",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &SearchParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*MyApiGRPC)
+				if interceptor == nil {
+					return g.callSearch(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.MyApi/Search"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callSearch(ctx, req.(*SearchParams))
+				})
+			},
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "List",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := &ListParams{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(*MyApiGRPC).callList(stream.Context(), in, stream)
+			},
+			ServerStreams: true,
+		},
+		{
+			StreamName: "Logins",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := &LoginsParams{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(*MyApiGRPC).callLogins(stream.Context(), in, stream)
+			},
+			ServerStreams: true,
+		},
+	},
+	Metadata: "api.proto",
+}
+
+func (g *MyApiGRPC) callProfile(ctx context.Context, in *ProfileParams) (*User, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	params := *in
+	errs := &apigen.Violations{}
+	validateProfileParams(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.Profile(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callCreate(ctx context.Context, in *CreateParams) (*NewUser, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	// учётные данные приходят в метаданных, проверяет их тот же Authenticator, что и для http
+	principal, err := apigen.Authenticate(g.opts.Auth, apigrpc.Request(ctx, "/main.MyApi/Create"))
+	if err != nil {
+		return nil, apigrpc.Error(http.StatusUnauthorized, "unauthorized")
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	params := *in
+	errs := &apigen.Violations{}
+	validateCreateParams(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.Create(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callMe(ctx context.Context, in *MeParams) (*User, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	// учётные данные приходят в метаданных, проверяет их тот же Authenticator, что и для http
+	principal, err := apigen.Authenticate(g.opts.Auth, apigrpc.Request(ctx, "/main.MyApi/Me"))
+	if err != nil {
+		return nil, apigrpc.Error(http.StatusUnauthorized, "unauthorized")
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	params := *in
+	out, err := g.srv.Me(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callSetStatus(ctx context.Context, in *StatusParams) (*User, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	// учётные данные приходят в метаданных, проверяет их тот же Authenticator, что и для http
+	principal, err := apigen.Authenticate(g.opts.Auth, apigrpc.Request(ctx, "/main.MyApi/SetStatus"))
+	if err != nil {
+		return nil, apigrpc.Error(http.StatusUnauthorized, "unauthorized")
+	}
+	if !principal.HasRoles("admin") {
+		return nil, apigrpc.Error(http.StatusForbidden, "forbidden")
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	params := *in
+	errs := &apigen.Violations{}
+	validateStatusParams(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.SetStatus(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callSetInfo(ctx context.Context, in *InfoParams) (*UserInfo, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	// учётные данные приходят в метаданных, проверяет их тот же Authenticator, что и для http
+	principal, err := apigen.Authenticate(g.opts.Auth, apigrpc.Request(ctx, "/main.MyApi/SetInfo"))
+	if err != nil {
+		return nil, apigrpc.Error(http.StatusUnauthorized, "unauthorized")
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	params := *in
+	errs := &apigen.Violations{}
+	validateInfoParams(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.SetInfo(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callInfo(ctx context.Context, in *InfoRequest) (*UserInfo, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	params := *in
+	errs := &apigen.Violations{}
+	validateInfoRequest(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.Info(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callSignup(ctx context.Context, in *SignupParams) (*NewUser, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	params := *in
+	errs := &apigen.Violations{}
+	validateSignupParams(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.Signup(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callSearch(ctx context.Context, in *SearchParams) (*SearchResult, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	params := *in
+	errs := &apigen.Violations{}
+	validateSearchParams(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.Search(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+func (g *MyApiGRPC) callList(ctx context.Context, in *ListParams, stream grpc.ServerStream) error {
+	ctx = apigrpc.WithRequestID(ctx)
+	params := *in
+	errs := &apigen.Violations{}
+	validateListParams(&params, errs)
+	if errs.Len() > 0 {
+		return apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	items, err := g.srv.List(ctx, params)
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+	for item := range items {
+		// клиент ушёл - дочитываем канал, чтобы не повесить горутину, которая в него пишет
+		if err == nil {
+			err = stream.SendMsg(item)
+		}
+	}
+	return err
+}
+
+func (g *MyApiGRPC) callLogins(ctx context.Context, in *LoginsParams, stream grpc.ServerStream) error {
+	ctx = apigrpc.WithRequestID(ctx)
+	params := *in
+	errs := &apigen.Violations{}
+	validateLoginsParams(&params, errs)
+	if errs.Len() > 0 {
+		return apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	items, err := g.srv.Logins(ctx, params)
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+	for item := range items {
+		if err := stream.SendMsg(&MyApiLoginsItem{Value: item}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OtherApiGRPC - методы OtherApi по gRPC, сервис main.OtherApi из api.proto.
+// Параметры проверяются теми же правилами apivalidator, что и в OtherApiHandler
+type OtherApiGRPC struct {
+	srv  *OtherApi
+	opts apigen.Options
+}
+
+func NewOtherApiGRPC(srv *OtherApi, opts apigen.Options) *OtherApiGRPC {
+	return &OtherApiGRPC{
+		srv:  srv,
+		opts: opts,
+	}
+}
+
+// Register добавляет сервис на сервер gRPC. Клиент со структурами из этого пакета
+// подключается с apigrpc.DialOption, клиент из protoc - если сервер создан с apigrpc.ServerOption
+func (g *OtherApiGRPC) Register(s grpc.ServiceRegistrar) {
+	s.RegisterService(&grpcDescOtherApi, g)
+}
+
+var grpcDescOtherApi = grpc.ServiceDesc{
+	ServiceName: "main.OtherApi",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Create",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &OtherCreateParams{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*OtherApiGRPC)
+				if interceptor == nil {
+					return g.callCreate(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/main.OtherApi/Create"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.callCreate(ctx, req.(*OtherCreateParams))
+				})
+			},
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "api.proto",
+}
+
+func (g *OtherApiGRPC) callCreate(ctx context.Context, in *OtherCreateParams) (*OtherUser, error) {
+	ctx = apigrpc.WithRequestID(ctx)
+	// учётные данные приходят в метаданных, проверяет их тот же Authenticator, что и для http
+	principal, err := apigen.Authenticate(g.opts.Auth, apigrpc.Request(ctx, "/main.OtherApi/Create"))
+	if err != nil {
+		return nil, apigrpc.Error(http.StatusUnauthorized, "unauthorized")
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	params := *in
+	errs := &apigen.Violations{}
+	validateOtherCreateParams(&params, errs)
+	if errs.Len() > 0 {
+		return nil, apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	out, err := g.srv.Create(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+
+// MarshalProto кодирует Address как сообщение Address из api.proto
+func (m *Address) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.City != "" {
+		e.String(1, m.City)
+	}
+	if m.Street != "" {
+		e.String(2, m.Street)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение Address, неизвестные поля пропускаются
+func (m *Address) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.City = d.Text()
+		case 2:
+			m.Street = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует CreateParams как сообщение CreateParams из api.proto
+func (m *CreateParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Login != "" {
+		e.String(1, m.Login)
+	}
+	if m.Name != "" {
+		e.String(2, m.Name)
+	}
+	if m.Status != "" {
+		e.String(3, m.Status)
+	}
+	if m.Age != 0 {
+		e.Int(4, int64(m.Age))
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение CreateParams, неизвестные поля пропускаются
+func (m *CreateParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Login = d.Text()
+		case 2:
+			m.Name = d.Text()
+		case 3:
+			m.Status = d.Text()
+		case 4:
+			m.Age = int(d.Int())
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует InfoParams как сообщение InfoParams из api.proto
+func (m *InfoParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Login != "" {
+		e.String(1, m.Login)
+	}
+	if m.Age != nil {
+		e.Int(2, int64(*m.Age))
+	}
+	if m.Rating != 0 {
+		e.Double(3, m.Rating)
+	}
+	if m.Visits != 0 {
+		e.Uint(4, uint64(m.Visits))
+	}
+	if m.Verified != nil {
+		e.Bool(5, *m.Verified)
+	}
+	if !m.Birthday.IsZero() {
+		e.Time(6, m.Birthday)
+	}
+	e.Strings(7, m.Tags)
+	if m.Address != nil {
+		e.Message(8, m.Address.MarshalProto())
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение InfoParams, неизвестные поля пропускаются
+func (m *InfoParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Login = d.Text()
+		case 2:
+			v := int(d.Int())
+			m.Age = &v
+		case 3:
+			m.Rating = d.Double()
+		case 4:
+			m.Visits = uint32(d.Uint())
+		case 5:
+			v := d.Bool()
+			m.Verified = &v
+		case 6:
+			m.Birthday = d.Time()
+		case 7:
+			m.Tags = append(m.Tags, d.Text())
+		case 8:
+			m.Address = &Address{}
+			if err := m.Address.UnmarshalProto(d.Message()); err != nil {
+				return err
+			}
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует InfoRequest как сообщение InfoRequest из api.proto
+func (m *InfoRequest) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Login != "" {
+		e.String(1, m.Login)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение InfoRequest, неизвестные поля пропускаются
+func (m *InfoRequest) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Login = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует ListParams как сообщение ListParams из api.proto
+func (m *ListParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Status != nil {
+		e.String(1, *m.Status)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение ListParams, неизвестные поля пропускаются
+func (m *ListParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			v := d.Text()
+			m.Status = &v
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует LoginsParams как сообщение LoginsParams из api.proto
+func (m *LoginsParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Prefix != "" {
+		e.String(1, m.Prefix)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение LoginsParams, неизвестные поля пропускаются
+func (m *LoginsParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Prefix = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует MeParams как сообщение MeParams из api.proto
+func (m *MeParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение MeParams, неизвестные поля пропускаются
+func (m *MeParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MyApiLoginsItem - элемент потока, простое значение в gRPC передаётся сообщением
+type MyApiLoginsItem struct {
+	Value string
+}
+
+// MarshalProto кодирует MyApiLoginsItem как сообщение MyApiLoginsItem из api.proto
+func (m *MyApiLoginsItem) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Value != "" {
+		e.String(1, m.Value)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение MyApiLoginsItem, неизвестные поля пропускаются
+func (m *MyApiLoginsItem) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Value = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует NewUser как сообщение NewUser из api.proto
+func (m *NewUser) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.ID != 0 {
+		e.Uint(1, m.ID)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение NewUser, неизвестные поля пропускаются
+func (m *NewUser) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Uint()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует OtherCreateParams как сообщение OtherCreateParams из api.proto
+func (m *OtherCreateParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Username != "" {
+		e.String(1, m.Username)
+	}
+	if m.Name != "" {
+		e.String(2, m.Name)
+	}
+	if m.Class != "" {
+		e.String(3, m.Class)
+	}
+	if m.Level != 0 {
+		e.Int(4, int64(m.Level))
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение OtherCreateParams, неизвестные поля пропускаются
+func (m *OtherCreateParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Username = d.Text()
+		case 2:
+			m.Name = d.Text()
+		case 3:
+			m.Class = d.Text()
+		case 4:
+			m.Level = int(d.Int())
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует OtherUser как сообщение OtherUser из api.proto
+func (m *OtherUser) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.ID != 0 {
+		e.Uint(1, m.ID)
+	}
+	if m.Login != "" {
+		e.String(2, m.Login)
+	}
+	if m.FullName != "" {
+		e.String(3, m.FullName)
+	}
+	if m.Level != 0 {
+		e.Int(4, int64(m.Level))
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение OtherUser, неизвестные поля пропускаются
+func (m *OtherUser) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Uint()
+		case 2:
+			m.Login = d.Text()
+		case 3:
+			m.FullName = d.Text()
+		case 4:
+			m.Level = int(d.Int())
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует ProfileParams как сообщение ProfileParams из api.proto
+func (m *ProfileParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Login != "" {
+		e.String(1, m.Login)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение ProfileParams, неизвестные поля пропускаются
+func (m *ProfileParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Login = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует SearchParams как сообщение SearchParams из api.proto
+func (m *SearchParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Query != "" {
+		e.String(1, m.Query)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение SearchParams, неизвестные поля пропускаются
+func (m *SearchParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Query = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует SearchResult как сообщение SearchResult из api.proto
+func (m *SearchResult) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.RequestID != "" {
+		e.String(1, m.RequestID)
+	}
+	e.Strings(2, m.Logins)
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение SearchResult, неизвестные поля пропускаются
+func (m *SearchResult) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.RequestID = d.Text()
+		case 2:
+			m.Logins = append(m.Logins, d.Text())
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует SignupParams как сообщение SignupParams из api.proto
+func (m *SignupParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Login != "" {
+		e.String(1, m.Login)
+	}
+	if m.Email != "" {
+		e.String(2, m.Email)
+	}
+	if m.Site != "" {
+		e.String(3, m.Site)
+	}
+	if m.Password != "" {
+		e.String(4, m.Password)
+	}
+	if m.PasswordConfirm != "" {
+		e.String(5, m.PasswordConfirm)
+	}
+	if m.Level != 0 {
+		e.Int(6, int64(m.Level))
+	}
+	if m.Invite != "" {
+		e.String(7, m.Invite)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение SignupParams, неизвестные поля пропускаются
+func (m *SignupParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Login = d.Text()
+		case 2:
+			m.Email = d.Text()
+		case 3:
+			m.Site = d.Text()
+		case 4:
+			m.Password = d.Text()
+		case 5:
+			m.PasswordConfirm = d.Text()
+		case 6:
+			m.Level = int(d.Int())
+		case 7:
+			m.Invite = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует StatusParams как сообщение StatusParams из api.proto
+func (m *StatusParams) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Login != "" {
+		e.String(1, m.Login)
+	}
+	if m.Status != "" {
+		e.String(2, m.Status)
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение StatusParams, неизвестные поля пропускаются
+func (m *StatusParams) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Login = d.Text()
+		case 2:
+			m.Status = d.Text()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует User как сообщение User из api.proto
+func (m *User) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.ID != 0 {
+		e.Uint(1, m.ID)
+	}
+	if m.Login != "" {
+		e.String(2, m.Login)
+	}
+	if m.FullName != "" {
+		e.String(3, m.FullName)
+	}
+	if m.Status != 0 {
+		e.Int(4, int64(m.Status))
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение User, неизвестные поля пропускаются
+func (m *User) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.ID = d.Uint()
+		case 2:
+			m.Login = d.Text()
+		case 3:
+			m.FullName = d.Text()
+		case 4:
+			m.Status = int(d.Int())
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+// MarshalProto кодирует UserInfo как сообщение UserInfo из api.proto
+func (m *UserInfo) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	if m.Login != "" {
+		e.String(1, m.Login)
+	}
+	if m.Age != 0 {
+		e.Int(2, int64(m.Age))
+	}
+	if m.Rating != 0 {
+		e.Double(3, m.Rating)
+	}
+	if m.Visits != 0 {
+		e.Uint(4, uint64(m.Visits))
+	}
+	if m.Verified {
+		e.Bool(5, m.Verified)
+	}
+	if m.Birthday != nil {
+		e.Time(6, *m.Birthday)
+	}
+	e.Strings(7, m.Tags)
+	if m.Address != nil {
+		e.Message(8, m.Address.MarshalProto())
+	}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение UserInfo, неизвестные поля пропускаются
+func (m *UserInfo) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.Login = d.Text()
+		case 2:
+			m.Age = int(d.Int())
+		case 3:
+			m.Rating = d.Double()
+		case 4:
+			m.Visits = uint32(d.Uint())
+		case 5:
+			m.Verified = d.Bool()
+		case 6:
+			v := d.Time()
+			m.Birthday = &v
+		case 7:
+			m.Tags = append(m.Tags, d.Text())
+		case 8:
+			m.Address = &Address{}
+			if err := m.Address.UnmarshalProto(d.Message()); err != nil {
+				return err
+			}
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
@@ -1,3 +1,5 @@
+// Code generated by handlers_gen. DO NOT EDIT.
+
 package main
 
 import (
@@ -5,13 +7,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
-)
+	"sync"
+	"time"
 
+	"week5/apigen"
+)
 
 func sendError(w http.ResponseWriter, error string, code int) {
 	js, err := json.Marshal(CR{"error": error})
@@ -19,34 +20,61 @@ func sendError(w http.ResponseWriter, error string, code int) {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-		
+
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(code)
 	fmt.Fprintln(w, string(js))
 }
 
+// clientError переводит ошибку из ответа сервера обратно в ApiError
+func clientError(err error) error {
+	if e, ok := err.(*apigen.StatusError); ok {
+		return ApiError{HTTPStatus: e.Status, Err: errors.New(e.Message)}
+	}
+	return err
+}
 
-func (srv *MyApi) ProfileWrapped(w http.ResponseWriter, r *http.Request) {
-	var q url.Values
-	err := errors.New("")
+func (h *MyApiHandler) handleProfile(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
 	// заполнение структуры params
 	params := ProfileParams{}
-	if r.Method == "GET" {
-		q = r.URL.Query()
-	} else {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		q, _ = url.ParseQuery(string(reqBody))
-	}
-	//Login
-	params.Login = q.Get("login") 
-	if params.Login == "" {
-		sendError(w, "login must me not empty", http.StatusBadRequest)
-		return	
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
 	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
 
+	// Login
+	{
+		v, err := src.String("login")
+		if err != nil {
+			errs.Add("login", "login must be string")
+		} else {
+			params.Login = v
+		}
+	}
 
-	user, err := srv.Profile(context.TODO(), params)
+	validateProfileParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.Profile(ctx, params)
 	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
 		switch err := err.(type) {
 		case ApiError:
 			sendError(w, err.Error(), err.HTTPStatus)
@@ -70,72 +98,274 @@ func (srv *MyApi) ProfileWrapped(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, string(b))
 }
 
-func (srv *MyApi) CreateWrapped(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("X-Auth") != "100500" {
-		sendError(w, "unauthorized", http.StatusForbidden)
+// validateProfileParams проверяет ProfileParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateProfileParams(params *ProfileParams, errs *apigen.Violations) {
+	// Login
+	if !errs.Failed("login") && params.Login == "" {
+		errs.Add("login", "login must me not empty")
+	}
+
+}
+
+func (h *MyApiHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	principal, err := apigen.Authenticate(h.opts.Auth, r)
+	if err == apigen.ErrBodyTooLarge {
+		sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
-	if r.Method != "POST" {
-		sendError(w, "bad method", http.StatusNotAcceptable)
+	if err != nil {
+		sendError(w, "unauthorized", http.StatusForbidden)
 		return
 	}
-	var q url.Values
-	err := errors.New("")
+	ctx = apigen.WithPrincipal(ctx, principal)
 	// заполнение структуры params
 	params := CreateParams{}
-	if r.Method == "GET" {
-		q = r.URL.Query()
-	} else {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		q, _ = url.ParseQuery(string(reqBody))
-	}
-	//Login
-	params.Login = q.Get("login") 
-	if params.Login == "" {
-		sendError(w, "login must me not empty", http.StatusBadRequest)
-		return	
-	}
-	if len(params.Login) < 10 {
-		sendError(w, "login len must be >= 10", http.StatusBadRequest)
-		return	
-	}//Name
-	params.Name = q.Get("full_name") 
-	//Status
-	params.Status = q.Get("status") 
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Login
+	{
+		v, err := src.String("login")
+		if err != nil {
+			errs.Add("login", "login must be string")
+		} else {
+			params.Login = v
+		}
+	}
+
+	// Name
+	{
+		v, err := src.String("full_name")
+		if err != nil {
+			errs.Add("full_name", "full_name must be string")
+		} else {
+			params.Name = v
+		}
+	}
+
+	// Status
+	{
+		v, err := src.String("status")
+		if err != nil {
+			errs.Add("status", "status must be string")
+		} else {
+			params.Status = v
+		}
+	}
+
+	// Age
+	{
+		v, err := src.Int("age", 64)
+		if err != nil {
+			errs.Add("age", "age must be int")
+		} else {
+			params.Age = int(v)
+		}
+	}
+
+	validateCreateParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.Create(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+}
+
+// validateCreateParams проверяет CreateParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateCreateParams(params *CreateParams, errs *apigen.Violations) {
+	// Login
+	if !errs.Failed("login") && params.Login == "" {
+		errs.Add("login", "login must me not empty")
+	}
+	if !errs.Failed("login") {
+		switch {
+		case len(params.Login) < 10:
+			errs.Add("login", "login len must be >= 10")
+		}
+	}
+
+	// Name
+
+	// Status
 	if params.Status == "" {
 		params.Status = "user"
 	}
-	enumStatusValid := false
-	enumStatus := []string{"user", "moderator", "admin"}
-	for _, valid := range enumStatus {
-		if valid == params.Status {
-			enumStatusValid = true
-			break
+	if !errs.Failed("status") {
+		switch {
+		case !apigen.OneOf(params.Status, "user", "moderator", "admin"):
+			errs.Add("status", "status must be one of [user, moderator, admin]")
+		}
+	}
+
+	// Age
+	if !errs.Failed("age") {
+		switch {
+		case params.Age < 0:
+			errs.Add("age", "age must be >= 0")
+		case params.Age > 128:
+			errs.Add("age", "age must be <= 128")
+		}
+	}
+
+}
+
+func (h *MyApiHandler) handleMe(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	principal, err := apigen.Authenticate(h.opts.Auth, r)
+	if err == apigen.ErrBodyTooLarge {
+		sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		sendError(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	// заполнение структуры params
+	params := MeParams{}
+
+	user, err := h.srv.Me(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
 		}
+		return
+	}
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+}
 
-	if !enumStatusValid {
-		sendError(w, "status must be one of [" + strings.Join(enumStatus, ", ") + "]", http.StatusBadRequest)
+func (h *MyApiHandler) handleSetStatus(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	principal, err := apigen.Authenticate(h.opts.Auth, r)
+	if err == apigen.ErrBodyTooLarge {
+		sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
-	//Age
-	params.Age, err = strconv.Atoi(q.Get("age"))
 	if err != nil {
-		sendError(w, "age must be int", http.StatusBadRequest)
-		return	
+		sendError(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+	if !principal.HasRoles("admin") {
+		sendError(w, "forbidden", http.StatusForbidden)
+		return
 	}
-	if params.Age < 0 {
-		sendError(w, "age must be >= 0", http.StatusBadRequest)
-		return	
+	ctx = apigen.WithPrincipal(ctx, principal)
+	// заполнение структуры params
+	params := StatusParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
 	}
-	if params.Age > 128 {
-		sendError(w, "age must be <= 128", http.StatusBadRequest)
-		return	
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Login
+	{
+		v, err := src.String("login")
+		if err != nil {
+			errs.Add("login", "login must be string")
+		} else {
+			params.Login = v
+		}
 	}
 
+	// Status
+	{
+		v, err := src.String("status")
+		if err != nil {
+			errs.Add("status", "status must be string")
+		} else {
+			params.Status = v
+		}
+	}
 
-	user, err := srv.Create(context.TODO(), params)
+	validateStatusParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.SetStatus(ctx, params)
 	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
 		switch err := err.(type) {
 		case ApiError:
 			sendError(w, err.Error(), err.HTTPStatus)
@@ -159,72 +389,264 @@ func (srv *MyApi) CreateWrapped(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, string(b))
 }
 
-func (srv *OtherApi) CreateWrapped(w http.ResponseWriter, r *http.Request) {
-	if r.Header.Get("X-Auth") != "100500" {
-		sendError(w, "unauthorized", http.StatusForbidden)
+// validateStatusParams проверяет StatusParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateStatusParams(params *StatusParams, errs *apigen.Violations) {
+	// Login
+	if !errs.Failed("login") && params.Login == "" {
+		errs.Add("login", "login must me not empty")
+	}
+
+	// Status
+	if params.Status == "" {
+		params.Status = "user"
+	}
+	if !errs.Failed("status") {
+		switch {
+		case !apigen.OneOf(params.Status, "user", "moderator", "admin"):
+			errs.Add("status", "status must be one of [user, moderator, admin]")
+		}
+	}
+
+}
+
+func (h *MyApiHandler) handleSetInfo(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	principal, err := apigen.Authenticate(h.opts.Auth, r)
+	if err == apigen.ErrBodyTooLarge {
+		sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
 		return
 	}
-	if r.Method != "POST" {
-		sendError(w, "bad method", http.StatusNotAcceptable)
+	if err != nil {
+		sendError(w, "unauthorized", http.StatusForbidden)
 		return
 	}
-	var q url.Values
-	err := errors.New("")
+	ctx = apigen.WithPrincipal(ctx, principal)
 	// заполнение структуры params
-	params := OtherCreateParams{}
-	if r.Method == "GET" {
-		q = r.URL.Query()
-	} else {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		q, _ = url.ParseQuery(string(reqBody))
-	}
-	//Username
-	params.Username = q.Get("username") 
-	if params.Username == "" {
-		sendError(w, "username must me not empty", http.StatusBadRequest)
-		return	
-	}
-	if len(params.Username) < 3 {
-		sendError(w, "username len must be >= 3", http.StatusBadRequest)
-		return	
-	}//Name
-	params.Name = q.Get("account_name") 
-	//Class
-	params.Class = q.Get("class") 
-	if params.Class == "" {
-		params.Class = "warrior"
+	params := InfoParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
 	}
-	enumClassValid := false
-	enumClass := []string{"warrior", "sorcerer", "rouge"}
-	for _, valid := range enumClass {
-		if valid == params.Class {
-			enumClassValid = true
-			break
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Login
+	{
+		v, err := src.String("login")
+		if err != nil {
+			errs.Add("login", "login must be string")
+		} else {
+			params.Login = v
+		}
+	}
+
+	// Age
+	if src.Has("age") {
+		v, err := src.Int("age", 64)
+		if err != nil {
+			errs.Add("age", "age must be int")
+		} else {
+			tmp := int(v)
+			params.Age = &tmp
+		}
+	}
+
+	// Rating
+	{
+		v, err := src.Float("rating", 64)
+		if err != nil {
+			errs.Add("rating", "rating must be float")
+		} else {
+			params.Rating = v
+		}
+	}
+
+	// Visits
+	{
+		v, err := src.Uint("visits", 32)
+		if err != nil {
+			errs.Add("visits", "visits must be uint")
+		} else {
+			params.Visits = uint32(v)
+		}
+	}
+
+	// Verified
+	if src.Has("verified") {
+		v, err := src.Bool("verified")
+		if err != nil {
+			errs.Add("verified", "verified must be bool")
+		} else {
+			tmp := v
+			params.Verified = &tmp
+		}
+	}
+
+	// Birthday
+	{
+		v, err := src.Time("birthday")
+		if err != nil {
+			errs.Add("birthday", "birthday must be RFC3339 time")
+		} else {
+			params.Birthday = v
+		}
+	}
+
+	// Tags
+	{
+		v, err := src.Strings("tags")
+		if err != nil {
+			errs.Add("tags", "tags must be list of strings")
+		} else {
+			params.Tags = v
+		}
+	}
+
+	// Address
+	if src.Has("address") {
+		params.Address = new(Address)
+		if err := src.Decode("address", params.Address); err != nil {
+			errs.Add("address", "address must be object")
 		}
 	}
 
-	if !enumClassValid {
-		sendError(w, "class must be one of [" + strings.Join(enumClass, ", ") + "]", http.StatusBadRequest)
+	validateInfoParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.SetInfo(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
 		return
 	}
-	//Level
-	params.Level, err = strconv.Atoi(q.Get("level"))
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
+
+	b, err := json.Marshal(result)
 	if err != nil {
-		sendError(w, "age must be int", http.StatusBadRequest)
-		return	
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+}
+
+// validateInfoParams проверяет InfoParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateInfoParams(params *InfoParams, errs *apigen.Violations) {
+	// Login
+	if !errs.Failed("login") && params.Login == "" {
+		errs.Add("login", "login must me not empty")
 	}
-	if params.Level < 1 {
-		sendError(w, "level must be >= 1", http.StatusBadRequest)
-		return	
+
+	// Age
+	if !errs.Failed("age") && params.Age != nil {
+		switch {
+		case *params.Age < 0:
+			errs.Add("age", "age must be >= 0")
+		case *params.Age > 128:
+			errs.Add("age", "age must be <= 128")
+		}
+	}
+
+	// Rating
+	if !errs.Failed("rating") {
+		switch {
+		case params.Rating < 0:
+			errs.Add("rating", "rating must be >= 0")
+		case params.Rating > 5:
+			errs.Add("rating", "rating must be <= 5")
+		}
+	}
+
+	// Visits
+	if !errs.Failed("visits") {
+		switch {
+		case params.Visits > 100000:
+			errs.Add("visits", "visits must be <= 100000")
+		}
+	}
+
+	// Verified
+
+	// Birthday
+
+	// Tags
+	if !errs.Failed("tags") {
+		switch {
+		case len(params.Tags) > 3:
+			errs.Add("tags", "tags len must be <= 3")
+		}
+	}
+
+	// Address
+
+}
+
+func (h *MyApiHandler) handleInfo(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
 	}
-	if params.Level > 50 {
-		sendError(w, "level must be <= 50", http.StatusBadRequest)
-		return	
+	// заполнение структуры params
+	params := InfoRequest{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
 	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
 
+	// Login
+	{
+		v, err := src.String("login")
+		if err != nil {
+			errs.Add("login", "login must be string")
+		} else {
+			params.Login = v
+		}
+	}
 
-	user, err := srv.Create(context.TODO(), params)
+	validateInfoRequest(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.Info(ctx, params)
 	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
 		switch err := err.(type) {
 		case ApiError:
 			sendError(w, err.Error(), err.HTTPStatus)
@@ -248,25 +670,2432 @@ func (srv *OtherApi) CreateWrapped(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, string(b))
 }
 
-func (srv *MyApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	
-	case "/user/profile":
-		srv.ProfileWrapped(w, r)
-	
-	case "/user/create":
-		srv.CreateWrapped(w, r)
-	default:
-		sendError(w, "unknown method", http.StatusNotFound)
+// validateInfoRequest проверяет InfoRequest по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateInfoRequest(params *InfoRequest, errs *apigen.Violations) {
+	// Login
+	if !errs.Failed("login") && params.Login == "" {
+		errs.Add("login", "login must me not empty")
 	}
+
 }
 
-func (srv *OtherApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	
-	case "/user/create":
-		srv.CreateWrapped(w, r)
-	default:
-		sendError(w, "unknown method", http.StatusNotFound)
+func (h *MyApiHandler) handleSignup(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	// заполнение структуры params
+	params := SignupParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Login
+	{
+		v, err := src.String("login")
+		if err != nil {
+			errs.Add("login", "login must be string")
+		} else {
+			params.Login = v
+		}
+	}
+
+	// Email
+	{
+		v, err := src.String("email")
+		if err != nil {
+			errs.Add("email", "email must be string")
+		} else {
+			params.Email = v
+		}
+	}
+
+	// Site
+	{
+		v, err := src.String("site")
+		if err != nil {
+			errs.Add("site", "site must be string")
+		} else {
+			params.Site = v
+		}
+	}
+
+	// Password
+	{
+		v, err := src.String("password")
+		if err != nil {
+			errs.Add("password", "password must be string")
+		} else {
+			params.Password = v
+		}
+	}
+
+	// PasswordConfirm
+	{
+		v, err := src.String("password_confirm")
+		if err != nil {
+			errs.Add("password_confirm", "password_confirm must be string")
+		} else {
+			params.PasswordConfirm = v
+		}
+	}
+
+	// Level
+	{
+		v, err := src.Int("level", 64)
+		if err != nil {
+			errs.Add("level", "level must be int")
+		} else {
+			params.Level = int(v)
+		}
+	}
+
+	// Invite
+	{
+		v, err := src.String("invite")
+		if err != nil {
+			errs.Add("invite", "invite must be string")
+		} else {
+			params.Invite = v
+		}
+	}
+
+	validateSignupParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.Signup(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+}
+
+// validateSignupParams проверяет SignupParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateSignupParams(params *SignupParams, errs *apigen.Violations) {
+	// Login
+	if !errs.Failed("login") && params.Login == "" {
+		errs.Add("login", "login must me not empty")
+	}
+	if !errs.Failed("login") {
+		switch {
+		case !apigen.MatchRegexp("^[a-z][a-z0-9_.]{2,15}$", params.Login):
+			errs.Add("login", "login must match ^[a-z][a-z0-9_.]{2,15}$")
+		}
+	}
+
+	// Email
+	if !errs.Failed("email") && params.Email == "" {
+		errs.Add("email", "email must me not empty")
+	}
+	if !errs.Failed("email") {
+		switch {
+		case !apigen.IsEmail(params.Email):
+			errs.Add("email", "email must be email")
+		}
+	}
+
+	// Site
+	if !errs.Failed("site") {
+		switch {
+		case params.Site != "" && !apigen.IsURL(params.Site):
+			errs.Add("site", "site must be url")
+		}
+	}
+
+	// Password
+	if !errs.Failed("password") && params.Password == "" {
+		errs.Add("password", "password must me not empty")
+	}
+	if !errs.Failed("password") {
+		if err := strongPassword(params.Password); err != nil {
+			errs.Add("password", "password "+err.Error())
+		}
+	}
+
+	// PasswordConfirm
+	if !errs.Failed("password_confirm") && params.PasswordConfirm == "" {
+		errs.Add("password_confirm", "password_confirm must me not empty")
+	}
+
+	// Level
+	if params.Level == 0 {
+		params.Level = 1
+	}
+	if !errs.Failed("level") {
+		switch {
+		case !apigen.OneOfInt(int64(params.Level), 1, 2, 3):
+			errs.Add("level", "level must be one of [1, 2, 3]")
+		}
+	}
+
+	// Invite
+	if !errs.Failed("invite") {
+		switch {
+		case params.Invite != "" && len(params.Invite) != 8:
+			errs.Add("invite", "invite len must be 8")
+		}
+	}
+
+	if !errs.Failed("password") && !errs.Failed("password_confirm") && params.Password != params.PasswordConfirm {
+		errs.Add("password", "password must be equal to password_confirm")
+	}
+}
+
+func (h *MyApiHandler) handleUploadAvatar(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, 2097152)
+	}
+	principal, err := apigen.Authenticate(h.opts.Auth, r)
+	if err == apigen.ErrBodyTooLarge {
+		sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		sendError(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	// заполнение структуры params
+	params := AvatarParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Avatar
+	{
+		v, err := src.File("avatar", 1048576)
+		if err != nil {
+			errs.Add("avatar", "avatar "+err.Error())
+		} else if v != nil {
+			params.Avatar = v
+		}
+	}
+
+	validateAvatarParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.UploadAvatar(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+}
+
+// validateAvatarParams проверяет AvatarParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateAvatarParams(params *AvatarParams, errs *apigen.Violations) {
+	// Avatar
+	if !errs.Failed("avatar") && params.Avatar == nil {
+		errs.Add("avatar", "avatar must me not empty")
+	}
+
+}
+
+func (h *MyApiHandler) handleAvatar(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	// заполнение структуры params
+	params := AvatarRequest{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Login
+	{
+		v, err := src.String("login")
+		if err != nil {
+			errs.Add("login", "login must be string")
+		} else {
+			params.Login = v
+		}
+	}
+
+	validateAvatarRequest(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	blob, err := h.srv.Avatar(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	apigen.ServeBlob(w, blob)
+}
+
+// validateAvatarRequest проверяет AvatarRequest по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateAvatarRequest(params *AvatarRequest, errs *apigen.Violations) {
+	// Login
+	if !errs.Failed("login") && params.Login == "" {
+		errs.Add("login", "login must me not empty")
+	}
+
+}
+
+func (h *MyApiHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	// заполнение структуры params
+	params := ListParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Status
+	if src.Has("status") {
+		v, err := src.String("status")
+		if err != nil {
+			errs.Add("status", "status must be string")
+		} else {
+			tmp := v
+			params.Status = &tmp
+		}
+	}
+
+	validateListParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := h.srv.List(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	stream := apigen.NewStream(ctx, w, r, "ndjson")
+	for item := range items {
+		// клиент ушёл - дочитываем канал, чтобы не повесить горутину, которая в него пишет
+		if err == nil {
+			err = stream.Send(item)
+		}
+	}
+}
+
+// validateListParams проверяет ListParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateListParams(params *ListParams, errs *apigen.Violations) {
+	// Status
+	if !errs.Failed("status") && params.Status != nil {
+		switch {
+		case !apigen.OneOf(*params.Status, "user", "moderator", "admin"):
+			errs.Add("status", "status must be one of [user, moderator, admin]")
+		}
+	}
+
+}
+
+func (h *MyApiHandler) handleLogins(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	// заполнение структуры params
+	params := LoginsParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Prefix
+	{
+		v, err := src.String("prefix")
+		if err != nil {
+			errs.Add("prefix", "prefix must be string")
+		} else {
+			params.Prefix = v
+		}
+	}
+
+	validateLoginsParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := h.srv.Logins(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	stream := apigen.NewStream(ctx, w, r, "sse")
+	for item := range items {
+		if err := stream.Send(item); err != nil {
+			break
+		}
+	}
+}
+
+// validateLoginsParams проверяет LoginsParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateLoginsParams(params *LoginsParams, errs *apigen.Violations) {
+	// Prefix
+
+}
+
+func (h *MyApiHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	ctx, cancel := context.WithTimeout(ctx, 200*time.Millisecond)
+	defer cancel()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	// заполнение структуры params
+	params := SearchParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Query
+	{
+		v, err := src.String("query")
+		if err != nil {
+			errs.Add("query", "query must be string")
+		} else {
+			params.Query = v
+		}
+	}
+
+	validateSearchParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.Search(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+}
+
+// validateSearchParams проверяет SearchParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateSearchParams(params *SearchParams, errs *apigen.Violations) {
+	// Query
+	if !errs.Failed("query") && params.Query == "" {
+		errs.Add("query", "query must me not empty")
+	}
+
+}
+
+func (h *OtherApiHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, apigen.DefaultMaxBody)
+	}
+	principal, err := apigen.Authenticate(h.opts.Auth, r)
+	if err == apigen.ErrBodyTooLarge {
+		sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		sendError(w, "unauthorized", http.StatusForbidden)
+		return
+	}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	// заполнение структуры params
+	params := OtherCreateParams{}
+	src, err := apigen.ReadParams(r)
+	if err != nil {
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
+	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
+
+	// Username
+	{
+		v, err := src.String("username")
+		if err != nil {
+			errs.Add("username", "username must be string")
+		} else {
+			params.Username = v
+		}
+	}
+
+	// Name
+	{
+		v, err := src.String("account_name")
+		if err != nil {
+			errs.Add("account_name", "account_name must be string")
+		} else {
+			params.Name = v
+		}
+	}
+
+	// Class
+	{
+		v, err := src.String("class")
+		if err != nil {
+			errs.Add("class", "class must be string")
+		} else {
+			params.Class = v
+		}
+	}
+
+	// Level
+	{
+		v, err := src.Int("level", 64)
+		if err != nil {
+			errs.Add("level", "level must be int")
+		} else {
+			params.Level = int(v)
+		}
+	}
+
+	validateOtherCreateParams(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
+	}
+	user, err := h.srv.Create(ctx, params)
+	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
+		switch err := err.(type) {
+		case ApiError:
+			sendError(w, err.Error(), err.HTTPStatus)
+		default:
+			sendError(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
+
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+}
+
+// validateOtherCreateParams проверяет OtherCreateParams по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validateOtherCreateParams(params *OtherCreateParams, errs *apigen.Violations) {
+	// Username
+	if !errs.Failed("username") && params.Username == "" {
+		errs.Add("username", "username must me not empty")
+	}
+	if !errs.Failed("username") {
+		switch {
+		case len(params.Username) < 3:
+			errs.Add("username", "username len must be >= 3")
+		}
+	}
+
+	// Name
+
+	// Class
+	if params.Class == "" {
+		params.Class = "warrior"
+	}
+	if !errs.Failed("class") {
+		switch {
+		case !apigen.OneOf(params.Class, "warrior", "sorcerer", "rouge"):
+			errs.Add("class", "class must be one of [warrior, sorcerer, rouge]")
+		}
+	}
+
+	// Level
+	if !errs.Failed("level") {
+		switch {
+		case params.Level < 1:
+			errs.Add("level", "level must be >= 1")
+		case params.Level > 50:
+			errs.Add("level", "level must be <= 50")
+		}
+	}
+
+}
+
+// MyApiHandler - http-обёртка над MyApi, создаётся через NewMyApiHandler
+type MyApiHandler struct {
+	srv  *MyApi
+	opts apigen.Options
+	// роутер, обёрнутый в общие middleware
+	handler http.Handler
+	// методы api, обёрнутые в свои middleware
+	endpoints map[string]http.Handler
+	// OpenAPI описание со схемами авторизации из opts.Auth
+	spec string
+}
+
+func NewMyApiHandler(srv *MyApi, opts apigen.Options) *MyApiHandler {
+	h := &MyApiHandler{
+		srv:  srv,
+		opts: opts,
+	}
+	h.endpoints = map[string]http.Handler{
+		"Profile":      apigen.Chain(http.HandlerFunc(h.handleProfile), opts.Endpoint["Profile"]...),
+		"Create":       apigen.Chain(http.HandlerFunc(h.handleCreate), opts.Endpoint["Create"]...),
+		"Me":           apigen.Chain(http.HandlerFunc(h.handleMe), opts.Endpoint["Me"]...),
+		"SetStatus":    apigen.Chain(http.HandlerFunc(h.handleSetStatus), opts.Endpoint["SetStatus"]...),
+		"SetInfo":      apigen.Chain(http.HandlerFunc(h.handleSetInfo), opts.Endpoint["SetInfo"]...),
+		"Info":         apigen.Chain(http.HandlerFunc(h.handleInfo), opts.Endpoint["Info"]...),
+		"Signup":       apigen.Chain(http.HandlerFunc(h.handleSignup), opts.Endpoint["Signup"]...),
+		"UploadAvatar": apigen.Chain(http.HandlerFunc(h.handleUploadAvatar), opts.Endpoint["UploadAvatar"]...),
+		"Avatar":       apigen.Chain(http.HandlerFunc(h.handleAvatar), opts.Endpoint["Avatar"]...),
+		"List":         apigen.Chain(http.HandlerFunc(h.handleList), opts.Endpoint["List"]...),
+		"Logins":       apigen.Chain(http.HandlerFunc(h.handleLogins), opts.Endpoint["Logins"]...),
+		"Search":       apigen.Chain(http.HandlerFunc(h.handleSearch), opts.Endpoint["Search"]...),
+	}
+	h.handler = apigen.Chain(http.HandlerFunc(h.route), opts.Middleware...)
+	if opts.OpenAPI {
+		h.spec = apigen.OpenAPI(openAPIMyApi, opts.Auth)
+	}
+	return h
+}
+
+// defaultMyApiHandlers - хендлеры ServeHTTP самого MyApi, по одному на экземпляр
+var defaultMyApiHandlers sync.Map
+
+// ServeHTTP без настроек: авторизация как раньше, ключом apigen.LegacyKey в X-Auth.
+// Свои Authenticator и middleware - через NewMyApiHandler
+func (srv *MyApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := defaultMyApiHandlers.Load(srv)
+	if !ok {
+		h, _ = defaultMyApiHandlers.LoadOrStore(srv, NewMyApiHandler(srv, apigen.Options{
+			Auth: apigen.LegacyAuth("admin"),
+		}))
+	}
+	h.(*MyApiHandler).ServeHTTP(w, r)
+}
+
+// openAPIMyApi - описание MyApi в формате OpenAPI 3
+const openAPIMyApi = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "MyApi",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/user/avatar": {
+      "post": {
+        "operationId": "UploadAvatar",
+        "summary": "загрузка файла формой, как в week6lec/rabbit/form.go, только форму разбирает сгенерированный код",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "multipart/form-data": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "avatar": {
+                    "type": "string",
+                    "format": "binary"
+                  }
+                },
+                "required": [
+                  "avatar"
+                ]
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/UploadedFile"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "403": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "413": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        },
+        "x-apigen-auth": true
+      }
+    },
+    "/user/create": {
+      "post": {
+        "operationId": "Create",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "age": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 0,
+                    "maximum": 128
+                  },
+                  "full_name": {
+                    "type": "string"
+                  },
+                  "login": {
+                    "type": "string",
+                    "minLength": 10
+                  },
+                  "status": {
+                    "type": "string",
+                    "enum": [
+                      "user",
+                      "moderator",
+                      "admin"
+                    ],
+                    "default": "user"
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            },
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "age": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 0,
+                    "maximum": 128
+                  },
+                  "full_name": {
+                    "type": "string"
+                  },
+                  "login": {
+                    "type": "string",
+                    "minLength": 10
+                  },
+                  "status": {
+                    "type": "string",
+                    "enum": [
+                      "user",
+                      "moderator",
+                      "admin"
+                    ],
+                    "default": "user"
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/NewUser"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "403": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        },
+        "x-apigen-auth": true
+      }
+    },
+    "/user/info": {
+      "post": {
+        "operationId": "SetInfo",
+        "summary": "параметры можно передать формой или json-телом",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "address": {
+                    "$ref": "#/components/schemas/Address"
+                  },
+                  "age": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 0,
+                    "maximum": 128
+                  },
+                  "birthday": {
+                    "type": "string",
+                    "format": "date-time"
+                  },
+                  "login": {
+                    "type": "string"
+                  },
+                  "rating": {
+                    "type": "number",
+                    "format": "double",
+                    "minimum": 0,
+                    "maximum": 5
+                  },
+                  "tags": {
+                    "type": "array",
+                    "items": {
+                      "type": "string"
+                    },
+                    "maxItems": 3
+                  },
+                  "verified": {
+                    "type": "boolean"
+                  },
+                  "visits": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 0,
+                    "maximum": 100000
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            },
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "address": {
+                    "$ref": "#/components/schemas/Address"
+                  },
+                  "age": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 0,
+                    "maximum": 128
+                  },
+                  "birthday": {
+                    "type": "string",
+                    "format": "date-time"
+                  },
+                  "login": {
+                    "type": "string"
+                  },
+                  "rating": {
+                    "type": "number",
+                    "format": "double",
+                    "minimum": 0,
+                    "maximum": 5
+                  },
+                  "tags": {
+                    "type": "array",
+                    "items": {
+                      "type": "string"
+                    },
+                    "maxItems": 3
+                  },
+                  "verified": {
+                    "type": "boolean"
+                  },
+                  "visits": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 0,
+                    "maximum": 100000
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/UserInfo"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "403": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        },
+        "x-apigen-auth": true
+      }
+    },
+    "/user/list": {
+      "get": {
+        "operationId": "List",
+        "summary": "все пользователи по логину, по одному на строку",
+        "parameters": [
+          {
+            "name": "status",
+            "in": "query",
+            "schema": {
+              "type": "string",
+              "enum": [
+                "user",
+                "moderator",
+                "admin"
+              ]
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "поток, формат по умолчанию ndjson, другой можно попросить через Accept",
+            "content": {
+              "application/x-ndjson": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/User"
+                    }
+                  }
+                }
+              },
+              "text/event-stream": {
+                "schema": {
+                  "$ref": "#/components/schemas/User"
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/user/logins": {
+      "get": {
+        "operationId": "Logins",
+        "summary": "логины по одному, по умолчанию как server-sent events",
+        "parameters": [
+          {
+            "name": "prefix",
+            "in": "query",
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "поток, формат по умолчанию sse, другой можно попросить через Accept",
+            "content": {
+              "application/x-ndjson": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "type": "string"
+                    }
+                  }
+                }
+              },
+              "text/event-stream": {
+                "schema": {
+                  "type": "string"
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/user/me": {
+      "get": {
+        "operationId": "MeGet",
+        "summary": "пользователь, от имени которого пришёл запрос, лежит в контексте",
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/User"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "403": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        },
+        "x-apigen-auth": true
+      },
+      "post": {
+        "operationId": "MePost",
+        "summary": "пользователь, от имени которого пришёл запрос, лежит в контексте",
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/User"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "403": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        },
+        "x-apigen-auth": true
+      }
+    },
+    "/user/profile": {
+      "get": {
+        "operationId": "ProfileGet",
+        "parameters": [
+          {
+            "name": "login",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/User"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      },
+      "post": {
+        "operationId": "ProfilePost",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "login": {
+                    "type": "string"
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            },
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "login": {
+                    "type": "string"
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/User"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/user/search": {
+      "get": {
+        "operationId": "Search",
+        "summary": "поиск ходит в медленное хранилище, поэтому у него свой таймаут",
+        "parameters": [
+          {
+            "name": "query",
+            "in": "query",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/SearchResult"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          },
+          "504": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/user/signup": {
+      "post": {
+        "operationId": "Signup",
+        "summary": "регистрация, все нарушения правил приходят одним ответом",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "email": {
+                    "type": "string",
+                    "format": "email"
+                  },
+                  "invite": {
+                    "type": "string",
+                    "minLength": 8,
+                    "maxLength": 8
+                  },
+                  "level": {
+                    "type": "integer",
+                    "format": "int64",
+                    "enum": [
+                      1,
+                      2,
+                      3
+                    ],
+                    "default": 1
+                  },
+                  "login": {
+                    "type": "string",
+                    "pattern": "^[a-z][a-z0-9_.]{2,15}$"
+                  },
+                  "password": {
+                    "type": "string"
+                  },
+                  "password_confirm": {
+                    "type": "string"
+                  },
+                  "site": {
+                    "type": "string",
+                    "format": "uri"
+                  }
+                },
+                "required": [
+                  "login",
+                  "email",
+                  "password",
+                  "password_confirm"
+                ]
+              }
+            },
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "email": {
+                    "type": "string",
+                    "format": "email"
+                  },
+                  "invite": {
+                    "type": "string",
+                    "minLength": 8,
+                    "maxLength": 8
+                  },
+                  "level": {
+                    "type": "integer",
+                    "format": "int64",
+                    "enum": [
+                      1,
+                      2,
+                      3
+                    ],
+                    "default": 1
+                  },
+                  "login": {
+                    "type": "string",
+                    "pattern": "^[a-z][a-z0-9_.]{2,15}$"
+                  },
+                  "password": {
+                    "type": "string"
+                  },
+                  "password_confirm": {
+                    "type": "string"
+                  },
+                  "site": {
+                    "type": "string",
+                    "format": "uri"
+                  }
+                },
+                "required": [
+                  "login",
+                  "email",
+                  "password",
+                  "password_confirm"
+                ]
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/NewUser"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/user/status": {
+      "post": {
+        "operationId": "SetStatus",
+        "summary": "менять статус может только админ",
+        "description": "нужны роли: admin",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "login": {
+                    "type": "string"
+                  },
+                  "status": {
+                    "type": "string",
+                    "enum": [
+                      "user",
+                      "moderator",
+                      "admin"
+                    ],
+                    "default": "user"
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            },
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "login": {
+                    "type": "string"
+                  },
+                  "status": {
+                    "type": "string",
+                    "enum": [
+                      "user",
+                      "moderator",
+                      "admin"
+                    ],
+                    "default": "user"
+                  }
+                },
+                "required": [
+                  "login"
+                ]
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/User"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "403": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        },
+        "x-apigen-auth": true
+      }
+    },
+    "/user/{login}/avatar": {
+      "get": {
+        "operationId": "Avatar",
+        "summary": "картинка отдаётся как есть, со своим Content-Type",
+        "parameters": [
+          {
+            "name": "login",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "содержимое как есть",
+            "content": {
+              "application/octet-stream": {
+                "schema": {
+                  "type": "string",
+                  "format": "binary"
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    },
+    "/user/{login}/info": {
+      "get": {
+        "operationId": "Info",
+        "summary": "логин берётся из пути",
+        "parameters": [
+          {
+            "name": "login",
+            "in": "path",
+            "required": true,
+            "schema": {
+              "type": "string"
+            }
+          }
+        ],
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/UserInfo"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        }
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Address": {
+        "type": "object",
+        "properties": {
+          "city": {
+            "type": "string"
+          },
+          "street": {
+            "type": "string"
+          }
+        }
+      },
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "error"
+        ]
+      },
+      "NewUser": {
+        "type": "object",
+        "properties": {
+          "id": {
+            "type": "integer",
+            "format": "int64",
+            "minimum": 0
+          }
+        }
+      },
+      "SearchResult": {
+        "type": "object",
+        "properties": {
+          "logins": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          },
+          "request_id": {
+            "type": "string"
+          }
+        }
+      },
+      "UploadedFile": {
+        "type": "object",
+        "properties": {
+          "md5": {
+            "type": "string"
+          },
+          "name": {
+            "type": "string"
+          },
+          "size": {
+            "type": "integer",
+            "format": "int64"
+          }
+        }
+      },
+      "User": {
+        "type": "object",
+        "properties": {
+          "full_name": {
+            "type": "string"
+          },
+          "id": {
+            "type": "integer",
+            "format": "int64",
+            "minimum": 0
+          },
+          "login": {
+            "type": "string"
+          },
+          "status": {
+            "type": "integer",
+            "format": "int64"
+          }
+        }
+      },
+      "UserInfo": {
+        "type": "object",
+        "properties": {
+          "address": {
+            "$ref": "#/components/schemas/Address"
+          },
+          "age": {
+            "type": "integer",
+            "format": "int64"
+          },
+          "birthday": {
+            "type": "string",
+            "format": "date-time",
+            "nullable": true
+          },
+          "login": {
+            "type": "string"
+          },
+          "rating": {
+            "type": "number",
+            "format": "double"
+          },
+          "tags": {
+            "type": "array",
+            "items": {
+              "type": "string"
+            }
+          },
+          "verified": {
+            "type": "boolean"
+          },
+          "visits": {
+            "type": "integer",
+            "format": "int64",
+            "minimum": 0
+          }
+        }
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "ошибка, текст в поле error",
+        "content": {
+          "application/json": {
+            "schema": {
+              "$ref": "#/components/schemas/Error"
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+func (h *MyApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, apigen.WithRequestID(w, r))
+}
+
+// route выбирает метод api по пути и http-методу.
+// пути без параметров проверяются раньше, чтобы /user/profile не ушёл в /user/{login}
+func (h *MyApiHandler) route(w http.ResponseWriter, r *http.Request) {
+	if h.opts.OpenAPI && r.URL.Path == apigen.OpenAPIPath {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, h.spec)
+		return
+	}
+	if r.URL.Path == "/user/profile" {
+		h.endpoints["Profile"].ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == "/user/create" {
+		switch r.Method {
+		case "POST":
+			h.endpoints["Create"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "POST")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.URL.Path == "/user/me" {
+		h.endpoints["Me"].ServeHTTP(w, r)
+		return
+	}
+	if r.URL.Path == "/user/status" {
+		switch r.Method {
+		case "POST":
+			h.endpoints["SetStatus"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "POST")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.URL.Path == "/user/info" {
+		switch r.Method {
+		case "POST":
+			h.endpoints["SetInfo"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "POST")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.URL.Path == "/user/signup" {
+		switch r.Method {
+		case "POST":
+			h.endpoints["Signup"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "POST")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.URL.Path == "/user/avatar" {
+		switch r.Method {
+		case "POST":
+			h.endpoints["UploadAvatar"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "POST")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.URL.Path == "/user/list" {
+		switch r.Method {
+		case "GET":
+			h.endpoints["List"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.URL.Path == "/user/logins" {
+		switch r.Method {
+		case "GET":
+			h.endpoints["Logins"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if r.URL.Path == "/user/search" {
+		switch r.Method {
+		case "GET":
+			h.endpoints["Search"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if vars, ok := apigen.MatchPath("/user/{login}/info", r.URL.Path); ok {
+		r = apigen.WithPathParams(r, vars)
+		switch r.Method {
+		case "GET":
+			h.endpoints["Info"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	if vars, ok := apigen.MatchPath("/user/{login}/avatar", r.URL.Path); ok {
+		r = apigen.WithPathParams(r, vars)
+		switch r.Method {
+		case "GET":
+			h.endpoints["Avatar"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "GET")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	sendError(w, "unknown method", http.StatusNotFound)
+}
+
+// MyApiClient - клиент к MyApi, параметры кодируются так же, как их разбирает MyApiHandler.
+// Ошибки сервера возвращаются как ApiError со статусом ответа
+type MyApiClient struct {
+	apigen.Client
+}
+
+func NewMyApiClient(baseURL string) *MyApiClient {
+	return &MyApiClient{apigen.Client{BaseURL: baseURL}}
+}
+
+func (c *MyApiClient) Profile(ctx context.Context, in ProfileParams) (*User, error) {
+	args := &apigen.Args{}
+	args.Set("login", in.Login)
+	out := &User{}
+	if err := c.Call(ctx, "GET", "/user/profile", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+func (c *MyApiClient) Create(ctx context.Context, in CreateParams) (*NewUser, error) {
+	args := &apigen.Args{}
+	args.Set("login", in.Login)
+	args.Set("full_name", in.Name)
+	args.Set("status", in.Status)
+	args.Set("age", in.Age)
+	out := &NewUser{}
+	if err := c.Call(ctx, "POST", "/user/create", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+func (c *MyApiClient) Me(ctx context.Context, in MeParams) (*User, error) {
+	args := &apigen.Args{}
+	out := &User{}
+	if err := c.Call(ctx, "GET", "/user/me", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+func (c *MyApiClient) SetStatus(ctx context.Context, in StatusParams) (*User, error) {
+	args := &apigen.Args{}
+	args.Set("login", in.Login)
+	args.Set("status", in.Status)
+	out := &User{}
+	if err := c.Call(ctx, "POST", "/user/status", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+func (c *MyApiClient) SetInfo(ctx context.Context, in InfoParams) (*UserInfo, error) {
+	args := &apigen.Args{}
+	args.Set("login", in.Login)
+	if in.Age != nil {
+		args.Set("age", *in.Age)
+	}
+	args.Set("rating", in.Rating)
+	args.Set("visits", in.Visits)
+	if in.Verified != nil {
+		args.Set("verified", *in.Verified)
+	}
+	args.Set("birthday", in.Birthday)
+	args.Set("tags", in.Tags)
+	if in.Address != nil {
+		args.Set("address", *in.Address)
+	}
+	out := &UserInfo{}
+	if err := c.Call(ctx, "POST", "/user/info", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+func (c *MyApiClient) Info(ctx context.Context, in InfoRequest) (*UserInfo, error) {
+	args := &apigen.Args{}
+	args.Set("login", in.Login)
+	out := &UserInfo{}
+	if err := c.Call(ctx, "GET", "/user/{login}/info", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+func (c *MyApiClient) Signup(ctx context.Context, in SignupParams) (*NewUser, error) {
+	args := &apigen.Args{}
+	args.Set("login", in.Login)
+	args.Set("email", in.Email)
+	args.Set("site", in.Site)
+	args.Set("password", in.Password)
+	args.Set("password_confirm", in.PasswordConfirm)
+	args.Set("level", in.Level)
+	args.Set("invite", in.Invite)
+	out := &NewUser{}
+	if err := c.Call(ctx, "POST", "/user/signup", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+func (c *MyApiClient) UploadAvatar(ctx context.Context, in AvatarParams) (*UploadedFile, error) {
+	args := &apigen.Args{}
+	if in.Avatar != nil {
+		args.Set("avatar", in.Avatar)
+	}
+	out := &UploadedFile{}
+	if err := c.Call(ctx, "POST", "/user/avatar", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+// Avatar - Body ответа надо закрыть
+func (c *MyApiClient) Avatar(ctx context.Context, in AvatarRequest) (*apigen.Blob, error) {
+	args := &apigen.Args{}
+	args.Set("login", in.Login)
+	blob, err := c.Download(ctx, "GET", "/user/{login}/avatar", args)
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return blob, nil
+}
+
+// List отдаёт элементы потока в fn по мере получения, ошибка из fn прекращает чтение
+func (c *MyApiClient) List(ctx context.Context, in ListParams, fn func(*User) error) error {
+	args := &apigen.Args{}
+	if in.Status != nil {
+		args.Set("status", *in.Status)
+	}
+	err := c.Stream(ctx, "GET", "/user/list", args, func(raw json.RawMessage) error {
+		var item *User
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		return fn(item)
+	})
+	return clientError(err)
+}
+
+// Logins отдаёт элементы потока в fn по мере получения, ошибка из fn прекращает чтение
+func (c *MyApiClient) Logins(ctx context.Context, in LoginsParams, fn func(string) error) error {
+	args := &apigen.Args{}
+	args.Set("prefix", in.Prefix)
+	err := c.Stream(ctx, "GET", "/user/logins", args, func(raw json.RawMessage) error {
+		var item string
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		return fn(item)
+	})
+	return clientError(err)
+}
+
+func (c *MyApiClient) Search(ctx context.Context, in SearchParams) (*SearchResult, error) {
+	args := &apigen.Args{}
+	args.Set("query", in.Query)
+	out := &SearchResult{}
+	if err := c.Call(ctx, "GET", "/user/search", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+}
+
+// OtherApiHandler - http-обёртка над OtherApi, создаётся через NewOtherApiHandler
+type OtherApiHandler struct {
+	srv  *OtherApi
+	opts apigen.Options
+	// роутер, обёрнутый в общие middleware
+	handler http.Handler
+	// методы api, обёрнутые в свои middleware
+	endpoints map[string]http.Handler
+	// OpenAPI описание со схемами авторизации из opts.Auth
+	spec string
+}
+
+func NewOtherApiHandler(srv *OtherApi, opts apigen.Options) *OtherApiHandler {
+	h := &OtherApiHandler{
+		srv:  srv,
+		opts: opts,
+	}
+	h.endpoints = map[string]http.Handler{
+		"Create": apigen.Chain(http.HandlerFunc(h.handleCreate), opts.Endpoint["Create"]...),
+	}
+	h.handler = apigen.Chain(http.HandlerFunc(h.route), opts.Middleware...)
+	if opts.OpenAPI {
+		h.spec = apigen.OpenAPI(openAPIOtherApi, opts.Auth)
+	}
+	return h
+}
+
+// defaultOtherApiHandlers - хендлеры ServeHTTP самого OtherApi, по одному на экземпляр
+var defaultOtherApiHandlers sync.Map
+
+// ServeHTTP без настроек: авторизация как раньше, ключом apigen.LegacyKey в X-Auth.
+// Свои Authenticator и middleware - через NewOtherApiHandler
+func (srv *OtherApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h, ok := defaultOtherApiHandlers.Load(srv)
+	if !ok {
+		h, _ = defaultOtherApiHandlers.LoadOrStore(srv, NewOtherApiHandler(srv, apigen.Options{
+			Auth: apigen.LegacyAuth(),
+		}))
+	}
+	h.(*OtherApiHandler).ServeHTTP(w, r)
+}
+
+// openAPIOtherApi - описание OtherApi в формате OpenAPI 3
+const openAPIOtherApi = `{
+  "openapi": "3.0.3",
+  "info": {
+    "title": "OtherApi",
+    "version": "1.0.0"
+  },
+  "paths": {
+    "/user/create": {
+      "post": {
+        "operationId": "Create",
+        "requestBody": {
+          "required": true,
+          "content": {
+            "application/json": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "account_name": {
+                    "type": "string"
+                  },
+                  "class": {
+                    "type": "string",
+                    "enum": [
+                      "warrior",
+                      "sorcerer",
+                      "rouge"
+                    ],
+                    "default": "warrior"
+                  },
+                  "level": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 1,
+                    "maximum": 50
+                  },
+                  "username": {
+                    "type": "string",
+                    "minLength": 3
+                  }
+                },
+                "required": [
+                  "username"
+                ]
+              }
+            },
+            "application/x-www-form-urlencoded": {
+              "schema": {
+                "type": "object",
+                "properties": {
+                  "account_name": {
+                    "type": "string"
+                  },
+                  "class": {
+                    "type": "string",
+                    "enum": [
+                      "warrior",
+                      "sorcerer",
+                      "rouge"
+                    ],
+                    "default": "warrior"
+                  },
+                  "level": {
+                    "type": "integer",
+                    "format": "int64",
+                    "minimum": 1,
+                    "maximum": 50
+                  },
+                  "username": {
+                    "type": "string",
+                    "minLength": 3
+                  }
+                },
+                "required": [
+                  "username"
+                ]
+              }
+            }
+          }
+        },
+        "responses": {
+          "200": {
+            "description": "успешный ответ",
+            "content": {
+              "application/json": {
+                "schema": {
+                  "type": "object",
+                  "properties": {
+                    "error": {
+                      "type": "string"
+                    },
+                    "response": {
+                      "$ref": "#/components/schemas/OtherUser"
+                    }
+                  }
+                }
+              }
+            }
+          },
+          "400": {
+            "$ref": "#/components/responses/Error"
+          },
+          "403": {
+            "$ref": "#/components/responses/Error"
+          },
+          "404": {
+            "$ref": "#/components/responses/Error"
+          },
+          "405": {
+            "$ref": "#/components/responses/Error"
+          },
+          "500": {
+            "$ref": "#/components/responses/Error"
+          }
+        },
+        "x-apigen-auth": true
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "Error": {
+        "type": "object",
+        "properties": {
+          "error": {
+            "type": "string"
+          }
+        },
+        "required": [
+          "error"
+        ]
+      },
+      "OtherUser": {
+        "type": "object",
+        "properties": {
+          "full_name": {
+            "type": "string"
+          },
+          "id": {
+            "type": "integer",
+            "format": "int64",
+            "minimum": 0
+          },
+          "level": {
+            "type": "integer",
+            "format": "int64"
+          },
+          "login": {
+            "type": "string"
+          }
+        }
+      }
+    },
+    "responses": {
+      "Error": {
+        "description": "ошибка, текст в поле error",
+        "content": {
+          "application/json": {
+            "schema": {
+              "$ref": "#/components/schemas/Error"
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+func (h *OtherApiHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, apigen.WithRequestID(w, r))
+}
+
+// route выбирает метод api по пути и http-методу.
+// пути без параметров проверяются раньше, чтобы /user/profile не ушёл в /user/{login}
+func (h *OtherApiHandler) route(w http.ResponseWriter, r *http.Request) {
+	if h.opts.OpenAPI && r.URL.Path == apigen.OpenAPIPath {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, h.spec)
+		return
+	}
+	if r.URL.Path == "/user/create" {
+		switch r.Method {
+		case "POST":
+			h.endpoints["Create"].ServeHTTP(w, r)
+		default:
+			w.Header().Set("Allow", "POST")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+	sendError(w, "unknown method", http.StatusNotFound)
+}
+
+// OtherApiClient - клиент к OtherApi, параметры кодируются так же, как их разбирает OtherApiHandler.
+// Ошибки сервера возвращаются как ApiError со статусом ответа
+type OtherApiClient struct {
+	apigen.Client
+}
+
+func NewOtherApiClient(baseURL string) *OtherApiClient {
+	return &OtherApiClient{apigen.Client{BaseURL: baseURL}}
+}
+
+func (c *OtherApiClient) Create(ctx context.Context, in OtherCreateParams) (*OtherUser, error) {
+	args := &apigen.Args{}
+	args.Set("username", in.Username)
+	args.Set("account_name", in.Name)
+	args.Set("class", in.Class)
+	args.Set("level", in.Level)
+	out := &OtherUser{}
+	if err := c.Call(ctx, "POST", "/user/create", args, out); err != nil {
+		return nil, clientError(err)
 	}
+	return out, nil
 }
@@ -0,0 +1,21 @@
+// Package apigen - общая часть для кода, который генерирует handlers_gen.
+// Сгенерированные хендлеры импортируют его, чтобы не тащить в каждый пакет
+// авторизацию и прочую обвязку.
+package apigen
+
+// Options передаются в сгенерированный New<Api>Handler
+type Options struct {
+	// проверка учётных данных для методов с "auth": true.
+	// nil - такие методы всегда отвечают unauthorized
+	Auth Authenticator
+	// отдавать OpenAPI описание api по OpenAPIPath
+	OpenAPI bool
+	// middleware для всех запросов к api, в том числе к неизвестным методам
+	Middleware []Middleware
+	// middleware отдельных методов, ключ - имя метода api, например "Create".
+	// вызываются после общих, когда метод уже выбран
+	Endpoint map[string][]Middleware
+}
+
+// OpenAPIPath - адрес OpenAPI описания, если оно включено в Options
+const OpenAPIPath = "/openapi.json"
@@ -0,0 +1,122 @@
+// Package apigrpc - общая часть gRPC-обёрток, которые генерирует handlers_gen -grpc.
+// Структуры apigen кодирует свой кодек Codec под именем "apigen": стандартный кодек proto
+// не трогается, и сервисы из protoc (как в week7) в том же процессе работают как раньше
+package apigrpc
+
+import (
+	"context"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/encoding/proto"
+	"google.golang.org/grpc/mem"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"week5/apigen"
+)
+
+// Name - имя кодека. Клиент с DialOption шлёт content-type application/grpc+apigen,
+// и сервер находит кодек по нему без всяких опций
+const Name = "apigen"
+
+// Codec кодирует структуры с MarshalProto сами, всё остальное отдаёт стандартному кодеку proto
+var Codec encoding.CodecV2 = codec{}
+
+func init() {
+	encoding.RegisterCodecV2(Codec)
+}
+
+// ServerOption - для сервера, сервисы apigen которого зовут и обычные клиенты gRPC (protoc, grpcurl):
+// их запросы приходят как proto. Сервисы из protoc на этом сервере кодек передаёт стандартному
+func ServerOption() grpc.ServerOption {
+	return grpc.ForceServerCodecV2(Codec)
+}
+
+// DialOption - для клиента, который зовёт сервисы apigen своими структурами
+func DialOption() grpc.DialOption {
+	return grpc.WithDefaultCallOptions(grpc.ForceCodecV2(Codec))
+}
+
+type codec struct{}
+
+func (codec) Name() string {
+	return Name
+}
+
+func (codec) Marshal(v interface{}) (mem.BufferSlice, error) {
+	if m, ok := v.(apigen.ProtoMessage); ok {
+		return mem.BufferSlice{mem.SliceBuffer(m.MarshalProto())}, nil
+	}
+	return encoding.GetCodecV2(proto.Name).Marshal(v)
+}
+
+func (codec) Unmarshal(data mem.BufferSlice, v interface{}) error {
+	if m, ok := v.(apigen.ProtoMessage); ok {
+		return m.UnmarshalProto(data.Materialize())
+	}
+	return encoding.GetCodecV2(proto.Name).Unmarshal(data, v)
+}
+
+// ----------------
+
+// Request - http-запрос из метаданных gRPC, чтобы проверить их теми же apigen.Authenticator:
+// ключ X-Auth приходит в метаданных x-auth. Тела у такого запроса нет, HMACAuth с ним не работает
+func Request(ctx context.Context, fullMethod string) *http.Request {
+	r, _ := http.NewRequest(http.MethodPost, fullMethod, nil)
+	r = r.WithContext(ctx)
+	md, _ := metadata.FromIncomingContext(ctx)
+	for key, values := range md {
+		if strings.HasPrefix(key, ":") {
+			continue
+		}
+		for _, v := range values {
+			r.Header.Add(textproto.CanonicalMIMEHeaderKey(key), v)
+		}
+	}
+	return r
+}
+
+// WithRequestID - id запроса из метаданных x-request-id или новый, как apigen.WithRequestID для http
+func WithRequestID(ctx context.Context) context.Context {
+	md, _ := metadata.FromIncomingContext(ctx)
+	id := ""
+	if ids := md.Get(apigen.RequestIDHeader); len(ids) > 0 {
+		id = ids[0]
+	}
+	return apigen.ContextWithRequestID(ctx, id)
+}
+
+// codesByStatus - http-статус ответа в код gRPC
+var codesByStatus = map[int]codes.Code{
+	http.StatusBadRequest:                   codes.InvalidArgument,
+	http.StatusUnauthorized:                 codes.Unauthenticated,
+	http.StatusForbidden:                    codes.PermissionDenied,
+	http.StatusNotFound:                     codes.NotFound,
+	http.StatusConflict:                     codes.AlreadyExists,
+	http.StatusPreconditionFailed:           codes.FailedPrecondition,
+	http.StatusRequestEntityTooLarge:        codes.ResourceExhausted,
+	http.StatusTooManyRequests:              codes.ResourceExhausted,
+	apigen.StatusClientClosedRequest:        codes.Canceled,
+	http.StatusNotImplemented:               codes.Unimplemented,
+	http.StatusServiceUnavailable:           codes.Unavailable,
+	http.StatusGatewayTimeout:               codes.DeadlineExceeded,
+	http.StatusRequestedRangeNotSatisfiable: codes.OutOfRange,
+}
+
+// Code - код gRPC для http-статуса, всё неизвестное - Internal
+func Code(httpStatus int) codes.Code {
+	if code, ok := codesByStatus[httpStatus]; ok {
+		return code
+	}
+	return codes.Internal
+}
+
+// Error - ошибка gRPC с кодом, соответствующим http-статусу
+func Error(httpStatus int, msg string) error {
+	return status.Error(Code(httpStatus), msg)
+}
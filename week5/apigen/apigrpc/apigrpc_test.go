@@ -0,0 +1,104 @@
+package apigrpc
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"week5/apigen"
+)
+
+// message - секунды из google.protobuf.Timestamp, но без protoc
+type message struct {
+	seconds int64
+}
+
+func (m *message) MarshalProto() []byte {
+	e := &apigen.ProtoEncoder{}
+	e.Int(1, m.seconds)
+	return e.Bytes()
+}
+
+func (m *message) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			m.seconds = d.Int()
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+
+func TestCodec(t *testing.T) {
+	// кодек регистрируется под своим именем, стандартный proto остаётся на месте
+	c := encoding.GetCodecV2(Name)
+	if _, ok := c.(codec); !ok {
+		t.Fatalf("codec is not registered: %T", c)
+	}
+	if _, ok := encoding.GetCodecV2("proto").(codec); ok {
+		t.Fatalf("standard proto codec is replaced")
+	}
+
+	// наши структуры и сообщения из protoc кодируются одинаково
+	data, err := c.Marshal(&message{seconds: 42})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	std := &timestamppb.Timestamp{}
+	if err := c.Unmarshal(data, std); err != nil || std.Seconds != 42 {
+		t.Errorf("bad protoc message: %v %v", err, std)
+	}
+	data, err = c.Marshal(&timestamppb.Timestamp{Seconds: 7, Nanos: 1})
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	m := &message{}
+	if err := c.Unmarshal(data, m); err != nil || m.seconds != 7 {
+		t.Errorf("bad message: %v %v", err, m)
+	}
+}
+
+func TestError(t *testing.T) {
+	cases := map[int]codes.Code{
+		http.StatusBadRequest:            codes.InvalidArgument,
+		http.StatusForbidden:             codes.PermissionDenied,
+		http.StatusNotFound:              codes.NotFound,
+		http.StatusConflict:              codes.AlreadyExists,
+		apigen.StatusClientClosedRequest: codes.Canceled,
+		http.StatusGatewayTimeout:        codes.DeadlineExceeded,
+		http.StatusInternalServerError:   codes.Internal,
+		http.StatusTeapot:                codes.Internal,
+	}
+	for httpStatus, code := range cases {
+		st, _ := status.FromError(Error(httpStatus, "msg"))
+		if st.Code() != code || st.Message() != "msg" {
+			t.Errorf("%d: expected %s, got %s", httpStatus, code, st.Code())
+		}
+	}
+}
+
+func TestRequest(t *testing.T) {
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		"x-auth", "100500",
+		"x-request-id", "req-1",
+	))
+	r := Request(ctx, "/main.MyApi/Create")
+	if r.Header.Get("X-Auth") != "100500" || r.URL.Path != "/main.MyApi/Create" {
+		t.Errorf("bad request: %v %v", r.URL, r.Header)
+	}
+	if id := apigen.RequestIDFrom(WithRequestID(ctx)); id != "req-1" {
+		t.Errorf("bad request id: %q", id)
+	}
+	if id := apigen.RequestIDFrom(WithRequestID(context.Background())); id == "" {
+		t.Errorf("expected generated request id")
+	}
+}
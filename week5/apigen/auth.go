@@ -0,0 +1,329 @@
+package apigen
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrUnauthorized - учётных данных нет или они не подошли
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNoCredentials - в запросе нет данных для этого способа авторизации,
+	// MultiAuth в этом случае пробует следующий
+	ErrNoCredentials = errors.New("no credentials")
+)
+
+// Principal - тот, от чьего имени пришёл запрос
+type Principal struct {
+	ID    string   `json:"sub"`
+	Roles []string `json:"roles,omitempty"`
+}
+
+// HasRoles проверяет что у пользователя есть все перечисленные роли
+func (p *Principal) HasRoles(roles ...string) bool {
+	for _, role := range roles {
+		found := false
+		for _, have := range p.Roles {
+			if have == role {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Authenticator определяет пользователя по запросу
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
+
+// AuthenticatorFunc позволяет использовать обычную функцию как Authenticator
+type AuthenticatorFunc func(r *http.Request) (*Principal, error)
+
+func (f AuthenticatorFunc) Authenticate(r *http.Request) (*Principal, error) {
+	return f(r)
+}
+
+// Authenticate вызывается из сгенерированного кода. Любая ошибка, кроме ErrBodyTooLarge,
+// превращается в ErrUnauthorized
+func Authenticate(auth Authenticator, r *http.Request) (*Principal, error) {
+	if auth == nil {
+		return nil, ErrUnauthorized
+	}
+	p, err := auth.Authenticate(r)
+	if err == ErrBodyTooLarge {
+		return nil, err
+	}
+	if err != nil || p == nil {
+		return nil, ErrUnauthorized
+	}
+	return p, nil
+}
+
+// линтер ругается если используем базовые типы в Value контекста
+type ctxKey int
+
+const principalKey ctxKey = 1
+
+// WithPrincipal кладёт пользователя в контекст, который получает метод API
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey, p)
+}
+
+// PrincipalFrom достаёт пользователя из контекста метода API
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey).(*Principal)
+	return p, ok
+}
+
+// MultiAuth пробует способы авторизации по очереди,
+// пока один из них не найдёт в запросе свои учётные данные
+type MultiAuth []Authenticator
+
+func (m MultiAuth) Authenticate(r *http.Request) (*Principal, error) {
+	for _, auth := range m {
+		p, err := auth.Authenticate(r)
+		if err == ErrNoCredentials {
+			continue
+		}
+		return p, err
+	}
+	return nil, ErrNoCredentials
+}
+
+// ----------------
+
+// DefaultAPIKeyHeader - хедер APIKeyAuth, если не задан другой
+const DefaultAPIKeyHeader = "X-Auth"
+
+// LegacyKey - ключ X-Auth, который был зашит в сгенерированный код до Authenticator
+const LegacyKey = "100500"
+
+// LegacyAuth - авторизация как до Authenticator: ключ LegacyKey в X-Auth открывает все методы,
+// поэтому у пользователя все роли api. Её получает ServeHTTP api, у которого нет Options
+func LegacyAuth(roles ...string) Authenticator {
+	return APIKeyAuth{Keys: map[string]Principal{LegacyKey: {ID: LegacyKey, Roles: roles}}}
+}
+
+// APIKeyAuth - статические ключи в хедере
+type APIKeyAuth struct {
+	// по умолчанию X-Auth
+	Header string
+	Keys   map[string]Principal
+}
+
+func (a APIKeyAuth) Authenticate(r *http.Request) (*Principal, error) {
+	header := a.Header
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	key := r.Header.Get(header)
+	if key == "" {
+		return nil, ErrNoCredentials
+	}
+	p, ok := a.Keys[key]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	return &p, nil
+}
+
+// ----------------
+
+// BearerAuth - JWT, подписанный HS256, в хедере Authorization: Bearer <token>.
+// Пользователь берётся из claims sub и roles, exp обязателен
+type BearerAuth struct {
+	Secret []byte
+	// для тестов, по умолчанию time.Now
+	Now func() time.Time
+}
+
+type jwtClaims struct {
+	Principal
+	Exp int64 `json:"exp"`
+	Nbf int64 `json:"nbf,omitempty"`
+}
+
+var jwtHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+func (a BearerAuth) Authenticate(r *http.Request) (*Principal, error) {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	parts := strings.Split(strings.TrimPrefix(header, "Bearer "), ".")
+	if len(parts) != 3 {
+		return nil, ErrUnauthorized
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	alg := struct {
+		Alg string `json:"alg"`
+	}{}
+	if json.Unmarshal(headerJSON, &alg) != nil || alg.Alg != "HS256" {
+		return nil, ErrUnauthorized
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, hmacSHA256(a.Secret, []byte(parts[0]+"."+parts[1]))) {
+		return nil, ErrUnauthorized
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	claims := jwtClaims{}
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.ID == "" {
+		return nil, ErrUnauthorized
+	}
+
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	ts := now().Unix()
+	if claims.Exp == 0 || ts >= claims.Exp || ts < claims.Nbf {
+		return nil, ErrUnauthorized
+	}
+	return &claims.Principal, nil
+}
+
+// SignJWT выпускает токен для BearerAuth
+func SignJWT(secret []byte, p Principal, ttl time.Duration) string {
+	payload, _ := json.Marshal(jwtClaims{
+		Principal: p,
+		Exp:       time.Now().Add(ttl).Unix(),
+	})
+	unsigned := jwtHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(hmacSHA256(secret, []byte(unsigned)))
+}
+
+// ----------------
+
+const (
+	HeaderKeyID     = "X-Auth-Key"
+	HeaderTimestamp = "X-Auth-Timestamp"
+	HeaderSignature = "X-Auth-Signature"
+)
+
+// HMACKey - секрет клиента и пользователь, от имени которого он ходит
+type HMACKey struct {
+	Secret    []byte
+	Principal Principal
+}
+
+// HMACAuth - запрос подписан секретом клиента, см. SignRequest
+type HMACAuth struct {
+	Keys map[string]HMACKey
+	// сколько тела читается для проверки подписи, по умолчанию DefaultMaxBody.
+	// Больше - ErrBodyTooLarge, даже если у метода max_body больше
+	MaxBody int64
+	// насколько время подписи может отличаться от текущего, по умолчанию 5 минут
+	MaxSkew time.Duration
+	// для тестов, по умолчанию time.Now
+	Now func() time.Time
+}
+
+func (a HMACAuth) Authenticate(r *http.Request) (*Principal, error) {
+	keyID := r.Header.Get(HeaderKeyID)
+	if keyID == "" {
+		return nil, ErrNoCredentials
+	}
+	key, ok := a.Keys[keyID]
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+
+	ts, err := strconv.ParseInt(r.Header.Get(HeaderTimestamp), 10, 64)
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	now := time.Now
+	if a.Now != nil {
+		now = a.Now
+	}
+	skew := a.MaxSkew
+	if skew == 0 {
+		skew = 5 * time.Minute
+	}
+	if d := now().Sub(time.Unix(ts, 0)); d > skew || d < -skew {
+		return nil, ErrUnauthorized
+	}
+
+	sig, err := hex.DecodeString(r.Header.Get(HeaderSignature))
+	if err != nil {
+		return nil, ErrUnauthorized
+	}
+	limit := a.MaxBody
+	if limit == 0 {
+		limit = DefaultMaxBody
+	}
+	expected, err := requestSignature(r, key.Secret, limit)
+	if err != nil {
+		return nil, readError(err)
+	}
+	if !hmac.Equal(sig, expected) {
+		return nil, ErrUnauthorized
+	}
+	p := key.Principal
+	return &p, nil
+}
+
+// SignRequest подписывает запрос для HMACAuth. Тело запроса читается и подменяется копией
+func SignRequest(r *http.Request, keyID string, secret []byte) error {
+	r.Header.Set(HeaderKeyID, keyID)
+	r.Header.Set(HeaderTimestamp, strconv.FormatInt(time.Now().Unix(), 10))
+	sig, err := requestSignature(r, secret, 0)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(HeaderSignature, hex.EncodeToString(sig))
+	return nil
+}
+
+// requestSignature - hmac-sha256 от метода, пути с query, времени подписи и тела.
+// тело после чтения возвращается на место, чтобы его смог разобрать хендлер.
+// limit - сколько тела можно прочитать, 0 - без ограничения (свой запрос при подписи)
+func requestSignature(r *http.Request, secret []byte, limit int64) ([]byte, error) {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		reader := r.Body
+		if limit > 0 {
+			reader = http.MaxBytesReader(nil, r.Body, limit)
+		}
+		body, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	msg := r.Method + "\n" + r.URL.RequestURI() + "\n" + r.Header.Get(HeaderTimestamp) + "\n"
+	return hmacSHA256(secret, append([]byte(msg), body...)), nil
+}
+
+func hmacSHA256(secret, data []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,99 @@
+package apigen
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHasRoles(t *testing.T) {
+	p := &Principal{ID: "rvasily", Roles: []string{"admin", "moderator"}}
+	if !p.HasRoles() || !p.HasRoles("admin") || !p.HasRoles("moderator", "admin") {
+		t.Errorf("expected roles to match")
+	}
+	if p.HasRoles("admin", "owner") {
+		t.Errorf("unexpected role owner")
+	}
+}
+
+func TestHMACAuthSkew(t *testing.T) {
+	secret := []byte("secret")
+	auth := HMACAuth{
+		Keys: map[string]HMACKey{"client": {Secret: secret, Principal: Principal{ID: "rvasily"}}},
+		Now:  func() time.Time { return time.Now().Add(time.Hour) },
+	}
+
+	r := httptest.NewRequest("POST", "/user/create?x=1", strings.NewReader("login=rvasily"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if err := SignRequest(r, "client", secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(r); err != ErrUnauthorized {
+		t.Errorf("expected old signature to be rejected, got %v", err)
+	}
+
+	auth.Now = nil
+	p, err := auth.Authenticate(r)
+	if err != nil || p.ID != "rvasily" {
+		t.Fatalf("expected signed request to pass, got %v %v", p, err)
+	}
+
+	// тело после проверки подписи остаётся на месте
+	r.ParseForm()
+	if r.PostForm.Get("login") != "rvasily" {
+		t.Errorf("body was not restored")
+	}
+}
+
+// подпись проверяется по телу, но читается не больше MaxBody
+func TestHMACAuthMaxBody(t *testing.T) {
+	secret := []byte("secret")
+	auth := HMACAuth{
+		Keys:    map[string]HMACKey{"client": {Secret: secret, Principal: Principal{ID: "rvasily"}}},
+		MaxBody: 8,
+	}
+	r := httptest.NewRequest("POST", "/user/create", strings.NewReader("login=rvasily"))
+	if err := SignRequest(r, "client", secret); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Authenticate(auth, r); err != ErrBodyTooLarge {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+}
+
+func TestMultiAuthAndContext(t *testing.T) {
+	auth := MultiAuth{
+		APIKeyAuth{Keys: map[string]Principal{"key": {ID: "by-key"}}},
+		BearerAuth{Secret: []byte("secret")},
+	}
+
+	r := httptest.NewRequest("GET", "/", nil)
+	if _, err := Authenticate(auth, r); err != ErrUnauthorized {
+		t.Errorf("expected unauthorized without credentials, got %v", err)
+	}
+	if _, err := Authenticate(nil, r); err != ErrUnauthorized {
+		t.Errorf("expected unauthorized without authenticator, got %v", err)
+	}
+
+	r.Header.Set("Authorization", "Bearer "+SignJWT([]byte("secret"), Principal{ID: "by-jwt", Roles: []string{"admin"}}, time.Minute))
+	p, err := Authenticate(auth, r)
+	if err != nil || p.ID != "by-jwt" || !p.HasRoles("admin") {
+		t.Fatalf("expected jwt principal, got %v %v", p, err)
+	}
+
+	ctx := WithPrincipal(context.Background(), p)
+	if got, ok := PrincipalFrom(ctx); !ok || got != p {
+		t.Errorf("principal not found in context")
+	}
+	if _, ok := PrincipalFrom(context.Background()); ok {
+		t.Errorf("unexpected principal in empty context")
+	}
+
+	r.Header.Set("Authorization", "Basic abc")
+	r.Header.Set("X-Auth", "key")
+	if p, err := Authenticate(auth, r); err != nil || p.ID != "by-key" {
+		t.Errorf("expected api key principal, got %v %v", p, err)
+	}
+}
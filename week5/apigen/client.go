@@ -0,0 +1,361 @@
+package apigen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusError - сервер ответил ошибкой в конверте {"error": "..."}.
+// Сгенерированный клиент превращает её в ApiError своего пакета
+type StatusError struct {
+	Status  int
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return e.Message
+}
+
+// Args - параметры вызова в том виде, в каком их ждёт сгенерированный хендлер
+type Args struct {
+	names  []string
+	values map[string]interface{}
+}
+
+// Set добавляет параметр. Нулевое время не передаётся - сервер считает его отсутствующим
+func (a *Args) Set(name string, v interface{}) {
+	if t, ok := v.(time.Time); ok && t.IsZero() {
+		return
+	}
+	if a.values == nil {
+		a.values = map[string]interface{}{}
+	}
+	if _, ok := a.values[name]; !ok {
+		a.names = append(a.names, name)
+	}
+	a.values[name] = v
+}
+
+// Query - параметры как форма: списки повторяются, время в RFC3339, структуры json-строкой
+func (a *Args) Query() (url.Values, error) {
+	q := url.Values{}
+	for _, name := range a.names {
+		switch v := a.values[name].(type) {
+		case string:
+			q.Set(name, v)
+		case []string:
+			q[name] = v
+		case bool:
+			q.Set(name, strconv.FormatBool(v))
+		case time.Time:
+			q.Set(name, v.Format(time.RFC3339))
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+			q.Set(name, fmt.Sprint(v))
+		case float32:
+			q.Set(name, strconv.FormatFloat(float64(v), 'g', -1, 32))
+		case float64:
+			q.Set(name, strconv.FormatFloat(v, 'g', -1, 64))
+		case io.Reader:
+			return nil, fmt.Errorf("%s: file can be sent only in request body", name)
+		default:
+			js, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", name, err)
+			}
+			q.Set(name, string(js))
+		}
+	}
+	return q, nil
+}
+
+// expand подставляет параметры в шаблон пути /user/{login} и убирает их из args
+func (a *Args) expand(pattern string) (string, error) {
+	vars := PathVars(pattern)
+	if len(vars) == 0 {
+		return pattern, nil
+	}
+	path := &Args{}
+	for _, name := range vars {
+		path.Set(name, a.values[name])
+	}
+	q, err := path.Query()
+	if err != nil {
+		return "", err
+	}
+	for _, name := range vars {
+		pattern = strings.Replace(pattern, "{"+name+"}", url.PathEscape(q.Get(name)), 1)
+		a.remove(name)
+	}
+	return pattern, nil
+}
+
+func (a *Args) remove(name string) {
+	delete(a.values, name)
+	for i, n := range a.names {
+		if n == name {
+			a.names = append(a.names[:i], a.names[i+1:]...)
+			break
+		}
+	}
+}
+
+func (a *Args) hasFiles() bool {
+	for _, v := range a.values {
+		if _, ok := v.(io.Reader); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Multipart - параметры multipart-формой: io.Reader (и *File) уходят файлами, остальное как в Query
+func (a *Args) Multipart() (io.Reader, string, error) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fields := &Args{}
+	for _, name := range a.names {
+		r, ok := a.values[name].(io.Reader)
+		if !ok {
+			fields.Set(name, a.values[name])
+			continue
+		}
+		filename := name
+		if f, ok := r.(*File); ok && f.Name != "" {
+			filename = f.Name
+		}
+		part, err := mw.CreateFormFile(name, filename)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := io.Copy(part, r); err != nil {
+			return nil, "", fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	q, err := fields.Query()
+	if err != nil {
+		return nil, "", err
+	}
+	for _, name := range fields.names {
+		for _, v := range q[name] {
+			if err := mw.WriteField(name, v); err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+	return body, mw.FormDataContentType(), nil
+}
+
+// JSON - параметры json-объектом для тела запроса
+func (a *Args) JSON() ([]byte, error) {
+	if a.values == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(a.values)
+}
+
+// Client - общая часть сгенерированных клиентов
+type Client struct {
+	// адрес сервера без завершающего /, например http://127.0.0.1:8080
+	BaseURL string
+	// nil - http.DefaultClient
+	HTTPClient *http.Client
+	// вызывается перед отправкой, чтобы добавить учётные данные
+	Sign func(r *http.Request) error
+}
+
+// APIKey - Sign для APIKeyAuth с заголовком по умолчанию
+func APIKey(key string) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		r.Header.Set(DefaultAPIKeyHeader, key)
+		return nil
+	}
+}
+
+// Bearer - Sign для BearerAuth
+func Bearer(token string) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		r.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	}
+}
+
+// HMAC - Sign для HMACAuth
+func HMAC(keyID string, secret []byte) func(r *http.Request) error {
+	return func(r *http.Request) error {
+		return SignRequest(r, keyID, secret)
+	}
+}
+
+// envelope - конверт, в котором сгенерированный хендлер отдаёт ответ
+type envelope struct {
+	Error    string          `json:"error"`
+	Response json.RawMessage `json:"response"`
+}
+
+// send собирает и отправляет запрос. GET передаёт параметры в query, остальные методы -
+// json-телом, а если среди параметров есть файлы - multipart-формой
+func (c *Client) send(ctx context.Context, method, path string, args *Args, accept string) (*http.Response, error) {
+	path, err := args.expand(path)
+	if err != nil {
+		return nil, err
+	}
+	var body io.Reader
+	var contentType string
+	target := strings.TrimRight(c.BaseURL, "/") + path
+	switch {
+	case method == http.MethodGet:
+		q, err := args.Query()
+		if err != nil {
+			return nil, err
+		}
+		if len(q) > 0 {
+			target += "?" + q.Encode()
+		}
+	case args.hasFiles():
+		if body, contentType, err = args.Multipart(); err != nil {
+			return nil, err
+		}
+	default:
+		js, err := args.JSON()
+		if err != nil {
+			return nil, err
+		}
+		body, contentType = bytes.NewReader(js), "application/json"
+	}
+
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.Sign != nil {
+		if err := c.Sign(req); err != nil {
+			return nil, err
+		}
+	}
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// statusError - ошибка из ответа с кодом не 200
+func statusError(resp *http.Response) error {
+	e := envelope{}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil || e.Error == "" {
+		// не наш конверт, например ответ прокси
+		return &StatusError{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+	}
+	return &StatusError{Status: resp.StatusCode, Message: e.Error}
+}
+
+// Call делает запрос и разбирает конверт {"error", "response"}: response уходит в out,
+// непустой error - в *StatusError с http-статусом ответа
+func (c *Client) Call(ctx context.Context, method, path string, args *Args, out interface{}) error {
+	resp, err := c.send(ctx, method, path, args, "application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	e := envelope{}
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		if resp.StatusCode != http.StatusOK {
+			// не наш конверт, например ответ прокси
+			return &StatusError{Status: resp.StatusCode, Message: http.StatusText(resp.StatusCode)}
+		}
+		return fmt.Errorf("cant unpack response: %v", err)
+	}
+	if e.Error != "" || resp.StatusCode != http.StatusOK {
+		return &StatusError{Status: resp.StatusCode, Message: e.Error}
+	}
+	if out == nil || len(e.Response) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(e.Response, out); err != nil {
+		return fmt.Errorf("cant unpack response: %v", err)
+	}
+	return nil
+}
+
+// Download - вызов метода, который отдаёт *apigen.Blob. Body - тело ответа, его закрывает Close
+func (c *Client) Download(ctx context.Context, method, path string, args *Args) (*Blob, error) {
+	resp, err := c.send(ctx, method, path, args, "")
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, statusError(resp)
+	}
+	blob := &Blob{ContentType: resp.Header.Get("Content-Type"), Body: resp.Body}
+	if resp.ContentLength > 0 {
+		blob.Size = resp.ContentLength
+	}
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		blob.Name = params["filename"]
+	}
+	return blob, nil
+}
+
+// Stream - вызов потокового метода, всегда в NDJSON. Каждый элемент уходит в fn, как только пришёл.
+// Ошибка из fn или из потока прекращает чтение
+func (c *Client) Stream(ctx context.Context, method, path string, args *Args, fn func(item json.RawMessage) error) error {
+	resp, err := c.send(ctx, method, path, args, "application/x-ndjson")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return statusError(resp)
+	}
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		e := envelope{}
+		if err := dec.Decode(&e); err == io.EOF {
+			return nil
+		} else if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return fmt.Errorf("cant unpack stream: %v", err)
+		}
+		if e.Error != "" {
+			// статус 200 уже пришёл, метод сломался посреди потока
+			return errors.New(e.Error)
+		}
+		if err := fn(e.Response); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package apigen
+
+import (
+	"testing"
+	"time"
+)
+
+func TestArgsQuery(t *testing.T) {
+	args := &Args{}
+	args.Set("login", "rvasily")
+	args.Set("tags", []string{"a", "b"})
+	args.Set("ok", true)
+	args.Set("rating", 4.5)
+	args.Set("at", time.Time{})
+	args.Set("address", struct {
+		City   string `json:"city"`
+		Street string `json:"street"`
+	}{City: "Moscow"})
+
+	q, err := args.Query()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := "address=%7B%22city%22%3A%22Moscow%22%2C%22street%22%3A%22%22%7D&login=rvasily&ok=true&rating=4.5&tags=a&tags=b"
+	if q.Encode() != expected {
+		t.Errorf("bad query\nGot: %s\nExpected: %s", q.Encode(), expected)
+	}
+}
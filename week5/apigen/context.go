@@ -0,0 +1,78 @@
+package apigen
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+)
+
+// RequestIDHeader - id запроса. Если его уже поставил балансировщик, используем его
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey ctxKey = 3
+
+// StatusClientClosedRequest - клиент ушёл, не дождавшись ответа. Код как у nginx,
+// до клиента он не доходит, но попадает в логи и тайминги
+const StatusClientClosedRequest = 499
+
+// WithRequestID берёт id из заголовка или придумывает новый, кладёт его в контекст запроса
+// и возвращает клиенту в том же заголовке
+func WithRequestID(w http.ResponseWriter, r *http.Request) *http.Request {
+	ctx := ContextWithRequestID(r.Context(), r.Header.Get(RequestIDHeader))
+	w.Header().Set(RequestIDHeader, RequestIDFrom(ctx))
+	return r.WithContext(ctx)
+}
+
+// maxRequestIDLen - id длиннее этого от клиента не принимаем
+const maxRequestIDLen = 64
+
+// ContextWithRequestID кладёт id запроса в контекст. Пустой или неподходящий id (см. validRequestID)
+// заменяется новым: он уходит в заголовки ответа и в логи, переносы строк и прочее туда пускать нельзя
+func ContextWithRequestID(ctx context.Context, id string) context.Context {
+	if !validRequestID(id) {
+		id = newRequestID()
+	}
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// validRequestID - непустой id не длиннее maxRequestIDLen из букв, цифр и ._-
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		c := id[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// RequestIDFrom - id запроса из контекста, пустой если его там нет
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// ContextError - метод упал, потому что кончился контекст: по таймауту это 504,
+// а если клиент ушёл - 499, отвечать уже некому
+func ContextError(ctx context.Context, err error) (status int, msg string, ok bool) {
+	switch {
+	case ctx.Err() == context.Canceled:
+		return StatusClientClosedRequest, "client closed request", true
+	case ctx.Err() == context.DeadlineExceeded, errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "timeout", true
+	}
+	return 0, "", false
+}
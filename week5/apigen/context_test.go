@@ -0,0 +1,60 @@
+package apigen
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestID(t *testing.T) {
+	w := httptest.NewRecorder()
+	r := WithRequestID(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	id := RequestIDFrom(r.Context())
+	if len(id) != 16 || w.Header().Get(RequestIDHeader) != id {
+		t.Errorf("bad generated id: %q, header %q", id, w.Header().Get(RequestIDHeader))
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(RequestIDHeader, "from-balancer")
+	if id := RequestIDFrom(WithRequestID(httptest.NewRecorder(), r).Context()); id != "from-balancer" {
+		t.Errorf("id from header must be kept, got %q", id)
+	}
+
+	// в заголовки ответа и логи чужое не пускаем
+	for _, bad := range []string{"a\r\nSet-Cookie: x=1", "id with spaces", "<script>", strings.Repeat("a", maxRequestIDLen+1)} {
+		r = httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(RequestIDHeader, bad)
+		w := httptest.NewRecorder()
+		if id := RequestIDFrom(WithRequestID(w, r).Context()); id == bad || len(id) != 16 || w.Header().Get(RequestIDHeader) != id {
+			t.Errorf("id %q must be replaced, got %q", bad, id)
+		}
+	}
+}
+
+func TestContextError(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+
+	cases := []struct {
+		ctx    context.Context
+		err    error
+		status int
+	}{
+		{canceled, canceled.Err(), StatusClientClosedRequest},
+		{expired, errors.New("db: query canceled"), http.StatusGatewayTimeout},
+		// таймаут внутри метода, свой контекст у него ещё жив
+		{context.Background(), context.DeadlineExceeded, http.StatusGatewayTimeout},
+		{context.Background(), errors.New("bad user"), 0},
+	}
+	for i, c := range cases {
+		status, _, ok := ContextError(c.ctx, c.err)
+		if status != c.status || ok != (c.status != 0) {
+			t.Errorf("[%d] expected %d, got %d", i, c.status, status)
+		}
+	}
+}
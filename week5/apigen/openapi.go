@@ -0,0 +1,102 @@
+package apigen
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+// AuthExtension - отметка операции метода с "auth": true в OpenAPI описании из генератора.
+// Как именно проходить авторизацию, генератор не знает: это подставляет OpenAPI по Authenticator
+const AuthExtension = "x-apigen-auth"
+
+// SecurityScheme - Security Scheme Object из OpenAPI 3
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// SecuritySchemer - Authenticator, который может описать себя в OpenAPI. Про остальные
+// (например HMACAuth, у подписи нет стандартной схемы) в описании ничего не будет
+type SecuritySchemer interface {
+	SecuritySchemes() map[string]SecurityScheme
+}
+
+// OpenAPI дополняет описание api из генератора схемами авторизации auth: они попадают в
+// components.securitySchemes, а в операции с AuthExtension - как security, подходит любая.
+// Если auth себя не описывает - spec как есть
+func OpenAPI(spec string, auth Authenticator) string {
+	schemer, ok := auth.(SecuritySchemer)
+	if !ok {
+		return spec
+	}
+	schemes := schemer.SecuritySchemes()
+	if len(schemes) == 0 {
+		return spec
+	}
+	var doc map[string]interface{}
+	dec := json.NewDecoder(strings.NewReader(spec))
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return spec
+	}
+
+	names := make([]string, 0, len(schemes))
+	for name := range schemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	security := make([]map[string][]string, 0, len(names))
+	for _, name := range names {
+		security = append(security, map[string][]string{name: {}})
+	}
+	paths, _ := doc["paths"].(map[string]interface{})
+	for _, item := range paths {
+		ops, _ := item.(map[string]interface{})
+		for _, op := range ops {
+			if op, ok := op.(map[string]interface{}); ok && op[AuthExtension] == true {
+				op["security"] = security
+			}
+		}
+	}
+	components, _ := doc["components"].(map[string]interface{})
+	if components == nil {
+		components = map[string]interface{}{}
+		doc["components"] = components
+	}
+	components["securitySchemes"] = schemes
+
+	b, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return spec
+	}
+	return string(b)
+}
+
+func (a APIKeyAuth) SecuritySchemes() map[string]SecurityScheme {
+	header := a.Header
+	if header == "" {
+		header = DefaultAPIKeyHeader
+	}
+	return map[string]SecurityScheme{"apiKey": {Type: "apiKey", In: "header", Name: header}}
+}
+
+func (a BearerAuth) SecuritySchemes() map[string]SecurityScheme {
+	return map[string]SecurityScheme{"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"}}
+}
+
+// SecuritySchemes - схемы всех вложенных Authenticator, которые себя описывают
+func (m MultiAuth) SecuritySchemes() map[string]SecurityScheme {
+	schemes := map[string]SecurityScheme{}
+	for _, auth := range m {
+		if schemer, ok := auth.(SecuritySchemer); ok {
+			for name, scheme := range schemer.SecuritySchemes() {
+				schemes[name] = scheme
+			}
+		}
+	}
+	return schemes
+}
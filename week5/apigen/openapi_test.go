@@ -0,0 +1,35 @@
+package apigen
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestOpenAPI(t *testing.T) {
+	spec := `{"paths": {"/a": {"get": {"x-apigen-auth": true}, "post": {}}}, "components": {"schemas": {}}}`
+	if OpenAPI(spec, nil) != spec || OpenAPI(spec, HMACAuth{}) != spec {
+		t.Errorf("spec without security schemes must stay as is")
+	}
+
+	doc := struct {
+		Paths map[string]map[string]struct {
+			Security []map[string][]string `json:"security"`
+		} `json:"paths"`
+		Components struct {
+			Schemas         map[string]interface{}    `json:"schemas"`
+			SecuritySchemes map[string]SecurityScheme `json:"securitySchemes"`
+		} `json:"components"`
+	}{}
+	if err := json.Unmarshal([]byte(OpenAPI(spec, LegacyAuth())), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if security := doc.Paths["/a"]["get"].Security; len(security) != 1 || security[0]["apiKey"] == nil {
+		t.Errorf("bad security of auth method: %v", security)
+	}
+	if security := doc.Paths["/a"]["post"].Security; security != nil {
+		t.Errorf("unexpected security of public method: %v", security)
+	}
+	if scheme := doc.Components.SecuritySchemes["apiKey"]; scheme.Name != DefaultAPIKeyHeader || scheme.In != "header" || doc.Components.Schemas == nil {
+		t.Errorf("bad components: %+v", doc.Components)
+	}
+}
@@ -0,0 +1,274 @@
+package apigen
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	// ErrBadJSON - тело с Content-Type application/json не разобралось
+	ErrBadJSON = errors.New("bad json body")
+	// ErrBodyTooLarge - тело больше, чем max_body в apigen:api (или DefaultMaxBody)
+	ErrBodyTooLarge = errors.New("request body too large")
+)
+
+// multipartMemory - сколько multipart-тела держим в памяти, остальное уходит во временные файлы
+const multipartMemory = 10 << 20
+
+// DefaultMaxBody - ограничение на тело запроса, если в apigen:api нет max_body
+const DefaultMaxBody = 10 << 20
+
+// ErrorStatus - http-статус для ошибки ReadParams
+func ErrorStatus(err error) int {
+	if err == ErrBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func readError(err error) error {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return ErrBodyTooLarge
+	}
+	return err
+}
+
+// Params - параметры запроса, из которых сгенерированный код заполняет структуру параметров.
+// Для GET это query, для остальных методов - тело: json при Content-Type application/json,
+// multipart/form-data (в нём же файлы), иначе form-urlencoded.
+// Параметры из пути (/user/{login}) важнее всех остальных.
+// Отсутствующий параметр (и пустая строка в форме) даёт нулевое значение
+type Params struct {
+	path      map[string]string
+	form      url.Values
+	json      map[string]json.RawMessage
+	multipart *multipart.Form
+	opened    []multipart.File
+}
+
+// ReadParams читает параметры из запроса
+func ReadParams(r *http.Request) (*Params, error) {
+	path := pathParams(r)
+	if r.Method == http.MethodGet {
+		return &Params{path: path, form: r.URL.Query()}, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(multipartMemory); err != nil {
+			return nil, readError(err)
+		}
+		return &Params{path: path, form: r.MultipartForm.Value, multipart: r.MultipartForm}, nil
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, readError(err)
+	}
+
+	if mediaType == "application/json" {
+		values := map[string]json.RawMessage{}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &values); err != nil {
+				return nil, ErrBadJSON
+			}
+		}
+		return &Params{path: path, json: values}, nil
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return nil, err
+	}
+	return &Params{path: path, form: form}, nil
+}
+
+// Close закрывает открытые файлы и удаляет временные файлы multipart-формы
+func (p *Params) Close() error {
+	for _, f := range p.opened {
+		f.Close()
+	}
+	if p.multipart != nil {
+		return p.multipart.RemoveAll()
+	}
+	return nil
+}
+
+// Has - параметр передан и не пустой
+func (p *Params) Has(name string) bool {
+	if _, ok := p.path[name]; ok {
+		return true
+	}
+	if p.multipart != nil && len(p.multipart.File[name]) > 0 {
+		return true
+	}
+	if p.json != nil {
+		raw, ok := p.json[name]
+		return ok && string(raw) != "null"
+	}
+	return p.form.Get(name) != ""
+}
+
+// raw возвращает строковое значение параметра из формы или json-значение как есть
+func (p *Params) raw(name string) (string, json.RawMessage, bool) {
+	if !p.Has(name) {
+		return "", nil, false
+	}
+	if v, ok := p.path[name]; ok {
+		return v, nil, true
+	}
+	if p.json != nil {
+		return "", p.json[name], true
+	}
+	return p.form.Get(name), nil, true
+}
+
+func (p *Params) String(name string) (string, error) {
+	s, raw, ok := p.raw(name)
+	if !ok || raw == nil {
+		return s, nil
+	}
+	err := json.Unmarshal(raw, &s)
+	return s, err
+}
+
+func (p *Params) Int(name string, bits int) (int64, error) {
+	s, raw, ok := p.raw(name)
+	if !ok {
+		return 0, nil
+	}
+	if raw != nil {
+		s = string(raw)
+	}
+	return strconv.ParseInt(s, 10, bits)
+}
+
+func (p *Params) Uint(name string, bits int) (uint64, error) {
+	s, raw, ok := p.raw(name)
+	if !ok {
+		return 0, nil
+	}
+	if raw != nil {
+		s = string(raw)
+	}
+	return strconv.ParseUint(s, 10, bits)
+}
+
+func (p *Params) Float(name string, bits int) (float64, error) {
+	s, raw, ok := p.raw(name)
+	if !ok {
+		return 0, nil
+	}
+	if raw != nil {
+		s = string(raw)
+	}
+	return strconv.ParseFloat(s, bits)
+}
+
+func (p *Params) Bool(name string) (bool, error) {
+	s, raw, ok := p.raw(name)
+	if !ok {
+		return false, nil
+	}
+	if raw != nil {
+		s = string(raw)
+	}
+	return strconv.ParseBool(s)
+}
+
+// Time ждёт время в RFC3339
+func (p *Params) Time(name string) (time.Time, error) {
+	s, err := p.String(name)
+	if err != nil || s == "" {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// Strings - в форме это повторяющийся параметр (tag=a&tag=b), в json - массив строк
+func (p *Params) Strings(name string) ([]string, error) {
+	if !p.Has(name) {
+		return nil, nil
+	}
+	if v, ok := p.path[name]; ok {
+		return []string{v}, nil
+	}
+	if p.json == nil {
+		return p.form[name], nil
+	}
+	list := []string{}
+	err := json.Unmarshal(p.json[name], &list)
+	return list, err
+}
+
+// Decode разбирает вложенную структуру по её json-тегам.
+// в форме она передаётся json-строкой в значении параметра
+func (p *Params) Decode(name string, v interface{}) error {
+	s, raw, ok := p.raw(name)
+	if !ok {
+		return nil
+	}
+	if raw == nil {
+		raw = json.RawMessage(s)
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// File - загруженный файл. Его можно положить в поле типа *apigen.File, io.Reader или multipart.File
+type File struct {
+	multipart.File
+	// имя файла, как его прислал клиент
+	Name        string
+	Size        int64
+	ContentType string
+}
+
+// File открывает файл из multipart-формы. maxSize > 0 - ограничение на размер в байтах.
+// Файл закроется в Close, сгенерированный код делает это после вызова метода
+func (p *Params) File(name string, maxSize int64) (*File, error) {
+	if p.multipart == nil || len(p.multipart.File[name]) == 0 {
+		if p.Has(name) {
+			// передали обычным значением, а не файлом
+			return nil, errors.New("must be file")
+		}
+		return nil, nil
+	}
+	header := p.multipart.File[name][0]
+	if maxSize > 0 && header.Size > maxSize {
+		return nil, fmt.Errorf("must be <= %d bytes", maxSize)
+	}
+	f, err := header.Open()
+	if err != nil {
+		return nil, err
+	}
+	p.opened = append(p.opened, f)
+	return &File{
+		File:        f,
+		Name:        header.Filename,
+		Size:        header.Size,
+		ContentType: header.Header.Get("Content-Type"),
+	}, nil
+}
+
+// NewFile - файл из памяти, например чтобы отправить его сгенерированным клиентом
+func NewFile(name string, data []byte) *File {
+	return &File{File: bytesFile{bytes.NewReader(data)}, Name: name, Size: int64(len(data))}
+}
+
+type bytesFile struct {
+	*bytes.Reader
+}
+
+func (bytesFile) Close() error {
+	return nil
+}
@@ -0,0 +1,114 @@
+package apigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadParams(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodGet, "/?age=20&tags=a&tags=b&empty=", nil)
+	p, err := ReadParams(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if age, err := p.Int("age", 64); err != nil || age != 20 {
+		t.Errorf("bad age: %v, %v", age, err)
+	}
+	if tags, _ := p.Strings("tags"); !reflect.DeepEqual(tags, []string{"a", "b"}) {
+		t.Errorf("bad tags: %v", tags)
+	}
+	if p.Has("empty") || p.Has("missing") {
+		t.Errorf("empty and missing params must not be set")
+	}
+	if v, err := p.Float("missing", 64); err != nil || v != 0 {
+		t.Errorf("missing param must be zero: %v, %v", v, err)
+	}
+
+	body := `{"age": 20, "ok": true, "at": "2020-01-02T03:04:05Z", "nothing": null, "obj": {"a": 1}}`
+	r, _ = http.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/json; charset=utf-8")
+	p, err = ReadParams(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ok, err := p.Bool("ok"); err != nil || !ok {
+		t.Errorf("bad ok: %v, %v", ok, err)
+	}
+	if at, err := p.Time("at"); err != nil || !at.Equal(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)) {
+		t.Errorf("bad at: %v, %v", at, err)
+	}
+	if p.Has("nothing") {
+		t.Errorf("null must be treated as absent")
+	}
+	if _, err := p.Int("at", 64); err == nil {
+		t.Errorf("expected error for string as int")
+	}
+	if _, err := p.String("age"); err == nil {
+		t.Errorf("expected error for number as string")
+	}
+	obj := struct{ A int }{}
+	if err := p.Decode("obj", &obj); err != nil || obj.A != 1 {
+		t.Errorf("bad obj: %+v, %v", obj, err)
+	}
+
+	r, _ = http.NewRequest(http.MethodPost, "/", strings.NewReader("{"))
+	r.Header.Set("Content-Type", "application/json")
+	if _, err := ReadParams(r); err != ErrBadJSON {
+		t.Errorf("expected ErrBadJSON, got %v", err)
+	}
+}
+
+func TestParamsFile(t *testing.T) {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	mw.WriteField("login", "rvasily")
+	part, _ := mw.CreateFormFile("avatar", "me.png")
+	part.Write([]byte("png data"))
+	mw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	p, err := ReadParams(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer p.Close()
+
+	if login, err := p.String("login"); err != nil || login != "rvasily" {
+		t.Errorf("bad login: %v, %v", login, err)
+	}
+	if !p.Has("avatar") {
+		t.Errorf("file must be set")
+	}
+	if _, err := p.File("avatar", 4); err == nil || err.Error() != "must be <= 4 bytes" {
+		t.Errorf("expected size error, got %v", err)
+	}
+	f, err := p.File("avatar", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := ioutil.ReadAll(f)
+	if f.Name != "me.png" || f.Size != 8 || string(data) != "png data" {
+		t.Errorf("bad file: %+v %q", f, data)
+	}
+	if f, err := p.File("missing", 0); f != nil || err != nil {
+		t.Errorf("missing file must be nil: %v, %v", f, err)
+	}
+	if _, err := p.File("login", 0); err == nil {
+		t.Errorf("expected error for value instead of file")
+	}
+
+	// тело больше http.MaxBytesReader
+	r = httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(make([]byte, 100)))
+	r.Body = http.MaxBytesReader(httptest.NewRecorder(), r.Body, 10)
+	if _, err := ReadParams(r); err != ErrBodyTooLarge || ErrorStatus(err) != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected ErrBodyTooLarge, got %v", err)
+	}
+}
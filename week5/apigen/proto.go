@@ -0,0 +1,274 @@
+package apigen
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Сгенерированный код кодирует структуры параметров и ответов в protobuf сам,
+// по описанию из .proto, которое выдаёт тот же генератор. Поэтому ни protoc,
+// ни сгенерированные им типы не нужны, а клиенты на других языках работают по этому .proto
+
+// ProtoMessage - структура, которую сгенерированный код умеет отправлять по gRPC
+type ProtoMessage interface {
+	MarshalProto() []byte
+	UnmarshalProto(data []byte) error
+}
+
+// типы значений в protobuf
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// ProtoEncoder дописывает поля в сообщение. Нулевые значения сгенерированный код пропускает сам,
+// кроме полей-указателей: у них в .proto стоит optional и ноль надо передать
+type ProtoEncoder struct {
+	buf []byte
+}
+
+func (e *ProtoEncoder) Bytes() []byte {
+	return e.buf
+}
+
+func (e *ProtoEncoder) tag(num, wire int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(num)<<3|uint64(wire))
+}
+
+func (e *ProtoEncoder) Int(num int, v int64) {
+	e.tag(num, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, uint64(v))
+}
+
+func (e *ProtoEncoder) Uint(num int, v uint64) {
+	e.tag(num, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *ProtoEncoder) Bool(num int, v bool) {
+	var n uint64
+	if v {
+		n = 1
+	}
+	e.Uint(num, n)
+}
+
+// Double - float64, в .proto это double
+func (e *ProtoEncoder) Double(num int, v float64) {
+	e.tag(num, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, math.Float64bits(v))
+}
+
+// Float - float32, в .proto это float
+func (e *ProtoEncoder) Float(num int, v float32) {
+	e.tag(num, wireFixed32)
+	e.buf = binary.LittleEndian.AppendUint32(e.buf, math.Float32bits(v))
+}
+
+func (e *ProtoEncoder) lenPrefixed(num int, v []byte) {
+	e.tag(num, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(v)))
+	e.buf = append(e.buf, v...)
+}
+
+func (e *ProtoEncoder) String(num int, v string) {
+	e.lenPrefixed(num, []byte(v))
+}
+
+// Strings - repeated string, по полю на элемент
+func (e *ProtoEncoder) Strings(num int, v []string) {
+	for _, s := range v {
+		e.String(num, s)
+	}
+}
+
+// Message - вложенное сообщение, уже закодированное
+func (e *ProtoEncoder) Message(num int, data []byte) {
+	e.lenPrefixed(num, data)
+}
+
+// Time - google.protobuf.Timestamp
+func (e *ProtoEncoder) Time(num int, t time.Time) {
+	ts := &ProtoEncoder{}
+	if t.Unix() != 0 {
+		ts.Int(1, t.Unix())
+	}
+	if t.Nanosecond() != 0 {
+		ts.Int(2, int64(t.Nanosecond()))
+	}
+	e.Message(num, ts.buf)
+}
+
+// ----------------
+
+var errProtoTruncated = errors.New("proto: truncated message")
+
+// ProtoDecoder читает поля сообщения по одному:
+//
+//	for d.Next() {
+//		switch d.Num() {
+//		case 1:
+//			m.Login = d.Text()
+//		default:
+//			d.Skip()
+//		}
+//	}
+//	return d.Err()
+type ProtoDecoder struct {
+	data []byte
+	num  int
+	wire int
+	err  error
+}
+
+func NewProtoDecoder(data []byte) *ProtoDecoder {
+	return &ProtoDecoder{data: data}
+}
+
+// Next читает номер следующего поля, false - сообщение кончилось или сломано
+func (d *ProtoDecoder) Next() bool {
+	if d.err != nil || len(d.data) == 0 {
+		return false
+	}
+	tag := d.varint()
+	if d.err != nil {
+		return false
+	}
+	d.num, d.wire = int(tag>>3), int(tag&7)
+	if d.num <= 0 {
+		d.err = fmt.Errorf("proto: bad field number %d", d.num)
+		return false
+	}
+	return true
+}
+
+// Num - номер текущего поля
+func (d *ProtoDecoder) Num() int {
+	return d.num
+}
+
+func (d *ProtoDecoder) Err() error {
+	return d.err
+}
+
+func (d *ProtoDecoder) varint() uint64 {
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errProtoTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *ProtoDecoder) next(n int) []byte {
+	if n < 0 || len(d.data) < n {
+		d.err = errProtoTruncated
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *ProtoDecoder) expect(wire int) bool {
+	if d.err != nil {
+		return false
+	}
+	if d.wire != wire {
+		d.err = fmt.Errorf("proto: field %d has wire type %d, expected %d", d.num, d.wire, wire)
+		return false
+	}
+	return true
+}
+
+// Skip пропускает поле, которого нет в структуре, например из новой версии .proto
+func (d *ProtoDecoder) Skip() {
+	switch d.wire {
+	case wireVarint:
+		d.varint()
+	case wireFixed64:
+		d.next(8)
+	case wireFixed32:
+		d.next(4)
+	case wireBytes:
+		d.next(int(d.varint()))
+	default:
+		d.err = fmt.Errorf("proto: field %d has unsupported wire type %d", d.num, d.wire)
+	}
+}
+
+func (d *ProtoDecoder) Int() int64 {
+	return int64(d.Uint())
+}
+
+func (d *ProtoDecoder) Uint() uint64 {
+	if !d.expect(wireVarint) {
+		return 0
+	}
+	return d.varint()
+}
+
+func (d *ProtoDecoder) Bool() bool {
+	return d.Uint() != 0
+}
+
+func (d *ProtoDecoder) Double() float64 {
+	if !d.expect(wireFixed64) {
+		return 0
+	}
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return math.Float64frombits(binary.LittleEndian.Uint64(b))
+}
+
+func (d *ProtoDecoder) Float() float32 {
+	if !d.expect(wireFixed32) {
+		return 0
+	}
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return math.Float32frombits(binary.LittleEndian.Uint32(b))
+}
+
+// Message - байты вложенного сообщения, их разбирает UnmarshalProto вложенной структуры
+func (d *ProtoDecoder) Message() []byte {
+	if !d.expect(wireBytes) {
+		return nil
+	}
+	return d.next(int(d.varint()))
+}
+
+// Text - строка. Не String, чтобы fmt не читал поле, печатая декодер
+func (d *ProtoDecoder) Text() string {
+	return string(d.Message())
+}
+
+// Time - google.protobuf.Timestamp, в UTC
+func (d *ProtoDecoder) Time() time.Time {
+	ts := NewProtoDecoder(d.Message())
+	var sec, nsec int64
+	for ts.Next() {
+		switch ts.Num() {
+		case 1:
+			sec = ts.Int()
+		case 2:
+			nsec = ts.Int()
+		default:
+			ts.Skip()
+		}
+	}
+	if ts.err != nil && d.err == nil {
+		d.err = ts.err
+	}
+	return time.Unix(sec, nsec).UTC()
+}
@@ -0,0 +1,108 @@
+package apigen
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestProtoEncoder(t *testing.T) {
+	// примеры из документации protobuf: 150 в поле 1 и "testing" в поле 2
+	e := &ProtoEncoder{}
+	e.Int(1, 150)
+	e.String(2, "testing")
+	expected := []byte{0x08, 0x96, 0x01, 0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'}
+	if !bytes.Equal(e.Bytes(), expected) {
+		t.Errorf("bad encoding: % x", e.Bytes())
+	}
+}
+
+func TestProtoRoundTrip(t *testing.T) {
+	at := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	nested := &ProtoEncoder{}
+	nested.String(1, "Moscow")
+
+	e := &ProtoEncoder{}
+	e.Int(1, -42)
+	e.Uint(2, 1<<40)
+	e.Bool(3, true)
+	e.Double(4, 4.5)
+	e.Float(5, 0.25)
+	e.Strings(6, []string{"a", "b"})
+	e.Time(7, at)
+	e.Message(8, nested.Bytes())
+	// поле из новой версии .proto, которого нет у читающего
+	e.String(100, "unknown")
+
+	d := NewProtoDecoder(e.Bytes())
+	var (
+		i    int64
+		u    uint64
+		b    bool
+		f64  float64
+		f32  float32
+		strs []string
+		ts   time.Time
+		city string
+	)
+	for d.Next() {
+		switch d.Num() {
+		case 1:
+			i = d.Int()
+		case 2:
+			u = d.Uint()
+		case 3:
+			b = d.Bool()
+		case 4:
+			f64 = d.Double()
+		case 5:
+			f32 = d.Float()
+		case 6:
+			strs = append(strs, d.Text())
+		case 7:
+			ts = d.Time()
+		case 8:
+			n := NewProtoDecoder(d.Message())
+			for n.Next() {
+				city = n.Text()
+			}
+		default:
+			d.Skip()
+		}
+	}
+	if d.Err() != nil {
+		t.Fatalf("unexpected error: %v", d.Err())
+	}
+	if i != -42 || u != 1<<40 || !b || f64 != 4.5 || f32 != 0.25 ||
+		len(strs) != 2 || strs[1] != "b" || !ts.Equal(at) || city != "Moscow" {
+		t.Errorf("bad values: %v %v %v %v %v %v %v %v", i, u, b, f64, f32, strs, ts, city)
+	}
+}
+
+func TestProtoDecoderErrors(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated varint": {0x08, 0x96},
+		"truncated string": {0x12, 0x07, 't', 'e'},
+		"zero field":       {0x00, 0x01},
+	}
+	for name, data := range cases {
+		d := NewProtoDecoder(data)
+		for d.Next() {
+			d.Skip()
+		}
+		if d.Err() == nil {
+			t.Errorf("[%s] expected error", name)
+		}
+	}
+
+	// строка там, где ждали число
+	e := &ProtoEncoder{}
+	e.String(1, "42")
+	d := NewProtoDecoder(e.Bytes())
+	for d.Next() {
+		d.Int()
+	}
+	if d.Err() == nil {
+		t.Errorf("expected wire type error")
+	}
+}
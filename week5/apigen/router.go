@@ -0,0 +1,155 @@
+package apigen
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MatchPath сопоставляет путь с шаблоном вида /user/{login}/info.
+// Параметр занимает ровно один непустой сегмент пути
+func MatchPath(pattern, path string) (map[string]string, bool) {
+	if !strings.Contains(pattern, "{") {
+		return nil, pattern == path
+	}
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(path, "/")
+	if len(patternParts) != len(pathParts) {
+		return nil, false
+	}
+	vars := map[string]string{}
+	for i, part := range patternParts {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			if pathParts[i] == "" {
+				return nil, false
+			}
+			vars[part[1:len(part)-1]] = pathParts[i]
+			continue
+		}
+		if part != pathParts[i] {
+			return nil, false
+		}
+	}
+	return vars, true
+}
+
+// PathVars - имена параметров в шаблоне пути
+func PathVars(pattern string) []string {
+	var vars []string
+	for _, part := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}") {
+			vars = append(vars, part[1:len(part)-1])
+		}
+	}
+	return vars
+}
+
+const pathParamsKey ctxKey = 2
+
+// WithPathParams кладёт параметры из пути в контекст запроса, их увидит ReadParams
+func WithPathParams(r *http.Request, vars map[string]string) *http.Request {
+	if len(vars) == 0 {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), pathParamsKey, vars))
+}
+
+func pathParams(r *http.Request) map[string]string {
+	vars, _ := r.Context().Value(pathParamsKey).(map[string]string)
+	return vars
+}
+
+// ----------------
+
+// Middleware - обёртка над хендлером, как в week5_lec/middleware
+type Middleware func(next http.Handler) http.Handler
+
+// Chain оборачивает h в middleware, первая в списке вызывается первой
+func Chain(h http.Handler, mws ...Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+	return h
+}
+
+// statusWriter запоминает код ответа для логов
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush - чтобы потоковые ответы проходили через middleware
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Logging пишет в лог метод, путь, код ответа, время обработки и id запроса. nil - стандартный логгер
+func Logging(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.New(log.Writer(), "", log.LstdFlags)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			line := fmt.Sprintf("[%s] %s %s %d %s", r.RemoteAddr, r.Method, r.URL.Path, sw.status, time.Since(start))
+			if id := w.Header().Get(RequestIDHeader); id != "" {
+				line += " " + id
+			}
+			logger.Print(line)
+		})
+	}
+}
+
+// Recovery превращает панику в хендлере в 500 с ошибкой в конверте
+func Recovery(logger *log.Logger) Middleware {
+	if logger == nil {
+		logger = log.New(log.Writer(), "", log.LstdFlags)
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if err := recover(); err != nil {
+					logger.Printf("recovered %s %s: %v", r.Method, r.URL.Path, err)
+					w.Header().Set("Content-Type", "application/json")
+					w.WriteHeader(http.StatusInternalServerError)
+					json.NewEncoder(w).Encode(map[string]string{"error": "internal error"})
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Timing сообщает, сколько обрабатывался запрос и с каким кодом
+func Timing(observe func(r *http.Request, status int, d time.Duration)) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r)
+			observe(r, sw.status, time.Since(start))
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package apigen
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern, path string
+		vars          map[string]string
+		ok            bool
+	}{
+		{"/user/profile", "/user/profile", nil, true},
+		{"/user/profile", "/user/profile/", nil, false},
+		{"/user/{login}", "/user/rvasily", map[string]string{"login": "rvasily"}, true},
+		{"/user/{login}", "/user/", nil, false},
+		{"/user/{login}/posts/{id}", "/user/rvasily/posts/42", map[string]string{"login": "rvasily", "id": "42"}, true},
+		{"/user/{login}/posts/{id}", "/user/rvasily/likes/42", nil, false},
+		{"/user/{login}", "/user/rvasily/info", nil, false},
+	}
+	for _, c := range cases {
+		vars, ok := MatchPath(c.pattern, c.path)
+		if ok != c.ok || (ok && !reflect.DeepEqual(vars, c.vars)) {
+			t.Errorf("MatchPath(%q, %q) = %v, %v; expected %v, %v", c.pattern, c.path, vars, ok, c.vars, c.ok)
+		}
+	}
+}
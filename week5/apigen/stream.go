@@ -0,0 +1,143 @@
+package apigen
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// форматы потокового ответа, "stream" в apigen:api
+const (
+	// NDJSON - по строке на элемент, каждая строка - конверт {"error", "response"}
+	NDJSON = "ndjson"
+	// SSE - server-sent events, в data голый элемент, ошибка - событие error
+	SSE = "sse"
+)
+
+// Stream пишет элементы потока по мере появления и сразу отправляет их клиенту
+type Stream struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	ctx     context.Context
+	sse     bool
+	id      int
+}
+
+// NewStream начинает потоковый ответ, ctx - контекст метода: когда он кончится, Send вернёт ошибку.
+// format - из apigen:api, но клиент может попросить другой через Accept: text/event-stream или application/x-ndjson
+func NewStream(ctx context.Context, w http.ResponseWriter, r *http.Request, format string) *Stream {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, "text/event-stream"):
+		format = SSE
+	case strings.Contains(accept, "application/x-ndjson"):
+		format = NDJSON
+	}
+	s := &Stream{w: w, ctx: ctx, sse: format == SSE}
+	s.flusher, _ = w.(http.Flusher)
+
+	if s.sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	s.flush()
+	return s
+}
+
+func (s *Stream) flush() {
+	if s.flusher != nil {
+		s.flusher.Flush()
+	}
+}
+
+// Send отправляет элемент. Ошибка - клиент ушёл или не получилось записать, дальше слать незачем
+func (s *Stream) Send(v interface{}) error {
+	if err := s.ctx.Err(); err != nil {
+		return err
+	}
+	var err error
+	if s.sse {
+		s.id++
+		var data []byte
+		if data, err = json.Marshal(v); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(s.w, "id: %d\ndata: %s\n\n", s.id, data)
+	} else {
+		err = json.NewEncoder(s.w).Encode(map[string]interface{}{"error": "", "response": v})
+	}
+	if err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// Fail сообщает об ошибке посреди потока, статус уже ушёл с заголовками
+func (s *Stream) Fail(err error) {
+	js, _ := json.Marshal(map[string]string{"error": err.Error()})
+	if s.sse {
+		fmt.Fprintf(s.w, "event: error\ndata: %s\n\n", js)
+	} else {
+		fmt.Fprintf(s.w, "%s\n", js)
+	}
+	s.flush()
+}
+
+// ----------------
+
+// Blob - ответ как есть, без конверта: картинка, архив, выгрузка
+type Blob struct {
+	// пустой - application/octet-stream
+	ContentType string
+	// имя файла для Content-Disposition, пустое - без заголовка
+	Name string
+	// больше 0 - уйдёт в Content-Length
+	Size int64
+	Body io.Reader
+}
+
+// NewBlob - Blob из байтов в памяти
+func NewBlob(contentType string, data []byte) *Blob {
+	return &Blob{ContentType: contentType, Size: int64(len(data)), Body: bytes.NewReader(data)}
+}
+
+// Close закрывает Body, если его можно закрыть. У Blob от клиента это тело ответа
+func (b *Blob) Close() error {
+	if c, ok := b.Body.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// ServeBlob отдаёт Blob клиенту. nil - пустой ответ 204
+func ServeBlob(w http.ResponseWriter, b *Blob) {
+	if b == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	defer b.Close()
+	contentType := b.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if b.Name != "" {
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": b.Name}))
+	}
+	if b.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(b.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	// ошибку записи уже некому отдать
+	io.Copy(w, b.Body)
+}
@@ -0,0 +1,69 @@
+package apigen
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	cases := []struct {
+		format      string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{NDJSON, "", "application/x-ndjson", "{\"error\":\"\",\"response\":1}\n{\"error\":\"\",\"response\":\"a\"}\n{\"error\":\"boom\"}\n"},
+		{SSE, "", "text/event-stream", "id: 1\ndata: 1\n\nid: 2\ndata: \"a\"\n\nevent: error\ndata: {\"error\":\"boom\"}\n\n"},
+		// клиент может попросить другой формат
+		{NDJSON, "text/event-stream", "text/event-stream", "id: 1\ndata: 1\n\nid: 2\ndata: \"a\"\n\nevent: error\ndata: {\"error\":\"boom\"}\n\n"},
+		{SSE, "application/x-ndjson", "application/x-ndjson", "{\"error\":\"\",\"response\":1}\n{\"error\":\"\",\"response\":\"a\"}\n{\"error\":\"boom\"}\n"},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if c.accept != "" {
+			r.Header.Set("Accept", c.accept)
+		}
+		w := httptest.NewRecorder()
+		s := NewStream(r.Context(), w, r, c.format)
+		s.Send(1)
+		s.Send("a")
+		s.Fail(errors.New("boom"))
+
+		if ct := w.Header().Get("Content-Type"); ct != c.contentType {
+			t.Errorf("[%s %s] expected %s, got %s", c.format, c.accept, c.contentType, ct)
+		}
+		if w.Body.String() != c.body {
+			t.Errorf("[%s %s] bad body: %q", c.format, c.accept, w.Body.String())
+		}
+		if !w.Flushed {
+			t.Errorf("[%s %s] stream must be flushed", c.format, c.accept)
+		}
+	}
+}
+
+func TestServeBlob(t *testing.T) {
+	w := httptest.NewRecorder()
+	blob := NewBlob("", []byte("data"))
+	blob.Name = "отчёт.csv"
+	ServeBlob(w, blob)
+	if w.Code != http.StatusOK || w.Body.String() != "data" {
+		t.Errorf("bad response: %d %q", w.Code, w.Body.String())
+	}
+	for header, expected := range map[string]string{
+		"Content-Type":        "application/octet-stream",
+		"Content-Length":      "4",
+		"Content-Disposition": "attachment; filename*=utf-8''%D0%BE%D1%82%D1%87%D1%91%D1%82.csv",
+	} {
+		if got := w.Header().Get(header); got != expected {
+			t.Errorf("%s: expected %q, got %q", header, expected, got)
+		}
+	}
+
+	w = httptest.NewRecorder()
+	ServeBlob(w, nil)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("nil blob must be 204, got %d", w.Code)
+	}
+}
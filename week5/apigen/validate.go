@@ -0,0 +1,102 @@
+package apigen
+
+import (
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Violations собирает все нарушения правил apivalidator в запросе,
+// чтобы вернуть их одним ответом, а не по одному за запрос
+type Violations struct {
+	params []string
+	msgs   []string
+}
+
+// Add запоминает нарушение для параметра
+func (v *Violations) Add(param, msg string) {
+	v.params = append(v.params, param)
+	v.msgs = append(v.msgs, msg)
+}
+
+// Failed - у параметра уже есть нарушение, дальше его проверять не надо
+func (v *Violations) Failed(param string) bool {
+	for _, p := range v.params {
+		if p == param {
+			return true
+		}
+	}
+	return false
+}
+
+func (v *Violations) Len() int {
+	return len(v.msgs)
+}
+
+// Error - все нарушения через "; " в порядке полей структуры
+func (v *Violations) Error() string {
+	return strings.Join(v.msgs, "; ")
+}
+
+// ----------------
+
+var (
+	regexpMu    sync.RWMutex
+	regexpCache = map[string]*regexp.Regexp{}
+)
+
+// MatchRegexp проверяет строку по регулярке из тега regexp=.
+// регулярки компилируются один раз, кодогенератор заранее проверил что они валидные
+func MatchRegexp(pattern, s string) bool {
+	regexpMu.RLock()
+	re, ok := regexpCache[pattern]
+	regexpMu.RUnlock()
+	if !ok {
+		re = regexp.MustCompile(pattern)
+		regexpMu.Lock()
+		regexpCache[pattern] = re
+		regexpMu.Unlock()
+	}
+	return re.MatchString(s)
+}
+
+// IsEmail - строка это голый адрес, без имени и угловых скобок
+func IsEmail(s string) bool {
+	addr, err := mail.ParseAddress(s)
+	return err == nil && addr.Address == s
+}
+
+// IsURL - абсолютный url со схемой и хостом
+func IsURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.Scheme != "" && u.Host != ""
+}
+
+func OneOf(v string, valid ...string) bool {
+	for _, s := range valid {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func OneOfInt(v int64, valid ...int64) bool {
+	for _, n := range valid {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func OneOfUint(v uint64, valid ...uint64) bool {
+	for _, n := range valid {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,45 @@
+package apigen
+
+import (
+	"testing"
+)
+
+func TestFormatValidators(t *testing.T) {
+	for s, ok := range map[string]bool{
+		"v@mail.ru":          true,
+		"Vasily <v@mail.ru>": false,
+		"mail.ru":            false,
+		"":                   false,
+	} {
+		if IsEmail(s) != ok {
+			t.Errorf("IsEmail(%q) != %v", s, ok)
+		}
+	}
+	for s, ok := range map[string]bool{
+		"https://mail.ru/path": true,
+		"mail.ru":              false,
+		"/relative":            false,
+	} {
+		if IsURL(s) != ok {
+			t.Errorf("IsURL(%q) != %v", s, ok)
+		}
+	}
+	if !MatchRegexp("^[a-z]{2,3}$", "abc") || MatchRegexp("^[a-z]{2,3}$", "abcd") {
+		t.Errorf("bad MatchRegexp")
+	}
+	if !OneOfInt(2, 1, 2, 3) || OneOfUint(4, 1, 2, 3) || !OneOf("b", "a", "b") {
+		t.Errorf("bad OneOf")
+	}
+}
+
+func TestViolations(t *testing.T) {
+	errs := &Violations{}
+	errs.Add("login", "login must me not empty")
+	errs.Add("age", "age must be int")
+	if !errs.Failed("age") || errs.Failed("status") || errs.Len() != 2 {
+		t.Errorf("bad violations: %+v", errs)
+	}
+	if errs.Error() != "login must me not empty; age must be int" {
+		t.Errorf("bad error: %s", errs.Error())
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"week5/apigen"
+)
+
+func TestAuthenticators(t *testing.T) {
+	secret := []byte("jwt-secret")
+	hmacSecret := []byte("hmac-secret")
+	opts := apigen.Options{
+		Auth: apigen.MultiAuth{
+			apigen.APIKeyAuth{
+				Keys: map[string]apigen.Principal{
+					"admin-key": {ID: "rvasily", Roles: []string{"admin"}},
+					"user-key":  {ID: "rvasily"},
+				},
+			},
+			apigen.BearerAuth{Secret: secret},
+			apigen.HMACAuth{
+				Keys: map[string]apigen.HMACKey{
+					"client": {Secret: hmacSecret, Principal: apigen.Principal{ID: "rvasily", Roles: []string{"admin"}}},
+				},
+			},
+		},
+	}
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), opts))
+	defer ts.Close()
+
+	cases := []struct {
+		Name   string
+		Method string
+		Path   string
+		Body   string
+		Auth   func(r *http.Request)
+		Status int
+		Error  string
+	}{
+		{
+			Name:   "me without credentials",
+			Path:   "/user/me",
+			Status: http.StatusForbidden,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "me by api key",
+			Path:   "/user/me",
+			Auth:   func(r *http.Request) { r.Header.Set("X-Auth", "user-key") },
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "me by unknown api key",
+			Path:   "/user/me",
+			Auth:   func(r *http.Request) { r.Header.Set("X-Auth", "bad-key") },
+			Status: http.StatusForbidden,
+			Error:  "unauthorized",
+		},
+		{
+			Name: "me by jwt",
+			Path: "/user/me",
+			Auth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+apigen.SignJWT(secret, apigen.Principal{ID: "rvasily"}, time.Minute))
+			},
+			Status: http.StatusOK,
+		},
+		{
+			Name: "me by expired jwt",
+			Path: "/user/me",
+			Auth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+apigen.SignJWT(secret, apigen.Principal{ID: "rvasily"}, -time.Minute))
+			},
+			Status: http.StatusForbidden,
+			Error:  "unauthorized",
+		},
+		{
+			Name: "me by jwt with other secret",
+			Path: "/user/me",
+			Auth: func(r *http.Request) {
+				r.Header.Set("Authorization", "Bearer "+apigen.SignJWT([]byte("other"), apigen.Principal{ID: "rvasily"}, time.Minute))
+			},
+			Status: http.StatusForbidden,
+			Error:  "unauthorized",
+		},
+		{
+			Name:   "status without admin role",
+			Method: http.MethodPost,
+			Path:   "/user/status",
+			Body:   "login=rvasily&status=user",
+			Auth:   func(r *http.Request) { r.Header.Set("X-Auth", "user-key") },
+			Status: http.StatusForbidden,
+			Error:  "forbidden",
+		},
+		{
+			Name:   "status by admin",
+			Method: http.MethodPost,
+			Path:   "/user/status",
+			Body:   "login=rvasily&status=moderator",
+			Auth:   func(r *http.Request) { r.Header.Set("X-Auth", "admin-key") },
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "status by signed request",
+			Method: http.MethodPost,
+			Path:   "/user/status",
+			Body:   "login=rvasily&status=admin",
+			Auth:   func(r *http.Request) { apigen.SignRequest(r, "client", hmacSecret) },
+			Status: http.StatusOK,
+		},
+		{
+			Name:   "status by request with bad signature",
+			Method: http.MethodPost,
+			Path:   "/user/status",
+			Body:   "login=rvasily&status=admin",
+			Auth: func(r *http.Request) {
+				apigen.SignRequest(r, "client", []byte("other"))
+			},
+			Status: http.StatusForbidden,
+			Error:  "unauthorized",
+		},
+	}
+
+	for _, item := range cases {
+		req, _ := http.NewRequest(item.Method, ts.URL+item.Path, strings.NewReader(item.Body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if item.Auth != nil {
+			item.Auth(req)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Errorf("[%s] request error: %v", item.Name, err)
+			continue
+		}
+		body, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%s] expected http status %v, got %v: %s", item.Name, item.Status, resp.StatusCode, body)
+			continue
+		}
+		result := CR{}
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Errorf("[%s] cant unpack json: %v", item.Name, err)
+			continue
+		}
+		if result["error"] != item.Error {
+			t.Errorf("[%s] expected error %q, got %q", item.Name, item.Error, result["error"])
+		}
+	}
+}
+
+// без Options api пускает старый ключ 100500 со всеми ролями, как до Authenticator
+func TestServeHTTPWithoutOptions(t *testing.T) {
+	ts := httptest.NewServer(NewMyApi())
+	defer ts.Close()
+
+	cases := []struct {
+		Key    string
+		Status int
+	}{
+		{"100500", http.StatusOK},
+		{"", http.StatusForbidden},
+		{"wrong", http.StatusForbidden},
+	}
+	for _, item := range cases {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/user/status", strings.NewReader("login=rvasily&status=moderator"))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if item.Key != "" {
+			req.Header.Set("X-Auth", item.Key)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != item.Status {
+			t.Errorf("[%q] expected http status %v, got %v", item.Key, item.Status, resp.StatusCode)
+		}
+	}
+}
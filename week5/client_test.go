@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"week5/apigen"
+)
+
+func TestGeneratedClient(t *testing.T) {
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), testOptions))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewMyApiClient(ts.URL)
+
+	user, err := c.Profile(ctx, ProfileParams{Login: "rvasily"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != 42 || user.FullName != "Vasily Romanov" {
+		t.Errorf("bad user: %+v", user)
+	}
+
+	// ApiError со статусом сервера
+	_, err = c.Profile(ctx, ProfileParams{Login: "not_exist_user"})
+	if apiErr, ok := err.(ApiError); !ok || apiErr.HTTPStatus != http.StatusNotFound || apiErr.Error() != "user not exist" {
+		t.Errorf("expected ApiError 404, got %#v", err)
+	}
+	_, err = c.Profile(ctx, ProfileParams{})
+	if apiErr, ok := err.(ApiError); !ok || apiErr.HTTPStatus != http.StatusBadRequest {
+		t.Errorf("expected ApiError 400, got %#v", err)
+	}
+
+	// без ключа
+	_, err = c.Create(ctx, CreateParams{Login: "mr.moderator", Age: 32})
+	if apiErr, ok := err.(ApiError); !ok || apiErr.HTTPStatus != http.StatusForbidden {
+		t.Errorf("expected ApiError 403, got %#v", err)
+	}
+
+	c.Sign = apigen.APIKey("100500")
+	created, err := c.Create(ctx, CreateParams{Login: "mr.moderator", Name: "Ivan", Age: 32})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	user, err = c.Profile(ctx, ProfileParams{Login: "mr.moderator"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if user.ID != created.ID || user.FullName != "Ivan" || user.Status != statusUser {
+		t.Errorf("bad created user: %+v", user)
+	}
+
+	// все типы параметров проходят через json-тело
+	age, verified := 33, true
+	birthday := time.Date(1990, 1, 2, 0, 0, 0, 0, time.UTC)
+	info, err := c.SetInfo(ctx, InfoParams{
+		Login:    "rvasily",
+		Age:      &age,
+		Rating:   4.5,
+		Visits:   10,
+		Verified: &verified,
+		Birthday: birthday,
+		Tags:     []string{"go", "db"},
+		Address:  &Address{City: "Moscow"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &UserInfo{
+		Login:    "rvasily",
+		Age:      33,
+		Rating:   4.5,
+		Visits:   10,
+		Verified: true,
+		Birthday: &birthday,
+		Tags:     []string{"go", "db"},
+		Address:  &Address{City: "Moscow"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("results not match\nGot: %#v\nExpected: %#v", info, expected)
+	}
+}
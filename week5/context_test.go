@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"week5/apigen"
+)
+
+func TestRequestContext(t *testing.T) {
+	api := NewMyApi()
+	ts := httptest.NewServer(NewMyApiHandler(api, testOptions))
+	defer ts.Close()
+
+	// id запроса из заголовка доходит до метода и возвращается клиенту
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/user/search?query=vas", nil)
+	req.Header.Set(apigen.RequestIDHeader, "req-1")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	result := struct {
+		Response SearchResult `json:"response"`
+	}{}
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(apigen.RequestIDHeader) != "req-1" ||
+		result.Response.RequestID != "req-1" || len(result.Response.Logins) != 1 {
+		t.Errorf("bad search: %d %s %+v", resp.StatusCode, resp.Header.Get(apigen.RequestIDHeader), result.Response)
+	}
+
+	// без заголовка id придумывается
+	resp, err = client.Get(ts.URL + "/user/profile?login=rvasily")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.Header.Get(apigen.RequestIDHeader) == "" {
+		t.Errorf("expected generated request id")
+	}
+
+	// хранилище не успевает за таймаут из apigen:api
+	api.searchDelay = time.Second
+	start := time.Now()
+	runTests(t, ts, []Case{
+		Case{
+			Path:   "/user/search",
+			Query:  "query=vas",
+			Status: http.StatusGatewayTimeout,
+			Result: CR{
+				"error": "timeout",
+			},
+		},
+	})
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("timeout must stop the method, took %s", time.Since(start))
+	}
+}
+
+func TestClientDisconnect(t *testing.T) {
+	api := NewMyApi()
+	api.searchDelay = time.Second
+	statuses := make(chan int, 1)
+	opts := testOptions
+	opts.Middleware = []apigen.Middleware{
+		apigen.Timing(func(r *http.Request, status int, d time.Duration) {
+			statuses <- status
+		}),
+	}
+	ts := httptest.NewServer(NewMyApiHandler(api, opts))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := NewMyApiClient(ts.URL).Search(ctx, SearchParams{Query: "vas"})
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected client deadline, got %v", err)
+	}
+
+	select {
+	case status := <-statuses:
+		if status != apigen.StatusClientClosedRequest {
+			t.Errorf("expected %d, got %d", apigen.StatusClientClosedRequest, status)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("method must stop when client is gone")
+	}
+}
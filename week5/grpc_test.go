@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"week5/apigen"
+	"week5/apigen/apigrpc"
+)
+
+// startGRPC поднимает сервер с MyApi на свободном порту, как week7 в service_test.go
+func startGRPC(t *testing.T, api *MyApi) (*grpc.ClientConn, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cant listen: %v", err)
+	}
+	server := grpc.NewServer()
+	NewMyApiGRPC(api, testOptions).Register(server)
+	go server.Serve(lis)
+
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()), apigrpc.DialOption())
+	if err != nil {
+		t.Fatalf("cant connect: %v", err)
+	}
+	return conn, func() {
+		conn.Close()
+		server.Stop()
+	}
+}
+
+func isCode(t *testing.T, name string, err error, code codes.Code, msg string) {
+	st, _ := status.FromError(err)
+	if st.Code() != code || st.Message() != msg {
+		t.Errorf("[%s] expected %s %q, got %v", name, code, msg, err)
+	}
+}
+
+func TestGRPC(t *testing.T) {
+	conn, stop := startGRPC(t, NewMyApi())
+	defer stop()
+	ctx := context.Background()
+	auth := metadata.AppendToOutgoingContext(ctx, "x-auth", "100500")
+
+	user := &User{}
+	err := conn.Invoke(ctx, "/main.MyApi/Profile", &ProfileParams{Login: "rvasily"}, user)
+	expected := &User{ID: 42, Login: "rvasily", FullName: "Vasily Romanov", Status: statusAdmin}
+	if err != nil || !reflect.DeepEqual(user, expected) {
+		t.Errorf("bad profile: %v %+v", err, user)
+	}
+
+	// ApiError становится кодом gRPC с тем же текстом
+	err = conn.Invoke(ctx, "/main.MyApi/Profile", &ProfileParams{Login: "nobody"}, &User{})
+	isCode(t, "not found", err, codes.NotFound, "user not exist")
+	err = conn.Invoke(ctx, "/main.MyApi/Profile", &ProfileParams{Login: "bad_user"}, &User{})
+	isCode(t, "internal", err, codes.Internal, "bad user")
+
+	// правила apivalidator те же, что и для http
+	err = conn.Invoke(ctx, "/main.MyApi/Profile", &ProfileParams{}, &User{})
+	isCode(t, "required", err, codes.InvalidArgument, "login must me not empty")
+	err = conn.Invoke(ctx, "/main.MyApi/Signup", &SignupParams{
+		Login:           "1user",
+		Email:           "Vasily <v@mail.ru>",
+		Site:            "mail.ru",
+		Password:        "secret",
+		PasswordConfirm: "secret",
+		Level:           4,
+		Invite:          "abc",
+	}, &NewUser{})
+	isCode(t, "signup", err, codes.InvalidArgument, "login must match ^[a-z][a-z0-9_.]{2,15}$; "+
+		"email must be email; "+
+		"site must be url; "+
+		"password must be at least 8 chars; "+
+		"level must be one of [1, 2, 3]; "+
+		"invite len must be 8")
+
+	// ключ приходит в метаданных x-auth
+	err = conn.Invoke(ctx, "/main.MyApi/Create", &CreateParams{Login: "new_moderator", Status: "moderator"}, &NewUser{})
+	isCode(t, "no auth", err, codes.Unauthenticated, "unauthorized")
+	created := &NewUser{}
+	err = conn.Invoke(auth, "/main.MyApi/Create", &CreateParams{Login: "new_moderator", Status: "moderator"}, created)
+	if err != nil || created.ID != 43 {
+		t.Errorf("bad create: %v %+v", err, created)
+	}
+	err = conn.Invoke(auth, "/main.MyApi/Create", &CreateParams{Login: "new_moderator"}, &NewUser{})
+	isCode(t, "exist", err, codes.AlreadyExists, "user new_moderator exist")
+
+	// default из тега тоже работает: статус не передан, значит user
+	status := &User{}
+	err = conn.Invoke(auth, "/main.MyApi/SetStatus", &StatusParams{Login: "new_moderator"}, status)
+	if err != nil || status.Status != statusUser {
+		t.Errorf("bad status: %v %+v", err, status)
+	}
+}
+
+func TestGRPCTypes(t *testing.T) {
+	conn, stop := startGRPC(t, NewMyApi())
+	defer stop()
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-auth", "100500")
+
+	age, verified := 0, true
+	birthday := time.Date(1990, 5, 17, 12, 30, 0, 500, time.UTC)
+	in := &InfoParams{
+		Login:    "rvasily",
+		Age:      &age,
+		Rating:   4.5,
+		Visits:   12,
+		Verified: &verified,
+		Birthday: birthday,
+		Tags:     []string{"go", "grpc"},
+		Address:  &Address{City: "Moscow"},
+	}
+	info := &UserInfo{}
+	if err := conn.Invoke(ctx, "/main.MyApi/SetInfo", in, info); err != nil {
+		t.Fatalf("set info: %v", err)
+	}
+	expected := &UserInfo{
+		Login:    "rvasily",
+		Age:      0,
+		Rating:   4.5,
+		Visits:   12,
+		Verified: true,
+		Birthday: &birthday,
+		Tags:     []string{"go", "grpc"},
+		Address:  &Address{City: "Moscow"},
+	}
+	if !reflect.DeepEqual(info, expected) {
+		t.Errorf("bad info:\n got %+v\nwant %+v", info, expected)
+	}
+}
+
+func TestGRPCStreams(t *testing.T) {
+	conn, stop := startGRPC(t, NewMyApi())
+	defer stop()
+	ctx := context.Background()
+
+	stream, err := conn.NewStream(ctx, &grpcDescMyApi.Streams[0], "/main.MyApi/List")
+	if err != nil {
+		t.Fatalf("cant open stream: %v", err)
+	}
+	stream.SendMsg(&ListParams{})
+	stream.CloseSend()
+	var logins []string
+	for {
+		user := &User{}
+		err := stream.RecvMsg(user)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("list: %v", err)
+		}
+		logins = append(logins, user.Login)
+	}
+	if !reflect.DeepEqual(logins, []string{"rvasily"}) {
+		t.Errorf("bad list: %v", logins)
+	}
+
+	// простые значения приходят обёрнутыми в сообщение
+	stream, err = conn.NewStream(ctx, &grpcDescMyApi.Streams[1], "/main.MyApi/Logins")
+	if err != nil {
+		t.Fatalf("cant open stream: %v", err)
+	}
+	stream.SendMsg(&LoginsParams{Prefix: "rv"})
+	stream.CloseSend()
+	item := &MyApiLoginsItem{}
+	if err := stream.RecvMsg(item); err != nil || item.Value != "rvasily" {
+		t.Errorf("bad login: %v %+v", err, item)
+	}
+	if err := stream.RecvMsg(item); err != io.EOF {
+		t.Errorf("expected end of stream, got %v", err)
+	}
+
+	// проверка параметров до начала потока
+	stream, _ = conn.NewStream(ctx, &grpcDescMyApi.Streams[0], "/main.MyApi/List")
+	bad := "banned"
+	stream.SendMsg(&ListParams{Status: &bad})
+	stream.CloseSend()
+	err = stream.RecvMsg(&User{})
+	isCode(t, "list status", err, codes.InvalidArgument, "status must be one of [user, moderator, admin]")
+}
+
+func TestGRPCContext(t *testing.T) {
+	api := NewMyApi()
+	conn, stop := startGRPC(t, api)
+	defer stop()
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), apigen.RequestIDHeader, "req-1")
+	result := &SearchResult{}
+	err := conn.Invoke(ctx, "/main.MyApi/Search", &SearchParams{Query: "vas"}, result)
+	if err != nil || result.RequestID != "req-1" || len(result.Logins) != 1 {
+		t.Errorf("bad search: %v %+v", err, result)
+	}
+
+	// таймаут из apigen:api работает и здесь
+	api.searchDelay = time.Second
+	err = conn.Invoke(ctx, "/main.MyApi/Search", &SearchParams{Query: "vas"}, result)
+	isCode(t, "timeout", err, codes.DeadlineExceeded, "timeout")
+}
@@ -1,19 +1,31 @@
-// go build handlers_gen/codegen.go && codegen.exe api.go api_handlers.go
+// go build -o codegen.exe ./handlers_gen && codegen.exe api.go api_handlers.go
+// или для всего пакета: //go:generate go run ./handlers_gen
+// с -grpc ещё api.proto и api_grpc.go с теми же методами по gRPC
 // go test -v
 package main
 
 import (
+	"bytes"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/token"
+	"go/types"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
 	"text/template"
+	"time"
+
+	"week5/apigen"
 )
 
 // {{range .StructFields.FList}} //{{.Name}}
@@ -24,16 +36,180 @@ import (
 
 type Fields struct {
 	FList []Field
+	// eqfield, nefield
+	Cross []Check
 }
 
 type JsonFields struct {
-	FList map[string]Field
+	FList []Field
 }
 
 type Field struct {
 	Name string
 	Tag  Rules
-	Type string
+	Type FieldType
+	// где поле объявлено, для ошибок
+	Pos token.Pos `json:"-"`
+	// номер поля в .proto из тега proto:"3", 0 - тега нет (см. parseProtoTag)
+	Proto int `json:"-"`
+}
+
+// FieldType - что генератор знает о типе поля структуры параметров
+type FieldType struct {
+	// тип как он записан в коде, без указателя: int, time.Time, []string, Address
+	Go string
+	// string, int, uint, float, bool, time, strings, file
+	// (io.Reader, multipart.File, *apigen.File) или struct для всего остального
+	Kind string
+	// размер для int, uint, float - нужен strconv
+	Bits int
+	// указатель - необязательный параметр, nil если не передан
+	Pointer bool
+}
+
+// Call - вызов метода apigen.Params, который достаёт значение этого типа
+func (f Field) Call() string {
+	name := strconv.Quote(f.Tag.ParamName)
+	switch f.Type.Kind {
+	case "string":
+		return "src.String(" + name + ")"
+	case "int":
+		return "src.Int(" + name + ", " + strconv.Itoa(f.Type.Bits) + ")"
+	case "uint":
+		return "src.Uint(" + name + ", " + strconv.Itoa(f.Type.Bits) + ")"
+	case "float":
+		return "src.Float(" + name + ", " + strconv.Itoa(f.Type.Bits) + ")"
+	case "bool":
+		return "src.Bool(" + name + ")"
+	case "time":
+		return "src.Time(" + name + ")"
+	case "strings":
+		return "src.Strings(" + name + ")"
+	case "file":
+		maxSize := f.Tag.MaxSize
+		if maxSize == "" {
+			maxSize = "0"
+		}
+		return "src.File(" + name + ", " + maxSize + ")"
+	}
+	return ""
+}
+
+// Convert - приведение значения из apigen.Params к типу поля
+func (f Field) Convert() string {
+	switch f.Type.Go {
+	case "string", "int64", "uint64", "float64", "bool", "time.Time", "[]string":
+		return "v"
+	}
+	return f.Type.Go + "(v)"
+}
+
+// KindName - как тип называется в тексте ошибки
+func (f Field) KindName() string {
+	switch f.Type.Kind {
+	case "time":
+		return "RFC3339 time"
+	case "strings":
+		return "list of strings"
+	case "struct":
+		return "object"
+	}
+	return f.Type.Kind
+}
+
+// Value - выражение со значением поля, для указателя - разыменованное
+func (f Field) Value() string {
+	if f.Type.Pointer {
+		return "*params." + f.Name
+	}
+	return "params." + f.Name
+}
+
+// IsZero - условие "параметр не передан" для required
+func (f Field) IsZero() string {
+	v := "params." + f.Name
+	switch {
+	case f.Type.Pointer || f.Type.Kind == "file":
+		return v + " == nil"
+	case f.Type.Kind == "string":
+		return v + ` == ""`
+	case f.Type.Kind == "int" || f.Type.Kind == "uint" || f.Type.Kind == "float":
+		return v + " == 0"
+	case f.Type.Kind == "bool":
+		return "!" + v
+	case f.Type.Kind == "time":
+		return v + ".IsZero()"
+	case f.Type.Kind == "strings":
+		return "len(" + v + ") == 0"
+	}
+	return ""
+}
+
+// Measure - что сравнивается с min и max: само число или длина
+func (f Field) Measure() string {
+	if f.Type.Kind == "string" || f.Type.Kind == "strings" {
+		return "len(" + f.Value() + ")"
+	}
+	return f.Value()
+}
+
+// MeasureName - начало текста ошибки min/max
+func (f Field) MeasureName() string {
+	if f.Type.Kind == "string" || f.Type.Kind == "strings" {
+		return f.Tag.ParamName + " len"
+	}
+	return f.Tag.ParamName
+}
+
+// DefaultLit - значение default как литерал go
+func (f Field) DefaultLit() string {
+	if f.Type.Kind == "string" {
+		return strconv.Quote(f.Tag.Default)
+	}
+	return f.Tag.Default
+}
+
+var basicKinds = map[string]FieldType{
+	"string":  {Kind: "string"},
+	"int":     {Kind: "int", Bits: 64},
+	"int8":    {Kind: "int", Bits: 8},
+	"int16":   {Kind: "int", Bits: 16},
+	"int32":   {Kind: "int", Bits: 32},
+	"int64":   {Kind: "int", Bits: 64},
+	"uint":    {Kind: "uint", Bits: 64},
+	"uint8":   {Kind: "uint", Bits: 8},
+	"uint16":  {Kind: "uint", Bits: 16},
+	"uint32":  {Kind: "uint", Bits: 32},
+	"uint64":  {Kind: "uint", Bits: 64},
+	"float32": {Kind: "float", Bits: 32},
+	"float64": {Kind: "float", Bits: 64},
+	"bool":    {Kind: "bool"},
+}
+
+func parseFieldType(expr ast.Expr) FieldType {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		ft := parseFieldType(star.X)
+		if ft.Go == "apigen.File" {
+			// файл и так nil, если его не прислали, необязательным указателем он не считается
+			return FieldType{Go: "*apigen.File", Kind: "file"}
+		}
+		ft.Pointer = true
+		return ft
+	}
+	ft := FieldType{Go: types.ExprString(expr), Kind: "struct"}
+	if basic, ok := basicKinds[ft.Go]; ok {
+		basic.Go = ft.Go
+		return basic
+	}
+	switch ft.Go {
+	case "time.Time":
+		ft.Kind = "time"
+	case "[]string":
+		ft.Kind = "strings"
+	case "io.Reader", "multipart.File":
+		ft.Kind = "file"
+	}
+	return ft
 }
 
 type TplParam struct {
@@ -45,121 +221,238 @@ type TplParam struct {
 	User         string
 	JsonStructs  JsonFields
 	Method       string
+	Auth         bool
+	Roles        []string
+	Result       Result
+	// формат потока по умолчанию, apigen.NDJSON или apigen.SSE
+	Stream string
+	// ограничение на тело запроса в байтах, 0 - без ограничения
+	MaxBody int64
+	// таймаут метода литералом go, например 2 * time.Second
+	Timeout string
+}
+
+// ClientMethod - http-метод для клиента: без "method" в apigen:api это GET,
+// но файлы в query не передать, тогда POST
+func (p TplParam) ClientMethod() string {
+	if p.Method != "" {
+		return p.Method
+	}
+	for _, f := range p.StructFields.FList {
+		if f.Type.Kind == "file" {
+			return "POST"
+		}
+	}
+	return "GET"
+}
+
+// HasFiles - в параметрах есть файлы, тело приходит multipart-формой
+func (p TplParam) HasFiles() bool {
+	for _, f := range p.StructFields.FList {
+		if f.Type.Kind == "file" {
+			return true
+		}
+	}
+	return false
 }
 
 type apiMeta struct {
 	Url    string
 	Auth   bool
 	Method string
+	// роли, которые должны быть у пользователя, имеет смысл только вместе с auth
+	Roles []string
+	// формат для методов, которые возвращают поток: ndjson (по умолчанию) или sse
+	Stream string
+	// ограничение на размер тела в байтах, больше - 413
+	MaxBody int64 `json:"max_body"`
+	// таймаут метода в формате time.ParseDuration, по истечении - 504
+	Timeout string
+}
+
+// Result - что возвращает метод api
+type Result struct {
+	// json - *Struct в конверте, blob - *apigen.Blob как есть,
+	// chan - <-chan T, seq - func(yield func(T) bool), seq2 - func(yield func(T, error) bool)
+	Kind string
+	// тип ответа или элемента потока как он записан в коде
+	Type string
+}
+
+// IsStream - метод отдаёт элементы по одному
+func (r Result) IsStream() bool {
+	return r.Kind == "chan" || r.Kind == "seq" || r.Kind == "seq2"
 }
 
 type Rules struct {
 	ParamName string
 	Required  bool
 	Min       bool
-	MinValue  int
+	MinValue  string
 	Max       bool
-	MaxValue  int
+	MaxValue  string
 	Enum      []string
 	Default   string
+	Len       string
+	Regexp    string
+	Email     bool
+	URL       bool
+	// ограничение на размер файла в байтах
+	MaxSize string
+	// eqfield, nefield и password==password_confirm
+	Compare []Compare
+	// функции, помеченные // apigen:validator
+	Custom []string
 }
 
 var wrapTpl = template.Must(template.New("wrapTpl").Parse(`
-func ({{.Srv}} *{{.ApiName}}) {{.FuncName}}Wrapped(w http.ResponseWriter, r *http.Request) {
-	{{- if eq .Method "POST"}}
-	if r.Header.Get("X-Auth") != "100500" {
+func (h *{{.ApiName}}Handler) handle{{.FuncName}}(w http.ResponseWriter, r *http.Request) {
+	var err error
+	// id запроса уже в контексте, его положил ServeHTTP
+	ctx := r.Context()
+	{{- if .Timeout }}
+	ctx, cancel := context.WithTimeout(ctx, {{.Timeout}})
+	defer cancel()
+	{{- end }}
+	// тело читают и подпись HMAC, и ReadParams: ограничение ставится до них
+	if r.Body != nil {
+		r.Body = http.MaxBytesReader(w, r.Body, {{ if .MaxBody }}{{.MaxBody}}{{ else }}apigen.DefaultMaxBody{{ end }})
+	}
+	{{- if .Auth }}
+	principal, err := apigen.Authenticate(h.opts.Auth, r)
+	if err == apigen.ErrBodyTooLarge {
+		sendError(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
 		sendError(w, "unauthorized", http.StatusForbidden)
 		return
 	}
-	if r.Method != "POST" {
-		sendError(w, "bad method", http.StatusNotAcceptable)
+	{{- if .Roles }}
+	if !principal.HasRoles({{ range $index, $role := .Roles }}{{ if $index }}, {{ end }}"{{ $role }}"{{ end }}) {
+		sendError(w, "forbidden", http.StatusForbidden)
 		return
 	}
 	{{- end }}
-	var q url.Values
-	err := errors.New("")
+	ctx = apigen.WithPrincipal(ctx, principal)
+	{{- end }}
 	// заполнение структуры params
 	params := {{.StructName}}{}
-	if r.Method == "GET" {
-		q = r.URL.Query()
-	} else {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		q, _ = url.ParseQuery(string(reqBody))
-	}
-	{{range .StructFields.FList -}}//{{.Name -}}
-	
-	{{ if eq .Type "int" }}
-	params.{{.Name}}, err = strconv.Atoi(q.Get("{{.Tag.ParamName}}"))
+	{{- if .StructFields.FList }}
+	src, err := apigen.ReadParams(r)
 	if err != nil {
-		sendError(w, "age must be int", http.StatusBadRequest)
-		return	
-	}
-	{{ else }}
-	params.{{.Name}} = q.Get("{{.Tag.ParamName}}") 
-	{{ end }} 
-
-	{{- if .Tag.Default -}}
-	if params.{{ .Name }} == "" {
-		params.{{ .Name }} = "{{ .Tag.Default }}"
-	}
-
-	{{- end -}}
-
-	{{- if .Tag.Required -}}
-	if params.{{.Name}} == "" {
-		sendError(w, "{{.Tag.ParamName}} must me not empty", http.StatusBadRequest)
-		return	
+		sendError(w, err.Error(), apigen.ErrorStatus(err))
+		return
 	}
+	// закрывает загруженные файлы и удаляет их временные копии
+	defer src.Close()
+	// нарушения собираем по всем полям и отдаём одной ошибкой
+	errs := &apigen.Violations{}
 	{{- end }}
-
-	{{- if and .Tag.Min (eq .Type "int") -}}
-	if params.{{.Name}} < {{ .Tag.MinValue }} {
-		sendError(w, "{{ .Tag.ParamName }} must be >= {{ .Tag.MinValue }}", http.StatusBadRequest)
-		return	
+	{{ range $f := .StructFields.FList }}
+	// {{.Name}}
+	{{- if .Type.Pointer }}
+	if src.Has("{{.Tag.ParamName}}") {
+	{{- else }}
+	{
+	{{- end }}
+		{{- if eq .Type.Kind "file" }}
+		v, err := {{.Call}}
+		if err != nil {
+			errs.Add("{{.Tag.ParamName}}", "{{.Tag.ParamName}} "+err.Error())
+		} else if v != nil {
+			params.{{.Name}} = v
+		}
+		{{- else if eq .Type.Kind "struct" }}
+		{{- if .Type.Pointer }}
+		params.{{.Name}} = new({{.Type.Go}})
+		if err := src.Decode("{{.Tag.ParamName}}", params.{{.Name}}); err != nil {
+		{{- else }}
+		if err := src.Decode("{{.Tag.ParamName}}", &params.{{.Name}}); err != nil {
+		{{- end }}
+			errs.Add("{{.Tag.ParamName}}", "{{.Tag.ParamName}} must be object")
+		}
+		{{- else }}
+		v, err := {{.Call}}
+		if err != nil {
+			errs.Add("{{.Tag.ParamName}}", "{{.Tag.ParamName}} must be {{.KindName}}")
+		} else {
+			{{- if .Type.Pointer }}
+			tmp := {{.Convert}}
+			params.{{.Name}} = &tmp
+			{{- else }}
+			params.{{.Name}} = {{.Convert}}
+			{{- end }}
+		}
+		{{- end }}
 	}
-	{{- end -}}
+	{{ end }}
 
-	{{- if and .Tag.Min (eq .Type "string") }}
-	if len(params.{{.Name}}) < {{.Tag.MinValue}} {
-		sendError(w, "{{ .Tag.ParamName }} len must be >= {{ .Tag.MinValue }}", http.StatusBadRequest)
-		return	
+	{{- if .StructFields.FList }}
+	validate{{.StructName}}(&params, errs)
+	if errs.Len() > 0 {
+		sendError(w, errs.Error(), http.StatusBadRequest)
+		return
 	}
-	{{- end -}}
+	{{- end }}
 
-	{{- if and .Tag.Max (eq .Type "int") }}
-	if params.{{.Name}} > {{.Tag.MaxValue}} {
-		sendError(w, "{{ .Tag.ParamName }} must be <= {{ .Tag.MaxValue }}", http.StatusBadRequest)
-		return	
+	{{- if eq .Result.Kind "blob" }}
+	blob, err := h.srv.{{.FuncName}}(ctx, params)
+	{{- template "apiError" }}
+	apigen.ServeBlob(w, blob)
+	{{- else if .Result.IsStream }}
+	items, err := h.srv.{{.FuncName}}(ctx, params)
+	{{- template "apiError" }}
+	stream := apigen.NewStream(ctx, w, r, "{{.Stream}}")
+	{{- if eq .Result.Kind "chan" }}
+	for item := range items {
+		// клиент ушёл - дочитываем канал, чтобы не повесить горутину, которая в него пишет
+		if err == nil {
+			err = stream.Send(item)
+		}
 	}
-	{{- end -}}
-
-	{{if and .Tag.Max (eq .Type "string")}}
-	if len(params.{{.Name}}) > {{.Tag.MaxValue}} {
-		sendError(w, "{{ .Tag.ParamName }} len must be <= {{ .Tag.MaxValue }}", http.StatusBadRequest)
-		return	
+	{{- else if eq .Result.Kind "seq2" }}
+	for item, err := range items {
+		if err != nil {
+			stream.Fail(err)
+			break
+		}
+		if err := stream.Send(item); err != nil {
+			break
+		}
 	}
-	{{- end -}}
-
-	{{- if .Tag.Enum }}
-	enum{{ .Name }}Valid := false
-	enum{{ .Name }} := []string{ {{- range $index, $element := .Tag.Enum }}{{ if $index }}, {{ end }}"{{ $element }}"{{ end -}} }
-	for _, valid := range enum{{ .Name }} {
-		if valid == params.{{ .Name }} {
-			enum{{ .Name }}Valid = true
+	{{- else }}
+	for item := range items {
+		if err := stream.Send(item); err != nil {
 			break
 		}
 	}
+	{{- end }}
+	{{- else }}
+	user, err := h.srv.{{.FuncName}}(ctx, params)
+	{{- template "apiError" }}
+
+	result := CR{
+		"error":    "",
+		"response": user,
+	}
 
-	if !enum{{ .Name }}Valid {
-		sendError(w, "{{ .Tag.ParamName }} must be one of [" + strings.Join(enum{{ .Name }}, ", ") + "]", http.StatusBadRequest)
+	b, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	{{ end }}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
 	{{- end }}
+}
 
-
-	user, err := srv.{{.FuncName}}(context.TODO(), params)
+{{- define "apiError" }}
 	if err != nil {
+		if status, msg, ok := apigen.ContextError(ctx, err); ok {
+			sendError(w, msg, status)
+			return
+		}
 		switch err := err.(type) {
 		case ApiError:
 			sendError(w, err.Error(), err.HTTPStatus)
@@ -168,19 +461,60 @@ func ({{.Srv}} *{{.ApiName}}) {{.FuncName}}Wrapped(w http.ResponseWriter, r *htt
 		}
 		return
 	}
+{{- end }}
+`))
 
-	result := CR{
-		"error":    "",
-		"response": user,
+// validateTpl - значения по умолчанию и правила apivalidator для структуры параметров.
+// Одна функция на структуру, её вызывают и http-хендлер после разбора, и gRPC-обёртка
+var validateTpl = template.Must(template.New("validateTpl").Parse(`
+// validate{{.StructName}} проверяет {{.StructName}} по тегам apivalidator.
+// поля, которые не разобрались, уже лежат в errs и не проверяются
+func validate{{.StructName}}(params *{{.StructName}}, errs *apigen.Violations) {
+	{{- range $f := .StructFields.FList }}
+	// {{.Name}}
+
+	{{- if .Tag.Default }}
+	if {{.IsZero}} {
+		{{- if .Type.Pointer }}
+		tmp := {{.Type.Go}}({{.DefaultLit}})
+		params.{{.Name}} = &tmp
+		{{- else }}
+		params.{{.Name}} = {{.DefaultLit}}
+		{{- end }}
 	}
+	{{- end }}
 
-	b, err := json.Marshal(result)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	{{- if .Tag.Required }}
+	if !errs.Failed("{{.Tag.ParamName}}") && {{.IsZero}} {
+		errs.Add("{{.Tag.ParamName}}", "{{.Tag.ParamName}} must me not empty")
 	}
-	w.Header().Set("Content-Type", "application/json")
-	fmt.Fprintln(w, string(b))
+	{{- end }}
+
+	{{- with .Checks }}
+	if {{$f.Guard}} {
+		switch {
+		{{- range . }}
+		case {{.Cond}}:
+			errs.Add("{{$f.Tag.ParamName}}", {{.Msg}})
+		{{- end }}
+		}
+	}
+	{{- end }}
+
+	{{- range .Tag.Custom }}
+	if {{$f.Guard}} {
+		if err := {{.}}({{$f.Value}}); err != nil {
+			errs.Add("{{$f.Tag.ParamName}}", "{{$f.Tag.ParamName}} "+err.Error())
+		}
+	}
+	{{- end }}
+	{{ end }}
+
+	{{- range .StructFields.Cross }}
+	if {{.Cond}} {
+		errs.Add("{{.Param}}", {{.Msg}})
+	}
+	{{- end }}
 }
 `))
 
@@ -188,49 +522,506 @@ type Api struct {
 	Srv     string
 	ApiName string
 	Methods []MR
+	Routes  []Route
+	// OpenAPI документ, готовый литерал go
+	Spec string
+	// все роли из apigen:api методов, их получает apigen.LegacyAuth
+	Roles []string
+}
+
+// Route - все методы api на одном пути
+type Route struct {
+	Pattern   string
+	Vars      []string
+	Endpoints []MR
+	// метод без "method" в apigen:api, принимает всё, что не забрали остальные
+	Any *MR
+	// для 405 - какие http-методы есть на этом пути
+	Allow string
+}
+
+// buildRoutes группирует методы по путям, пути без параметров идут первыми
+func buildRoutes(methods []MR) []Route {
+	var routes []Route
+	index := map[string]int{}
+	for _, m := range methods {
+		i, ok := index[m.Route]
+		if !ok {
+			i = len(routes)
+			index[m.Route] = i
+			routes = append(routes, Route{Pattern: m.Route, Vars: apigen.PathVars(m.Route)})
+		}
+		route := &routes[i]
+		if m.Tpl.Method == "" {
+			if route.Any != nil {
+				errorf(m.Pos, "%s and %s both handle %s without method", route.Any.Method, m.Method, m.Route)
+				continue
+			}
+			m := m
+			route.Any = &m
+			continue
+		}
+		for _, e := range route.Endpoints {
+			if e.Tpl.Method == m.Tpl.Method {
+				errorf(m.Pos, "%s and %s both handle %s %s", e.Method, m.Method, m.Tpl.Method, m.Route)
+			}
+		}
+		route.Endpoints = append(route.Endpoints, m)
+		if route.Allow != "" {
+			route.Allow += ", "
+		}
+		route.Allow += m.Tpl.Method
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].Vars) < len(routes[j].Vars)
+	})
+	return routes
 }
 
+// checkPathVars - каждый параметр пути должен быть полем структуры параметров
+func checkPathVars(pos token.Pos, funcName, route string, fields Fields) {
+	for _, name := range apigen.PathVars(route) {
+		found := false
+		for _, f := range fields.FList {
+			if f.Tag.ParamName == name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errorf(pos, "%s: path param {%s} has no field in params struct", funcName, name)
+		}
+	}
+}
+
+var clientTpl = template.Must(template.New("clientTpl").Parse(`
+// {{.ApiName}}Client - клиент к {{.ApiName}}, параметры кодируются так же, как их разбирает {{.ApiName}}Handler.
+// Ошибки сервера возвращаются как ApiError со статусом ответа
+type {{.ApiName}}Client struct {
+	apigen.Client
+}
+
+func New{{.ApiName}}Client(baseURL string) *{{.ApiName}}Client {
+	return &{{.ApiName}}Client{apigen.Client{BaseURL: baseURL}}
+}
+{{ range .Methods }}
+{{- if eq .Tpl.Result.Kind "blob" }}
+// {{.Method}} - Body ответа надо закрыть
+func (c *{{$.ApiName}}Client) {{.Method}}(ctx context.Context, in {{.Tpl.StructName}}) (*apigen.Blob, error) {
+{{- else if .Tpl.Result.IsStream }}
+// {{.Method}} отдаёт элементы потока в fn по мере получения, ошибка из fn прекращает чтение
+func (c *{{$.ApiName}}Client) {{.Method}}(ctx context.Context, in {{.Tpl.StructName}}, fn func({{.Tpl.Result.Type}}) error) error {
+{{- else }}
+func (c *{{$.ApiName}}Client) {{.Method}}(ctx context.Context, in {{.Tpl.StructName}}) (*{{.Tpl.User}}, error) {
+{{- end }}
+	args := &apigen.Args{}
+	{{- range .Tpl.StructFields.FList }}
+	{{- if eq .Type.Kind "file" }}
+	if in.{{.Name}} != nil {
+		args.Set("{{.Tag.ParamName}}", in.{{.Name}})
+	}
+	{{- else if .Type.Pointer }}
+	if in.{{.Name}} != nil {
+		args.Set("{{.Tag.ParamName}}", *in.{{.Name}})
+	}
+	{{- else }}
+	args.Set("{{.Tag.ParamName}}", in.{{.Name}})
+	{{- end }}
+	{{- end }}
+	{{- if eq .Tpl.Result.Kind "blob" }}
+	blob, err := c.Download(ctx, "{{.Tpl.ClientMethod}}", "{{.Route}}", args)
+	if err != nil {
+		return nil, clientError(err)
+	}
+	return blob, nil
+	{{- else if .Tpl.Result.IsStream }}
+	err := c.Stream(ctx, "{{.Tpl.ClientMethod}}", "{{.Route}}", args, func(raw json.RawMessage) error {
+		var item {{.Tpl.Result.Type}}
+		if err := json.Unmarshal(raw, &item); err != nil {
+			return err
+		}
+		return fn(item)
+	})
+	return clientError(err)
+	{{- else }}
+	out := &{{.Tpl.User}}{}
+	if err := c.Call(ctx, "{{.Tpl.ClientMethod}}", "{{.Route}}", args, out); err != nil {
+		return nil, clientError(err)
+	}
+	return out, nil
+	{{- end }}
+}
+{{ end }}`))
+
 type MR struct {
 	Method string
 	Route  string
+	Pos    token.Pos
+	// для OpenAPI: текст комментария без apigen:api и всё, что знаем о методе
+	Doc string
+	Tpl TplParam
 }
 
 var serveTpl = template.Must(template.New("serveTpl").Parse(`
+// {{.ApiName}}Handler - http-обёртка над {{.ApiName}}, создаётся через New{{.ApiName}}Handler
+type {{.ApiName}}Handler struct {
+	srv  *{{.ApiName}}
+	opts apigen.Options
+	// роутер, обёрнутый в общие middleware
+	handler http.Handler
+	// методы api, обёрнутые в свои middleware
+	endpoints map[string]http.Handler
+	// OpenAPI описание со схемами авторизации из opts.Auth
+	spec string
+}
+
+func New{{.ApiName}}Handler(srv *{{.ApiName}}, opts apigen.Options) *{{.ApiName}}Handler {
+	h := &{{.ApiName}}Handler{
+		srv:  srv,
+		opts: opts,
+	}
+	h.endpoints = map[string]http.Handler{
+		{{- range .Methods }}
+		"{{.Method}}": apigen.Chain(http.HandlerFunc(h.handle{{.Method}}), opts.Endpoint["{{.Method}}"]...),
+		{{- end }}
+	}
+	h.handler = apigen.Chain(http.HandlerFunc(h.route), opts.Middleware...)
+	if opts.OpenAPI {
+		h.spec = apigen.OpenAPI(openAPI{{.ApiName}}, opts.Auth)
+	}
+	return h
+}
+
+// default{{.ApiName}}Handlers - хендлеры ServeHTTP самого {{.ApiName}}, по одному на экземпляр
+var default{{.ApiName}}Handlers sync.Map
+
+// ServeHTTP без настроек: авторизация как раньше, ключом apigen.LegacyKey в X-Auth.
+// Свои Authenticator и middleware - через New{{.ApiName}}Handler
 func ({{.Srv}} *{{.ApiName}}) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	switch r.URL.Path {
-	{{ range .Methods }}
-	case "{{.Route}}":
-		{{$.Srv}}.{{ .Method }}Wrapped(w, r)
-	{{ end }}default:
-		sendError(w, "unknown method", http.StatusNotFound)
+	h, ok := default{{.ApiName}}Handlers.Load({{.Srv}})
+	if !ok {
+		h, _ = default{{.ApiName}}Handlers.LoadOrStore({{.Srv}}, New{{.ApiName}}Handler({{.Srv}}, apigen.Options{
+			Auth: apigen.LegacyAuth({{ range $index, $role := .Roles }}{{ if $index }}, {{ end }}"{{ $role }}"{{ end }}),
+		}))
+	}
+	h.(*{{.ApiName}}Handler).ServeHTTP(w, r)
+}
+
+// openAPI{{.ApiName}} - описание {{.ApiName}} в формате OpenAPI 3
+const openAPI{{.ApiName}} = {{.Spec}}
+
+func (h *{{.ApiName}}Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.handler.ServeHTTP(w, apigen.WithRequestID(w, r))
+}
+
+// route выбирает метод api по пути и http-методу.
+// пути без параметров проверяются раньше, чтобы /user/profile не ушёл в /user/{login}
+func (h *{{.ApiName}}Handler) route(w http.ResponseWriter, r *http.Request) {
+	if h.opts.OpenAPI && r.URL.Path == apigen.OpenAPIPath {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintln(w, h.spec)
+		return
+	}
+	{{- range .Routes }}
+	{{- if .Vars }}
+	if vars, ok := apigen.MatchPath("{{.Pattern}}", r.URL.Path); ok {
+		r = apigen.WithPathParams(r, vars)
+	{{- else }}
+	if r.URL.Path == "{{.Pattern}}" {
+	{{- end }}
+		{{- if .Endpoints }}
+		switch r.Method {
+		{{- range .Endpoints }}
+		case "{{.Tpl.Method}}":
+			h.endpoints["{{.Method}}"].ServeHTTP(w, r)
+		{{- end }}
+		default:
+			{{- if .Any }}
+			h.endpoints["{{.Any.Method}}"].ServeHTTP(w, r)
+			{{- else }}
+			w.Header().Set("Allow", "{{.Allow}}")
+			sendError(w, "bad method", http.StatusMethodNotAllowed)
+			{{- end }}
+		}
+		{{- else }}
+		h.endpoints["{{.Any.Method}}"].ServeHTTP(w, r)
+		{{- end }}
+		return
 	}
+	{{- end }}
+	sendError(w, "unknown method", http.StatusNotFound)
 }
 `))
 
+// пакет с общей частью сгенерированного кода
+const runtimePkg = "week5/apigen"
+
 func main() {
-	fset := token.NewFileSet()
+	output := flag.String("o", "", "куда писать, по умолчанию "+defaultOutput+" рядом с исходниками")
+	check := flag.Bool("check", false, "ничего не писать, а упасть, если сгенерированный файл устарел")
+	withGRPC := flag.Bool("grpc", false, "ещё "+protoOutput+" и "+grpcOutput+" с gRPC-сервисом из тех же методов")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: codegen [-o file] [-check] [-grpc] [dir | files.go...]\n")
+		fmt.Fprintf(os.Stderr, "       codegen api.go api_handlers.go\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	inputs := flag.Args()
+	if *output == "" && len(inputs) == 2 && strings.HasSuffix(inputs[0], ".go") && strings.HasSuffix(inputs[1], ".go") {
+		// старый вызов: codegen api.go api_handlers.go
+		inputs, *output = inputs[:1], inputs[1]
+	}
+	if len(inputs) == 0 {
+		// из go:generate запускаемся в папке пакета
+		inputs = []string{"."}
+	}
+	if *output == "" {
+		dir := inputs[0]
+		if strings.HasSuffix(dir, ".go") {
+			dir = filepath.Dir(dir)
+		}
+		*output = filepath.Join(dir, defaultOutput)
+	}
 
-	node, err := parser.ParseFile(fset, os.Args[1], nil, parser.ParseComments)
+	files, err := parseInputs(inputs, *output)
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	out, err := generate(files, *withGRPC)
+	for _, w := range warnings {
+		fmt.Fprintln(os.Stderr, w)
+	}
+	if len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintln(os.Stderr, p)
+		}
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
-	out, _ := os.Create(os.Args[2])
+	results := map[string][]byte{*output: out.Handlers}
+	if *withGRPC {
+		// gRPC-файлы всегда рядом с хендлерами, в том же пакете
+		dir := filepath.Dir(*output)
+		results[filepath.Join(dir, grpcOutput)] = out.GRPC
+		results[filepath.Join(dir, protoOutput)] = out.Proto
+	}
+	names := make([]string, 0, len(results))
+	for name := range results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	if *check {
+		stale := false
+		for _, name := range names {
+			current, err := ioutil.ReadFile(name)
+			if err != nil || !bytes.Equal(current, results[name]) {
+				fmt.Fprintf(os.Stderr, "%s is out of date, run go generate\n", name)
+				stale = true
+			}
+		}
+		if stale {
+			os.Exit(1)
+		}
+		return
+	}
+	for _, name := range names {
+		if err := ioutil.WriteFile(name, results[name], 0644); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// defaultOutput - имя сгенерированного файла, если не указано другое
+const defaultOutput = "api_handlers.go"
+
+// generatedHeader - по нему go vet и прочие понимают, что файл сгенерирован
+const generatedHeader = "// Code generated by handlers_gen. DO NOT EDIT.\n"
+
+// parseInputs разбирает папку пакета или список файлов. Тесты и сгенерированные файлы
+// (в том числе прошлый результат) пропускаются, файлы идут по имени, чтобы вывод не зависел от ОС
+func parseInputs(inputs []string, output string) ([]*ast.File, error) {
+	var names []string
+	for _, in := range inputs {
+		info, err := os.Stat(in)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			names = append(names, in)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(in, "*.go"))
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range matches {
+			if !strings.HasSuffix(name, "_test.go") {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	outAbs, _ := filepath.Abs(output)
+	var files []*ast.File
+	for _, name := range names {
+		if abs, _ := filepath.Abs(name); abs == outAbs {
+			continue
+		}
+		file, err := parser.ParseFile(fset, name, nil, parser.ParseComments)
+		if err != nil {
+			return nil, err
+		}
+		if isGenerated(file) {
+			continue
+		}
+		if len(files) > 0 && file.Name.Name != files[0].Name.Name {
+			return nil, fmt.Errorf("%s: package %s, expected %s", name, file.Name.Name, files[0].Name.Name)
+		}
+		files = append(files, file)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no go files in %s", strings.Join(inputs, " "))
+	}
+	return files, nil
+}
+
+func isGenerated(file *ast.File) bool {
+	for _, group := range file.Comments {
+		if group.Pos() >= file.Package {
+			break
+		}
+		for _, comment := range group.List {
+			if strings.HasPrefix(comment.Text, "// Code generated ") && strings.HasSuffix(comment.Text, " DO NOT EDIT.") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func allDecls(files []*ast.File) []ast.Decl {
+	var decls []ast.Decl
+	for _, file := range files {
+		decls = append(decls, file.Decls...)
+	}
+	return decls
+}
+
+// apiSignature проверяет что метод выглядит как
+// func (srv *Api) Name(ctx context.Context, in Params) (*Result, error).
+// Вместо *Result может быть *apigen.Blob, <-chan T или итератор func(yield func(T) bool)
+func apiSignature(g *ast.FuncDecl) (apiName, structName string, result Result, ok bool) {
+	fail := func() (string, string, Result, bool) {
+		errorf(g.Pos(), "%s: apigen:api method must be func (srv *Api) %s(ctx context.Context, in Params) (*Result, error)", g.Name.Name, g.Name.Name)
+		return "", "", Result{}, false
+	}
+	if g.Recv == nil || len(g.Recv.List) != 1 || len(g.Recv.List[0].Names) != 1 {
+		return fail()
+	}
+	recv, ok := g.Recv.List[0].Type.(*ast.StarExpr)
+	if !ok {
+		return fail()
+	}
+	api, ok := recv.X.(*ast.Ident)
+	if !ok {
+		return fail()
+	}
+	params := g.Type.Params.List
+	if len(params) != 2 || len(params[0].Names) > 1 || len(params[1].Names) > 1 || types.ExprString(params[0].Type) != "context.Context" {
+		return fail()
+	}
+	in, ok := params[1].Type.(*ast.Ident)
+	if !ok {
+		return fail()
+	}
+	if g.Type.Results == nil || len(g.Type.Results.List) != 2 || types.ExprString(g.Type.Results.List[1].Type) != "error" {
+		return fail()
+	}
+	result, ok = parseResult(g.Type.Results.List[0].Type)
+	if !ok {
+		return fail()
+	}
+	return api.Name, in.Name, result, true
+}
+
+// parseResult узнаёт, что возвращает метод: структуру, Blob или поток
+func parseResult(expr ast.Expr) (Result, bool) {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		if types.ExprString(t.X) == "apigen.Blob" {
+			return Result{Kind: "blob", Type: "*apigen.Blob"}, true
+		}
+		if out, ok := t.X.(*ast.Ident); ok {
+			return Result{Kind: "json", Type: out.Name}, true
+		}
+	case *ast.ChanType:
+		if t.Dir == ast.RECV {
+			return Result{Kind: "chan", Type: types.ExprString(t.Value)}, true
+		}
+	case *ast.FuncType:
+		// func(yield func(T) bool) или func(yield func(T, error) bool)
+		if t.Results != nil || len(t.Params.List) != 1 {
+			break
+		}
+		yield, ok := t.Params.List[0].Type.(*ast.FuncType)
+		if !ok || yield.Results == nil || len(yield.Results.List) != 1 || types.ExprString(yield.Results.List[0].Type) != "bool" {
+			break
+		}
+		var args []ast.Expr
+		for _, field := range yield.Params.List {
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				args = append(args, field.Type)
+			}
+		}
+		switch {
+		case len(args) == 1:
+			return Result{Kind: "seq", Type: types.ExprString(args[0])}, true
+		case len(args) == 2 && types.ExprString(args[1]) == "error":
+			return Result{Kind: "seq2", Type: types.ExprString(args[0])}, true
+		}
+	case *ast.IndexExpr:
+		// iter.Seq[T]
+		if types.ExprString(t.X) == "iter.Seq" {
+			return Result{Kind: "seq", Type: types.ExprString(t.Index)}, true
+		}
+	case *ast.IndexListExpr:
+		// iter.Seq2[T, error]
+		if types.ExprString(t.X) == "iter.Seq2" && len(t.Indices) == 2 && types.ExprString(t.Indices[1]) == "error" {
+			return Result{Kind: "seq2", Type: types.ExprString(t.Indices[0])}, true
+		}
+	}
+	return Result{}, false
+}
+
+// Output - сгенерированные файлы пакета
+type Output struct {
+	Handlers []byte
+	// api_grpc.go и api.proto, только если они нужны
+	GRPC  []byte
+	Proto []byte
+}
+
+// generate строит код хендлеров по всем файлам пакета: сначала структуры, потом методы,
+// потому что они могут лежать в разных файлах. Результат прогоняется через go/format
+func generate(files []*ast.File, withGRPC bool) (*Output, error) {
+	// код пишется после заголовка, а импорты зависят от того, что в нём оказалось
+	out := &bytes.Buffer{}
+	imports := []string{"context", "encoding/json", "errors", "fmt", "net/http"}
 
-	fmt.Fprintln(out, `package `+node.Name.Name)
-	fmt.Fprintln(out)
-	fmt.Fprintln(out, `import (
-	"context"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/url"
-	"strconv"
-	"strings"
-)
-`)
 	fmt.Fprintln(out, `
 func sendError(w http.ResponseWriter, error string, code int) {
 	js, err := json.Marshal(CR{"error": error})
@@ -243,15 +1034,28 @@ func sendError(w http.ResponseWriter, error string, code int) {
 	w.WriteHeader(code)
 	fmt.Fprintln(w, string(js))
 }
-`)
+
+// clientError переводит ошибку из ответа сервера обратно в ApiError
+func clientError(err error) error {
+	if e, ok := err.(*apigen.StatusError); ok {
+		return ApiError{HTTPStatus: e.Status, Err: errors.New(e.Message)}
+	}
+	return err
+}`)
 
 	Structs := make(map[string]Fields)
 	jsonStructs := make(map[string]JsonFields)
+	// все структуры пакета, параметры метода должны быть одной из них
+	knownStructs := make(map[string]bool)
 
 	// var ApiList []Api
 	MRmap := make(map[string][]MR)
-	apiNames := make(map[string]bool)
-	for _, f := range node.Decls {
+	var apiNames []string
+	validators := findValidators(files)
+	// структуры, для которых уже есть validate-функция
+	validated := make(map[string]bool)
+	decls := allDecls(files)
+	for _, f := range decls {
 		//Struct parsing
 		if g, ok := f.(*ast.GenDecl); ok {
 			if g.Tok != token.TYPE {
@@ -270,80 +1074,67 @@ func sendError(w http.ResponseWriter, error string, code int) {
 				// for _, field := range currStruct.Fields.List {
 				// 	 fmt.Printf("	Struct fields %#v\n", field.Names[0].Name)
 				// }
+				knownStructs[structName] = true
 				var F Fields
-				J := JsonFields{FList: make(map[string]Field)}
+				J := JsonFields{}
 				needVal := false
 				needJson := false
 
 			FIELDS_LOOP:
 				for _, field := range currStruct.Fields.List {
 
-					if field.Tag == nil {
+					if len(field.Names) == 0 || !field.Names[0].IsExported() {
 						// fmt.Printf("SKIP %T is not ast.StructType\n", currStruct)
 						continue FIELDS_LOOP
 					}
 
-					tag := reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
-
-					//search json tag
-					t, ok := tag.Lookup("json")
-					if !ok {
-						// fmt.Printf("No json tag, tag is %s\n", tag)
+					var tag reflect.StructTag
+					if field.Tag != nil {
+						tag = reflect.StructTag(field.Tag.Value[1 : len(field.Tag.Value)-1])
 					}
 
-					needJson = true
-					// J.FList = append(J.FList, Field{
-					// 	Name: field.Names[0].Name,
-					// 	Tag:  t,
-					// 	Type: field.Type.(*ast.Ident).Name,
-					// })
-					J.FList[field.Names[0].Name] = Field{
-						Name: field.Names[0].Name,
-						Tag:  Rules{ParamName: t},
-						Type: field.Type.(*ast.Ident).Name,
+					//search json tag, как его понимает encoding/json
+					protoNum := parseProtoTag(field.Pos(), structName, field.Names[0].Name, tag)
+
+					t, _ := tag.Lookup("json")
+					jsonName := strings.Split(t, ",")[0]
+					if jsonName != "-" {
+						if jsonName == "" {
+							jsonName = field.Names[0].Name
+						}
+						needJson = true
+						J.FList = append(J.FList, Field{
+							Name:  field.Names[0].Name,
+							Tag:   Rules{ParamName: jsonName},
+							Type:  parseFieldType(field.Type),
+							Proto: protoNum,
+						})
 					}
 
 					//search apivalidator tag
-					t, ok = tag.Lookup("apivalidator")
+					t, ok := tag.Lookup("apivalidator")
 					if !ok {
 						// fmt.Printf("No validation tag, tag is %s\n", tag)
 						continue FIELDS_LOOP
 					}
 
-					rules := Rules{}
-					for _, rule := range strings.Split(t, ",") {
-						tParts := strings.Split(rule, "=")
-						switch tParts[0] {
-						case "required":
-							rules.Required = true
-						case "paramname":
-							rules.ParamName = tParts[1]
-						case "min":
-							rules.Min = true
-							rules.MinValue, _ = strconv.Atoi(tParts[1])
-						case "max":
-							rules.Max = true
-							rules.MaxValue, _ = strconv.Atoi(tParts[1])
-						case "enum":
-							rules.Enum = strings.Split(tParts[1], "|")
-						case "default":
-							rules.Default = tParts[1]
-						}
-					}
+					rules := parseRules(field.Pos(), structName, field.Names[0].Name, t, parseFieldType(field.Type), validators)
 
 					if rules.ParamName == "" {
 						rules.ParamName = strings.ToLower(field.Names[0].Name)
 					}
 
 					needVal = true
-					fmt.Printf("%+v\n", rules)
 					F.FList = append(F.FList, Field{
-						Name: field.Names[0].Name,
-						Tag:  rules,
-						Type: field.Type.(*ast.Ident).Name,
+						Name:  field.Names[0].Name,
+						Tag:   rules,
+						Type:  parseFieldType(field.Type),
+						Pos:   field.Pos(),
+						Proto: protoNum,
 					})
 				}
 				if needVal == true {
+					F.Cross = crossChecks(structName, F.FList)
 					Structs[structName] = F
 				}
 
@@ -352,28 +1143,47 @@ func sendError(w http.ResponseWriter, error string, code int) {
 				}
 			}
 		}
+	}
+	for _, f := range decls {
 		//Func parsing
 		if g, ok := f.(*ast.FuncDecl); ok {
 			if g.Doc == nil {
 				// fmt.Printf("SKIP func %#v doesnt have comments\n", g.Name.Name)
 				continue
 			}
-			fmt.Printf("\nfunc %#v\n", g.Name.Name)
 			needCodegen := false
 			api := apiMeta{}
-			jsonStr := ""
+			var doc []string
 			for _, comment := range g.Doc.List {
-				jsonStr = comment.Text[len("// apigen:api"):]
-				if err := json.Unmarshal([]byte(jsonStr), &api); err == nil {
-					break
+				if !strings.HasPrefix(comment.Text, "// apigen:api") {
+					doc = append(doc, strings.TrimSpace(strings.TrimPrefix(comment.Text, "//")))
+					continue
+				}
+				jsonStr := comment.Text[len("// apigen:api"):]
+				if err := json.Unmarshal([]byte(jsonStr), &api); err != nil {
+					errorf(comment.Pos(), "bad apigen:api comment for %s: %s", g.Name.Name, err)
 				}
-				needCodegen = needCodegen || strings.HasPrefix(comment.Text, "// apigen")
+				needCodegen = true
+				break
+			}
+			if !needCodegen {
+				continue
 			}
 
-			apiName := g.Recv.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name
-			structName := g.Type.Params.List[1].Type.(*ast.Ident).Name
-			user := g.Type.Results.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name
-			fmt.Println(">>>>>>>>>>> ", g.Type.Results.List[0].Type.(*ast.StarExpr).X.(*ast.Ident).Name, "<<<<<<<<<<")
+			apiName, structName, result, ok := apiSignature(g)
+			if !ok {
+				continue
+			}
+			// для потока - тип элемента, для Blob в конверте ничего нет
+			user := strings.TrimPrefix(result.Type, "*")
+			switch {
+			case api.Stream != "" && !result.IsStream():
+				errorf(g.Pos(), "%s: stream is only for methods returning channel or iterator", g.Name.Name)
+			case api.Stream == "":
+				api.Stream = apigen.NDJSON
+			case api.Stream != apigen.NDJSON && api.Stream != apigen.SSE:
+				errorf(g.Pos(), "%s: stream must be %s or %s, got %q", g.Name.Name, apigen.NDJSON, apigen.SSE, api.Stream)
+			}
 			p := TplParam{
 				Srv:          g.Recv.List[0].Names[0].Name, //srv
 				ApiName:      apiName,                      //MyApi
@@ -383,41 +1193,157 @@ func sendError(w http.ResponseWriter, error string, code int) {
 				User:         user,        //User, NewUser
 				JsonStructs:  jsonStructs[user],
 				Method:       api.Method,
+				Auth:         api.Auth,
+				Roles:        api.Roles,
+				Result:       result,
+				Stream:       api.Stream,
+				MaxBody:      api.MaxBody,
+			}
+			if !knownStructs[structName] {
+				errorf(g.Type.Params.List[1].Pos(), "%s: %s is not a struct in this package", g.Name.Name, structName)
+			}
+			if api.Timeout != "" {
+				d, err := time.ParseDuration(api.Timeout)
+				if err != nil || d <= 0 {
+					errorf(g.Pos(), "%s: bad timeout %q", g.Name.Name, api.Timeout)
+				} else {
+					p.Timeout = goDuration(d)
+					imports = appendOnce(imports, "time")
+				}
+			}
+			checkPathVars(g.Pos(), g.Name.Name, api.Url, p.StructFields)
+			if err := wrapTpl.Execute(out, p); err != nil {
+				return nil, err
 			}
-			err = wrapTpl.Execute(out, p)
-			if err != nil {
-				fmt.Println(">>>>", err, "<<<<<")
+			if len(p.StructFields.FList) > 0 && !validated[structName] {
+				validated[structName] = true
+				if err := validateTpl.Execute(out, p); err != nil {
+					return nil, err
+				}
+			}
+			if _, exist := MRmap[apiName]; !exist {
+				apiNames = append(apiNames, apiName)
 			}
-			apiNames[apiName] = true
 			MRmap[apiName] = append(MRmap[apiName], MR{
 				Method: g.Name.Name,
 				Route:  api.Url,
+				Pos:    g.Pos(),
+				Doc:    strings.Join(doc, " "),
+				Tpl:    p,
 			})
 		}
 
 	}
-	for api, _ := range apiNames {
+	sort.Strings(apiNames)
+	if len(apiNames) > 0 {
+		imports = appendOnce(imports, "sync")
+	}
+	for _, api := range apiNames {
+		spec, err := buildOpenAPI(api, MRmap[api], jsonStructs)
+		if err != nil {
+			return nil, fmt.Errorf("openapi for %s: %s", api, err)
+		}
+		var roles []string
+		for _, m := range MRmap[api] {
+			for _, role := range m.Tpl.Roles {
+				roles = appendOnce(roles, role)
+			}
+		}
+		sort.Strings(roles)
 		Api := Api{
 			Srv:     "srv",
 			ApiName: api,
 			Methods: MRmap[api],
+			Routes:  buildRoutes(MRmap[api]),
+			Spec:    goString(string(spec)),
+			Roles:   roles,
 		}
-		err = serveTpl.Execute(out, Api)
-		if err != nil {
-			fmt.Println(err)
+		if err := serveTpl.Execute(out, Api); err != nil {
+			return nil, err
+		}
+		if err := clientTpl.Execute(out, Api); err != nil {
+			return nil, err
 		}
 	}
 	// PRINT(Structs, "CreateParams")
 	// jsonPRINT(jsonStructs, "User")
 	// fmt.Printf("%#v", Structs)
 
+	header := &bytes.Buffer{}
+	fmt.Fprint(header, generatedHeader)
+	fmt.Fprintln(header)
+	fmt.Fprintln(header, `package `+files[0].Name.Name)
+	fmt.Fprintln(header)
+	sort.Strings(imports)
+	fmt.Fprintln(header, "import (")
+	for _, imp := range imports {
+		fmt.Fprintf(header, "\t%q\n", imp)
+	}
+	fmt.Fprintf(header, "\n\t%q\n)\n", runtimePkg)
+
+	src, err := format.Source(append(header.Bytes(), out.Bytes()...))
+	if err != nil {
+		return nil, fmt.Errorf("generated code is not valid go: %s", err)
+	}
+	result := &Output{Handlers: src}
+	if withGRPC {
+		result.GRPC, result.Proto, err = generateGRPC(files[0].Name.Name, apiNames, MRmap, Structs, jsonStructs, knownStructs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// goString - литерал go для строки, по возможности raw, чтобы json в коде читался
+func goString(s string) string {
+	if strings.Contains(s, "`") {
+		return strconv.Quote(s)
+	}
+	return "`" + s + "`"
+}
+
+// goDuration - литерал go для таймаута: 2 * time.Second, 150 * time.Millisecond
+func goDuration(d time.Duration) string {
+	for _, unit := range []struct {
+		d    time.Duration
+		name string
+	}{
+		{time.Hour, "time.Hour"},
+		{time.Minute, "time.Minute"},
+		{time.Second, "time.Second"},
+		{time.Millisecond, "time.Millisecond"},
+		{time.Microsecond, "time.Microsecond"},
+	} {
+		if d%unit.d == 0 {
+			return strconv.FormatInt(int64(d/unit.d), 10) + " * " + unit.name
+		}
+	}
+	return "time.Duration(" + strconv.FormatInt(int64(d), 10) + ")"
+}
+
+func appendOnce(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// mustNumber проверяет значение min/max, чтобы не сгенерировать некомпилирующийся код
+func mustNumber(pos token.Pos, where, value string) string {
+	if _, err := strconv.ParseFloat(value, 64); err != nil {
+		errorf(pos, "%s: min/max must be number, got %q", where, value)
+	}
+	return value
 }
 
 func PRINT(s map[string]Fields, key string) {
 	fmt.Println()
 	empJSON, err := json.MarshalIndent(s[key], "", "  ")
 	if err != nil {
-		log.Fatalf(err.Error())
+		log.Fatal(err)
 	}
 	fmt.Printf("MarshalIndent funnction output\n %s\n", string(empJSON))
 }
@@ -426,7 +1352,7 @@ func jsonPRINT(s map[string]JsonFields, key string) {
 	fmt.Println()
 	empJSON, err := json.MarshalIndent(s[key], "", "  ")
 	if err != nil {
-		log.Fatalf(err.Error())
+		log.Fatal(err)
 	}
 	fmt.Printf("MarshalIndent funnction output\n %s\n", string(empJSON))
 }
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "handlers_gen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, src := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func generateDir(t *testing.T, dir string) ([]byte, error) {
+	problems = nil
+	files, err := parseInputs([]string{dir}, filepath.Join(dir, defaultOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := generate(files, false)
+	if err != nil {
+		return nil, err
+	}
+	return out.Handlers, nil
+}
+
+// параметры, методы и валидаторы лежат в разных файлах
+var multiFile = map[string]string{
+	"params.go": `package api
+
+type Params struct {
+	Login string ` + "`apivalidator:\"required,validate=login\"`" + `
+}
+
+type Result struct {
+	ID int ` + "`json:\"id\"`" + `
+}
+`,
+	"api.go": `package api
+
+import (
+	"context"
+	"iter"
+)
+
+type Api struct{}
+
+// apigen:api {"url": "/b", "method": "POST"}
+func (srv *Api) B(ctx context.Context, in Params) (*Result, error) { return nil, nil }
+
+// apigen:api {"url": "/a"}
+func (srv *Api) A(ctx context.Context, in Params) (*Result, error) { return nil, nil }
+
+// apigen:api {"url": "/c", "stream": "sse"}
+func (srv *Api) C(ctx context.Context, in Params) (iter.Seq2[*Result, error], error) { return nil, nil }
+`,
+	"validators.go": `package api
+
+// apigen:validator login
+func checkLogin(s string) error { return nil }
+`,
+	"api_test.go": `package api
+
+this is not go, tests must be skipped
+`,
+	defaultOutput: "// Code generated by handlers_gen. DO NOT EDIT.\n\npackage api\n",
+}
+
+func TestGeneratePackage(t *testing.T) {
+	dir := writeFiles(t, multiFile)
+	defer os.RemoveAll(dir)
+
+	src, err := generateDir(t, dir)
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("unexpected error: %v, %v", err, problems)
+	}
+	for _, expected := range []string{
+		"// Code generated by handlers_gen. DO NOT EDIT.",
+		"func (h *ApiHandler) handleA(",
+		"func (h *ApiHandler) handleB(",
+		"checkLogin(params.Login)",
+		"type ApiClient struct",
+		`stream := apigen.NewStream(ctx, w, r, "sse")`,
+		"for item, err := range items {",
+		"func (c *ApiClient) C(ctx context.Context, in Params, fn func(*Result) error) error {",
+	} {
+		if !bytes.Contains(src, []byte(expected)) {
+			t.Errorf("expected %q in generated code", expected)
+		}
+	}
+	// методы в порядке объявления
+	if bytes.Index(src, []byte("handleB(w")) > bytes.Index(src, []byte("handleA(w")) {
+		t.Errorf("handlers must follow declaration order")
+	}
+
+	// повторный запуск даёт ровно тот же результат
+	for i := 0; i < 5; i++ {
+		again, err := generateDir(t, dir)
+		if err != nil || !bytes.Equal(src, again) {
+			t.Fatalf("output is not stable between runs")
+		}
+	}
+}
+
+func TestGenerateProblems(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type Api struct{}
+
+type Params struct {
+	Age int ` + "`apivalidator:\"min=ten\"`" + `
+	Name string ` + "`apivalidator:\"regexp=[\"`" + `
+}
+
+// apigen:api {"url": "/a"
+func (srv *Api) A(ctx context.Context, in Params) (*Api, error) { return nil, nil }
+
+// apigen:api {"url": "/b"}
+func (srv Api) B(in Params) *Api { return nil }
+
+// apigen:api {"url": "/c/{id}"}
+func (srv *Api) C(ctx context.Context, in Params) (*Api, error) { return nil, nil }
+
+// apigen:api {"url": "/d", "stream": "sse"}
+func (srv *Api) D(ctx context.Context, in Params) (*Api, error) { return nil, nil }
+
+// apigen:api {"url": "/e", "timeout": "soon"}
+func (srv *Api) E(ctx context.Context, in Params) (*Api, error) { return nil, nil }
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	generateDir(t, dir)
+	expected := []string{
+		"api.go:8:2: Params.Age: min/max must be number",
+		"api.go:9:2: Params.Name: bad regexp",
+		"api.go:12:1: bad apigen:api comment for A",
+		"api.go:16:1: B: apigen:api method must be",
+		"api.go:19:1: C: path param {id} has no field in params struct",
+		"api.go:22:1: D: stream is only for methods returning channel or iterator",
+		"api.go:25:1: E: bad timeout \"soon\"",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for i, msg := range expected {
+		if !strings.Contains(problems[i], msg) {
+			t.Errorf("problem %d: expected %q, got %q", i, msg, problems[i])
+		}
+	}
+}
+
+func TestGenerateGRPC(t *testing.T) {
+	dir := writeFiles(t, multiFile)
+	defer os.RemoveAll(dir)
+
+	problems, warnings = nil, nil
+	files, err := parseInputs([]string{dir}, filepath.Join(dir, defaultOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := generate(files, true)
+	if err != nil || len(problems) > 0 {
+		t.Fatalf("unexpected error: %v, %v", err, problems)
+	}
+	for _, expected := range []string{
+		"service Api {",
+		"rpc B (Params) returns (Result);",
+		"rpc C (Params) returns (stream Result);",
+		"message Params {\n  string login = 1;\n}",
+		"message Result {\n  int64 id = 1;\n}",
+	} {
+		if !bytes.Contains(out.Proto, []byte(expected)) {
+			t.Errorf("expected %q in proto:\n%s", expected, out.Proto)
+		}
+	}
+	for _, expected := range []string{
+		"// Code generated by handlers_gen. DO NOT EDIT.",
+		`ServiceName: "api.Api",`,
+		"func (g *ApiGRPC) callB(ctx context.Context, in *Params) (*Result, error) {",
+		"validateParams(&params, errs)",
+		"func (m *Result) MarshalProto() []byte {",
+		"e.Int(1, int64(m.ID))",
+		"m.ID = int(d.Int())",
+	} {
+		if !bytes.Contains(out.GRPC, []byte(expected)) {
+			t.Errorf("expected %q in grpc code", expected)
+		}
+	}
+	// проверка параметров общая для http и gRPC
+	if !bytes.Contains(out.Handlers, []byte("func validateParams(params *Params, errs *apigen.Violations) {")) {
+		t.Errorf("expected shared validate function in handlers")
+	}
+	// без тегов proto номера по порядку полей, о чём генератор предупреждает
+	if len(warnings) != 2 || !strings.Contains(warnings[0], "Params: no proto tags") {
+		t.Errorf("expected warnings about field order, got %v", warnings)
+	}
+}
+
+func TestGenerateProtoNumbers(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type Api struct{}
+
+type Params struct {
+	Name  string ` + "`apivalidator:\"paramname=name\" proto:\"3\"`" + `
+	Login string ` + "`apivalidator:\"required\" proto:\"1\"`" + `
+}
+
+type Result struct {
+	ID int ` + "`json:\"id\" proto:\"5\"`" + `
+}
+
+// apigen:api {"url": "/a"}
+func (srv *Api) A(ctx context.Context, in Params) (*Result, error) { return nil, nil }
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	problems, warnings = nil, nil
+	files, err := parseInputs([]string{dir}, filepath.Join(dir, defaultOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := generate(files, true)
+	if err != nil || len(problems) > 0 || len(warnings) > 0 {
+		t.Fatalf("unexpected error: %v, %v, %v", err, problems, warnings)
+	}
+	// номера из тегов, порядок полей в структуре не важен
+	for _, expected := range []string{
+		"message Params {\n  string name = 3;\n  string login = 1;\n}",
+		"message Result {\n  int64 id = 5;\n}",
+	} {
+		if !bytes.Contains(out.Proto, []byte(expected)) {
+			t.Errorf("expected %q in proto:\n%s", expected, out.Proto)
+		}
+	}
+	for _, expected := range []string{"e.String(3, m.Name)", "e.Int(5, int64(m.ID))", "case 1:"} {
+		if !bytes.Contains(out.GRPC, []byte(expected)) {
+			t.Errorf("expected %q in grpc code", expected)
+		}
+	}
+
+	dir = writeFiles(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type Api struct{}
+
+type Params struct {
+	Login string ` + "`apivalidator:\"required\" proto:\"1\"`" + `
+	Name  string ` + "`apivalidator:\"paramname=name\" proto:\"1\"`" + `
+	Age   int    ` + "`apivalidator:\"min=0\"`" + `
+	Level int    ` + "`apivalidator:\"min=0\" proto:\"19001\"`" + `
+}
+
+// apigen:api {"url": "/a"}
+func (srv *Api) A(ctx context.Context, in Params) (*Params, error) { return nil, nil }
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	problems = nil
+	files, err = parseInputs([]string{dir}, filepath.Join(dir, defaultOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	generate(files, true)
+	expected := []string{
+		"api.go:11:2: Params.Level: bad proto tag \"19001\", expected field number",
+		"api.go:9:2: Params.Name: proto field number 1 is already used by Login",
+		"api.go:10:2: Params.Age: proto tag is required, other fields of Params have it",
+		"api.go:15:1: Params is used both as params and as result",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for i, msg := range expected {
+		if !strings.Contains(problems[i], msg) {
+			t.Errorf("problem %d: expected %q, got %q", i, msg, problems[i])
+		}
+	}
+}
+
+func TestGenerateGRPCProblems(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"api.go": `package api
+
+import "context"
+
+type Api struct{}
+
+type Params struct {
+	Login string ` + "`apivalidator:\"required\"`" + `
+}
+
+type Result struct {
+	Meta map[string]string ` + "`json:\"meta\"`" + `
+	Name string            ` + "`json:\"full-name\"`" + `
+}
+
+// apigen:api {"url": "/a"}
+func (srv *Api) A(ctx context.Context, in Params) (*Result, error) { return nil, nil }
+
+// apigen:api {"url": "/b"}
+func (srv *Api) B(ctx context.Context, in Params) (*Params, error) { return nil, nil }
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	problems = nil
+	files, err := parseInputs([]string{dir}, filepath.Join(dir, defaultOutput))
+	if err != nil {
+		t.Fatal(err)
+	}
+	generate(files, true)
+	expected := []string{
+		"api.go:17:1: Result.Meta: type map[string]string is not supported in grpc",
+		"api.go:17:1: Result.Name: \"full-name\" is not a valid proto field name",
+		"api.go:20:1: Params is used both as params and as result",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("expected %d problems, got %d: %v", len(expected), len(problems), problems)
+	}
+	for i, msg := range expected {
+		if !strings.Contains(problems[i], msg) {
+			t.Errorf("problem %d: expected %q, got %q", i, msg, problems[i])
+		}
+	}
+}
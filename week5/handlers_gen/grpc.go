@@ -0,0 +1,685 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// с флагом -grpc генератор пишет рядом с хендлерами api.proto с сервисами из тех же методов
+// и api_grpc.go: кодек для структур пакета и обёртку, которая вызывает методы api по gRPC
+
+const (
+	grpcOutput  = "api_grpc.go"
+	protoOutput = "api.proto"
+	// общая часть gRPC-обёрток
+	grpcRuntimePkg = runtimePkg + "/apigrpc"
+)
+
+// protoField - поле сообщения: поле структуры и его номер в .proto
+type protoField struct {
+	Name string
+	// имя в .proto - paramname для параметров, json-имя для ответов
+	Proto string
+	Num   int
+	Type  FieldType
+}
+
+// protoMessage - сообщение .proto, по нему же генерируются MarshalProto и UnmarshalProto
+type protoMessage struct {
+	Name   string
+	Fields []protoField
+	// params - структура параметров, поля из apivalidator, json - ответ или вложенная структура.
+	// одна структура не может быть и тем и другим, имена полей у них разные
+	Usage string
+	// элемент потока простого типа: в .proto он заворачивается в сообщение с полем value,
+	// а в коде для него генерируется своя структура
+	Wrapper bool
+}
+
+// ProtoType - тип поля в .proto
+func (f protoField) ProtoType() string {
+	t := ""
+	switch f.Type.Kind {
+	case "string":
+		t = "string"
+	case "int":
+		t = "int64"
+		if f.Type.Bits <= 32 {
+			t = "int32"
+		}
+	case "uint":
+		t = "uint64"
+		if f.Type.Bits <= 32 {
+			t = "uint32"
+		}
+	case "float":
+		t = "double"
+		if f.Type.Bits == 32 {
+			t = "float"
+		}
+	case "bool":
+		t = "bool"
+	case "strings":
+		return "repeated string"
+	case "time":
+		// у сообщений и так видно, передали ли их, optional не нужен
+		return "google.protobuf.Timestamp"
+	case "struct":
+		return f.Type.Go
+	}
+	if f.Type.Pointer {
+		return "optional " + t
+	}
+	return t
+}
+
+// convert приводит значение поля v к типу to, в котором его принимает ProtoEncoder
+func (f protoField) convert(to, v string) string {
+	if f.Type.Go == to {
+		return v
+	}
+	return to + "(" + v + ")"
+}
+
+// cast - обратное convert: значение v типа from из ProtoDecoder в тип поля
+func (f protoField) cast(from, v string) string {
+	if f.Type.Go == from {
+		return v
+	}
+	return f.Type.Go + "(" + v + ")"
+}
+
+// Encode - код, который дописывает поле в ProtoEncoder
+func (f protoField) Encode() string {
+	v := "m." + f.Name
+	if f.Type.Pointer && f.Type.Kind != "struct" {
+		v = "*" + v
+	}
+	num := strconv.Itoa(f.Num)
+	call := ""
+	switch f.Type.Kind {
+	case "string":
+		call = "e.String(" + num + ", " + v + ")"
+	case "int":
+		call = "e.Int(" + num + ", " + f.convert("int64", v) + ")"
+	case "uint":
+		call = "e.Uint(" + num + ", " + f.convert("uint64", v) + ")"
+	case "float":
+		if f.Type.Bits == 32 {
+			call = "e.Float(" + num + ", " + f.convert("float32", v) + ")"
+		} else {
+			call = "e.Double(" + num + ", " + f.convert("float64", v) + ")"
+		}
+	case "bool":
+		call = "e.Bool(" + num + ", " + v + ")"
+	case "time":
+		call = "e.Time(" + num + ", " + v + ")"
+	case "strings":
+		return "e.Strings(" + num + ", " + v + ")"
+	case "struct":
+		call = "e.Message(" + num + ", " + v + ".MarshalProto())"
+	}
+
+	// нулевые значения в proto3 не передаются, у указателей - только nil
+	zero := ""
+	switch {
+	case f.Type.Pointer:
+		zero = "m." + f.Name + " != nil"
+	case f.Type.Kind == "string":
+		zero = v + ` != ""`
+	case f.Type.Kind == "int" || f.Type.Kind == "uint" || f.Type.Kind == "float":
+		zero = v + " != 0"
+	case f.Type.Kind == "bool":
+		zero = v
+	case f.Type.Kind == "time":
+		zero = "!" + v + ".IsZero()"
+	default:
+		return call
+	}
+	return "if " + zero + " {\n" + call + "\n}"
+}
+
+// Decode - код, который читает поле из ProtoDecoder
+func (f protoField) Decode() string {
+	v := ""
+	switch f.Type.Kind {
+	case "string":
+		v = "d.Text()"
+	case "int":
+		v = f.cast("int64", "d.Int()")
+	case "uint":
+		v = f.cast("uint64", "d.Uint()")
+	case "float":
+		if f.Type.Bits == 32 {
+			v = f.cast("float32", "d.Float()")
+		} else {
+			v = f.cast("float64", "d.Double()")
+		}
+	case "bool":
+		v = "d.Bool()"
+	case "time":
+		v = "d.Time()"
+	case "strings":
+		return "m." + f.Name + " = append(m." + f.Name + ", d.Text())"
+	case "struct":
+		unmarshal := "if err := m." + f.Name + ".UnmarshalProto(d.Message()); err != nil {\nreturn err\n}"
+		if f.Type.Pointer {
+			return "m." + f.Name + " = &" + f.Type.Go + "{}\n" + unmarshal
+		}
+		return unmarshal
+	}
+	if f.Type.Pointer {
+		return "v := " + v + "\nm." + f.Name + " = &v"
+	}
+	return "m." + f.Name + " = " + v
+}
+
+// grpcMethod - метод api, доступный по gRPC
+type grpcMethod struct {
+	TplParam
+	Doc        string
+	FullMethod string
+	// сообщение ответа или элемента потока
+	Message string
+	// что отправляется в поток: item, &item или обёртка над простым значением
+	Send string
+}
+
+// grpcApi - сервис .proto из одной структуры api
+type grpcApi struct {
+	ApiName string
+	Service string
+	Unary   []grpcMethod
+	Streams []grpcMethod
+	// методы только для http, в .proto они упоминаются комментарием
+	Skipped []string
+}
+
+// grpcBuilder собирает сообщения для всех методов. Поля берутся из тех же разобранных
+// структур, что и для хендлеров, поэтому имена в .proto совпадают с параметрами и json
+type grpcBuilder struct {
+	params   map[string]Fields
+	json     map[string]JsonFields
+	known    map[string]bool
+	messages map[string]*protoMessage
+	// нужен import google/protobuf/timestamp.proto
+	timestamp bool
+	// нужен import "time" в api_grpc.go
+	goTime bool
+}
+
+// message добавляет в .proto структуру пакета и все вложенные в неё
+func (b *grpcBuilder) message(pos token.Pos, name, usage string) {
+	if m, exist := b.messages[name]; exist {
+		if m.Usage != usage {
+			errorf(pos, "%s is used both as params and as result, grpc needs different messages", name)
+		}
+		return
+	}
+	if !b.known[name] {
+		errorf(pos, "%s is not a struct in this package", name)
+		return
+	}
+	m := &protoMessage{Name: name, Usage: usage}
+	b.messages[name] = m
+
+	fields := b.json[name].FList
+	if usage == "params" {
+		fields = b.params[name].FList
+	}
+	nums := protoNumbers(pos, name, fields)
+	for i, f := range fields {
+		m.Fields = append(m.Fields, protoField{
+			Name:  f.Name,
+			Proto: f.Tag.ParamName,
+			Num:   nums[i],
+			Type:  f.Type,
+		})
+		b.field(pos, name, f)
+	}
+}
+
+// protoNumbers - номера полей сообщения. Они из тегов proto:"3": по ним клиенты узнают поля,
+// так что поля структуры можно добавлять и переставлять. Без тегов номер - позиция поля,
+// и это работает только пока структуру не трогают
+func protoNumbers(pos token.Pos, structName string, fields []Field) []int {
+	nums := make([]int, len(fields))
+	tagged := 0
+	for _, f := range fields {
+		if f.Proto != 0 {
+			tagged++
+		}
+	}
+	if tagged == 0 {
+		if len(fields) > 0 {
+			warnf(pos, "%s: no proto tags, field numbers follow field order, adding or moving a field breaks grpc clients", structName)
+		}
+		for i := range fields {
+			nums[i] = i + 1
+		}
+		return nums
+	}
+	used := map[int]string{}
+	for i, f := range fields {
+		fpos := pos
+		if f.Pos.IsValid() {
+			fpos = f.Pos
+		}
+		switch other, dup := used[f.Proto]; {
+		case f.Proto < 0:
+			// ошибка уже есть из parseProtoTag
+		case f.Proto == 0:
+			errorf(fpos, "%s.%s: proto tag is required, other fields of %s have it", structName, f.Name, structName)
+		case dup:
+			errorf(fpos, "%s.%s: proto field number %d is already used by %s", structName, f.Name, f.Proto, other)
+		default:
+			used[f.Proto] = f.Name
+		}
+		nums[i] = f.Proto
+	}
+	return nums
+}
+
+// parseProtoTag - номер из тега proto:"3", 0 если тега нет, -1 - тег с ошибкой
+func parseProtoTag(pos token.Pos, structName, fieldName string, tag reflect.StructTag) int {
+	t, ok := tag.Lookup("proto")
+	if !ok {
+		return 0
+	}
+	num, err := strconv.Atoi(t)
+	// 19000-19999 зарезервированы самим protobuf
+	if err != nil || num < 1 || num > 1<<29-1 || num >= 19000 && num <= 19999 {
+		errorf(pos, "%s.%s: bad proto tag %q, expected field number", structName, fieldName, t)
+		return -1
+	}
+	return num
+}
+
+// field проверяет, что тип поля можно описать в .proto
+func (b *grpcBuilder) field(pos token.Pos, structName string, f Field) {
+	if f.Pos.IsValid() {
+		pos = f.Pos
+	}
+	if !paramNameRe.MatchString(f.Tag.ParamName) {
+		errorf(pos, "%s.%s: %q is not a valid proto field name", structName, f.Name, f.Tag.ParamName)
+	}
+	switch f.Type.Kind {
+	case "file":
+		errorf(pos, "%s.%s: files are not supported in grpc", structName, f.Name)
+	case "time":
+		b.timestamp = true
+	case "struct":
+		if !b.known[f.Type.Go] {
+			errorf(pos, "%s.%s: type %s is not supported in grpc", structName, f.Name, f.Type.Go)
+			return
+		}
+		b.message(pos, f.Type.Go, "json")
+	}
+}
+
+// method - метод api для gRPC, false - метод только для http
+func (b *grpcBuilder) method(pkg string, m MR) (grpcMethod, bool) {
+	p := m.Tpl
+	if p.HasFiles() || p.Result.Kind == "blob" {
+		return grpcMethod{}, false
+	}
+	gm := grpcMethod{
+		TplParam:   p,
+		Doc:        m.Doc,
+		FullMethod: "/" + pkg + "." + p.ApiName + "/" + p.FuncName,
+	}
+	b.message(m.Pos, p.StructName, "params")
+	if !p.Result.IsStream() {
+		gm.Message = p.User
+		b.message(m.Pos, p.User, "json")
+		return gm, true
+	}
+
+	// элемент потока - структура пакета или простое значение, которое надо завернуть
+	item := strings.TrimPrefix(p.Result.Type, "*")
+	if b.known[item] {
+		gm.Message = item
+		gm.Send = "item"
+		if !strings.HasPrefix(p.Result.Type, "*") {
+			gm.Send = "&item"
+		}
+		b.message(m.Pos, item, "json")
+		return gm, true
+	}
+	ft := FieldType{Go: p.Result.Type, Kind: "struct"}
+	if basic, ok := basicKinds[ft.Go]; ok {
+		basic.Go = ft.Go
+		ft = basic
+	}
+	switch ft.Go {
+	case "time.Time":
+		ft.Kind = "time"
+		b.timestamp, b.goTime = true, true
+	case "[]string":
+		ft.Kind = "strings"
+	}
+	if ft.Kind == "struct" {
+		errorf(m.Pos, "%s: stream of %s is not supported in grpc", p.FuncName, p.Result.Type)
+		return grpcMethod{}, false
+	}
+	gm.Message = p.ApiName + p.FuncName + "Item"
+	gm.Send = "&" + gm.Message + "{Value: item}"
+	if b.known[gm.Message] {
+		errorf(m.Pos, "%s: type %s already exists, cant use it for stream item", p.FuncName, gm.Message)
+	}
+	b.messages[gm.Message] = &protoMessage{
+		Name:    gm.Message,
+		Usage:   "json",
+		Wrapper: true,
+		Fields:  []protoField{{Name: "Value", Proto: "value", Num: 1, Type: ft}},
+	}
+	return gm, true
+}
+
+// sortedMessages - сообщения по имени, чтобы вывод не зависел от порядка обхода
+func (b *grpcBuilder) sortedMessages() []*protoMessage {
+	var list []*protoMessage
+	for _, m := range b.messages {
+		list = append(list, m)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// generateGRPC строит api_grpc.go и api.proto для всех api пакета
+func generateGRPC(pkg string, apiNames []string, methods map[string][]MR, params map[string]Fields, jsonStructs map[string]JsonFields, known map[string]bool) (src, proto []byte, err error) {
+	b := &grpcBuilder{
+		params:   params,
+		json:     jsonStructs,
+		known:    known,
+		messages: make(map[string]*protoMessage),
+	}
+	var apis []grpcApi
+	for _, name := range apiNames {
+		api := grpcApi{ApiName: name, Service: pkg + "." + name}
+		for _, m := range methods[name] {
+			gm, ok := b.method(pkg, m)
+			switch {
+			case !ok:
+				api.Skipped = append(api.Skipped, m.Method)
+			case gm.Result.IsStream():
+				api.Streams = append(api.Streams, gm)
+			default:
+				api.Unary = append(api.Unary, gm)
+			}
+			if gm.Timeout != "" {
+				b.goTime = true
+			}
+		}
+		apis = append(apis, api)
+	}
+	messages := b.sortedMessages()
+
+	out := &bytes.Buffer{}
+	fmt.Fprint(out, generatedHeader)
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "package "+pkg)
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "import (")
+	fmt.Fprintln(out, `	"context"`)
+	fmt.Fprintln(out, `	"net/http"`)
+	if b.goTime {
+		fmt.Fprintln(out, `	"time"`)
+	}
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, `	"google.golang.org/grpc"`)
+	fmt.Fprintln(out)
+	fmt.Fprintf(out, "\t%q\n", runtimePkg)
+	fmt.Fprintf(out, "\t%q\n", grpcRuntimePkg)
+	fmt.Fprintln(out, ")")
+	if err := grpcTpl.Execute(out, apis); err != nil {
+		return nil, nil, err
+	}
+	for _, m := range messages {
+		if err := messageTpl.Execute(out, m); err != nil {
+			return nil, nil, err
+		}
+	}
+	src, err = format.Source(out.Bytes())
+	if err != nil {
+		return nil, nil, fmt.Errorf("generated grpc code is not valid go: %s", err)
+	}
+	return src, buildProto(pkg, apis, messages, b.timestamp), nil
+}
+
+// buildProto - описание сервисов для клиентов на других языках
+func buildProto(pkg string, apis []grpcApi, messages []*protoMessage, timestamp bool) []byte {
+	out := &bytes.Buffer{}
+	fmt.Fprint(out, generatedHeader)
+	fmt.Fprintf(out, "// сообщения кодирует %s, protoc для сервера не нужен\n\n", grpcOutput)
+	fmt.Fprint(out, "syntax = \"proto3\";\n\n")
+	fmt.Fprintf(out, "package %s;\n", pkg)
+	if timestamp {
+		fmt.Fprint(out, "\nimport \"google/protobuf/timestamp.proto\";\n")
+	}
+	for _, api := range apis {
+		fmt.Fprintf(out, "\nservice %s {\n", api.ApiName)
+		for _, m := range api.Unary {
+			if m.Doc != "" {
+				fmt.Fprintf(out, "  // %s\n", m.Doc)
+			}
+			fmt.Fprintf(out, "  rpc %s (%s) returns (%s);\n", m.FuncName, m.StructName, m.Message)
+		}
+		for _, m := range api.Streams {
+			if m.Doc != "" {
+				fmt.Fprintf(out, "  // %s\n", m.Doc)
+			}
+			fmt.Fprintf(out, "  rpc %s (%s) returns (stream %s);\n", m.FuncName, m.StructName, m.Message)
+		}
+		for _, name := range api.Skipped {
+			fmt.Fprintf(out, "  // %s - только http: файлы в gRPC не передаются\n", name)
+		}
+		fmt.Fprintln(out, "}")
+	}
+	for _, m := range messages {
+		fmt.Fprintf(out, "\nmessage %s {\n", m.Name)
+		for _, f := range m.Fields {
+			fmt.Fprintf(out, "  %s %s = %d;\n", f.ProtoType(), f.Proto, f.Num)
+		}
+		fmt.Fprintln(out, "}")
+	}
+	return out.Bytes()
+}
+
+var grpcTpl = template.Must(template.New("grpcTpl").Parse(`
+// grpcError переводит ошибку метода в статус gRPC по тем же правилам, по которым
+// http-хендлер выбирает код ответа
+func grpcError(ctx context.Context, err error) error {
+	if status, msg, ok := apigen.ContextError(ctx, err); ok {
+		return apigrpc.Error(status, msg)
+	}
+	if err, ok := err.(ApiError); ok {
+		return apigrpc.Error(err.HTTPStatus, err.Error())
+	}
+	return apigrpc.Error(http.StatusInternalServerError, err.Error())
+}
+{{ range $api := . }}
+// {{.ApiName}}GRPC - методы {{.ApiName}} по gRPC, сервис {{.Service}} из api.proto.
+// Параметры проверяются теми же правилами apivalidator, что и в {{.ApiName}}Handler
+type {{.ApiName}}GRPC struct {
+	srv  *{{.ApiName}}
+	opts apigen.Options
+}
+
+func New{{.ApiName}}GRPC(srv *{{.ApiName}}, opts apigen.Options) *{{.ApiName}}GRPC {
+	return &{{.ApiName}}GRPC{
+		srv:  srv,
+		opts: opts,
+	}
+}
+
+// Register добавляет сервис на сервер gRPC. Клиент со структурами из этого пакета
+// подключается с apigrpc.DialOption, клиент из protoc - если сервер создан с apigrpc.ServerOption
+func (g *{{.ApiName}}GRPC) Register(s grpc.ServiceRegistrar) {
+	s.RegisterService(&grpcDesc{{.ApiName}}, g)
+}
+
+var grpcDesc{{.ApiName}} = grpc.ServiceDesc{
+	ServiceName: "{{.Service}}",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		{{- range .Unary }}
+		{
+			MethodName: "{{.FuncName}}",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &{{.StructName}}{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				g := srv.(*{{$api.ApiName}}GRPC)
+				if interceptor == nil {
+					return g.call{{.FuncName}}(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "{{.FullMethod}}"}
+				return interceptor(ctx, in, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+					return g.call{{.FuncName}}(ctx, req.(*{{.StructName}}))
+				})
+			},
+		},
+		{{- end }}
+	},
+	Streams: []grpc.StreamDesc{
+		{{- range .Streams }}
+		{
+			StreamName: "{{.FuncName}}",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				in := &{{.StructName}}{}
+				if err := stream.RecvMsg(in); err != nil {
+					return err
+				}
+				return srv.(*{{$api.ApiName}}GRPC).call{{.FuncName}}(stream.Context(), in, stream)
+			},
+			ServerStreams: true,
+		},
+		{{- end }}
+	},
+	Metadata: "api.proto",
+}
+{{ range .Unary }}
+func (g *{{$api.ApiName}}GRPC) call{{.FuncName}}(ctx context.Context, in *{{.StructName}}) (*{{.Message}}, error) {
+	{{- template "prelude" . }}
+	out, err := g.srv.{{.FuncName}}(ctx, params)
+	if err != nil {
+		return nil, grpcError(ctx, err)
+	}
+	return out, nil
+}
+{{ end }}
+{{- range .Streams }}
+func (g *{{$api.ApiName}}GRPC) call{{.FuncName}}(ctx context.Context, in *{{.StructName}}, stream grpc.ServerStream) error {
+	{{- template "prelude" . }}
+	items, err := g.srv.{{.FuncName}}(ctx, params)
+	if err != nil {
+		return grpcError(ctx, err)
+	}
+	{{- if eq .Result.Kind "chan" }}
+	for item := range items {
+		// клиент ушёл - дочитываем канал, чтобы не повесить горутину, которая в него пишет
+		if err == nil {
+			err = stream.SendMsg({{.Send}})
+		}
+	}
+	return err
+	{{- else if eq .Result.Kind "seq2" }}
+	for item, err := range items {
+		if err != nil {
+			return grpcError(ctx, err)
+		}
+		if err := stream.SendMsg({{.Send}}); err != nil {
+			return err
+		}
+	}
+	return nil
+	{{- else }}
+	for item := range items {
+		if err := stream.SendMsg({{.Send}}); err != nil {
+			return err
+		}
+	}
+	return nil
+	{{- end }}
+}
+{{ end }}
+{{- end }}
+
+{{- define "prelude" }}
+	ctx = apigrpc.WithRequestID(ctx)
+	{{- if .Timeout }}
+	ctx, cancel := context.WithTimeout(ctx, {{.Timeout}})
+	defer cancel()
+	{{- end }}
+	{{- if .Auth }}
+	// учётные данные приходят в метаданных, проверяет их тот же Authenticator, что и для http
+	principal, err := apigen.Authenticate(g.opts.Auth, apigrpc.Request(ctx, "{{.FullMethod}}"))
+	if err != nil {
+		{{ template "return" . }}apigrpc.Error(http.StatusUnauthorized, "unauthorized")
+	}
+	{{- if .Roles }}
+	if !principal.HasRoles({{ range $index, $role := .Roles }}{{ if $index }}, {{ end }}"{{ $role }}"{{ end }}) {
+		{{ template "return" . }}apigrpc.Error(http.StatusForbidden, "forbidden")
+	}
+	{{- end }}
+	ctx = apigen.WithPrincipal(ctx, principal)
+	{{- end }}
+	params := *in
+	{{- if .StructFields.FList }}
+	errs := &apigen.Violations{}
+	validate{{.StructName}}(&params, errs)
+	if errs.Len() > 0 {
+		{{ template "return" . }}apigrpc.Error(http.StatusBadRequest, errs.Error())
+	}
+	{{- end }}
+{{- end }}
+
+{{- define "return" }}{{ if .Result.IsStream }}return {{ else }}return nil, {{ end }}{{ end }}
+`))
+
+var messageTpl = template.Must(template.New("messageTpl").Parse(`
+{{- if .Wrapper }}
+// {{.Name}} - элемент потока, простое значение в gRPC передаётся сообщением
+type {{.Name}} struct {
+	{{- range .Fields }}
+	{{.Name}} {{.Type.Go}}
+	{{- end }}
+}
+{{ end }}
+// MarshalProto кодирует {{.Name}} как сообщение {{.Name}} из api.proto
+func (m *{{.Name}}) MarshalProto() []byte {
+	if m == nil {
+		return nil
+	}
+	e := &apigen.ProtoEncoder{}
+	{{- range .Fields }}
+	{{.Encode}}
+	{{- end }}
+	return e.Bytes()
+}
+
+// UnmarshalProto разбирает сообщение {{.Name}}, неизвестные поля пропускаются
+func (m *{{.Name}}) UnmarshalProto(data []byte) error {
+	d := apigen.NewProtoDecoder(data)
+	for d.Next() {
+		switch d.Num() {
+		{{- range .Fields }}
+		case {{.Num}}:
+			{{.Decode}}
+		{{- end }}
+		default:
+			d.Skip()
+		}
+	}
+	return d.Err()
+}
+`))
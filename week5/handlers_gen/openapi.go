@@ -0,0 +1,361 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+
+	"week5/apigen"
+)
+
+// OpenAPI 3 описание api собирается из тех же apigen:api комментариев и apivalidator тегов,
+// что и хендлеры, и вшивается в сгенерированный файл строкой
+
+type oaSchema struct {
+	Ref        string               `json:"$ref,omitempty"`
+	Type       string               `json:"type,omitempty"`
+	Format     string               `json:"format,omitempty"`
+	Nullable   bool                 `json:"nullable,omitempty"`
+	Items      *oaSchema            `json:"items,omitempty"`
+	Properties map[string]*oaSchema `json:"properties,omitempty"`
+	Required   []string             `json:"required,omitempty"`
+	Enum       []interface{}        `json:"enum,omitempty"`
+	Default    interface{}          `json:"default,omitempty"`
+	Pattern    string               `json:"pattern,omitempty"`
+	Minimum    *json.Number         `json:"minimum,omitempty"`
+	Maximum    *json.Number         `json:"maximum,omitempty"`
+	MinLength  *json.Number         `json:"minLength,omitempty"`
+	MaxLength  *json.Number         `json:"maxLength,omitempty"`
+	MinItems   *json.Number         `json:"minItems,omitempty"`
+	MaxItems   *json.Number         `json:"maxItems,omitempty"`
+}
+
+type oaParameter struct {
+	Name     string    `json:"name"`
+	In       string    `json:"in"`
+	Required bool      `json:"required,omitempty"`
+	Schema   *oaSchema `json:"schema"`
+}
+
+type oaMedia struct {
+	Schema *oaSchema `json:"schema"`
+}
+
+type oaBody struct {
+	Required bool               `json:"required,omitempty"`
+	Content  map[string]oaMedia `json:"content"`
+}
+
+type oaResponse struct {
+	Ref         string             `json:"$ref,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Content     map[string]oaMedia `json:"content,omitempty"`
+}
+
+type oaOperation struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Description string                `json:"description,omitempty"`
+	Parameters  []oaParameter         `json:"parameters,omitempty"`
+	RequestBody *oaBody               `json:"requestBody,omitempty"`
+	Responses   map[string]oaResponse `json:"responses"`
+	// apigen.AuthExtension: security подставит apigen.OpenAPI по настроенному Authenticator
+	Auth bool `json:"x-apigen-auth,omitempty"`
+}
+
+type oaComponents struct {
+	Schemas   map[string]*oaSchema  `json:"schemas"`
+	Responses map[string]oaResponse `json:"responses"`
+}
+
+type oaDoc struct {
+	OpenAPI    string                             `json:"openapi"`
+	Info       map[string]string                  `json:"info"`
+	Paths      map[string]map[string]*oaOperation `json:"paths"`
+	Components oaComponents                       `json:"components"`
+}
+
+// openAPIBuilder собирает документ для одного api
+type openAPIBuilder struct {
+	doc         *oaDoc
+	jsonStructs map[string]JsonFields
+}
+
+// buildOpenAPI - документ для api со списком методов
+func buildOpenAPI(apiName string, methods []MR, jsonStructs map[string]JsonFields) ([]byte, error) {
+	b := &openAPIBuilder{
+		doc: &oaDoc{
+			OpenAPI: "3.0.3",
+			Info:    map[string]string{"title": apiName, "version": "1.0.0"},
+			Paths:   map[string]map[string]*oaOperation{},
+			Components: oaComponents{
+				Schemas: map[string]*oaSchema{
+					"Error": {
+						Type:       "object",
+						Properties: map[string]*oaSchema{"error": {Type: "string"}},
+						Required:   []string{"error"},
+					},
+				},
+				Responses: map[string]oaResponse{
+					"Error": {
+						Description: "ошибка, текст в поле error",
+						Content: map[string]oaMedia{
+							"application/json": {Schema: &oaSchema{Ref: "#/components/schemas/Error"}},
+						},
+					},
+				},
+			},
+		},
+		jsonStructs: jsonStructs,
+	}
+
+	for _, m := range methods {
+		methods := []string{strings.ToLower(m.Tpl.Method)}
+		if m.Tpl.Method == "" {
+			// метод не указан - принимаем и query, и тело
+			methods = []string{"get", "post"}
+		}
+		for _, method := range methods {
+			op := b.operation(m, method)
+			if m.Tpl.Method == "" {
+				op.OperationID += strings.ToUpper(method[:1]) + method[1:]
+			}
+			if b.doc.Paths[m.Route] == nil {
+				b.doc.Paths[m.Route] = map[string]*oaOperation{}
+			}
+			b.doc.Paths[m.Route][method] = op
+		}
+	}
+
+	return json.MarshalIndent(b.doc, "", "  ")
+}
+
+func (b *openAPIBuilder) operation(m MR, method string) *oaOperation {
+	op := &oaOperation{
+		OperationID: m.Method,
+		Summary:     m.Doc,
+		Responses: map[string]oaResponse{
+			"200": b.response(m.Tpl),
+			"400": {Ref: "#/components/responses/Error"},
+			"404": {Ref: "#/components/responses/Error"},
+			"500": {Ref: "#/components/responses/Error"},
+		},
+	}
+	if m.Tpl.Method != "" {
+		op.Responses["405"] = oaResponse{Ref: "#/components/responses/Error"}
+	}
+	if m.Tpl.Timeout != "" {
+		op.Responses["504"] = oaResponse{Ref: "#/components/responses/Error"}
+	}
+	if m.Tpl.Auth {
+		op.Auth = true
+		op.Responses["403"] = oaResponse{Ref: "#/components/responses/Error"}
+		if len(m.Tpl.Roles) > 0 {
+			op.Description = "нужны роли: " + strings.Join(m.Tpl.Roles, ", ")
+		}
+	}
+
+	inPath := map[string]bool{}
+	for _, name := range apigen.PathVars(m.Route) {
+		inPath[name] = true
+	}
+	var fields []Field
+	for _, f := range m.Tpl.StructFields.FList {
+		if !inPath[f.Tag.ParamName] {
+			fields = append(fields, f)
+			continue
+		}
+		op.Parameters = append(op.Parameters, oaParameter{
+			Name:     f.Tag.ParamName,
+			In:       "path",
+			Required: true,
+			Schema:   b.paramSchema(f),
+		})
+	}
+	if len(fields) == 0 {
+		return op
+	}
+	if method == "get" {
+		for _, f := range fields {
+			op.Parameters = append(op.Parameters, oaParameter{
+				Name:     f.Tag.ParamName,
+				In:       "query",
+				Required: f.Tag.Required,
+				Schema:   b.paramSchema(f),
+			})
+		}
+		return op
+	}
+
+	body := &oaSchema{Type: "object", Properties: map[string]*oaSchema{}}
+	for _, f := range fields {
+		body.Properties[f.Tag.ParamName] = b.paramSchema(f)
+		if f.Tag.Required {
+			body.Required = append(body.Required, f.Tag.ParamName)
+		}
+	}
+	op.RequestBody = &oaBody{
+		Required: len(body.Required) > 0,
+		Content: map[string]oaMedia{
+			"application/json":                  {Schema: body},
+			"application/x-www-form-urlencoded": {Schema: body},
+		},
+	}
+	if m.Tpl.HasFiles() {
+		// файлы приходят только формой
+		op.RequestBody.Content = map[string]oaMedia{"multipart/form-data": {Schema: body}}
+	}
+	if m.Tpl.MaxBody > 0 {
+		op.Responses["413"] = oaResponse{Ref: "#/components/responses/Error"}
+	}
+	return op
+}
+
+// response - успешный ответ: конверт, поток элементов или Blob как есть
+func (b *openAPIBuilder) response(p TplParam) oaResponse {
+	envelope := func(response *oaSchema) *oaSchema {
+		return &oaSchema{
+			Type: "object",
+			Properties: map[string]*oaSchema{
+				"error":    {Type: "string"},
+				"response": response,
+			},
+		}
+	}
+	switch {
+	case p.Result.Kind == "blob":
+		return oaResponse{
+			Description: "содержимое как есть",
+			Content: map[string]oaMedia{
+				"application/octet-stream": {Schema: &oaSchema{Type: "string", Format: "binary"}},
+			},
+		}
+	case p.Result.IsStream():
+		item := b.schema(p.Result.Type)
+		return oaResponse{
+			Description: "поток, формат по умолчанию " + p.Stream + ", другой можно попросить через Accept",
+			Content: map[string]oaMedia{
+				"application/x-ndjson": {Schema: envelope(item)},
+				"text/event-stream":    {Schema: item},
+			},
+		}
+	}
+	return oaResponse{
+		Description: "успешный ответ",
+		Content: map[string]oaMedia{
+			"application/json": {Schema: envelope(b.schema(p.User))},
+		},
+	}
+}
+
+// paramSchema - схема параметра вместе с ограничениями из apivalidator
+func (b *openAPIBuilder) paramSchema(f Field) *oaSchema {
+	if f.Type.Kind == "file" {
+		return &oaSchema{Type: "string", Format: "binary"}
+	}
+	s := b.schema(f.Type.Go)
+	rules := f.Tag
+	min, max := &s.Minimum, &s.Maximum
+	switch f.Type.Kind {
+	case "string":
+		min, max = &s.MinLength, &s.MaxLength
+	case "strings":
+		min, max = &s.MinItems, &s.MaxItems
+	}
+	if rules.Min {
+		n := json.Number(rules.MinValue)
+		*min = &n
+	}
+	if rules.Max {
+		n := json.Number(rules.MaxValue)
+		*max = &n
+	}
+	if rules.Len != "" {
+		n := json.Number(rules.Len)
+		*min, *max = &n, &n
+	}
+	for _, v := range rules.Enum {
+		if f.Type.Kind == "string" {
+			s.Enum = append(s.Enum, v)
+		} else {
+			s.Enum = append(s.Enum, json.Number(v))
+		}
+	}
+	switch {
+	case rules.Email:
+		s.Format = "email"
+	case rules.URL:
+		s.Format = "uri"
+	}
+	s.Pattern = rules.Regexp
+	if rules.Default != "" {
+		s.Default = rules.Default
+		switch f.Type.Kind {
+		case "int", "uint", "float":
+			s.Default = json.Number(rules.Default)
+		case "bool":
+			s.Default, _ = strconv.ParseBool(rules.Default)
+		}
+	}
+	return s
+}
+
+var oaBasic = map[string]oaSchema{
+	"string":    {Type: "string"},
+	"int":       {Type: "integer", Format: "int64"},
+	"int8":      {Type: "integer", Format: "int32"},
+	"int16":     {Type: "integer", Format: "int32"},
+	"int32":     {Type: "integer", Format: "int32"},
+	"int64":     {Type: "integer", Format: "int64"},
+	"uint":      {Type: "integer", Format: "int64"},
+	"uint8":     {Type: "integer", Format: "int32"},
+	"uint16":    {Type: "integer", Format: "int32"},
+	"uint32":    {Type: "integer", Format: "int64"},
+	"uint64":    {Type: "integer", Format: "int64"},
+	"float32":   {Type: "number", Format: "float"},
+	"float64":   {Type: "number", Format: "double"},
+	"bool":      {Type: "boolean"},
+	"time.Time": {Type: "string", Format: "date-time"},
+}
+
+// schema по типу go, как он записан в коде.
+// структуры из файла уходят в components/schemas и подставляются ссылкой
+func (b *openAPIBuilder) schema(goType string) *oaSchema {
+	if strings.HasPrefix(goType, "*") {
+		s := b.schema(goType[1:])
+		if s.Ref != "" {
+			// у $ref не может быть соседних полей в 3.0
+			return s
+		}
+		s.Nullable = true
+		return s
+	}
+	if strings.HasPrefix(goType, "[]") {
+		return &oaSchema{Type: "array", Items: b.schema(goType[2:])}
+	}
+	if basic, ok := oaBasic[goType]; ok {
+		if strings.HasPrefix(goType, "uint") {
+			zero := json.Number("0")
+			basic.Minimum = &zero
+		}
+		return &basic
+	}
+
+	fields, ok := b.jsonStructs[goType]
+	if !ok {
+		return &oaSchema{Type: "object"}
+	}
+	if _, done := b.doc.Components.Schemas[goType]; !done {
+		s := &oaSchema{Type: "object", Properties: map[string]*oaSchema{}}
+		// сначала кладём заглушку, чтобы не зациклиться на рекурсивных типах
+		b.doc.Components.Schemas[goType] = s
+		for _, f := range fields.FList {
+			goType := f.Type.Go
+			if f.Type.Pointer {
+				goType = "*" + goType
+			}
+			s.Properties[f.Tag.ParamName] = b.schema(goType)
+		}
+	}
+	return &oaSchema{Ref: "#/components/schemas/" + goType}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+)
+
+// fset - позиции всех разобранных файлов, нужны для ошибок вида api.go:12:2
+var fset = token.NewFileSet()
+
+// problems - ошибки в аннотациях и тегах. Собираем все сразу, чтобы не чинить их по одной
+var problems []string
+
+func errorf(pos token.Pos, format string, args ...interface{}) {
+	problems = append(problems, fmt.Sprintf("%s: %s", fset.Position(pos), fmt.Sprintf(format, args...)))
+}
+
+// warnings - то, что сгенерируется, но может выстрелить потом. Печатаются, но генерацию не останавливают
+var warnings []string
+
+func warnf(pos token.Pos, format string, args ...interface{}) {
+	warnings = append(warnings, fmt.Sprintf("%s: warning: %s", fset.Position(pos), fmt.Sprintf(format, args...)))
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// правила apivalidator, у которых есть значение после =
+var valueRules = map[string]bool{
+	"paramname": true,
+	"min":       true,
+	"max":       true,
+	"len":       true,
+	"enum":      true,
+	"oneof":     true,
+	"default":   true,
+	"regexp":    true,
+	"eqfield":   true,
+	"nefield":   true,
+	"validate":  true,
+	"maxsize":   true,
+}
+
+// правила без значения
+var flagRules = map[string]bool{
+	"required": true,
+	"email":    true,
+	"url":      true,
+}
+
+// splitRules режет тег по запятым, но запятая внутри regexp={1,3} правило не разрывает:
+// кусок, который не начинается с известного правила, приклеивается к предыдущему
+func splitRules(tag string) []string {
+	var rules []string
+	for _, part := range strings.Split(tag, ",") {
+		name := strings.SplitN(part, "=", 2)[0]
+		_, _, compare := splitCompare(part)
+		known := compare || flagRules[name] || (valueRules[name] && strings.Contains(part, "="))
+		if !known && len(rules) > 0 {
+			rules[len(rules)-1] += "," + part
+			continue
+		}
+		rules = append(rules, part)
+	}
+	return rules
+}
+
+// parseRules разбирает тег apivalidator поля. Ошибки в теге - это ошибки в коде api,
+// поэтому генератор сразу падает, а не генерирует то, что не скомпилируется
+func parseRules(pos token.Pos, structName, fieldName, tag string, ft FieldType, validators map[string]string) Rules {
+	where := structName + "." + fieldName
+	numeric := ft.Kind == "int" || ft.Kind == "uint" || ft.Kind == "float"
+	sized := numeric || ft.Kind == "string" || ft.Kind == "strings"
+
+	rules := Rules{}
+	for _, rule := range splitRules(tag) {
+		if rule == "" {
+			continue
+		}
+		if left, op, ok := splitCompare(rule); ok {
+			// password==password_confirm - правило между двумя параметрами
+			right := rule[len(left)+len(op):]
+			rules.Compare = append(rules.Compare, Compare{Left: left, Op: op, Right: right})
+			continue
+		}
+		tParts := strings.SplitN(rule, "=", 2)
+		name, value := tParts[0], ""
+		if len(tParts) == 2 {
+			value = tParts[1]
+		}
+		if !flagRules[name] && !valueRules[name] {
+			errorf(pos, "%s: unknown rule %q", where, rule)
+		}
+		if valueRules[name] && value == "" {
+			errorf(pos, "%s: rule %s needs value", where, name)
+		}
+		switch name {
+		case "required":
+			rules.Required = true
+		case "paramname":
+			rules.ParamName = value
+		case "min", "max":
+			if !sized {
+				errorf(pos, "%s: %s is not supported for %s", where, name, ft.Go)
+			}
+			if name == "min" {
+				rules.Min = true
+				rules.MinValue = mustNumber(pos, where, value)
+			} else {
+				rules.Max = true
+				rules.MaxValue = mustNumber(pos, where, value)
+			}
+		case "len":
+			if ft.Kind != "string" && ft.Kind != "strings" {
+				errorf(pos, "%s: len is only for strings and lists", where)
+			}
+			if _, err := strconv.Atoi(value); err != nil {
+				errorf(pos, "%s: len must be int, got %q", where, value)
+			}
+			rules.Len = value
+		case "enum", "oneof":
+			rules.Enum = strings.Split(value, "|")
+			switch ft.Kind {
+			case "string":
+			case "int", "uint":
+				for _, v := range rules.Enum {
+					if _, err := strconv.ParseInt(v, 10, 64); err != nil {
+						errorf(pos, "%s: %s values must be int, got %q", where, name, v)
+					}
+				}
+			default:
+				errorf(pos, "%s: %s is not supported for %s", where, name, ft.Go)
+			}
+		case "default":
+			if ft.Kind == "file" || ft.Kind == "struct" {
+				errorf(pos, "%s: default is not supported for %s", where, ft.Go)
+			}
+			rules.Default = value
+		case "maxsize":
+			if ft.Kind != "file" {
+				errorf(pos, "%s: maxsize is only for files", where)
+			}
+			if n, err := strconv.ParseInt(value, 10, 64); err != nil || n <= 0 {
+				errorf(pos, "%s: maxsize must be positive int, got %q", where, value)
+			}
+			rules.MaxSize = value
+		case "regexp", "email", "url":
+			if ft.Kind != "string" {
+				errorf(pos, "%s: %s is only for strings", where, name)
+			}
+			switch name {
+			case "regexp":
+				if _, err := regexp.Compile(value); err != nil {
+					errorf(pos, "%s: bad regexp: %s", where, err)
+				}
+				rules.Regexp = value
+			case "email":
+				rules.Email = true
+			case "url":
+				rules.URL = true
+			}
+		case "eqfield":
+			rules.Compare = append(rules.Compare, Compare{Op: "==", Right: value})
+		case "nefield":
+			rules.Compare = append(rules.Compare, Compare{Op: "!=", Right: value})
+		case "validate":
+			fn, ok := validators[value]
+			if !ok {
+				errorf(pos, "%s: unknown validator %q, mark func with // apigen:validator %s", where, value, value)
+			}
+			rules.Custom = append(rules.Custom, fn)
+		}
+	}
+	return rules
+}
+
+// Compare - правило между параметрами, Left пустой - это само поле с тегом
+type Compare struct {
+	Left  string
+	Op    string
+	Right string
+}
+
+var paramNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// splitCompare узнаёт правило вида a==b или a!=b, где a и b - имена параметров
+func splitCompare(rule string) (string, string, bool) {
+	for _, op := range []string{"==", "!="} {
+		parts := strings.SplitN(rule, op, 2)
+		if len(parts) == 2 && paramNameRe.MatchString(parts[0]) && paramNameRe.MatchString(parts[1]) {
+			return parts[0], op, true
+		}
+	}
+	return "", "", false
+}
+
+// findValidators собирает функции с комментарием "// apigen:validator <name>".
+// такая функция принимает значение поля и возвращает error, текст которого дописывается к имени параметра
+func findValidators(files []*ast.File) map[string]string {
+	validators := map[string]string{}
+	for _, decl := range allDecls(files) {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok || fn.Doc == nil || fn.Recv != nil {
+			continue
+		}
+		for _, comment := range fn.Doc.List {
+			if !strings.HasPrefix(comment.Text, "// apigen:validator") {
+				continue
+			}
+			name := strings.TrimSpace(comment.Text[len("// apigen:validator"):])
+			if name == "" {
+				name = fn.Name.Name
+			}
+			if other, exist := validators[name]; exist {
+				errorf(fn.Pos(), "validator %s: both %s and %s", name, other, fn.Name.Name)
+			}
+			validators[name] = fn.Name.Name
+		}
+	}
+	return validators
+}
+
+// Check - одно условие нарушения и текст ошибки, go-литерал
+type Check struct {
+	// для правил между полями - к какому параметру относится нарушение
+	Param string
+	Cond  string
+	Msg   string
+}
+
+// Guard - условие, при котором поле проверяется: разбор не упал, указатель не nil
+func (f Field) Guard() string {
+	guard := `!errs.Failed("` + f.Tag.ParamName + `")`
+	if f.Type.Pointer || f.Type.Kind == "file" {
+		guard += " && params." + f.Name + " != nil"
+	}
+	return guard
+}
+
+// Checks - условия для switch, срабатывает первое нарушенное правило поля
+func (f Field) Checks() []Check {
+	var checks []Check
+	rules := f.Tag
+	name := f.Tag.ParamName
+	// форматы проверяем только у переданной строки, пустая - забота required
+	skipEmpty := ""
+	if !rules.Required && !f.Type.Pointer {
+		switch f.Type.Kind {
+		case "string":
+			skipEmpty = f.Value() + ` != "" && `
+		case "strings":
+			skipEmpty = "len(" + f.Value() + ") != 0 && "
+		}
+	}
+	if rules.Len != "" {
+		checks = append(checks, Check{
+			Cond: skipEmpty + f.Measure() + " != " + rules.Len,
+			Msg:  strconv.Quote(f.MeasureName() + " must be " + rules.Len),
+		})
+	}
+	if rules.Min {
+		checks = append(checks, Check{
+			Cond: f.Measure() + " < " + rules.MinValue,
+			Msg:  strconv.Quote(f.MeasureName() + " must be >= " + rules.MinValue),
+		})
+	}
+	if rules.Max {
+		checks = append(checks, Check{
+			Cond: f.Measure() + " > " + rules.MaxValue,
+			Msg:  strconv.Quote(f.MeasureName() + " must be <= " + rules.MaxValue),
+		})
+	}
+	if len(rules.Enum) > 0 {
+		call := "apigen.OneOf(" + f.Value()
+		switch f.Type.Kind {
+		case "int":
+			call = "apigen.OneOfInt(int64(" + f.Value() + ")"
+		case "uint":
+			call = "apigen.OneOfUint(uint64(" + f.Value() + ")"
+		}
+		for _, v := range rules.Enum {
+			if f.Type.Kind == "string" {
+				v = strconv.Quote(v)
+			}
+			call += ", " + v
+		}
+		checks = append(checks, Check{
+			Cond: "!" + call + ")",
+			Msg:  strconv.Quote(name + " must be one of [" + strings.Join(rules.Enum, ", ") + "]"),
+		})
+	}
+	if rules.Email {
+		checks = append(checks, Check{
+			Cond: skipEmpty + "!apigen.IsEmail(" + f.Value() + ")",
+			Msg:  strconv.Quote(name + " must be email"),
+		})
+	}
+	if rules.URL {
+		checks = append(checks, Check{
+			Cond: skipEmpty + "!apigen.IsURL(" + f.Value() + ")",
+			Msg:  strconv.Quote(name + " must be url"),
+		})
+	}
+	if rules.Regexp != "" {
+		checks = append(checks, Check{
+			Cond: skipEmpty + "!apigen.MatchRegexp(" + strconv.Quote(rules.Regexp) + ", " + f.Value() + ")",
+			Msg:  strconv.Quote(name + " must match " + rules.Regexp),
+		})
+	}
+	return checks
+}
+
+// crossChecks - правила eqfield и nefield, проверяются после разбора всех полей
+func crossChecks(structName string, fields []Field) []Check {
+	byParam := map[string]Field{}
+	for _, f := range fields {
+		byParam[f.Tag.ParamName] = f
+	}
+	var checks []Check
+	for _, field := range fields {
+		for _, c := range field.Tag.Compare {
+			f := field
+			if c.Left != "" {
+				var ok bool
+				if f, ok = byParam[c.Left]; !ok {
+					errorf(field.Pos, "%s.%s: no param %s to compare", structName, field.Name, c.Left)
+					continue
+				}
+			}
+			o, ok := byParam[c.Right]
+			if !ok {
+				errorf(field.Pos, "%s.%s: no param %s to compare with", structName, field.Name, c.Right)
+				continue
+			}
+			if o.Type != f.Type || f.Type.Pointer || f.Type.Kind == "strings" || f.Type.Kind == "struct" || f.Type.Kind == "file" {
+				errorf(field.Pos, "%s.%s: cant compare with %s", structName, f.Name, o.Name)
+				continue
+			}
+			// в условии - нарушение, то есть обратная операция
+			violated, text := "!=", "equal to"
+			if c.Op == "!=" {
+				violated, text = "==", "different from"
+			}
+			checks = append(checks, Check{
+				Param: f.Tag.ParamName,
+				Cond: `!errs.Failed("` + f.Tag.ParamName + `") && !errs.Failed("` + o.Tag.ParamName + `") && ` +
+					"params." + f.Name + " " + violated + " params." + o.Name,
+				Msg: strconv.Quote(f.Tag.ParamName + " must be " + text + " " + o.Tag.ParamName),
+			})
+		}
+	}
+	return checks
+}
@@ -7,12 +7,43 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"net/http"
+	"os"
+
+	"google.golang.org/grpc"
+
+	"week5/apigen"
+	"week5/apigen/apigrpc"
 )
 
 func main() {
-	// будет вызван метод ServeHTTP у структуры MyApi
-	http.Handle("/user/", NewMyApi())
+	// ключ для методов с авторизацией берём из окружения, в коде его держать не надо
+	auth := apigen.APIKeyAuth{
+		Keys: map[string]apigen.Principal{
+			os.Getenv("API_KEY"): {ID: "rvasily", Roles: []string{"admin"}},
+		},
+	}
+
+	api := NewMyApi()
+
+	// те же методы по gRPC, описание сервиса в api.proto
+	lis, err := net.Listen("tcp", ":8081")
+	if err != nil {
+		fmt.Println("cant listen grpc port:", err)
+		os.Exit(1)
+	}
+	// apigrpc.ServerOption - чтобы сервис могли звать и клиенты, собранные protoc из api.proto
+	server := grpc.NewServer(apigrpc.ServerOption())
+	NewMyApiGRPC(api, apigen.Options{Auth: auth}).Register(server)
+	fmt.Println("starting grpc server at :8081")
+	go server.Serve(lis)
+
+	// будет вызван метод ServeHTTP у MyApiHandler
+	http.Handle("/user/", NewMyApiHandler(api, apigen.Options{
+		Auth:       auth,
+		Middleware: []apigen.Middleware{apigen.Logging(nil), apigen.Recovery(nil)},
+	}))
 
 	fmt.Println("starting server at :8080")
 	http.ListenAndServe(":8080", nil)
@@ -10,6 +10,8 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"week5/apigen"
 )
 
 func CheckoutDummy(w http.ResponseWriter, r *http.Request) {
@@ -23,6 +25,7 @@ type Case struct {
 	Method string // GET по-умолчанию в http.NewRequest если передали пустую строку
 	Path   string
 	Query  string
+	Body   string // если задан - уходит json-телом, Query тогда только в url
 	Auth   bool
 	Status int
 	Result interface{}
@@ -36,8 +39,17 @@ const (
 // CaseResponse
 type CR map[string]interface{}
 
+// ключ 100500 из X-Auth, с которым ходят тесты
+var testOptions = apigen.Options{
+	Auth: apigen.APIKeyAuth{
+		Keys: map[string]apigen.Principal{
+			"100500": {ID: "rvasily", Roles: []string{"admin"}},
+		},
+	},
+}
+
 func TestMyApi(t *testing.T) {
-	ts := httptest.NewServer(NewMyApi())
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), testOptions))
 
 	cases := []Case{
 		Case{ // успешный запрос
@@ -135,7 +147,7 @@ func TestMyApi(t *testing.T) {
 			Path:   ApiUserCreate,
 			Method: http.MethodGet,
 			Query:  "login=mr.moderator&age=32&status=moderator&full_name=GetMethod",
-			Status: http.StatusNotAcceptable,
+			Status: http.StatusMethodNotAllowed,
 			Auth:   true,
 			Result: CR{
 				"error": "bad method",
@@ -250,7 +262,7 @@ func TestMyApi(t *testing.T) {
 }
 
 func TestOtherApi(t *testing.T) {
-	ts := httptest.NewServer(NewOtherApi())
+	ts := httptest.NewServer(NewOtherApiHandler(NewOtherApi(), testOptions))
 
 	cases := []Case{
 		Case{
@@ -295,7 +307,10 @@ func runTests(t *testing.T, ts *httptest.Server, cases []Case) {
 
 		caseName := fmt.Sprintf("case %d: [%s] %s %s", idx, item.Method, item.Path, item.Query)
 
-		if item.Method == http.MethodPost {
+		if item.Body != "" {
+			req, err = http.NewRequest(item.Method, ts.URL+item.Path+"?"+item.Query, strings.NewReader(item.Body))
+			req.Header.Add("Content-Type", "application/json")
+		} else if item.Method == http.MethodPost {
 			reqBody := strings.NewReader(item.Query)
 			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
 			req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
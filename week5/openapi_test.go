@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"week5/apigen"
+)
+
+func TestOpenAPI(t *testing.T) {
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), apigen.Options{
+		OpenAPI: true,
+		Auth:    apigen.MultiAuth{apigen.BearerAuth{Secret: []byte("secret")}, apigen.HMACAuth{}, apigen.APIKeyAuth{Header: "X-Api-Key"}},
+	}))
+	defer ts.Close()
+
+	resp, err := client.Get(ts.URL + apigen.OpenAPIPath)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	spec := struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			Parameters []struct {
+				Name     string `json:"name"`
+				Required bool   `json:"required"`
+			} `json:"parameters"`
+			RequestBody struct {
+				Content map[string]struct {
+					Schema struct {
+						Properties map[string]struct {
+							Type    string        `json:"type"`
+							Format  string        `json:"format"`
+							Pattern string        `json:"pattern"`
+							Enum    []interface{} `json:"enum"`
+							Default interface{}   `json:"default"`
+							Minimum *float64      `json:"minimum"`
+							Maximum *float64      `json:"maximum"`
+						} `json:"properties"`
+						Required []string `json:"required"`
+					} `json:"schema"`
+				} `json:"content"`
+			} `json:"requestBody"`
+			Security  []map[string][]string  `json:"security"`
+			Responses map[string]interface{} `json:"responses"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]interface{} `json:"properties"`
+			} `json:"schemas"`
+			SecuritySchemes map[string]apigen.SecurityScheme `json:"securitySchemes"`
+		} `json:"components"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&spec); err != nil {
+		t.Fatalf("cant unpack json: %v", err)
+	}
+
+	if spec.OpenAPI != "3.0.3" {
+		t.Errorf("bad openapi version %q", spec.OpenAPI)
+	}
+
+	// метод не указан - и get, и post
+	profile := spec.Paths["/user/profile"]
+	if len(profile) != 2 {
+		t.Errorf("expected get and post for /user/profile, got %v", profile)
+	}
+	if params := profile["get"].Parameters; len(params) != 1 || params[0].Name != "login" || !params[0].Required {
+		t.Errorf("bad profile params: %+v", params)
+	}
+
+	create, ok := spec.Paths["/user/create"]["post"]
+	if !ok || len(spec.Paths["/user/create"]) != 1 {
+		t.Fatalf("expected only post for /user/create, got %v", spec.Paths["/user/create"])
+	}
+	// схемы - из настроенного Authenticator, у подписи HMAC своей схемы нет
+	if len(create.Security) != 2 || create.Security[0]["apiKey"] == nil || create.Security[1]["bearer"] == nil {
+		t.Errorf("expected apiKey or bearer security for /user/create, got %v", create.Security)
+	}
+	if len(profile["get"].Security) != 0 {
+		t.Errorf("expected no security for /user/profile")
+	}
+	expectedSchemes := map[string]apigen.SecurityScheme{
+		"apiKey": {Type: "apiKey", In: "header", Name: "X-Api-Key"},
+		"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+	}
+	if !reflect.DeepEqual(spec.Components.SecuritySchemes, expectedSchemes) {
+		t.Errorf("bad security schemes: %+v", spec.Components.SecuritySchemes)
+	}
+	if _, ok := create.Responses["403"]; !ok {
+		t.Errorf("expected 403 response for /user/create")
+	}
+	body := create.RequestBody.Content["application/json"].Schema
+	if len(body.Required) != 1 || body.Required[0] != "login" {
+		t.Errorf("bad required: %v", body.Required)
+	}
+	if status := body.Properties["status"]; len(status.Enum) != 3 || status.Default != "user" {
+		t.Errorf("bad status: %+v", status)
+	}
+	if age := body.Properties["age"]; age.Type != "integer" || age.Minimum == nil || *age.Maximum != 128 {
+		t.Errorf("bad age: %+v", age)
+	}
+	if _, ok := body.Properties["full_name"]; !ok {
+		t.Errorf("expected paramname full_name in body")
+	}
+
+	signup := spec.Paths["/user/signup"]["post"].RequestBody.Content["application/json"].Schema
+	if email := signup.Properties["email"]; email.Format != "email" {
+		t.Errorf("bad email: %+v", email)
+	}
+	if login := signup.Properties["login"]; login.Pattern == "" {
+		t.Errorf("bad login: %+v", login)
+	}
+	if level := signup.Properties["level"]; len(level.Enum) != 3 || level.Enum[0] != float64(1) || level.Default != float64(1) {
+		t.Errorf("bad level: %+v", level)
+	}
+
+	for _, name := range []string{"User", "NewUser", "UserInfo", "Address", "Error"} {
+		if _, ok := spec.Components.Schemas[name]; !ok {
+			t.Errorf("expected schema %s", name)
+		}
+	}
+	if _, ok := spec.Components.Schemas["User"].Properties["full_name"]; !ok {
+		t.Errorf("expected json name full_name in User schema")
+	}
+
+	// по умолчанию описание не отдаём
+	ts2 := httptest.NewServer(NewMyApiHandler(NewMyApi(), apigen.Options{}))
+	defer ts2.Close()
+	resp2, err := client.Get(ts2.URL + apigen.OpenAPIPath)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusNotFound {
+		t.Errorf("expected 404 without OpenAPI option, got %d", resp2.StatusCode)
+	}
+
+	// Authenticator себя не описывает - схем в описании нет
+	ts3 := httptest.NewServer(NewMyApiHandler(NewMyApi(), apigen.Options{OpenAPI: true, Auth: apigen.HMACAuth{}}))
+	defer ts3.Close()
+	resp3, err := client.Get(ts3.URL + apigen.OpenAPIPath)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp3.Body.Close()
+	plain := struct {
+		Components map[string]interface{} `json:"components"`
+	}{}
+	if err := json.NewDecoder(resp3.Body).Decode(&plain); err != nil {
+		t.Fatalf("cant unpack json: %v", err)
+	}
+	if _, ok := plain.Components["securitySchemes"]; ok {
+		t.Errorf("expected no security schemes for HMACAuth")
+	}
+}
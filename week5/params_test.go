@@ -0,0 +1,160 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"week5/apigen"
+)
+
+const ApiUserInfo = "/user/info"
+
+func TestTypedParams(t *testing.T) {
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), testOptions))
+
+	cases := []Case{
+		Case{ // форма, повторяющийся параметр - список
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&age=33&rating=4.5&visits=10&verified=true&birthday=1990-01-02T00:00:00Z&tags=go&tags=db",
+			Auth:   true,
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"login":    "rvasily",
+					"age":      33,
+					"rating":   4.5,
+					"visits":   10,
+					"verified": true,
+					"birthday": "1990-01-02T00:00:00Z",
+					"tags":     []string{"go", "db"},
+				},
+			},
+		},
+		Case{ // json, необязательные параметры не переданы - остаются старые значения
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Body:   `{"login": "rvasily", "rating": 5, "tags": ["go"], "address": {"city": "Moscow", "street": "Tverskaya"}}`,
+			Auth:   true,
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"login":    "rvasily",
+					"age":      33,
+					"rating":   5,
+					"visits":   0,
+					"verified": true,
+					"birthday": "1990-01-02T00:00:00Z",
+					"tags":     []string{"go"},
+					"address":  CR{"city": "Moscow", "street": "Tverskaya"},
+				},
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Body:   `{"login": "rvasily", "verified": "yes"}`,
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "verified must be bool",
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Body:   `{"login": 42}`,
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "login must be string",
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Body:   `{"login": "rvasily", "age": -1}`,
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "age must be >= 0",
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&visits=-1",
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "visits must be uint",
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&rating=5.5",
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "rating must be <= 5",
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&birthday=02.01.1990",
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "birthday must be RFC3339 time",
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&tags=a&tags=b&tags=c&tags=d",
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "tags len must be <= 3",
+			},
+		},
+		Case{ // вложенная структура в форме - json-строкой
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&address=moscow",
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "address must be object",
+			},
+		},
+		Case{
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Body:   `{"login": "rvasily"`,
+			Auth:   true,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "bad json body",
+			},
+		},
+		Case{ // без max_body в apigen:api тело всё равно ограничено
+			Path:   ApiUserInfo,
+			Method: http.MethodPost,
+			Body:   `{"login": "` + strings.Repeat("a", apigen.DefaultMaxBody) + `"}`,
+			Auth:   true,
+			Status: http.StatusRequestEntityTooLarge,
+			Result: CR{
+				"error": "request body too large",
+			},
+		},
+	}
+
+	runTests(t, ts, cases)
+}
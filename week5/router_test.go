@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"week5/apigen"
+)
+
+func TestPathParams(t *testing.T) {
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), testOptions))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/user/rvasily/info",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"login":    "rvasily",
+					"age":      0,
+					"rating":   0,
+					"visits":   0,
+					"verified": false,
+					"tags":     nil,
+				},
+			},
+		},
+		Case{ // параметр из пути важнее query
+			Path:   "/user/not_exist_user/info",
+			Query:  "login=rvasily",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "user not exist",
+			},
+		},
+		Case{
+			Path:   "/user//info",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+	}
+	runTests(t, ts, cases)
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/user/rvasily/info", nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != "GET" {
+		t.Errorf("expected 405 with Allow: GET, got %d, %q", resp.StatusCode, resp.Header.Get("Allow"))
+	}
+
+	// клиент подставляет параметр в путь
+	info, err := NewMyApiClient(ts.URL).Info(context.Background(), InfoRequest{Login: "rvasily"})
+	if err != nil || info.Login != "rvasily" {
+		t.Errorf("bad info: %+v, %v", info, err)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	var calls []string
+	trace := func(name string) apigen.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	var timed []int
+	logs := &bytes.Buffer{}
+	logger := log.New(logs, "", 0)
+
+	opts := testOptions
+	opts.Middleware = []apigen.Middleware{
+		apigen.Logging(logger),
+		apigen.Timing(func(r *http.Request, status int, d time.Duration) {
+			timed = append(timed, status)
+		}),
+		// паника не должна пролететь мимо логов и таймингов
+		apigen.Recovery(logger),
+		trace("api"),
+	}
+	opts.Endpoint = map[string][]apigen.Middleware{
+		"Profile": {trace("profile")},
+		"Me": {func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				panic("boom")
+			})
+		}},
+	}
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), opts))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   ApiUserProfile,
+			Query:  "login=rvasily",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id":        42,
+					"login":     "rvasily",
+					"full_name": "Vasily Romanov",
+					"status":    20,
+				},
+			},
+		},
+		Case{ // общие middleware видят и неизвестные методы
+			Path:   "/user/unknown",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+		Case{
+			Path:   "/user/me",
+			Auth:   true,
+			Status: http.StatusInternalServerError,
+			Result: CR{
+				"error": "internal error",
+			},
+		},
+	}
+	runTests(t, ts, cases)
+
+	if strings.Join(calls, ",") != "api,profile,api,api" {
+		t.Errorf("bad middleware calls: %v", calls)
+	}
+	if len(timed) != 3 || timed[0] != http.StatusOK || timed[1] != http.StatusNotFound || timed[2] != http.StatusInternalServerError {
+		t.Errorf("bad timings: %v", timed)
+	}
+	if !strings.Contains(logs.String(), "GET /user/profile 200") || !strings.Contains(logs.String(), "recovered GET /user/me: boom") {
+		t.Errorf("bad logs: %s", logs.String())
+	}
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"week5/apigen"
+)
+
+// uploadRequest - multipart-форма с одним файлом avatar
+func uploadRequest(t *testing.T, url string, data []byte) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	part, err := mw.CreateFormFile("avatar", "me.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	mw.Close()
+	req, _ := http.NewRequest(http.MethodPost, url+"/user/avatar", body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	req.Header.Set("X-Auth", "100500")
+	return req
+}
+
+func TestUploadAvatar(t *testing.T) {
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), testOptions))
+	defer ts.Close()
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{1}, 100)...)
+	sum := md5.Sum(png)
+
+	// строка вместо файла
+	noFile, _ := http.NewRequest(http.MethodPost, ts.URL+"/user/avatar", strings.NewReader("avatar=abc"))
+	noFile.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	noFile.Header.Set("X-Auth", "100500")
+
+	cases := []struct {
+		name   string
+		req    *http.Request
+		status int
+		error  string
+	}{
+		{"ok", uploadRequest(t, ts.URL, png), http.StatusOK, ""},
+		{"too big file", uploadRequest(t, ts.URL, make([]byte, 1048577)), http.StatusBadRequest, "avatar must be <= 1048576 bytes"},
+		{"too big body", uploadRequest(t, ts.URL, make([]byte, 3<<20)), http.StatusRequestEntityTooLarge, "request body too large"},
+		{"not a file", noFile, http.StatusBadRequest, "avatar must be file"},
+	}
+
+	for _, c := range cases {
+		resp, err := client.Do(c.req)
+		if err != nil {
+			t.Fatalf("[%s] request error: %v", c.name, err)
+		}
+		result := struct {
+			Error    string       `json:"error"`
+			Response UploadedFile `json:"response"`
+		}{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if resp.StatusCode != c.status || result.Error != c.error {
+			t.Errorf("[%s] expected %d %q, got %d %q", c.name, c.status, c.error, resp.StatusCode, result.Error)
+		}
+		if c.status == http.StatusOK {
+			expected := UploadedFile{Name: "me.png", MD5: hex.EncodeToString(sum[:]), Size: int64(len(png))}
+			if result.Response != expected {
+				t.Errorf("[%s] expected %+v, got %+v", c.name, expected, result.Response)
+			}
+		}
+	}
+
+	// файл отдаётся как есть, со своим Content-Type
+	resp, err := client.Get(ts.URL + "/user/rvasily/avatar")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/png" || !bytes.Equal(body, png) {
+		t.Errorf("bad avatar: %d %s %q", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	if cd := resp.Header.Get("Content-Disposition"); cd != "attachment; filename=me.png" {
+		t.Errorf("bad Content-Disposition: %q", cd)
+	}
+}
+
+func TestAvatarClient(t *testing.T) {
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), testOptions))
+	defer ts.Close()
+
+	ctx := context.Background()
+	c := NewMyApiClient(ts.URL)
+	c.Sign = apigen.APIKey("100500")
+
+	if _, err := c.Avatar(ctx, AvatarRequest{Login: "rvasily"}); !isStatus(err, http.StatusNotFound) {
+		t.Errorf("expected ApiError 404, got %#v", err)
+	}
+	if _, err := c.UploadAvatar(ctx, AvatarParams{}); !isStatus(err, http.StatusBadRequest) {
+		t.Errorf("expected ApiError 400, got %#v", err)
+	}
+
+	data := []byte("GIF89a avatar")
+	uploaded, err := c.UploadAvatar(ctx, AvatarParams{Avatar: apigen.NewFile("me.gif", data)})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if uploaded.Name != "me.gif" || uploaded.Size != int64(len(data)) {
+		t.Errorf("bad upload: %+v", uploaded)
+	}
+
+	blob, err := c.Avatar(ctx, AvatarRequest{Login: "rvasily"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer blob.Close()
+	got, _ := ioutil.ReadAll(blob.Body)
+	if blob.ContentType != "image/gif" || blob.Name != "me.gif" || blob.Size != int64(len(data)) || !bytes.Equal(got, data) {
+		t.Errorf("bad blob: %+v %q", blob, got)
+	}
+}
+
+func isStatus(err error, status int) bool {
+	apiErr, ok := err.(ApiError)
+	return ok && apiErr.HTTPStatus == status
+}
+
+func TestStreams(t *testing.T) {
+	api := NewMyApi()
+	api.users["admin"] = &User{ID: 1, Login: "admin", Status: statusAdmin}
+	api.users["bob"] = &User{ID: 2, Login: "bob", Status: statusUser}
+	ts := httptest.NewServer(NewMyApiHandler(api, testOptions))
+	defer ts.Close()
+
+	// NDJSON: по конверту на строку
+	resp, err := client.Get(ts.URL + "/user/list?status=admin")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	expected := `{"error":"","response":{"id":1,"login":"admin","full_name":"","status":20}}` + "\n" +
+		`{"error":"","response":{"id":42,"login":"rvasily","full_name":"Vasily Romanov","status":20}}` + "\n"
+	if resp.Header.Get("Content-Type") != "application/x-ndjson" || string(body) != expected {
+		t.Errorf("bad ndjson stream: %s\n%s", resp.Header.Get("Content-Type"), body)
+	}
+
+	resp, err = client.Get(ts.URL + "/user/list?status=root")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected 400 before stream starts, got %d", resp.StatusCode)
+	}
+
+	// logins по умолчанию отдаются как server-sent events
+	resp, err = client.Get(ts.URL + "/user/logins?prefix=b")
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" || string(body) != "id: 1\ndata: \"bob\"\n\n" {
+		t.Errorf("bad sse stream: %s\n%q", resp.Header.Get("Content-Type"), body)
+	}
+
+	// клиент всегда просит NDJSON
+	ctx := context.Background()
+	c := NewMyApiClient(ts.URL)
+	var logins []string
+	err = c.Logins(ctx, LoginsParams{}, func(login string) error {
+		logins = append(logins, login)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(logins, []string{"admin", "bob", "rvasily"}) {
+		t.Errorf("bad logins: %v, %v", logins, err)
+	}
+
+	var ids []uint64
+	status := "user"
+	err = c.List(ctx, ListParams{Status: &status}, func(u *User) error {
+		ids = append(ids, u.ID)
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(ids, []uint64{2}) {
+		t.Errorf("bad list: %v, %v", ids, err)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+const ApiUserSignup = "/user/signup"
+
+func TestValidators(t *testing.T) {
+	ts := httptest.NewServer(NewMyApiHandler(NewMyApi(), testOptions))
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   ApiUserSignup,
+			Method: http.MethodPost,
+			Query:  "login=new.user&email=new@mail.ru&password=secret123&password_confirm=secret123",
+			Status: http.StatusOK,
+			Result: CR{
+				"error": "",
+				"response": CR{
+					"id": 43,
+				},
+			},
+		},
+		Case{ // все нарушения одним ответом, по одному на поле
+			Path:   ApiUserSignup,
+			Method: http.MethodPost,
+			Query:  "login=1user&email=Vasily <v@mail.ru>&site=mail.ru&password=secret&password_confirm=secret&level=4&invite=abc",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "login must match ^[a-z][a-z0-9_.]{2,15}$; " +
+					"email must be email; " +
+					"site must be url; " +
+					"password must be at least 8 chars; " +
+					"level must be one of [1, 2, 3]; " +
+					"invite len must be 8",
+			},
+		},
+		Case{ // ошибка типа не мешает проверить остальные поля
+			Path:   ApiUserSignup,
+			Method: http.MethodPost,
+			Body:   `{"login": 42, "email": "v@mail.ru", "site": "https://mail.ru", "password": "secret123", "password_confirm": "secret321", "level": "one"}`,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "login must be string; " +
+					"level must be int; " +
+					"password must be equal to password_confirm",
+			},
+		},
+		Case{
+			Path:   ApiUserSignup,
+			Method: http.MethodPost,
+			Body:   `{"password": "nodigitshere"}`,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "login must me not empty; " +
+					"email must me not empty; " +
+					"password must contain digit; " +
+					"password_confirm must me not empty",
+			},
+		},
+		Case{
+			Path:   ApiUserSignup,
+			Method: http.MethodPost,
+			Query:  "login=rvasily&email=v@mail.ru&password=secret123&password_confirm=secret123&level=3&invite=abcdefgh",
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "user rvasily exist",
+			},
+		},
+	}
+
+	runTests(t, ts, cases)
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Operation - одна операция POST /_batch:
+//
+//	{"op": "create", "table": "items", "data": {"title": "..."}}
+//	{"op": "update", "table": "items", "id": 3, "data": {"title": "..."}}
+//	{"op": "delete", "table": "items", "id": 3}
+type Operation struct {
+	Op    string                 `json:"op"`
+	Table string                 `json:"table"`
+	ID    interface{}            `json:"id"`
+	Data  map[string]interface{} `json:"data"`
+}
+
+// inTx выполняет fn в транзакции: ошибка fn или паника - откат, иначе commit
+func (h *Handler) inTx(fn func(tx *sql.Tx) error) (err error) {
+	tx, err := h.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+	return fn(tx)
+}
+
+// opError - ошибка i-й записи или операции пакета, статус остаётся от исходной ошибки
+func opError(i int, err error) error {
+	status, msg := errorStatus(err)
+	if status == http.StatusInternalServerError {
+		return fmt.Errorf("operation %d: %w", i, err)
+	}
+	return ApiError{status, fmt.Errorf("operation %d: %s", i, msg)}
+}
+
+// PATCH /$table?filter - обновляет все записи, подходящие под фильтры списка (см. ListQuery).
+// Без фильтра не работает, чтобы случайно не переписать всю таблицу
+func (h *Handler) Patch(w http.ResponseWriter, r *http.Request) error {
+	name, base, _ := splitPath(r.URL.Path)
+	if base != "" {
+		return unknownMethod(tableMethods(r.URL.Path)...)
+	}
+	t, err := h.table(r, name, OpUpdate)
+	if err != nil {
+		return err
+	}
+	lq, err := ParseListQuery(t, r.URL.Query())
+	if err != nil {
+		return badRequest(err)
+	}
+	// условия политики на строки - не фильтр запроса
+	if len(lq.Filters) == len(t.filters) {
+		return badRequest(errors.New("filter is required"))
+	}
+	records, many, err := ReadRecords(r)
+	if err != nil {
+		return err
+	}
+	if many {
+		return badRequest(errors.New("expected one record"))
+	}
+	cols, params, err := records[0].Values(t, false)
+	if err != nil {
+		return err
+	}
+	var (
+		affected int64
+		changes  []Change
+	)
+	err = h.inTx(func(tx *sql.Tx) error {
+		// записи ищутся до UPDATE: после него фильтр может им уже не подходить
+		ids, err := h.matchingIDs(tx, t, lq)
+		if err != nil {
+			return err
+		}
+		befores, err := h.snapshots(tx, t, ids)
+		if err != nil {
+			return err
+		}
+		where, whereParams := lq.Where(h.Dialect, len(params)+1)
+		if affected, err = h.update(tx, t, cols, params, where, whereParams); err != nil {
+			return err
+		}
+		changes, err = h.changesOf(tx, t, OpUpdate, ids, befores)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	h.publish(changes...)
+	return sendResponse(w, map[string]interface{}{
+		"updated": affected,
+	})
+}
+
+// matchingIDs - первичные ключи записей под фильтром lq, только если есть лента изменений
+func (h *Handler) matchingIDs(q querier, t *Table, lq *ListQuery) ([]interface{}, error) {
+	pk := t.PK()
+	if h.Bus == nil || pk == nil {
+		return nil, nil
+	}
+	where, params := lq.Where(h.Dialect, 1)
+	rows, err := q.Query("SELECT "+h.Dialect.Quote(pk.Name)+" FROM "+h.Dialect.Quote(t.Name)+" WHERE "+where, params...)
+	if err != nil {
+		return nil, err
+	}
+	var ids []interface{}
+	err = scanRows(t, rows, func(columns []string, item map[string]interface{}) error {
+		ids = append(ids, item[pk.Name])
+		return nil
+	})
+	return ids, err
+}
+
+// POST /_batch - список операций в одной транзакции: или выполняются все, или ни одной.
+// В ответе результат каждой по порядку, ошибка - с номером операции, на которой всё откатилось
+func (h *Handler) Batch(w http.ResponseWriter, r *http.Request) error {
+	var ops []Operation
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&ops); err != nil {
+		return badRequest(errors.New("bad json: " + err.Error()))
+	}
+	if len(ops) == 0 {
+		return errEmptyRequest
+	}
+
+	results := make([]map[string]interface{}, 0, len(ops))
+	var changes []Change
+	err := h.inTx(func(tx *sql.Tx) error {
+		for i, op := range ops {
+			result, c, err := h.execOperation(tx, r, op)
+			if err != nil {
+				return opError(i, err)
+			}
+			results = append(results, result)
+			if c != nil {
+				changes = append(changes, *c)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	h.publish(changes...)
+	return sendResponse(w, map[string]interface{}{
+		"results": results,
+	})
+}
+
+// opRights - операция политики для операции пакета
+var opRights = map[string]string{
+	"create": OpCreate,
+	"update": OpUpdate,
+	"delete": OpDelete,
+}
+
+// execOperation выполняет операцию пакета, изменение для ленты - nil, если ничего не поменялось
+func (h *Handler) execOperation(tx *sql.Tx, r *http.Request, op Operation) (map[string]interface{}, *Change, error) {
+	right, ok := opRights[op.Op]
+	if !ok {
+		return nil, nil, badRequest(errors.New("unknown op " + op.Op))
+	}
+	t, err := h.table(r, op.Table, right)
+	if err != nil {
+		return nil, nil, err
+	}
+	rec := Record{Fields: op.Data}
+
+	if op.Op == "create" {
+		cols, params, err := rec.Values(t, true)
+		if err != nil {
+			return nil, nil, err
+		}
+		id, err := h.insert(tx, t, cols, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		c, err := h.change(tx, t, OpCreate, id, nil)
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{t.PrimaryKey: id}, &c, nil
+	}
+
+	pk := t.PK()
+	if pk == nil {
+		return nil, nil, badRequest(errors.New("table " + t.Name + " has no primary key"))
+	}
+	if op.ID == nil {
+		return nil, nil, badRequest(errors.New("id is required"))
+	}
+	id, err := pk.Convert(op.ID)
+	if err != nil {
+		return nil, nil, errRecordNotFound
+	}
+	before, err := h.snapshot(tx, t, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch op.Op {
+	case "update":
+		cols, params, err := rec.Values(t, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		where, whereParams := h.recordWhere(t, pk, id, len(params)+1)
+		affected, err := h.update(tx, t, cols, params, where, whereParams)
+		if err != nil || affected == 0 {
+			return map[string]interface{}{"updated": affected}, nil, err
+		}
+		c, err := h.change(tx, t, OpUpdate, id, before)
+		if err != nil {
+			return nil, nil, err
+		}
+		return map[string]interface{}{"updated": affected}, &c, nil
+	case "delete":
+		deleted, err := h.remove(tx, t, pk, id)
+		if err != nil || deleted == 0 {
+			return map[string]interface{}{"deleted": deleted}, nil, err
+		}
+		return map[string]interface{}{"deleted": deleted}, &Change{Table: t.Name, Op: OpDelete, PK: id, Before: before}, nil
+	}
+	return nil, nil, badRequest(errors.New("unknown op " + op.Op))
+}
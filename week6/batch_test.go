@@ -0,0 +1,222 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBulk(t *testing.T) {
+	db := testDB(t)
+	CleanupTestApis(db)
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Body: []CR{
+				CR{"title": "first", "description": "one"},
+				CR{"title": "second", "description": "two", "updated": "bulk"},
+			},
+			Result: CR{
+				"response": CR{
+					"id": []int{3, 4},
+				},
+			},
+		},
+		// одна плохая запись - не вставляется ни одна
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Status: http.StatusBadRequest,
+			Body: []CR{
+				CR{"title": "third", "description": "three"},
+				CR{"title": 42},
+			},
+			Result: CR{
+				"error": "operation 1: field title have invalid type",
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=id",
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"id": 1}, CR{"id": 2}, CR{"id": 3}, CR{"id": 4}},
+				},
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "id[gte]=3",
+			Method: http.MethodPatch,
+			Body:   CR{"updated": "patched"},
+			Result: CR{
+				"response": CR{
+					"updated": 2,
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=id,updated",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "updated": "rvasily"},
+						CR{"id": 2, "updated": nil},
+						CR{"id": 3, "updated": "patched"},
+						CR{"id": 4, "updated": "patched"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/items",
+			Method: http.MethodPatch,
+			Status: http.StatusBadRequest,
+			Body:   CR{"updated": "everything"},
+			Result: CR{
+				"error": "filter is required",
+			},
+		},
+		Case{
+			Path:   "/items/3",
+			Method: http.MethodPatch,
+			Status: http.StatusMethodNotAllowed,
+			Body:   CR{"updated": "one"},
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "id=1",
+			Method: http.MethodPatch,
+			Status: http.StatusBadRequest,
+			Body:   CR{"id": 5},
+			Result: CR{
+				"error": "field id have invalid type",
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+}
+
+func TestBatch(t *testing.T) {
+	db := testDB(t)
+	CleanupTestApis(db)
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	cases := []Case{
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Body: []CR{
+				CR{"op": "create", "table": "items", "data": CR{"title": "batch", "description": "created"}},
+				CR{"op": "update", "table": "items", "id": 3, "data": CR{"updated": "batch"}},
+				CR{"op": "update", "table": "users", "id": 1, "data": CR{"info": "batch"}},
+				CR{"op": "delete", "table": "items", "id": 2},
+			},
+			Result: CR{
+				"response": CR{
+					"results": []CR{
+						CR{"id": 3},
+						CR{"updated": 1},
+						CR{"updated": 1},
+						CR{"deleted": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path: "/items/3",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":          3,
+						"title":       "batch",
+						"description": "created",
+						"updated":     "batch",
+					},
+				},
+			},
+		},
+		// ошибка во второй операции откатывает и первую
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Status: http.StatusNotFound,
+			Body: []CR{
+				CR{"op": "delete", "table": "items", "id": 3},
+				CR{"op": "create", "table": "orders", "data": CR{"title": "nope"}},
+			},
+			Result: CR{
+				"error": "operation 1: unknown table",
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=id",
+			Result: CR{
+				"response": CR{
+					"records": []CR{CR{"id": 1}, CR{"id": 3}},
+				},
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: []CR{
+				CR{"op": "update", "table": "items", "id": 1, "data": CR{"title": nil}},
+			},
+			Result: CR{
+				"error": "operation 0: field title have invalid type",
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: []CR{
+				CR{"op": "upsert", "table": "items", "id": 1},
+			},
+			Result: CR{
+				"error": "operation 0: unknown op upsert",
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body:   []CR{},
+			Result: CR{
+				"error": "empty request",
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Status: http.StatusMethodNotAllowed,
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+}
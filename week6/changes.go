@@ -0,0 +1,340 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// changeLogSize - сколько последних изменений хранится, чтобы продолжить ленту с Last-Event-ID
+	changeLogSize = 1000
+	// changeBuffer - сколько изменений может ждать подписчика, дальше он отключается как отставший
+	changeBuffer = 64
+	// changesHeartbeat - как часто пустое сообщение напоминает прокси, что соединение живо
+	changesHeartbeat = 15 * time.Second
+	// snapshotChunk - сколько ключей в одном WHERE pk IN (...), когда записи перечитываются для ленты
+	snapshotChunk = 500
+)
+
+// Change - одно изменение записи: create, update или delete (см. OpCreate).
+// Before - запись до изменения, After - после, у create нет Before, у delete - After
+type Change struct {
+	ID     uint64                 `json:"id"`
+	Table  string                 `json:"table"`
+	Op     string                 `json:"op"`
+	PK     interface{}            `json:"pk"`
+	Before map[string]interface{} `json:"before,omitempty"`
+	After  map[string]interface{} `json:"after,omitempty"`
+	Time   time.Time              `json:"time"`
+}
+
+// ChangeBus - шина изменений внутри процесса: Publish раздаёт изменения подписчикам
+// и хранит последние size из них, чтобы переподключившийся клиент ничего не пропустил
+type ChangeBus struct {
+	mu     sync.Mutex
+	size   int
+	log    []Change
+	lastID uint64
+	// subs - канал подписчика: его таблица
+	subs map[chan Change]string
+}
+
+func NewChangeBus(size int) *ChangeBus {
+	return &ChangeBus{
+		size: size,
+		subs: make(map[chan Change]string),
+	}
+}
+
+// Publish нумерует изменения и раздаёт подписчикам их таблиц. Подписчика, который не успевает
+// читать, не ждёт: его канал закрывается, а клиент переподключится с Last-Event-ID
+func (b *ChangeBus) Publish(changes ...Change) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, c := range changes {
+		b.lastID++
+		c.ID = b.lastID
+		c.Time = time.Now()
+		if b.log = append(b.log, c); len(b.log) > b.size {
+			b.log = b.log[len(b.log)-b.size:]
+		}
+		for ch, table := range b.subs {
+			if table != c.Table {
+				continue
+			}
+			select {
+			case ch <- c:
+			default:
+				delete(b.subs, ch)
+				close(ch)
+			}
+		}
+	}
+}
+
+// Subscribe - изменения таблицы: backlog - сохранённые после lastID, дальше - в канале.
+// reset - часть изменений после lastID уже не хранится (или lastID из прошлого запуска), их не восстановить.
+// cancel отписывает, канал после этого закрыт
+func (b *ChangeBus) Subscribe(table string, lastID uint64) (backlog []Change, reset bool, ch <-chan Change, cancel func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if lastID > 0 {
+		reset = lastID > b.lastID || len(b.log) > 0 && b.log[0].ID > lastID+1
+		for _, c := range b.log {
+			if c.ID > lastID && c.Table == table {
+				backlog = append(backlog, c)
+			}
+		}
+	}
+	sub := make(chan Change, changeBuffer)
+	b.subs[sub] = table
+	return backlog, reset, sub, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		if _, ok := b.subs[sub]; ok {
+			delete(b.subs, sub)
+			close(sub)
+		}
+	}
+}
+
+// publish отдаёт изменения в шину, если она есть. Вызывать после commit
+func (h *Handler) publish(changes ...Change) {
+	if h.Bus != nil && len(changes) > 0 {
+		h.Bus.Publish(changes...)
+	}
+}
+
+// snapshot - запись целиком, как она лежит в базе, для ленты изменений. nil - записи нет или ленты нет
+func (h *Handler) snapshot(q querier, t *Table, id interface{}) (map[string]interface{}, error) {
+	base := h.Schema().Tables[t.Name]
+	if h.Bus == nil || base == nil || base.PK() == nil {
+		return nil, nil
+	}
+	rec, _, err := h.fetch(q, base, base.PK(), id)
+	if err == errRecordNotFound {
+		return nil, nil
+	}
+	return rec, err
+}
+
+// change - изменение записи id для ленты, запись после него читается из q (у delete её нет)
+func (h *Handler) change(q querier, t *Table, op string, id interface{}, before map[string]interface{}) (Change, error) {
+	c := Change{Table: t.Name, Op: op, PK: id, Before: before}
+	if op == OpDelete {
+		return c, nil
+	}
+	after, err := h.snapshot(q, t, id)
+	c.After = after
+	return c, err
+}
+
+// snapshots - записи ids целиком, как snapshot, но запросом WHERE pk IN (...) на каждые snapshotChunk ключей.
+// ключ - fmt.Sprint(id). nil - ленты нет
+func (h *Handler) snapshots(q querier, t *Table, ids []interface{}) (map[string]map[string]interface{}, error) {
+	base := h.Schema().Tables[t.Name]
+	if h.Bus == nil || base == nil || base.PK() == nil || len(ids) == 0 {
+		return nil, nil
+	}
+	pk := base.PK()
+	recs := make(map[string]map[string]interface{}, len(ids))
+	for start := 0; start < len(ids); start += snapshotChunk {
+		end := start + snapshotChunk
+		if end > len(ids) {
+			end = len(ids)
+		}
+		lq := &ListQuery{Filters: []Filter{{Column: pk, Op: "in", Values: ids[start:end]}}}
+		where, params := lq.Where(h.Dialect, 1)
+		rows, err := q.Query("SELECT * FROM "+h.Dialect.Quote(base.Name)+" WHERE "+where, params...)
+		if err != nil {
+			return nil, err
+		}
+		res, err := GetResult(base, rows)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range res {
+			recs[fmt.Sprint(rec[pk.Name])] = rec
+		}
+	}
+	return recs, nil
+}
+
+// changesOf - изменения записей ids для ленты, как change, записи после них читаются из q одним запросом.
+// befores - из snapshots до изменения, nil для create
+func (h *Handler) changesOf(q querier, t *Table, op string, ids []interface{}, befores map[string]map[string]interface{}) ([]Change, error) {
+	afters, err := h.snapshots(q, t, ids)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]Change, 0, len(ids))
+	for _, id := range ids {
+		key := fmt.Sprint(id)
+		changes = append(changes, Change{Table: t.Name, Op: op, PK: id, Before: befores[key], After: afters[key]})
+	}
+	return changes, nil
+}
+
+// upgrader без CheckOrigin пускает только страницы своего же хоста: иначе любой сайт
+// открыл бы ленту с куками и токенами посетителя. Другие сайты - через Handler.Origins
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// GET /$table/_changes - лента изменений таблицы: server-sent events, а с Upgrade: websocket - websocket.
+// Сначала приходит то, что было после Last-Event-ID (заголовок или ?last_event_id=), потом новые изменения.
+// Если пропущенное уже не хранится - сначала событие reset: таблицу надо перечитать.
+// Записи в изменениях - такие, какими их видит принципал запроса, чужие строки не приходят
+func (h *Handler) Changes(w http.ResponseWriter, r *http.Request, t *Table) error {
+	if h.Bus == nil {
+		return ApiError{http.StatusNotFound, errors.New("changes are disabled")}
+	}
+	last := r.Header.Get("Last-Event-ID")
+	if last == "" {
+		last = r.URL.Query().Get("last_event_id")
+	}
+	var lastID uint64
+	if last != "" {
+		var err error
+		if lastID, err = strconv.ParseUint(last, 10, 64); err != nil {
+			return badRequest(errors.New("bad last event id " + last))
+		}
+	}
+	if websocket.IsWebSocketUpgrade(r) {
+		return h.changesWebsocket(w, r, t, lastID)
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return errors.New("streaming is not supported")
+	}
+	backlog, reset, ch, cancel := h.Bus.Subscribe(t.Name, lastID)
+	defer cancel()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	return streamChanges(r.Context(), t, backlog, reset, ch, func(c *Change) error {
+		var err error
+		if c == nil {
+			_, err = fmt.Fprint(w, "event: reset\ndata: {}\n\n")
+		} else {
+			b, _ := json.Marshal(c)
+			_, err = fmt.Fprintf(w, "id: %d\nevent: change\ndata: %s\n\n", c.ID, b)
+		}
+		flusher.Flush()
+		return err
+	}, func() error {
+		_, err := fmt.Fprint(w, ": ping\n\n")
+		flusher.Flush()
+		return err
+	})
+}
+
+// changesWebsocket - та же лента через websocket: сообщения {"event": "change", "data": {...}} и {"event": "reset"}
+func (h *Handler) changesWebsocket(w http.ResponseWriter, r *http.Request, t *Table, lastID uint64) error {
+	var upgradeErr error
+	up := upgrader
+	if len(h.Origins) > 0 {
+		up.CheckOrigin = h.checkOrigin
+	}
+	up.Error = func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		upgradeErr = ApiError{status, reason}
+	}
+	ws, err := up.Upgrade(w, r, nil)
+	if err != nil {
+		if upgradeErr != nil {
+			return upgradeErr
+		}
+		return err
+	}
+	defer ws.Close()
+	backlog, reset, ch, cancel := h.Bus.Subscribe(t.Name, lastID)
+	defer cancel()
+
+	// клиент ничего не шлёт, но читать надо - так узнаём, что он закрыл соединение
+	ctx, stop := context.WithCancel(r.Context())
+	defer stop()
+	go func() {
+		defer stop()
+		for {
+			if _, _, err := ws.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	return streamChanges(ctx, t, backlog, reset, ch, func(c *Change) error {
+		msg := map[string]interface{}{"event": "reset"}
+		if c != nil {
+			msg = map[string]interface{}{"event": "change", "data": c}
+		}
+		return ws.WriteJSON(msg)
+	}, func() error {
+		return ws.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+	})
+}
+
+// checkOrigin - websocket со своего хоста или с одного из Origins
+func (h *Handler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, allowed := range h.Origins {
+		if strings.EqualFold(origin, allowed) {
+			return true
+		}
+	}
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, r.Host)
+}
+
+// streamChanges отдаёт в send ленту (nil - reset), пока клиент не уйдёт или шина не отключит его как отставшего
+func streamChanges(ctx context.Context, t *Table, backlog []Change, reset bool, ch <-chan Change, send func(*Change) error, ping func() error) error {
+	if reset {
+		if err := send(nil); err != nil {
+			return nil
+		}
+	}
+	for _, c := range backlog {
+		if c, ok := t.visible(c); ok {
+			if err := send(&c); err != nil {
+				return nil
+			}
+		}
+	}
+	ticker := time.NewTicker(changesHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case c, ok := <-ch:
+			if !ok {
+				return nil
+			}
+			if c, ok := t.visible(c); ok {
+				if err := send(&c); err != nil {
+					return nil
+				}
+			}
+		case <-ticker.C:
+			if err := ping(); err != nil {
+				return nil
+			}
+		}
+	}
+}
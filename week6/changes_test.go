@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestChangeBus(t *testing.T) {
+	bus := NewChangeBus(3)
+	_, _, items, cancelItems := bus.Subscribe("items", 0)
+	defer cancelItems()
+	_, _, users, cancelUsers := bus.Subscribe("users", 0)
+
+	bus.Publish(Change{Table: "items", Op: OpCreate, PK: 1}, Change{Table: "users", Op: OpUpdate, PK: 1})
+	if c := <-items; c.ID != 1 || c.PK != 1 || c.Time.IsZero() {
+		t.Errorf("bad items change: %+v", c)
+	}
+	if c := <-users; c.ID != 2 || c.Op != OpUpdate {
+		t.Errorf("bad users change: %+v", c)
+	}
+	cancelUsers()
+	if _, ok := <-users; ok {
+		t.Errorf("channel is open after cancel")
+	}
+
+	bus.Publish(Change{Table: "items", PK: 2}, Change{Table: "items", PK: 3})
+	backlog, reset, _, cancel := bus.Subscribe("items", 2)
+	cancel()
+	if reset || len(backlog) != 2 || backlog[0].ID != 3 || backlog[1].ID != 4 {
+		t.Errorf("resume after 2: reset %v, backlog %+v", reset, backlog)
+	}
+	// хранятся только 3 последних, первого уже нет
+	for lastID, expected := range map[uint64]bool{1: false, 4: false, 100: true} {
+		if _, reset, _, cancel := bus.Subscribe("items", lastID); reset != expected {
+			t.Errorf("resume after %d: expected reset %v", lastID, expected)
+			cancel()
+		} else {
+			cancel()
+		}
+	}
+
+	// отставший подписчик отключается, остальные не ждут
+	for i := 0; i < changeBuffer+1; i++ {
+		bus.Publish(Change{Table: "items"})
+	}
+	n := 0
+	for range items {
+		n++
+	}
+	if n != changeBuffer {
+		t.Errorf("expected %d changes before disconnect, got %d", changeBuffer, n)
+	}
+	// а в логе первых уже нет
+	if _, reset, _, cancel := bus.Subscribe("items", 4); !reset {
+		t.Errorf("resume after 4: expected reset")
+		cancel()
+	} else {
+		cancel()
+	}
+}
+
+func TestChangeVisible(t *testing.T) {
+	users := testUsersTable()
+	users.Columns = append(users.Columns, &Column{Name: "password", Kind: "string"}, &Column{Name: "email", Kind: "string"})
+	pr := &Principal{Name: "rvasily", Vars: map[string]interface{}{"user_id": float64(1)}, Tables: map[string]*TablePolicy{
+		"users": {Ops: []string{OpRead}, Hidden: []string{"password"}, Masked: []string{"email"}, Rows: map[string]interface{}{"user_id": "$user_id"}},
+	}}
+	view, err := pr.View(users, OpRead)
+	if err != nil {
+		t.Fatal(err)
+	}
+	own := map[string]interface{}{"user_id": int64(1), "login": "rvasily", "password": "love", "email": "rvasily@example.com"}
+	other := map[string]interface{}{"user_id": int64(2), "login": "ann", "password": "pass", "email": "ann@example.com"}
+
+	c, ok := view.visible(Change{Table: "users", Op: OpUpdate, PK: 1, Before: own, After: own})
+	expected := map[string]interface{}{"user_id": int64(1), "login": "rvasily", "email": "r*****y@example.com"}
+	if !ok || !reflect.DeepEqual(c.Before, expected) || !reflect.DeepEqual(c.After, expected) {
+		t.Errorf("own change: %v %+v", ok, c)
+	}
+	if _, ok := view.visible(Change{Table: "users", Op: OpDelete, PK: 2, Before: other}); ok {
+		t.Errorf("other user's change is visible")
+	}
+}
+
+// readEvents - события server-sent events из тела ответа по мере прихода
+func readEvents(body io.Reader) <-chan map[string]string {
+	events := make(chan map[string]string)
+	go func() {
+		defer close(events)
+		sc := bufio.NewScanner(body)
+		ev := map[string]string{}
+		for sc.Scan() {
+			line := sc.Text()
+			switch {
+			case line == "":
+				if len(ev) > 0 {
+					events <- ev
+				}
+				ev = map[string]string{}
+			case !strings.HasPrefix(line, ":"):
+				k, v, _ := strings.Cut(line, ": ")
+				ev[k] = v
+			}
+		}
+	}()
+	return events
+}
+
+func nextEvent(t *testing.T, events <-chan map[string]string) (map[string]string, Change) {
+	var c Change
+	select {
+	case ev, ok := <-events:
+		if !ok {
+			t.Fatal("stream closed")
+		}
+		if ev["event"] == "change" {
+			if err := json.Unmarshal([]byte(ev["data"]), &c); err != nil {
+				t.Fatalf("bad event data %q: %v", ev["data"], err)
+			}
+		}
+		return ev, c
+	case <-time.After(2 * time.Second):
+		t.Fatal("no event")
+	}
+	return nil, c
+}
+
+// subscribe - запрос к ленте без таймаута клиента, она не кончается
+func subscribe(t *testing.T, url string, lastID string) (*http.Response, <-chan map[string]string) {
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := (&http.Client{}).Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("expected event stream, got %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	return resp, readEvents(resp.Body)
+}
+
+func TestChanges(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	handler.Bus = NewChangeBus(changeLogSize)
+
+	resp, events := subscribe(t, ts.URL+"/items/_changes", "")
+	doRequest(t, http.MethodPut, ts.URL+"/items/", nil, CR{"title": "new", "description": "added"}, nil)
+	doRequest(t, http.MethodPost, ts.URL+"/items/1", nil, CR{"updated": "changed"}, nil)
+	doRequest(t, http.MethodDelete, ts.URL+"/items/2", nil, nil, nil)
+	// изменения других таблиц сюда не приходят
+	doRequest(t, http.MethodPost, ts.URL+"/users/1", nil, CR{"info": "changed"}, nil)
+	doRequest(t, http.MethodPost, ts.URL+"/_batch", nil, []CR{
+		CR{"op": "update", "table": "items", "id": 3, "data": CR{"title": "batch"}},
+		CR{"op": "update", "table": "items", "id": 100500, "data": CR{"title": "none"}},
+	}, nil)
+
+	ev, c := nextEvent(t, events)
+	if ev["id"] != "1" || c.Op != OpCreate || c.PK != float64(3) || c.Before != nil || c.After["title"] != "new" {
+		t.Errorf("bad create: %v", ev)
+	}
+	ev, c = nextEvent(t, events)
+	if ev["id"] != "2" || c.Op != OpUpdate || c.Before["updated"] != "rvasily" || c.After["updated"] != "changed" {
+		t.Errorf("bad update: %v", ev)
+	}
+	ev, c = nextEvent(t, events)
+	if ev["id"] != "3" || c.Op != OpDelete || c.Before["title"] != "memcache" || c.After != nil {
+		t.Errorf("bad delete: %v", ev)
+	}
+	ev, c = nextEvent(t, events)
+	if ev["id"] != "5" || c.Table != "items" || c.Before["title"] != "new" || c.After["title"] != "batch" {
+		t.Errorf("bad batch update: %v", ev)
+	}
+	resp.Body.Close()
+
+	// продолжение с Last-Event-ID - пропущенное из лога
+	resp, events = subscribe(t, ts.URL+"/items/_changes", "2")
+	if ev, _ := nextEvent(t, events); ev["id"] != "3" {
+		t.Errorf("resume: expected event 3, got %v", ev)
+	}
+	if ev, _ := nextEvent(t, events); ev["id"] != "5" {
+		t.Errorf("resume: expected event 5, got %v", ev)
+	}
+	resp.Body.Close()
+
+	resp, events = subscribe(t, ts.URL+"/items/_changes", "100500")
+	if ev, _ := nextEvent(t, events); ev["event"] != "reset" {
+		t.Errorf("unknown id: expected reset, got %v", ev)
+	}
+	resp.Body.Close()
+
+	// websocket - на том же адресе
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http")+"/items/_changes?last_event_id=4", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg struct {
+		Event string
+		Data  Change
+	}
+	if err := ws.ReadJSON(&msg); err != nil || msg.Event != "change" || msg.Data.ID != 5 {
+		t.Errorf("websocket backlog: %v %+v", err, msg)
+	}
+	doRequest(t, http.MethodPut, ts.URL+"/items/", nil, CR{"title": "live", "description": "ws"}, nil)
+	if err := ws.ReadJSON(&msg); err != nil || msg.Data.Op != OpCreate || msg.Data.After["title"] != "live" {
+		t.Errorf("websocket change: %v %+v", err, msg)
+	}
+
+	for path, status := range map[string]int{
+		"/orders/_changes":                  http.StatusNotFound,
+		"/items/_changes?last_event_id=abc": http.StatusBadRequest,
+		"/items/_changes/1":                 http.StatusNotFound,
+	} {
+		if resp, body := doRequest(t, http.MethodGet, ts.URL+path, nil, nil, nil); resp.StatusCode != status {
+			t.Errorf("[%s] expected %d, got %d %s", path, status, resp.StatusCode, body)
+		}
+	}
+
+	// без шины ленты нет
+	handler.Bus = nil
+	if resp, body := doRequest(t, http.MethodGet, ts.URL+"/items/_changes", nil, nil, nil); resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "changes are disabled") {
+		t.Errorf("disabled changes: expected 404, got %d %s", resp.StatusCode, body)
+	}
+}
+
+// PATCH по фильтру, PUT списком и импорт - тоже изменения записей, по одному на запись
+func TestChangesBulk(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	handler.Bus = NewChangeBus(changeLogSize)
+
+	resp, events := subscribe(t, ts.URL+"/items/_changes", "")
+	defer resp.Body.Close()
+	doRequest(t, http.MethodPatch, ts.URL+"/items?id[in]=1,2", nil, CR{"updated": "patched"}, nil)
+	for _, expected := range []float64{1, 2} {
+		ev, c := nextEvent(t, events)
+		if c.Op != OpUpdate || c.PK != expected || c.Before["updated"] == "patched" || c.After["updated"] != "patched" {
+			t.Errorf("bad patch of %v: %v", expected, ev)
+		}
+	}
+
+	doRequest(t, http.MethodPut, ts.URL+"/items/", nil, []CR{{"title": "p1", "description": "d1"}, {"title": "p2", "description": "d2"}}, nil)
+	for _, title := range []string{"p1", "p2"} {
+		ev, c := nextEvent(t, events)
+		if c.Op != OpCreate || c.Before != nil || c.After["title"] != title {
+			t.Errorf("bad put of %s: %v", title, ev)
+		}
+	}
+
+	// вторая пачка не проходит целиком и вставляется по одной: в ленте только вставленные
+	report := struct{ Response ImportReport }{}
+	imp, body := doRequest(t, http.MethodPost, ts.URL+"/items/_import", map[string]string{"Content-Type": "application/x-ndjson"}, strings.NewReader(`{"title": "n1", "description": "d1"}
+{"title": "n2", "description": "d2"}
+`), &report)
+	if imp.StatusCode != http.StatusOK || report.Response.Inserted != 2 {
+		t.Fatalf("import: got %d %s", imp.StatusCode, body)
+	}
+	report.Response = ImportReport{}
+	imp, body = doRequest(t, http.MethodPost, ts.URL+"/items/_import", map[string]string{"Content-Type": "application/x-ndjson"}, strings.NewReader(`{"title": "n3", "description": "d3"}
+{"title": null}
+`), &report)
+	if imp.StatusCode != http.StatusOK || report.Response.Inserted != 1 || report.Response.Failed != 1 {
+		t.Fatalf("import with errors: got %d %s", imp.StatusCode, body)
+	}
+	for _, title := range []string{"n1", "n2", "n3"} {
+		ev, c := nextEvent(t, events)
+		if c.Op != OpCreate || c.Before != nil || c.After["title"] != title {
+			t.Errorf("bad import of %s: %v", title, ev)
+		}
+	}
+}
+
+// чужой сайт не открывает ленту через websocket, если его нет в Origins
+func TestChangesOrigin(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	handler.Bus = NewChangeBus(changeLogSize)
+	url := "ws" + strings.TrimPrefix(ts.URL, "http") + "/items/_changes"
+
+	dial := func(origin string) int {
+		ws, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {origin}})
+		if err == nil {
+			ws.Close()
+			return http.StatusSwitchingProtocols
+		}
+		if resp == nil {
+			t.Fatalf("dial from %s: %v", origin, err)
+		}
+		return resp.StatusCode
+	}
+	if status := dial(ts.URL); status != http.StatusSwitchingProtocols {
+		t.Errorf("same origin: expected upgrade, got %d", status)
+	}
+	if status := dial("https://evil.example"); status != http.StatusForbidden {
+		t.Errorf("other origin: expected 403, got %d", status)
+	}
+	handler.Origins = []string{"https://app.example"}
+	if status := dial("https://app.example"); status != http.StatusSwitchingProtocols {
+		t.Errorf("allowed origin: expected upgrade, got %d", status)
+	}
+	if status := dial("https://evil.example"); status != http.StatusForbidden {
+		t.Errorf("other origin with allowlist: expected 403, got %d", status)
+	}
+}
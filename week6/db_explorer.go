@@ -1,43 +1,91 @@
 package main
 
 import (
+	"bufio"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"io"
+	"log/slog"
+	"net"
 	"net/http"
-	"path"
-	"reflect"
-	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 )
 
 type Handler struct {
-	DB     *sql.DB
-	Tables []string
+	DB      *sql.DB
+	Dialect Dialect
+	// Logger - лог запросов и ошибок базы, по умолчанию slog.Default()
+	Logger *slog.Logger
+	// Policy - права на таблицы, nil - можно всё и всем
+	Policy *Policy
+	// Bus - лента изменений для GET /$table/_changes, nil - без неё. По умолчанию выключена:
+	// с ней каждая запись перечитывается после изменения, а изменения - до
+	Bus *ChangeBus
+	// Origins - другие сайты (https://example.com), страницам которых можно открыть ленту через websocket
+	Origins []string
+
+	mu     sync.RWMutex
+	schema *Schema
+	// versions - таблица: колонка версии, см. SetVersion
+	versions map[string]string
 }
 
+// NewDbExplorer - диалект выбирается по драйверу базы
 func NewDbExplorer(db *sql.DB) (*Handler, error) {
-	tables := GetTables(db)
-	return &Handler{
-		DB:     db,
-		Tables: tables,
-	}, nil
+	dialect, err := DialectFor(db)
+	if err != nil {
+		return nil, err
+	}
+	return NewDbExplorerDialect(db, dialect)
+}
+
+// NewDbExplorerDialect - для драйверов, которые DialectFor не знает
+func NewDbExplorerDialect(db *sql.DB, dialect Dialect) (*Handler, error) {
+	h := &Handler{
+		DB:      db,
+		Dialect: dialect,
+		Logger:  slog.Default(),
+	}
+	if err := h.Refresh(); err != nil {
+		return nil, err
+	}
+	h.Logger.Info("schema loaded", "dialect", dialect.Name(), "tables", h.Schema().Names)
+	return h, nil
 }
 
-func GetTables(db *sql.DB) []string {
-	rows, _ := db.Query("SHOW TABLES")
-	items, _ := GetItems(rows)
-	fmt.Println("GetTables", items)
-	var result []string
-	for _, item := range items {
-		result = append(result, item[0].(string))
+// Refresh перечитывает схему, например после миграции. Запросы, которые уже начались,
+// дорабатывают со старой
+func (h *Handler) Refresh() error {
+	schema, err := LoadSchema(h.DB, h.Dialect)
+	if err != nil {
+		return err
 	}
-	return result
+	h.mu.Lock()
+	h.schema = schema
+	h.mu.Unlock()
+	return nil
+}
 
+// Schema - схема, с которой сейчас работает explorer
+func (h *Handler) Schema() *Schema {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.schema
+}
+
+// table - таблица из схемы такой, какой её для операции op видит принципал запроса
+func (h *Handler) table(r *http.Request, name, op string) (*Table, error) {
+	t := h.Schema().Tables[name]
+	if t == nil {
+		return nil, errUnknownTable
+	}
+	return principalFrom(r).View(t, op)
 }
 
 func sendError(w http.ResponseWriter, error string, code int) {
@@ -52,21 +100,192 @@ func sendError(w http.ResponseWriter, error string, code int) {
 	fmt.Fprintln(w, string(js))
 }
 
+func sendResponse(w http.ResponseWriter, result interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"response": result})
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+	return nil
+}
+
+// splitPath - /$table/$id/$relation на части, любая может быть пустой
+func splitPath(p string) (table, id, relation string) {
+	parts := strings.SplitN(strings.Trim(p, "/"), "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// tableMethods - методы, которые route принимает для пути таблицы, для заголовка Allow
+func tableMethods(p string) []string {
+	table, id, relation := splitPath(p)
+	switch {
+	case table == "" || id == "_changes":
+		return []string{http.MethodGet}
+	case id == "":
+		return []string{http.MethodGet, http.MethodPut, http.MethodPatch}
+	case relation == "":
+		return []string{http.MethodGet, http.MethodPut, http.MethodPost, http.MethodDelete}
+	}
+	return []string{http.MethodGet, http.MethodPut}
+}
+
+// statusWriter запоминает статус ответа для лога и то, начал ли ответ уходить клиенту
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	wrote  bool
+}
+
+func (sw *statusWriter) WriteHeader(status int) {
+	sw.status = status
+	sw.wrote = true
+	sw.ResponseWriter.WriteHeader(status)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	sw.wrote = true
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush - для выгрузки и ленты изменений, которые отдают ответ частями
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		sw.wrote = true
+		f.Flush()
+	}
+}
+
+// Hijack - для websocket
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("hijack is not supported")
+	}
+	sw.status = http.StatusSwitchingProtocols
+	sw.wrote = true
+	return hj.Hijack()
+}
+
+// ServeHTTP - любая ошибка обработчика превращается в {"error": ...} со статусом из errorStatus,
+// каждый запрос пишется в лог одной строкой
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+	var err error
+	if h.Policy != nil {
+		var pr *Principal
+		if pr, err = h.Policy.Authenticate(r); err == nil {
+			r = withPrincipal(r, pr)
+		}
+	}
+	if err == nil {
+		err = h.route(sw, r)
+	}
+	// ответ уже начал уходить (выгрузка оборвалась на середине) - статус не поменять, ошибка только в лог
+	aborted := err != nil && sw.wrote
+	if err != nil && !aborted {
+		status, msg := errorStatus(err)
+		var me methodError
+		if errors.As(err, &me) {
+			sw.Header().Set("Allow", strings.Join(me.allow, ", "))
+		}
+		sendError(sw, msg, status)
+	}
+
+	attrs := []any{
+		"method", r.Method,
+		"path", r.URL.Path,
+		"status", sw.status,
+		"duration", time.Since(start),
+	}
+	if pr := principalFrom(r); pr != nil {
+		attrs = append(attrs, "principal", pr.Name)
+	}
+	switch {
+	case aborted:
+		h.Logger.Error("response aborted", append(attrs, "error", err)...)
+	case sw.status >= http.StatusInternalServerError:
+		h.Logger.Error("request failed", append(attrs, "error", err)...)
+	case err != nil:
+		h.Logger.Info("request rejected", append(attrs, "error", err)...)
+	default:
+		h.Logger.Info("request", attrs...)
+	}
+}
+
+func (h *Handler) route(w http.ResponseWriter, r *http.Request) error {
+	switch r.URL.Path {
+	case "/_schema":
+		if r.Method != "GET" {
+			return unknownMethod(http.MethodGet)
+		}
+		return h.GetSchema(w, r)
+	case "/_schema/refresh":
+		if r.Method != "POST" {
+			return unknownMethod(http.MethodPost)
+		}
+		return h.RefreshSchema(w, r)
+	case "/_batch":
+		if r.Method != "POST" {
+			return unknownMethod(http.MethodPost)
+		}
+		return h.Batch(w, r)
+	}
+	if _, id, relation := splitPath(r.URL.Path); id == "_import" && relation == "" {
+		if r.Method != "POST" {
+			return unknownMethod(http.MethodPost)
+		}
+		return h.Import(w, r)
+	}
 
 	switch r.Method {
 	case "GET":
-		h.Get(w, r)
+		return h.Get(w, r)
 	case "PUT":
-		h.Put(w, r)
+		return h.Put(w, r)
 	case "POST":
-		h.Post(w, r)
+		return h.Post(w, r)
+	case "PATCH":
+		return h.Patch(w, r)
 	case "DELETE":
-		h.Delete(w, r)
-	default:
-		// sendError(w, "unknown method", http.StatusNotFound)
-		fmt.Println("unkown method")
+		return h.Delete(w, r)
+	}
+	return unknownMethod(tableMethods(r.URL.Path)...)
+}
+
+// GET /_schema - таблицы и колонки, как их видит explorer, а с политикой - принципал запроса
+func (h *Handler) GetSchema(w http.ResponseWriter, r *http.Request) error {
+	schema := h.Schema()
+	tables := make(map[string]*Table)
+	for _, name := range principalFrom(r).Readable(schema) {
+		t, err := h.table(r, name, OpRead)
+		if err != nil {
+			return err
+		}
+		tables[name] = t
 	}
+	return sendResponse(w, map[string]interface{}{
+		"tables": tables,
+	})
+}
+
+// POST /_schema/refresh - перечитать схему после изменения таблиц.
+// С политикой - только тем, у кого есть права на все таблицы ("*")
+func (h *Handler) RefreshSchema(w http.ResponseWriter, r *http.Request) error {
+	if pr := principalFrom(r); pr != nil && pr.Tables["*"] == nil {
+		return errForbidden
+	}
+	if err := h.Refresh(); err != nil {
+		return err
+	}
+	return sendResponse(w, map[string]interface{}{
+		"tables": principalFrom(r).Readable(h.Schema()),
+	})
 }
 
 func contains(s []string, str string) bool {
@@ -79,415 +298,549 @@ func contains(s []string, str string) bool {
 	return false
 }
 
-//get table items from row
-func GetItems(rows *sql.Rows) ([][]interface{}, error) {
-
-	items := make([][]interface{}, 0)
+// GetResult - записи из rows, значения приводятся по типам колонок таблицы.
+// Колонок, которых в таблице нет (скрытых политикой), в записях тоже нет
+func GetResult(t *Table, rows *sql.Rows) ([]map[string]interface{}, error) {
+	set := make([]map[string]interface{}, 0)
+	err := scanRows(t, rows, func(columns []string, item map[string]interface{}) error {
+		set = append(set, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return set, nil
+}
 
+// scanRows - то же, что GetResult, но записи по одной уходят в fn, а не копятся в памяти.
+// columns - колонки записей в порядке запроса. rows закрывается
+func scanRows(t *Table, rows *sql.Rows, fn func(columns []string, item map[string]interface{}) error) error {
 	defer rows.Close()
-	types, _ := rows.ColumnTypes()
+	names, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	var columns []string
+	for _, name := range names {
+		if t.Column(name) != nil {
+			columns = append(columns, name)
+		}
+	}
+	row := make([]interface{}, len(names))
 	for rows.Next() {
-		row := make([]interface{}, len(types))
 		for i := range row {
 			row[i] = &row[i]
 		}
-		err := rows.Scan(row...)
-		if err != nil {
-			return items, err
+		if err := rows.Scan(row...); err != nil {
+			return err
 		}
-
-		for i := range row {
-			if row[i] != nil {
-				switch row[i].(type) {
-				case []byte:
-					row[i] = string(row[i].([]byte))
-				case int64:
-					row[i] = fmt.Sprint(row[i].(int64))
-				default:
-					fmt.Println("unknown type")
-				}
-
+		item := make(map[string]interface{}, len(columns))
+		for i, name := range names {
+			c := t.Column(name)
+			switch {
+			case c == nil:
+				continue
+			case c.Masked:
+				item[name] = mask(c.Decode(row[i]))
+			default:
+				item[name] = c.Decode(row[i])
 			}
 		}
-		items = append(items, row)
+		if err := fn(columns, item); err != nil {
+			return err
+		}
 	}
-	return items, nil
+	return rows.Err()
 }
 
-func GetId(h *Handler, table string) (string, error) {
-	rows, err := h.DB.Query("SHOW KEYS FROM " + table + " WHERE Key_name = 'PRIMARY'")
+// GET / - возвращает список все таблиц (которые мы можем использовать в дальнейших запросах)
+// GET /$table?limit=5&offset=7 - возвращает список из 5 записей (limit) начиная с 7-й (offset) из таблицы $table. limit по-умолчанию 5, offset 0.
+// Фильтры, сортировка и выбор колонок - см. ListQuery
+// GET /$table/$id - возвращает информацию о самой записи или 404, ?include= - со связанными
+// GET /$table/$id/$relation - связанные записи, см. GetRelated
+// GET /$table/_changes - лента изменений, см. Changes
+func (h *Handler) Get(w http.ResponseWriter, r *http.Request) error {
+	name, base, relation := splitPath(r.URL.Path)
+	if name == "" {
+		return sendResponse(w, map[string]interface{}{
+			"tables": principalFrom(r).Readable(h.Schema()),
+		})
+	}
+	t, err := h.table(r, name, OpRead)
 	if err != nil {
-		return "", err
+		return err
 	}
-	items, _ := GetItems(rows)
-	// fmt.Println(items[0][4].(string))
-	return items[0][4].(string), nil
-}
 
-func GetTableSize(h *Handler, table string) (int, error) {
-	rows, err := h.DB.Query("SELECT COUNT(1) FROM " + table)
-	if err != nil {
-		return 0, err
+	if base == "" {
+		lq, err := ParseListQuery(t, r.URL.Query())
+		if err != nil {
+			return badRequest(err)
+		}
+		format, err := exportFormat(r)
+		if err != nil {
+			return badRequest(err)
+		}
+		if format != "" {
+			return h.Export(w, r, t, lq, format)
+		}
+		return h.list(w, r, t, lq)
+	}
+	if base == "_changes" && relation == "" {
+		return h.Changes(w, r, t)
+	}
+	if relation != "" {
+		return h.GetRelated(w, r, t, base, relation)
 	}
-	items, _ := GetItems(rows)
-	result, _ := strconv.Atoi(items[0][0].(string))
-	return result, nil
-}
 
-func GetResult(rows *sql.Rows) []map[string]interface{} {
-	columns, _ := rows.Columns()
-	colTypes, _ := rows.ColumnTypes()
-	items, _ := GetItems(rows)
-	fmt.Println("GET RESULT", items)
-	fmt.Println("GET RESULT", columns)
-	var set []map[string]interface{}
-	for i := range items {
-		item := make(map[string]interface{}, len(columns))
-		for j := range columns {
-			// fmt.Println(colTypes[j].DatabaseTypeName())
-			item[columns[j]] = items[i][j]
-			if colTypes[j].DatabaseTypeName()[:3] == "INT" {
-				item[columns[j]], _ = strconv.Atoi(items[i][j].(string))
-			}
+	include, err := parseInclude(t, r.URL.Query())
+	if err != nil {
+		return badRequest(err)
+	}
+	pk, id, err := recordID(t, base)
+	if err != nil {
+		return err
+	}
+	rec, etag, err := h.fetch(h.DB, t, pk, id)
+	if err != nil {
+		return err
+	}
+	if len(include) > 0 {
+		// со связанными ответ - уже не одна запись, ETag - по всему ответу
+		if err := h.include(r, t, []map[string]interface{}{rec}, include); err != nil {
+			return err
 		}
-		set = append(set, item)
+		etag = ""
 	}
-	fmt.Println("SET", set)
-	return set
+	return sendTagged(w, r, etag, map[string]interface{}{
+		"record": rec,
+	})
 }
 
-// GET / - возвращает список все таблиц (которые мы можем использовать в дальнейших запросах)
-// GET /$table?limit=5&offset=7 - возвращает список из 5 записей (limit) начиная с 7-й (offset) из таблицы $table. limit по-умолчанию 5, offset 0
-// GET /$table/$id - возвращает информацию о самой записи или 404
-func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
-	q := r.URL.Query()
-
-	var rows *sql.Rows
-	result := make(map[string]interface{}, 1)
-	dir, base := path.Split(r.URL.Path)
-	fmt.Println(r.URL)
-	if dir == "/" {
-		if base != "" {
-			if !contains(h.Tables, base) {
-				sendError(w, "unknown table", http.StatusNotFound)
-				return
-			}
-			table := r.URL.Path[1:]
-			limit := q.Get("limit")
-			offset := q.Get("offset")
-
-			if q.Get("limit") == "" {
-				limit = "5"
-			}
-			if q.Get("offset") == "" {
-				offset = "0"
-			}
-
-			lim, err := strconv.Atoi(limit)
-			if err != nil {
-				fmt.Println("limit is not a number: ", limit, err)
-				lim = 5
-			}
-			off, err := strconv.Atoi(offset)
-			if err != nil {
-				fmt.Println("offset is not a number: ", offset, err)
-				off = 0
-			}
+// list - страница записей по разобранному запросу, со связанными
+func (h *Handler) list(w http.ResponseWriter, r *http.Request, t *Table, lq *ListQuery) error {
+	if lq.Limit > maxLimit {
+		lq.Limit = maxLimit
+	}
+	query, params := lq.SQL(h.Dialect, t)
+	rows, err := h.DB.Query(query, params...)
+	if err != nil {
+		return err
+	}
+	records, err := GetResult(t, rows)
+	if err != nil {
+		return err
+	}
+	if err := h.include(r, t, records, lq.Include); err != nil {
+		return err
+	}
+	return sendTagged(w, r, "", map[string]interface{}{
+		"records": records,
+	})
+}
 
-			fmt.Println("HEREEEE", table, limit, offset)
+// recordID - первичный ключ таблицы и id записи из адреса, приведённый к его типу.
+// id не того типа - такой записи точно нет
+func recordID(t *Table, base string) (*Column, interface{}, error) {
+	pk := t.PK()
+	if pk == nil {
+		return nil, nil, badRequest(errors.New("table " + t.Name + " has no primary key"))
+	}
+	id, err := pk.ConvertString(base)
+	if err != nil {
+		return nil, nil, errRecordNotFound
+	}
+	return pk, id, nil
+}
 
-			size, err := GetTableSize(h, table)
-			if err != nil {
-				fmt.Println("GetTableSize err: ", err)
-			}
-			if lim+off > size {
-				lim = size
-				off = 0
-			}
+// querier - то общее, что есть у *sql.DB и *sql.Tx: одни и те же запросы идут и сами по себе, и в /_batch
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
 
-			rows, err = h.DB.Query("SELECT * FROM "+table+" LIMIT ? OFFSET ?", lim, off)
-			if err != nil {
-				fmt.Println("limit/offset error", err.Error())
-				return
-			}
-			result["records"] = GetResult(rows)
+// Record - поля одной записи из тела запроса. Form - значения пришли из формы, там всё строки
+type Record struct {
+	Fields map[string]interface{}
+	Form   bool
+}
 
-		} else {
-			result["tables"] = h.Tables
-		}
-	} else {
-		table := "`" + dir[1:len(dir)-1] + "`"
-		id, err := strconv.Atoi(base)
-		if err != nil {
-			fmt.Println("GET WRONG ID: ", err)
-			return
-		}
-		table_id, err := GetId(h, table)
-		if err != nil {
-			fmt.Println("GetId rows.Scan error: ", err)
-			return
-		}
-		rows, err = h.DB.Query("SELECT * FROM "+table+" WHERE "+table_id+" = ?", id)
-		if err != nil {
-			fmt.Println("id error", err.Error())
-			return
+// ReadRecords - записи из тела запроса: json-объект, json-массив объектов или форма.
+// many - пришёл массив
+func ReadRecords(r *http.Request) (records []Record, many bool, err error) {
+	if r.Header.Get("Content-Type") != "application/json" {
+		if err := r.ParseForm(); err != nil {
+			return nil, false, badRequest(err)
 		}
-		res := GetResult(rows)
-		if len(res) == 0 {
-			sendError(w, "record not found", http.StatusNotFound)
-			return
+		rec := Record{Fields: make(map[string]interface{}), Form: true}
+		for name := range r.Form {
+			rec.Fields[name] = r.Form.Get(name)
 		}
-		result["record"] = res[0]
+		return []Record{rec}, false, nil
 	}
 
-	response := map[string]interface{}{
-		"response": result,
+	var body interface{}
+	dec := json.NewDecoder(r.Body)
+	// числа остаются json.Number, иначе большие id и decimal теряют точность
+	dec.UseNumber()
+	err = dec.Decode(&body)
+	switch body := body.(type) {
+	case nil:
+		if err != nil && err != io.EOF {
+			return nil, false, badRequest(errors.New("bad json: " + err.Error()))
+		}
+		return []Record{{Fields: map[string]interface{}{}}}, false, nil
+	case map[string]interface{}:
+		return []Record{{Fields: body}}, false, nil
+	case []interface{}:
+		for i, item := range body {
+			fields, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, true, badRequest(fmt.Errorf("record %d is not an object", i))
+			}
+			records = append(records, Record{Fields: fields})
+		}
+		return records, true, nil
 	}
-	b, _ := json.Marshal(response)
-	fmt.Fprintln(w, string(b))
+	return nil, false, badRequest(errors.New("bad json: expected object or array"))
 }
 
-func GetCols(h *Handler, table string, r *http.Request) ([]string, []interface{}, []string, error) {
+// Values - колонки и значения для INSERT (insert) или UPDATE, проверенные по схеме.
+// Неизвестные поля пропускаются, при вставке NOT NULL колонки без default получают нулевое значение.
+// Колонки из условий политики на строки при вставке заполняются сами, а поменять их нельзя - 403.
+// Остальные ошибки - ошибки запроса, 400
+func (rec Record) Values(t *Table, insert bool) ([]string, []interface{}, error) {
 	var cols []string
 	var params []interface{}
-	var placeholders []string
-	rows, err := h.DB.Query("SHOW FULL COLUMNS FROM " + table)
-	if err != nil {
-		return cols, params, placeholders, err
+	if len(rec.Fields) == 0 {
+		return cols, params, errEmptyRequest
 	}
-	items, err := GetItems(rows)
-	if err != nil {
-		fmt.Println("GetItems error: ", err)
-		return cols, params, placeholders, err
+	scope := make(map[string]interface{}, len(t.filters))
+	for _, f := range t.filters {
+		scope[f.Column.Name] = f.Values[0]
 	}
 
-	if r.Header.Get("Content-Type") == "application/json" {
-		reqBody, _ := ioutil.ReadAll(r.Body)
-		got := make(map[string]interface{}, 1)
-		fmt.Println("GOT ", got)
-		err = json.Unmarshal(reqBody, &got)
-		fmt.Println("got ", got)
-		if err != nil {
-			return cols, params, placeholders, err
-		}
-		if len(got) == 0 {
-			return cols, params, placeholders, errors.New("empty request")
-		}
-		for _, str := range items {
-			if got[str[0].(string)] != nil {
-				t := reflect.TypeOf(got[str[0].(string)]).String()
-				fmt.Println("GOT ", got[str[0].(string)])
-				fmt.Println("REFLECT ", reflect.TypeOf(got[str[0].(string)]).String())
-				b1 := t == "float64" && str[1].(string)[:3] == "int"
-				b2 := t == "string" && (str[1].(string) == "text" || str[1].(string) == "varchar(255)")
-				fmt.Println(str[1].(string))
-				fmt.Println(b1, b2)
-				if !(b1 || b2) {
-					return cols, params, placeholders, errors.New("field " + str[0].(string) + " have invalid type")
-				}
-			} else {
-				_, ok := got[str[0].(string)]
-				if str[3].(string) == "NO" && ok {
-					return cols, params, placeholders, errors.New("field " + str[0].(string) + " have invalid type")
+	// скрытые колонки тоже нужны: NOT NULL без default при вставке надо чем-то заполнить
+	all := append(t.Columns[:len(t.Columns):len(t.Columns)], t.hidden...)
+	for _, c := range all {
+		v, ok := rec.Fields[c.Name]
+		if value, scoped := scope[c.Name]; scoped && (insert || ok) {
+			if ok {
+				val, err := c.Convert(v)
+				if err != nil || fmt.Sprint(val) != fmt.Sprint(value) {
+					return cols, params, errForbidden
 				}
 			}
-
-			if str[4].(string) == "PRI" {
-				if r.Method == "POST" && got[str[0].(string)] != nil {
-					return cols, params, placeholders, errors.New("field " + str[0].(string) + " have invalid type")
-				}
-				continue
+			if insert {
+				cols = append(cols, c.Name)
+				params = append(params, value)
 			}
-
-			if str[3].(string) == "NO" {
-				fmt.Println(str[0].(string), got[str[0].(string)], str[3].(string))
-				if val, ok := got[str[0].(string)]; val == nil {
-					if !ok && str[5] == nil && r.Method == "PUT" {
-						cols = append(cols, "`"+str[0].(string)+"`")
-						placeholders = append(placeholders, "?")
-						if str[1].(string)[:3] == "int" {
-							params = append(params, 0)
-						}
-						if str[1].(string) == "text" || str[1].(string) == "varchar(255)" {
-							params = append(params, "")
-						}
-					}
-					continue
-				}
-				cols = append(cols, "`"+str[0].(string)+"`")
-				if got[str[0].(string)] == "" {
-					placeholders = append(placeholders, "''")
-				} else {
-					placeholders = append(placeholders, "?")
-					params = append(params, got[str[0].(string)])
-				}
-			} else {
-				if got[str[0].(string)] == nil {
-					params = append(params, sql.NullString{})
-				} else {
-					params = append(params, got[str[0].(string)])
-				}
-				cols = append(cols, "`"+str[0].(string)+"`")
-				placeholders = append(placeholders, "?")
+			continue
+		}
+		if !ok || t.Column(c.Name) == nil {
+			if insert && !c.Nullable && c.Default == nil && !c.AutoIncrement {
+				cols = append(cols, c.Name)
+				params = append(params, c.Zero())
 			}
+			continue
 		}
-	} else {
-		r.ParseForm()
-		for _, str := range items {
-			if str[4].(string) == "PRI" {
-				continue
+		var val interface{}
+		var err error
+		if s, isString := v.(string); rec.Form && isString {
+			val, err = c.ConvertString(s)
+		} else {
+			val, err = c.Convert(v)
+		}
+		if err != nil {
+			return cols, params, badRequest(err)
+		}
+		if c.PrimaryKey {
+			// primary key нельзя обновлять у существующей записи
+			if !insert {
+				return cols, params, badRequest(c.errInvalidType())
 			}
-			if str[3].(string) == "NO" {
-				fmt.Println(str[0].(string), r.FormValue(str[0].(string)), str[3].(string))
-				cols = append(cols, "`"+str[0].(string)+"`")
-				if r.FormValue(str[0].(string)) == "" {
-					placeholders = append(placeholders, "''")
-				} else {
-					placeholders = append(placeholders, "?")
-					params = append(params, "'"+r.FormValue(str[0].(string))+"'")
-				}
+			// auto increment primary key игнорируется при вставке
+			if c.AutoIncrement {
+				continue
 			}
 		}
+		cols = append(cols, c.Name)
+		params = append(params, val)
+	}
+	if !insert && len(cols) == 0 {
+		return cols, params, errEmptyRequest
 	}
-	return cols, params, placeholders, nil
+	return cols, params, nil
 }
 
-// PUT /$table - создаёт новую запись, данный по записи в теле запроса (POST-параметры)
-func (h *Handler) Put(w http.ResponseWriter, r *http.Request) {
-	// в целям упрощения примера пропущена валидация
-	table := r.URL.Path[1 : len(r.URL.Path)-1]
-	// fmt.Println(table)
-	cols, params, placeholders, err := GetCols(h, table, r)
+// insert - одна запись, возвращает её id
+func (h *Handler) insert(q querier, t *Table, cols []string, params []interface{}) (interface{}, error) {
+	pk := t.PK()
+	if pk == nil {
+		return nil, badRequest(errors.New("table " + t.Name + " has no primary key"))
+	}
+	d := h.Dialect
+	cols, params, _ = h.withVersion(t, cols, params, true)
+	// ключ не из автоинкремента пришёл в запросе, его и возвращаем
+	var given interface{}
+	quoted := make([]string, len(cols))
+	placeholders := make([]string, len(cols))
+	for i := range cols {
+		if cols[i] == pk.Name {
+			given = params[i]
+		}
+		quoted[i] = d.Quote(cols[i])
+		placeholders[i] = d.Placeholder(i + 1)
+	}
+	req := "INSERT INTO " + d.Quote(t.Name) + " (" + strings.Join(quoted, ",") + ") VALUES (" + strings.Join(placeholders, ",") + ")"
+
+	var (
+		lastID interface{}
+		err    error
+	)
+	switch returning := d.Returning(pk.Name); {
+	case given != nil:
+		_, err = q.Exec(req, params...)
+		lastID = given
+	case returning != "":
+		var id int64
+		err = q.QueryRow(req+returning, params...).Scan(&id)
+		lastID = id
+	default:
+		var result sql.Result
+		result, err = q.Exec(req, params...)
+		if err == nil {
+			lastID, err = result.LastInsertId()
+		}
+	}
 	if err != nil {
-		fmt.Println("GetCols error: ", err)
-		return
+		return nil, d.Constraint(err)
+	}
+	return lastID, nil
+}
+
+// update - UPDATE записей по условию where, возвращает число изменённых.
+// Версия таблицы, если она есть, увеличивается на 1
+func (h *Handler) update(q querier, t *Table, cols []string, params []interface{}, where string, whereParams []interface{}) (int64, error) {
+	d := h.Dialect
+	cols, params, version := h.withVersion(t, cols, params, false)
+	if len(cols) == 0 {
+		return 0, errEmptyRequest
 	}
+	set := make([]string, len(cols))
+	for i, col := range cols {
+		set[i] = d.Quote(col) + " = " + d.Placeholder(i+1)
+	}
+	if version != nil {
+		set = append(set, d.Quote(version.Name)+" = "+d.Quote(version.Name)+" + 1")
+	}
+	query := "UPDATE " + d.Quote(t.Name) + " SET " + strings.Join(set, ",") + " WHERE " + where
+	result, err := q.Exec(query, append(params, whereParams...)...)
 	if err != nil {
-		fmt.Println("show full columns error : ", err)
-		return
+		return 0, d.Constraint(err)
+	}
+	return result.RowsAffected()
+}
+
+// recordWhere - условие на одну запись: первичный ключ, условия политики на строки и extra.
+// Нумерация плейсхолдеров начинается с first
+func (h *Handler) recordWhere(t *Table, pk *Column, id interface{}, first int, extra ...Filter) (string, []interface{}) {
+	filters := append([]Filter{{Column: pk, Op: "eq", Values: []interface{}{id}}}, t.filters...)
+	lq := &ListQuery{
+		Filters: append(filters, extra...),
 	}
-	req := "INSERT INTO " + table + " (" + strings.Join(cols, ",") + ") VALUES (" + strings.Join(placeholders, ",") + ")"
-	result, err := h.DB.Exec(req, params...)
+	return lq.Where(h.Dialect, first)
+}
+
+// remove - DELETE одной записи, возвращает число удалённых
+func (h *Handler) remove(q querier, t *Table, pk *Column, id interface{}, extra ...Filter) (int64, error) {
+	where, params := h.recordWhere(t, pk, id, 1, extra...)
+	result, err := q.Exec("DELETE FROM "+h.Dialect.Quote(t.Name)+" WHERE "+where, params...)
 	if err != nil {
-		fmt.Println(req)
-		fmt.Println("PUT ERROR :", err)
-		return
+		return 0, h.Dialect.Constraint(err)
 	}
+	return result.RowsAffected()
+}
 
-	affected, err := result.RowsAffected()
+// PUT /$table - создаёт новую запись, данный по записи в теле запроса (POST-параметры).
+// Массив записей вставляется в одной транзакции, в ответе - id всех по порядку
+func (h *Handler) Put(w http.ResponseWriter, r *http.Request) error {
+	name, _, _ := splitPath(r.URL.Path)
+	t, err := h.table(r, name, OpCreate)
 	if err != nil {
-		fmt.Println("Rows affected err: ", affected)
-		return
+		return err
+	}
+	pk := t.PK()
+	if pk == nil {
+		return badRequest(errors.New("table " + t.Name + " has no primary key"))
 	}
-	lastID, err := result.LastInsertId()
+	records, many, err := ReadRecords(r)
 	if err != nil {
-		fmt.Println("Last id err: ", err)
-		return
+		return err
 	}
-	id, _ := GetId(h, table)
 
-	fmt.Println("Insert - RowsAffected", affected, "LastInsertId: ", lastID)
-	response := map[string]interface{}{
-		"response": map[string]interface{}{
-			id: lastID,
-		},
+	if !many {
+		cols, params, err := records[0].Values(t, true)
+		if err != nil {
+			return err
+		}
+		var c Change
+		err = h.inTx(func(tx *sql.Tx) error {
+			id, err := h.insert(tx, t, cols, params)
+			if err != nil {
+				return err
+			}
+			c, err = h.change(tx, t, OpCreate, id, nil)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		h.publish(c)
+		return sendResponse(w, map[string]interface{}{
+			pk.Name: c.PK,
+		})
 	}
 
-	b, _ := json.Marshal(response)
-	fmt.Fprintln(w, string(b))
+	if len(records) == 0 {
+		return errEmptyRequest
+	}
+	ids := make([]interface{}, 0, len(records))
+	var changes []Change
+	err = h.inTx(func(tx *sql.Tx) error {
+		for i, rec := range records {
+			cols, params, err := rec.Values(t, true)
+			if err == nil {
+				var id interface{}
+				id, err = h.insert(tx, t, cols, params)
+				ids = append(ids, id)
+			}
+			if err != nil {
+				return opError(i, err)
+			}
+		}
+		var err error
+		changes, err = h.changesOf(tx, t, OpCreate, ids, nil)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	h.publish(changes...)
+	return sendResponse(w, map[string]interface{}{
+		pk.Name: ids,
+	})
 }
 
 // POST /$table/$id - обновляет запись, данные приходят в теле запроса (POST-параметры)
-func (h *Handler) Post(w http.ResponseWriter, r *http.Request) {
-	dir, base := path.Split(r.URL.Path)
-	table := "`" + dir[1:len(dir)-1] + "`"
-
-	fmt.Println("Here POST ", r.URL.Path)
-	table_id, err := GetId(h, table)
+func (h *Handler) Post(w http.ResponseWriter, r *http.Request) error {
+	name, base, relation := splitPath(r.URL.Path)
+	if relation != "" {
+		return unknownMethod(tableMethods(r.URL.Path)...)
+	}
+	t, err := h.table(r, name, OpUpdate)
 	if err != nil {
-		fmt.Println("GetId rows.Scan error: ", err)
-		return
+		return err
 	}
-	id, err := strconv.Atoi(base)
+	pk, id, err := recordID(t, base)
 	if err != nil {
-		fmt.Println("POST WRONG ID: ", err)
-		return
+		return err
 	}
-
-	cols, params, placeholders, err := GetCols(h, table, r)
+	records, many, err := ReadRecords(r)
 	if err != nil {
-		fmt.Println("GetCols error: ", err)
-		sendError(w, err.Error(), http.StatusBadRequest)
-		return
+		return err
 	}
-	fmt.Println(cols, params, placeholders)
-
-	req := ""
-	for i, col := range cols {
-		req += col + " = " + placeholders[i] + ","
+	if many {
+		return badRequest(errors.New("expected one record"))
 	}
-	params = append(params, id)
-	fmt.Println("UPDATE " + table + " SET " + req[:len(req)-1] + " WHERE " + table_id + " = ?")
-	result, err := h.DB.Exec("UPDATE "+table+" SET "+req[:len(req)-1]+" WHERE "+table_id+" = ?", params...)
+	cols, params, err := records[0].Values(t, false)
 	if err != nil {
-		fmt.Println(cols)
-		fmt.Println(params)
-		fmt.Println("UPDATE " + table + " SET " + req[:len(req)-1] + " WHERE " + table_id + " = ?")
-		fmt.Println("Post exec error: ", err)
-		return
+		return err
 	}
-	affected, err := result.RowsAffected()
+	var (
+		affected int64
+		etag     string
+		c        Change
+	)
+	err = h.inTx(func(tx *sql.Tx) error {
+		extra, err := h.ifMatch(tx, r, t, pk, id)
+		if err != nil {
+			return err
+		}
+		before, err := h.snapshot(tx, t, id)
+		if err != nil {
+			return err
+		}
+		where, whereParams := h.recordWhere(t, pk, id, len(params)+1, extra...)
+		if affected, err = h.update(tx, t, cols, params, where, whereParams); err != nil {
+			return err
+		}
+		if affected == 0 {
+			if extra != nil {
+				// версию поменяли между проверкой и UPDATE
+				return errPreconditionFailed
+			}
+			return nil
+		}
+		if c, err = h.change(tx, t, OpUpdate, id, before); err != nil {
+			return err
+		}
+		// новый ETag - чтобы следующее изменение можно было сделать с If-Match без GET
+		_, etag, err = h.fetch(tx, t, pk, id)
+		return err
+	})
 	if err != nil {
-		fmt.Println("Affected error: ", err)
-		return
+		return err
 	}
-	fmt.Println("Post -- RowsAffected", affected)
-	response := map[string]interface{}{
-		"response": map[string]interface{}{
-			"updated": affected,
-		},
+	if affected > 0 {
+		h.publish(c)
 	}
-
-	b, _ := json.Marshal(response)
-	fmt.Fprintln(w, string(b))
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	return sendResponse(w, map[string]interface{}{
+		"updated": affected,
+	})
 }
 
 // DELETE /$table/$id - удаляет запись
-func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
-	dir, base := path.Split(r.URL.Path)
-	table := "`" + dir[1:len(dir)-1] + "`"
-
-	table_id, err := GetId(h, table)
-	if err != nil {
-		fmt.Println("GetId error: ", err)
-		return
+func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) error {
+	name, base, relation := splitPath(r.URL.Path)
+	if relation != "" {
+		return unknownMethod(tableMethods(r.URL.Path)...)
 	}
-
-	id, err := strconv.Atoi(base)
+	t, err := h.table(r, name, OpDelete)
 	if err != nil {
-		fmt.Println("DELETE WRONG ID: ", err)
-		return
+		return err
 	}
-
-	result, err := h.DB.Exec("DELETE FROM "+table+" WHERE "+table_id+" = ?", id)
+	pk, id, err := recordID(t, base)
 	if err != nil {
-		fmt.Println("DELETE ERROR: ", err)
-		return
+		return err
 	}
-
-	deleted, err := result.RowsAffected()
+	var (
+		deleted int64
+		before  map[string]interface{}
+	)
+	err = h.inTx(func(tx *sql.Tx) error {
+		extra, err := h.ifMatch(tx, r, t, pk, id)
+		if err != nil {
+			return err
+		}
+		if before, err = h.snapshot(tx, t, id); err != nil {
+			return err
+		}
+		if deleted, err = h.remove(tx, t, pk, id, extra...); err == nil && deleted == 0 && extra != nil {
+			return errPreconditionFailed
+		}
+		return err
+	})
 	if err != nil {
-		fmt.Println("Rows affected err: ", err)
-		return
+		return err
 	}
-
-	fmt.Println("Delete - RowsAffected", deleted)
-	response := map[string]interface{}{
-		"response": map[string]interface{}{
-			"deleted": deleted,
-		},
+	if deleted > 0 {
+		h.publish(Change{Table: t.Name, Op: OpDelete, PK: id, Before: before})
 	}
-
-	b, _ := json.Marshal(response)
-	fmt.Fprintln(w, string(b))
+	return sendResponse(w, map[string]interface{}{
+		"deleted": deleted,
+	})
 }
@@ -0,0 +1,529 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+// Dialect - всё, чем базы отличаются для db_explorer: откуда брать список таблиц и колонок,
+// как экранировать имена и как выглядят плейсхолдеры и LIMIT/OFFSET
+type Dialect interface {
+	Name() string
+	// Tables - таблицы базы по алфавиту
+	Tables(db *sql.DB) ([]string, error)
+	// Columns - колонки таблицы в порядке объявления. Kind, Size и ForeignKey заполняет LoadSchema
+	Columns(db *sql.DB, table string) ([]Column, error)
+	// ForeignKeys - внешние ключи таблицы по имени колонки. Составные ключи не поддерживаются
+	ForeignKeys(db *sql.DB, table string) (map[string]ForeignKey, error)
+	// Quote - имя таблицы или колонки для подстановки в запрос
+	Quote(name string) string
+	// Placeholder - плейсхолдер n-го параметра запроса, n начинается с 1
+	Placeholder(n int) string
+	// Limit - конец SELECT с уже готовыми плейсхолдерами для limit и offset
+	Limit(limit, offset string) string
+	// Returning - что дописать к INSERT, чтобы получить id новой записи строкой результата.
+	// Пусто - id отдаёт sql.Result.LastInsertId
+	Returning(pk string) string
+	// Constraint - *ConstraintError, если err - нарушение unique, внешнего ключа или NOT NULL,
+	// иначе сама err
+	Constraint(err error) error
+	// ForUpdate - SELECT * из таблицы по where в транзакции q, после которого прочитанное
+	// до конца транзакции не изменит никто другой: такая же транзакция рядом ждёт, пока эта закончится
+	ForUpdate(q querier, table, where string, args ...interface{}) (*sql.Rows, error)
+}
+
+// DialectFor выбирает диалект по драйверу, через который открыта база
+func DialectFor(db *sql.DB) (Dialect, error) {
+	driver := fmt.Sprintf("%T", db.Driver())
+	switch {
+	case strings.HasPrefix(driver, "*mysql."):
+		return MySQL{}, nil
+	case strings.HasPrefix(driver, "*pq."), strings.HasPrefix(driver, "*stdlib."):
+		return Postgres{}, nil
+	case strings.HasPrefix(driver, "*sqlite3."), strings.HasPrefix(driver, "*sqlite."):
+		return SQLite{}, nil
+	}
+	return nil, fmt.Errorf("unsupported driver %s", driver)
+}
+
+// quoteWith экранирует имя: кавычка внутри имени удваивается
+func quoteWith(q, name string) string {
+	return q + strings.Replace(name, q, q+q, -1) + q
+}
+
+// queryStrings - первая колонка всех строк запроса
+func queryStrings(db *sql.DB, query string, args ...interface{}) ([]string, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// queryForeignKeys - внешние ключи из запроса с колонками: колонка, таблица, колонка в ней
+func queryForeignKeys(db *sql.DB, query string, args ...interface{}) (map[string]ForeignKey, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := make(map[string]ForeignKey)
+	for rows.Next() {
+		var column string
+		var fk ForeignKey
+		if err := rows.Scan(&column, &fk.Table, &fk.Column); err != nil {
+			return nil, err
+		}
+		result[column] = fk
+	}
+	return result, rows.Err()
+}
+
+// ----------------
+
+type MySQL struct{}
+
+func (MySQL) Name() string {
+	return "mysql"
+}
+
+func (MySQL) Tables(db *sql.DB) ([]string, error) {
+	return queryStrings(db, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name`)
+}
+
+// Columns берёт то же, что SHOW FULL COLUMNS, но из information_schema:
+// туда можно передать имя таблицы плейсхолдером, а колонки выбрать по имени, а не по номеру
+func (MySQL) Columns(db *sql.DB, table string) ([]Column, error) {
+	rows, err := db.Query(`SELECT column_name, column_type, is_nullable, column_default, column_key, extra
+		FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND table_name = ? ORDER BY ordinal_position`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []Column
+	for rows.Next() {
+		var (
+			c                    Column
+			nullable, key, extra string
+			def                  sql.NullString
+		)
+		if err := rows.Scan(&c.Name, &c.Type, &nullable, &def, &key, &extra); err != nil {
+			return nil, err
+		}
+		c.Type = strings.ToLower(c.Type)
+		c.Nullable = nullable == "YES"
+		if def.Valid {
+			c.Default = &def.String
+		}
+		c.PrimaryKey = key == "PRI"
+		c.Unique = key == "PRI" || key == "UNI"
+		c.AutoIncrement = strings.Contains(extra, "auto_increment")
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func (MySQL) ForeignKeys(db *sql.DB, table string) (map[string]ForeignKey, error) {
+	return queryForeignKeys(db, `SELECT column_name, referenced_table_name, referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND table_name = ? AND referenced_table_name IS NOT NULL`, table)
+}
+
+func (MySQL) Quote(name string) string {
+	return quoteWith("`", name)
+}
+
+func (MySQL) Placeholder(n int) string {
+	return "?"
+}
+
+func (MySQL) Limit(limit, offset string) string {
+	return "LIMIT " + limit + " OFFSET " + offset
+}
+
+func (MySQL) Returning(pk string) string {
+	return ""
+}
+
+func (MySQL) Constraint(err error) error {
+	var me *mysql.MySQLError
+	if !errors.As(err, &me) {
+		return err
+	}
+	switch me.Number {
+	case 1062: // Duplicate entry '1' for key 'PRIMARY'
+		return &ConstraintError{Kind: ConstraintUnique, Err: err}
+	case 1451, 1452: // Cannot delete or update a parent row, Cannot add or update a child row
+		return &ConstraintError{Kind: ConstraintForeignKey, Err: err}
+	case 1048, 1364: // Column 'title' cannot be null, Field 'title' doesn't have a default value
+		return &ConstraintError{Kind: ConstraintNotNull, Column: quoted(me.Message, "'"), Err: err}
+	}
+	return err
+}
+
+func (d MySQL) ForUpdate(q querier, table, where string, args ...interface{}) (*sql.Rows, error) {
+	return q.Query("SELECT * FROM "+d.Quote(table)+" WHERE "+where+" FOR UPDATE", args...)
+}
+
+// ----------------
+
+type Postgres struct{}
+
+func (Postgres) Name() string {
+	return "postgres"
+}
+
+func (Postgres) Tables(db *sql.DB) ([]string, error) {
+	return queryStrings(db, `SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema() AND table_type = 'BASE TABLE' ORDER BY table_name`)
+}
+
+// pgTypes - типы postgres в записи mysql, чтобы остальной код видел одно и то же
+var pgTypes = map[string]string{
+	"character varying": "varchar",
+	"character":         "char",
+	"integer":           "int",
+	"double precision":  "double",
+	"boolean":           "bool",
+	// timestamp и time без зоны, с зоной - timestamptz
+	"timestamp without time zone": "timestamp",
+	"timestamp with time zone":    "timestamptz",
+	"time without time zone":      "time",
+}
+
+// pgConstraint - колонки ограничения таблицы заданного типа, только ограничения на одну колонку
+const pgConstraint = `SELECT min(kcu.column_name)
+	FROM information_schema.table_constraints tc
+	JOIN information_schema.key_column_usage kcu
+		ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema
+	WHERE tc.constraint_type = $1 AND tc.table_schema = current_schema() AND tc.table_name = $2
+	GROUP BY tc.constraint_name HAVING count(*) = 1`
+
+func (p Postgres) Columns(db *sql.DB, table string) ([]Column, error) {
+	primary, err := queryStrings(db, pgConstraint, "PRIMARY KEY", table)
+	if err != nil {
+		return nil, err
+	}
+	unique, err := queryStrings(db, pgConstraint, "UNIQUE", table)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT column_name, data_type, character_maximum_length, is_nullable, column_default, is_identity
+		FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1 ORDER BY ordinal_position`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var columns []Column
+	for rows.Next() {
+		var (
+			c                  Column
+			length             sql.NullInt64
+			nullable, identity string
+			def                sql.NullString
+		)
+		if err := rows.Scan(&c.Name, &c.Type, &length, &nullable, &def, &identity); err != nil {
+			return nil, err
+		}
+		if t, ok := pgTypes[c.Type]; ok {
+			c.Type = t
+		}
+		if length.Valid {
+			c.Type += "(" + strconv.FormatInt(length.Int64, 10) + ")"
+		}
+		c.Nullable = nullable == "YES"
+		// serial - это default nextval(...)
+		c.AutoIncrement = identity == "YES" || strings.HasPrefix(def.String, "nextval(")
+		if def.Valid && !c.AutoIncrement {
+			c.Default = &def.String
+		}
+		c.PrimaryKey = contains(primary, c.Name)
+		c.Unique = c.PrimaryKey || contains(unique, c.Name)
+		columns = append(columns, c)
+	}
+	return columns, rows.Err()
+}
+
+func (Postgres) ForeignKeys(db *sql.DB, table string) (map[string]ForeignKey, error) {
+	return queryForeignKeys(db, `SELECT kcu.column_name, ccu.table_name, ccu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_name = tc.constraint_name AND kcu.table_schema = tc.table_schema
+		JOIN information_schema.constraint_column_usage ccu
+			ON ccu.constraint_name = tc.constraint_name AND ccu.table_schema = tc.table_schema
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema() AND tc.table_name = $1`, table)
+}
+
+func (Postgres) Quote(name string) string {
+	return quoteWith(`"`, name)
+}
+
+func (Postgres) Placeholder(n int) string {
+	return "$" + strconv.Itoa(n)
+}
+
+func (Postgres) Limit(limit, offset string) string {
+	return "LIMIT " + limit + " OFFSET " + offset
+}
+
+// Returning - lib/pq не умеет LastInsertId
+func (p Postgres) Returning(pk string) string {
+	return " RETURNING " + p.Quote(pk)
+}
+
+func (Postgres) Constraint(err error) error {
+	var pe *pq.Error
+	if !errors.As(err, &pe) {
+		return err
+	}
+	switch pe.Code {
+	case "23505":
+		return &ConstraintError{Kind: ConstraintUnique, Column: pe.Column, Err: err}
+	case "23503":
+		return &ConstraintError{Kind: ConstraintForeignKey, Column: pe.Column, Err: err}
+	case "23502":
+		return &ConstraintError{Kind: ConstraintNotNull, Column: pe.Column, Err: err}
+	}
+	return err
+}
+
+func (d Postgres) ForUpdate(q querier, table, where string, args ...interface{}) (*sql.Rows, error) {
+	return q.Query("SELECT * FROM "+d.Quote(table)+" WHERE "+where+" FOR UPDATE", args...)
+}
+
+// ----------------
+
+type SQLite struct{}
+
+func (SQLite) Name() string {
+	return "sqlite"
+}
+
+func (SQLite) Tables(db *sql.DB) ([]string, error) {
+	return queryStrings(db, `SELECT name FROM sqlite_master
+		WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name`)
+}
+
+func (s SQLite) Columns(db *sql.DB, table string) ([]Column, error) {
+	// у PRAGMA нет плейсхолдеров, имя экранируется
+	rows, err := db.Query("PRAGMA table_info(" + s.Quote(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		columns []Column
+		pkCount int
+	)
+	for rows.Next() {
+		var (
+			c       Column
+			cid     int
+			notNull int
+			def     sql.NullString
+			pk      int
+		)
+		if err := rows.Scan(&cid, &c.Name, &c.Type, &notNull, &def, &pk); err != nil {
+			return nil, err
+		}
+		c.Type = strings.ToLower(c.Type)
+		c.Nullable = notNull == 0 && pk == 0
+		// DEFAULT NULL - то же, что без default, как в mysql
+		if def.Valid && strings.ToUpper(def.String) != "NULL" {
+			c.Default = &def.String
+		}
+		c.PrimaryKey = pk > 0
+		if c.PrimaryKey {
+			pkCount++
+		}
+		columns = append(columns, c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	unique, err := s.uniqueColumns(db, table)
+	if err != nil {
+		return nil, err
+	}
+	for i := range columns {
+		// INTEGER PRIMARY KEY - это rowid, он заполняется сам
+		if pkCount == 1 && columns[i].PrimaryKey && columns[i].Type == "integer" {
+			columns[i].AutoIncrement = true
+		}
+		columns[i].Unique = pkCount == 1 && columns[i].PrimaryKey || contains(unique, columns[i].Name)
+	}
+	return columns, nil
+}
+
+// uniqueColumns - колонки, на которых есть уникальный индекс из одной колонки.
+// Список индексов читается целиком до index_info: вложенный запрос занял бы второе соединение
+func (s SQLite) uniqueColumns(db *sql.DB, table string) ([]string, error) {
+	rows, err := db.Query("PRAGMA index_list(" + s.Quote(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	var indexes []string
+	for rows.Next() {
+		var (
+			seq, unique, partial int
+			name, origin         string
+		)
+		if err := rows.Scan(&seq, &name, &unique, &origin, &partial); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if unique == 1 && partial == 0 {
+			indexes = append(indexes, name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	for _, index := range indexes {
+		names, err := queryIndexColumns(db, "PRAGMA index_info("+s.Quote(index)+")")
+		if err != nil {
+			return nil, err
+		}
+		if len(names) == 1 {
+			columns = append(columns, names[0])
+		}
+	}
+	return columns, nil
+}
+
+// queryIndexColumns - имена колонок из PRAGMA index_info: seqno, cid, name
+func queryIndexColumns(db *sql.DB, query string) ([]string, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var (
+			seqno, cid int
+			name       sql.NullString
+		)
+		if err := rows.Scan(&seqno, &cid, &name); err != nil {
+			return nil, err
+		}
+		names = append(names, name.String)
+	}
+	return names, rows.Err()
+}
+
+// ForeignKeys - ссылка без колонки (REFERENCES users) указывает на первичный ключ,
+// его подставит LoadSchema
+func (s SQLite) ForeignKeys(db *sql.DB, table string) (map[string]ForeignKey, error) {
+	rows, err := db.Query("PRAGMA foreign_key_list(" + s.Quote(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var (
+		keys  = make(map[int]ForeignKey)
+		froms = make(map[int]string)
+		sizes = make(map[int]int)
+	)
+	for rows.Next() {
+		var (
+			id, seq                   int
+			ref, from                 string
+			to                        sql.NullString
+			onUpdate, onDelete, match string
+		)
+		if err := rows.Scan(&id, &seq, &ref, &from, &to, &onUpdate, &onDelete, &match); err != nil {
+			return nil, err
+		}
+		keys[id] = ForeignKey{Table: ref, Column: to.String}
+		froms[id] = from
+		sizes[id]++
+	}
+	// составной ключ - несколько строк с одним id, такие пропускаем
+	result := make(map[string]ForeignKey)
+	for id, fk := range keys {
+		if sizes[id] == 1 {
+			result[froms[id]] = fk
+		}
+	}
+	return result, rows.Err()
+}
+
+func (SQLite) Quote(name string) string {
+	return quoteWith(`"`, name)
+}
+
+func (SQLite) Placeholder(n int) string {
+	return "?"
+}
+
+func (SQLite) Limit(limit, offset string) string {
+	return "LIMIT " + limit + " OFFSET " + offset
+}
+
+func (SQLite) Returning(pk string) string {
+	return ""
+}
+
+// sqliteConstraints - начало текста ошибки sqlite. Драйвер не импортируется,
+// чтобы db_explorer собирался без cgo, поэтому ошибку узнаём по тексту
+var sqliteConstraints = map[string]string{
+	"UNIQUE constraint failed":      ConstraintUnique,
+	"FOREIGN KEY constraint failed": ConstraintForeignKey,
+	"NOT NULL constraint failed":    ConstraintNotNull,
+}
+
+func (SQLite) Constraint(err error) error {
+	if err == nil {
+		return nil
+	}
+	msg := err.Error()
+	for prefix, kind := range sqliteConstraints {
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		// UNIQUE constraint failed: products.name, у составных - через запятую
+		column := strings.TrimSpace(strings.TrimPrefix(msg, prefix))
+		column = strings.TrimPrefix(column, ":")
+		if i := strings.LastIndex(column, "."); i >= 0 && !strings.Contains(column, ",") {
+			column = column[i+1:]
+		} else {
+			column = ""
+		}
+		return &ConstraintError{Kind: kind, Column: column, Err: err}
+	}
+	return err
+}
+
+// ForUpdate: строк sqlite не блокирует, а транзакция из database/sql начинается читающей.
+// DELETE без строк делает её пишущей сразу, и вторая такая ждёт первую ещё до чтения
+func (d SQLite) ForUpdate(q querier, table, where string, args ...interface{}) (*sql.Rows, error) {
+	if _, err := q.Exec("DELETE FROM " + d.Quote(table) + " WHERE 0"); err != nil {
+		return nil, err
+	}
+	return q.Query("SELECT * FROM "+d.Quote(table)+" WHERE "+where, args...)
+}
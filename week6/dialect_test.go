@@ -0,0 +1,76 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"testing"
+)
+
+func TestDialectFor(t *testing.T) {
+	for driver, name := range map[string]string{
+		"mysql":    "mysql",
+		"postgres": "postgres",
+		"sqlite3":  "sqlite",
+	} {
+		// sql.Open не подключается к базе, сервер не нужен
+		db, err := sql.Open(driver, "")
+		if err != nil {
+			t.Fatalf("[%s] open: %v", driver, err)
+		}
+		d, err := DialectFor(db)
+		if err != nil || d.Name() != name {
+			t.Errorf("[%s] expected %s dialect, got %v %v", driver, name, d, err)
+		}
+		db.Close()
+	}
+}
+
+func TestDialectSyntax(t *testing.T) {
+	cases := []struct {
+		d           Dialect
+		name        string
+		quote       string
+		placeholder string
+		returning   string
+	}{
+		{MySQL{}, "we`ird", "`we``ird`", "?", ""},
+		{Postgres{}, `we"ird`, `"we""ird"`, "$2", ` RETURNING "id"`},
+		{SQLite{}, `we"ird`, `"we""ird"`, "?", ""},
+	}
+	for _, c := range cases {
+		// кавычка в имени не закрывает идентификатор
+		if q := c.d.Quote(c.name); q != c.quote {
+			t.Errorf("[%s] bad quote: %s", c.d.Name(), q)
+		}
+		if p := c.d.Placeholder(2); p != c.placeholder {
+			t.Errorf("[%s] bad placeholder: %s", c.d.Name(), p)
+		}
+		if r := c.d.Returning("id"); r != c.returning {
+			t.Errorf("[%s] bad returning: %q", c.d.Name(), r)
+		}
+	}
+}
+
+func TestSQLiteColumns(t *testing.T) {
+	db := testDB(t)
+	d, _ := DialectFor(db)
+	if d.Name() != "sqlite" {
+		t.Skip("columns are checked on sqlite only")
+	}
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+
+	columns, err := d.Columns(db, "items")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Column{
+		{Name: "id", Type: "integer", PrimaryKey: true, AutoIncrement: true, Unique: true},
+		{Name: "title", Type: "varchar(255)"},
+		{Name: "description", Type: "text"},
+		{Name: "updated", Type: "varchar(255)", Nullable: true},
+	}
+	if !reflect.DeepEqual(columns, expected) {
+		t.Errorf("bad columns:\n got %+v\nwant %+v", columns, expected)
+	}
+}
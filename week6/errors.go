@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ApiError - ошибка с http-статусом, текст уходит клиенту как есть. Как в week5
+type ApiError struct {
+	HTTPStatus int
+	Err        error
+}
+
+func (ae ApiError) Error() string {
+	return ae.Err.Error()
+}
+
+func badRequest(err error) error {
+	return ApiError{http.StatusBadRequest, err}
+}
+
+var (
+	errUnknownTable   = ApiError{http.StatusNotFound, errors.New("unknown table")}
+	errRecordNotFound = ApiError{http.StatusNotFound, errors.New("record not found")}
+	errUnknownMethod  = ApiError{http.StatusMethodNotAllowed, errors.New("unknown method")}
+	errEmptyRequest   = ApiError{http.StatusBadRequest, errors.New("empty request")}
+)
+
+// methodError - errUnknownMethod с методами, которые у пути есть: они уходят в заголовок Allow
+type methodError struct {
+	ApiError
+	allow []string
+}
+
+func (me methodError) Unwrap() error {
+	return me.ApiError
+}
+
+// unknownMethod - 405 для пути, у которого есть только методы allow
+func unknownMethod(allow ...string) error {
+	return methodError{errUnknownMethod, allow}
+}
+
+// виды нарушенных ограничений
+const (
+	ConstraintUnique     = "unique"
+	ConstraintForeignKey = "foreign_key"
+	ConstraintNotNull    = "not_null"
+)
+
+// ConstraintError - запрос не прошёл ограничение базы. Dialect.Constraint узнаёт
+// такие ошибки у своего драйвера, клиент получает 409
+type ConstraintError struct {
+	Kind string
+	// колонка, если база её назвала
+	Column string
+	Err    error
+}
+
+func (ce *ConstraintError) Error() string {
+	switch {
+	case ce.Kind == ConstraintUnique && ce.Column != "":
+		return "duplicate value for field " + ce.Column
+	case ce.Kind == ConstraintUnique:
+		return "duplicate key"
+	case ce.Kind == ConstraintForeignKey && ce.Column != "":
+		return "foreign key violation on field " + ce.Column
+	case ce.Kind == ConstraintForeignKey:
+		return "foreign key violation"
+	case ce.Column != "":
+		return "field " + ce.Column + " can not be null"
+	}
+	return "not null violation"
+}
+
+func (ce *ConstraintError) Unwrap() error {
+	return ce.Err
+}
+
+// errorStatus - статус и текст ответа для ошибки обработчика. Всё, что не ApiError
+// и не ConstraintError, - ошибка базы: подробности только в лог, клиенту 500
+func errorStatus(err error) (int, string) {
+	var ae ApiError
+	if errors.As(err, &ae) {
+		return ae.HTTPStatus, ae.Error()
+	}
+	var ce *ConstraintError
+	if errors.As(err, &ce) {
+		return http.StatusConflict, ce.Error()
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return http.StatusNotFound, errRecordNotFound.Error()
+	}
+	return http.StatusInternalServerError, "internal error"
+}
+
+// quoted - первое имя в кавычках из текста ошибки: Column 'title' cannot be null
+func quoted(msg, quote string) string {
+	parts := strings.SplitN(msg, quote, 3)
+	if len(parts) < 3 {
+		return ""
+	}
+	return parts[1]
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+)
+
+func TestConstraint(t *testing.T) {
+	cases := []struct {
+		d   Dialect
+		err error
+		msg string
+	}{
+		{MySQL{}, &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'a' for key 'name'"}, "duplicate key"},
+		{MySQL{}, &mysql.MySQLError{Number: 1452, Message: "Cannot add or update a child row"}, "foreign key violation"},
+		{MySQL{}, &mysql.MySQLError{Number: 1048, Message: "Column 'title' cannot be null"}, "field title can not be null"},
+		{Postgres{}, &pq.Error{Code: "23505"}, "duplicate key"},
+		{Postgres{}, &pq.Error{Code: "23503"}, "foreign key violation"},
+		{Postgres{}, &pq.Error{Code: "23502", Column: "title"}, "field title can not be null"},
+		{SQLite{}, errors.New("UNIQUE constraint failed: products.name"), "duplicate value for field name"},
+		{SQLite{}, errors.New("UNIQUE constraint failed: t.a, t.b"), "duplicate key"},
+		{SQLite{}, errors.New("FOREIGN KEY constraint failed"), "foreign key violation"},
+		{SQLite{}, errors.New("NOT NULL constraint failed: items.title"), "field title can not be null"},
+	}
+	for _, c := range cases {
+		err := c.d.Constraint(c.err)
+		status, msg := errorStatus(err)
+		if status != http.StatusConflict || msg != c.msg || !errors.Is(err, c.err) {
+			t.Errorf("[%s] %v: expected 409 %q, got %d %q", c.d.Name(), c.err, c.msg, status, msg)
+		}
+	}
+
+	// остальные ошибки драйвера - 500 без подробностей
+	other := errors.New("no such table: tags")
+	if status, msg := errorStatus(SQLite{}.Constraint(other)); status != http.StatusInternalServerError || msg != "internal error" {
+		t.Errorf("expected 500, got %d %q", status, msg)
+	}
+	if err := (MySQL{}).Constraint(other); err != other {
+		t.Errorf("expected error as is, got %v", err)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+	logs := &bytes.Buffer{}
+	handler.Logger = slog.New(slog.NewJSONHandler(logs, nil))
+
+	cases := []Case{
+		Case{
+			Path:   "/items",
+			Method: http.MethodOptions,
+			Status: http.StatusMethodNotAllowed,
+			Body:   CR{"title": "options"},
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+		Case{
+			Path:   "/_schema",
+			Method: http.MethodDelete,
+			Status: http.StatusMethodNotAllowed,
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+		Case{
+			Path:   "/unknown_table/1",
+			Method: http.MethodDelete,
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+			},
+		},
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body:   CR{},
+			Result: CR{
+				"error": "empty request",
+			},
+		},
+		// неизвестные поля игнорируются, обновлять нечего
+		Case{
+			Path:   "/items/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body:   CR{"unknown": 1},
+			Result: CR{
+				"error": "empty request",
+			},
+		},
+		Case{
+			Path:   "/products/",
+			Method: http.MethodPut,
+			Body:   CR{"name": "lamp", "price": 10, "user_id": 1},
+			Result: CR{
+				"response": CR{
+					"id": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/products/",
+			Method: http.MethodPut,
+			Status: http.StatusConflict,
+			Body:   CR{"name": "lamp", "price": 20},
+			Result: CR{
+				"error": "duplicate value for field name",
+			},
+		},
+		Case{
+			Path:   "/products/",
+			Method: http.MethodPut,
+			Status: http.StatusConflict,
+			Body:   CR{"name": "chair", "price": 20, "user_id": 100500},
+			Result: CR{
+				"error": "foreign key violation",
+			},
+		},
+		// на пользователя ссылается товар
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodDelete,
+			Status: http.StatusConflict,
+			Result: CR{
+				"error": "foreign key violation",
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+
+	// в Allow - только методы, которые есть у пути
+	for _, c := range []struct {
+		method, path, allow string
+	}{
+		{http.MethodDelete, "/_schema", "GET"},
+		{http.MethodGet, "/_schema/refresh", "POST"},
+		{http.MethodGet, "/_batch", "POST"},
+		{http.MethodGet, "/items/_import", "POST"},
+		{http.MethodOptions, "/items", "GET, PUT, PATCH"},
+		{http.MethodPatch, "/items/3", "GET, PUT, POST, DELETE"},
+		{http.MethodDelete, "/users/1/items", "GET, PUT"},
+	} {
+		resp, _ := doRequest(t, c.method, ts.URL+c.path, nil, nil, nil)
+		if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") != c.allow {
+			t.Errorf("[%s %s] expected 405 with Allow %q, got %d %q", c.method, c.path, c.allow, resp.StatusCode, resp.Header.Get("Allow"))
+		}
+	}
+
+	// кривой json - ошибка клиента
+	body := CR{}
+	resp, _ := doRequest(t, http.MethodPost, ts.URL+"/items/1", map[string]string{"Content-Type": "application/json"}, strings.NewReader(`{"title": `), &body)
+	if resp.StatusCode != http.StatusBadRequest || !strings.HasPrefix(body["error"].(string), "bad json: ") {
+		t.Errorf("bad json: expected 400, got %d %v", resp.StatusCode, body)
+	}
+
+	// таблицы больше нет, а схема про это не знает - ошибка базы
+	if _, err := db.Exec(`DROP TABLE products;`); err != nil {
+		t.Fatal(err)
+	}
+	body = CR{}
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/products", nil, nil, &body)
+	if resp.StatusCode != http.StatusInternalServerError || body["error"] != "internal error" {
+		t.Errorf("dropped table: expected 500, got %d %v", resp.StatusCode, body)
+	}
+
+	// в логе - статус и настоящая ошибка
+	var found bool
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		entry := map[string]interface{}{}
+		json.Unmarshal([]byte(line), &entry)
+		if entry["status"] == float64(500) {
+			found = entry["level"] == "ERROR" && strings.Contains(entry["error"].(string), "no such table")
+		}
+	}
+	if !found {
+		t.Errorf("no error in log:\n%s", logs)
+	}
+}
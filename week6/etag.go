@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ETag записи - её версия, если у таблицы есть колонка версии (см. SetVersion), иначе хеш записи.
+// GET /$table/$id отдаёт ETag, POST и DELETE с If-Match меняют запись, только если он не изменился, иначе 412.
+// Список и запись с ?include= получают ETag по хешу всего ответа, с If-None-Match на нём - 304 без тела.
+// errPreconditionFailed - запись поменялась (или её нет) с тех пор, как клиент получил ETag
+var errPreconditionFailed = ApiError{http.StatusPreconditionFailed, errors.New("precondition failed")}
+
+// SetVersion включает для таблицы колонку версии: при вставке она 1, каждый UPDATE увеличивает её на 1,
+// значения из запросов в неё не пишутся. Колонка должна быть целочисленной
+func (h *Handler) SetVersion(table, column string) error {
+	t := h.Schema().Tables[table]
+	if t == nil {
+		return fmt.Errorf("version: unknown table %s", table)
+	}
+	c := t.Column(column)
+	if c == nil || c.Kind != "int" {
+		return fmt.Errorf("version: %s.%s is not an integer column", table, column)
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.versions == nil {
+		h.versions = make(map[string]string)
+	}
+	h.versions[table] = column
+	return nil
+}
+
+// version - колонка версии таблицы из схемы (даже если политика её скрыла), nil - версий нет
+func (h *Handler) version(t *Table) *Column {
+	h.mu.RLock()
+	name := h.versions[t.Name]
+	h.mu.RUnlock()
+	if name == "" {
+		return nil
+	}
+	if base := h.Schema().Tables[t.Name]; base != nil {
+		return base.Column(name)
+	}
+	return nil
+}
+
+// fetch - одна запись по id и её ETag
+func (h *Handler) fetch(q querier, t *Table, pk *Column, id interface{}) (map[string]interface{}, string, error) {
+	where, params := h.recordWhere(t, pk, id, 1)
+	rows, err := q.Query("SELECT * FROM "+h.Dialect.Quote(t.Name)+" WHERE "+where, params...)
+	return h.tagged(t, rows, err)
+}
+
+// fetchForUpdate - то же, что fetch, но до конца транзакции q запись никто больше не изменит
+func (h *Handler) fetchForUpdate(q querier, t *Table, pk *Column, id interface{}) (map[string]interface{}, string, error) {
+	where, params := h.recordWhere(t, pk, id, 1)
+	rows, err := h.Dialect.ForUpdate(q, t.Name, where, params...)
+	return h.tagged(t, rows, err)
+}
+
+// tagged - первая запись из rows и её ETag
+func (h *Handler) tagged(t *Table, rows *sql.Rows, err error) (map[string]interface{}, string, error) {
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := GetResult(t, rows)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(res) == 0 {
+		return nil, "", errRecordNotFound
+	}
+	rec := res[0]
+	if v := h.version(t); v != nil {
+		// скрытую или замаскированную версию в ETag не видно, тогда остаётся хеш
+		if c := t.Column(v.Name); c != nil && !c.Masked && rec[v.Name] != nil {
+			return rec, fmt.Sprintf(`"v%v"`, rec[v.Name]), nil
+		}
+	}
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return nil, "", err
+	}
+	return rec, hashETag(b), nil
+}
+
+// ifMatch проверяет If-Match запроса по текущей записи. Запись должна быть, ETag - совпадать, иначе 412.
+// Запись читается с блокировкой: пока транзакция q не закончится, второй запрос с тем же ETag ждёт
+// и потом получает 412, а не затирает это изменение. Возвращает ещё и условие на версию для UPDATE или DELETE.
+// Без If-Match ничего не проверяет
+func (h *Handler) ifMatch(q querier, r *http.Request, t *Table, pk *Column, id interface{}) ([]Filter, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return nil, nil
+	}
+	rec, etag, err := h.fetchForUpdate(q, t, pk, id)
+	if err == errRecordNotFound {
+		return nil, errPreconditionFailed
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !matchETag(header, etag, false) {
+		return nil, errPreconditionFailed
+	}
+	if v := h.version(t); v != nil && strings.HasPrefix(etag, `"v`) {
+		return []Filter{{Column: v, Op: "eq", Values: []interface{}{rec[v.Name]}}}, nil
+	}
+	return nil, nil
+}
+
+// withVersion убирает колонку версии из колонок запроса, при вставке - ставит её в 1
+func (h *Handler) withVersion(t *Table, cols []string, params []interface{}, insert bool) ([]string, []interface{}, *Column) {
+	v := h.version(t)
+	if v == nil {
+		return cols, params, nil
+	}
+	var (
+		vcols   []string
+		vparams []interface{}
+	)
+	for i, col := range cols {
+		if col != v.Name {
+			vcols = append(vcols, col)
+			vparams = append(vparams, params[i])
+		}
+	}
+	if insert {
+		vcols = append(vcols, v.Name)
+		vparams = append(vparams, 1)
+	}
+	return vcols, vparams, v
+}
+
+// sendTagged - ответ с ETag, пустой etag - хеш тела. Если клиент прислал его же в If-None-Match - 304 без тела
+func sendTagged(w http.ResponseWriter, r *http.Request, etag string, result interface{}) error {
+	b, err := json.Marshal(map[string]interface{}{"response": result})
+	if err != nil {
+		return err
+	}
+	if etag == "" {
+		etag = hashETag(b)
+	}
+	w.Header().Set("ETag", etag)
+	if header := r.Header.Get("If-None-Match"); header != "" && matchETag(header, etag, true) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintln(w, string(b))
+	return nil
+}
+
+func hashETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// matchETag - есть ли etag в списке из If-Match или If-None-Match. "*" подходит к любому.
+// weak - слабое сравнение (If-None-Match): W/"x" и "x" - одно и то же, для If-Match W/ не подходит никогда
+func matchETag(header, etag string, weak bool) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" {
+			return true
+		}
+		if strings.HasPrefix(tag, "W/") {
+			if !weak {
+				continue
+			}
+			tag = tag[2:]
+		}
+		if tag == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+func TestMatchETag(t *testing.T) {
+	cases := []struct {
+		header string
+		weak   bool
+		match  bool
+	}{
+		{`"a"`, false, true},
+		{`"b", "a"`, false, true},
+		{`*`, false, true},
+		{`W/"a"`, false, false},
+		{`W/"a"`, true, true},
+		{`"b"`, true, false},
+		{`a`, false, false},
+	}
+	for _, c := range cases {
+		if match := matchETag(c.header, `"a"`, c.weak); match != c.match {
+			t.Errorf("matchETag(%s, weak=%v): expected %v", c.header, c.weak, c.match)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	_, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	url := ts.URL + "/items/1"
+
+	resp, _ := doRequest(t, http.MethodGet, url, nil, nil, nil)
+	etag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || len(etag) != 34 {
+		t.Fatalf("expected ETag, got %d %q", resp.StatusCode, etag)
+	}
+	if resp, _ := doRequest(t, http.MethodGet, url, nil, nil, nil); resp.Header.Get("ETag") != etag {
+		t.Errorf("ETag of the same record changed: %q", resp.Header.Get("ETag"))
+	}
+	if resp, _ := doRequest(t, http.MethodGet, url, map[string]string{"If-None-Match": etag}, nil, nil); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("If-None-Match: expected 304, got %d", resp.StatusCode)
+	}
+
+	resp, raw := doRequest(t, http.MethodPost, url, map[string]string{"If-Match": etag}, CR{"title": "edited"}, nil)
+	newETag := resp.Header.Get("ETag")
+	if resp.StatusCode != http.StatusOK || newETag == "" || newETag == etag {
+		t.Fatalf("If-Match: expected 200 with new ETag, got %d %s %q", resp.StatusCode, raw, newETag)
+	}
+
+	// второй редактор со старым ETag
+	for _, method := range []string{http.MethodPost, http.MethodDelete} {
+		body := CR{}
+		resp, _ = doRequest(t, method, url, map[string]string{"If-Match": etag}, CR{"title": "lost update"}, &body)
+		if resp.StatusCode != http.StatusPreconditionFailed || body["error"] != "precondition failed" {
+			t.Errorf("%s with stale ETag: expected 412, got %d %v", method, resp.StatusCode, body)
+		}
+	}
+	if resp, _ = doRequest(t, http.MethodDelete, url, map[string]string{"If-Match": "W/" + newETag}, nil, nil); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("If-Match with weak ETag: expected 412, got %d", resp.StatusCode)
+	}
+	if resp, _ = doRequest(t, http.MethodPost, ts.URL+"/items/100500", map[string]string{"If-Match": "*"}, CR{"title": "none"}, nil); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("If-Match on missing record: expected 412, got %d", resp.StatusCode)
+	}
+	body := CR{}
+	resp, _ = doRequest(t, http.MethodDelete, url, map[string]string{"If-Match": newETag}, nil, &body)
+	if resp.StatusCode != http.StatusOK || body["response"].(map[string]interface{})["deleted"] != float64(1) {
+		t.Errorf("If-Match with current ETag: expected delete, got %d %v", resp.StatusCode, body)
+	}
+
+	// список - ETag по всему ответу
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/items", nil, nil, nil)
+	listETag := resp.Header.Get("ETag")
+	if resp, _ = doRequest(t, http.MethodGet, ts.URL+"/items", map[string]string{"If-None-Match": `"x", W/` + listETag}, nil, nil); resp.StatusCode != http.StatusNotModified {
+		t.Errorf("list If-None-Match: expected 304, got %d", resp.StatusCode)
+	}
+	doRequest(t, http.MethodPost, ts.URL+"/items/2", nil, CR{"updated": "someone"}, nil)
+	body = CR{}
+	resp, _ = doRequest(t, http.MethodGet, ts.URL+"/items", map[string]string{"If-None-Match": listETag}, nil, &body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == listETag || body["response"] == nil {
+		t.Errorf("changed list: expected 200 with new ETag, got %d %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+}
+
+// одновременные POST с одним ETag: запись меняет только первый, остальные получают 412, а не затирают его
+func TestETagRace(t *testing.T) {
+	_, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	url := ts.URL + "/items/1"
+	resp, _ := doRequest(t, http.MethodGet, url, nil, nil, nil)
+	etag := resp.Header.Get("ETag")
+
+	const writers = 8
+	statuses := make(chan int, writers)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, _ := doRequest(t, http.MethodPost, url, map[string]string{"If-Match": etag}, CR{"title": fmt.Sprint("writer ", i)}, nil)
+			statuses <- resp.StatusCode
+		}(i)
+	}
+	wg.Wait()
+	close(statuses)
+	count := map[int]int{}
+	for status := range statuses {
+		count[status]++
+	}
+	if count[http.StatusOK] != 1 || count[http.StatusPreconditionFailed] != writers-1 {
+		t.Errorf("expected one 200 and %d 412, got %v", writers-1, count)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+	if _, err := db.Exec(`CREATE TABLE notes (
+  id integer PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  version integer NOT NULL
+);`); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE notes;`)
+	if err := handler.Refresh(); err != nil {
+		t.Fatal(err)
+	}
+	for _, bad := range [][2]string{{"orders", "version"}, {"notes", "revision"}, {"notes", "title"}} {
+		if err := handler.SetVersion(bad[0], bad[1]); err == nil {
+			t.Errorf("SetVersion(%s, %s): expected error", bad[0], bad[1])
+		}
+	}
+	if err := handler.SetVersion("notes", "version"); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []Case{
+		// версию ведёт explorer, присланная игнорируется
+		Case{
+			Path:   "/notes/",
+			Method: http.MethodPut,
+			Body:   CR{"title": "first", "version": 100},
+			Result: CR{
+				"response": CR{
+					"id": 1,
+				},
+			},
+		},
+		Case{
+			Path: "/notes/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 1, "title": "first", "version": 1},
+				},
+			},
+		},
+		Case{
+			Path:   "/notes",
+			Query:  "id=1",
+			Method: http.MethodPatch,
+			Body:   CR{"title": "patched"},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/notes/1",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body:   CR{"version": 5},
+			Result: CR{
+				"error": "empty request",
+			},
+		},
+		Case{
+			Path: "/notes/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{"id": 1, "title": "patched", "version": 2},
+				},
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+
+	url := ts.URL + "/notes/1"
+	resp, _ := doRequest(t, http.MethodGet, url, nil, nil, nil)
+	if etag := resp.Header.Get("ETag"); etag != `"v2"` {
+		t.Fatalf(`expected ETag "v2", got %q`, etag)
+	}
+	resp, _ = doRequest(t, http.MethodPost, url, map[string]string{"If-Match": `"v2"`}, CR{"title": "second"}, nil)
+	if etag := resp.Header.Get("ETag"); resp.StatusCode != http.StatusOK || etag != `"v3"` {
+		t.Errorf(`expected 200 with ETag "v3", got %d %q`, resp.StatusCode, etag)
+	}
+	if resp, _ = doRequest(t, http.MethodPost, url, map[string]string{"If-Match": `"v2"`}, CR{"title": "third"}, nil); resp.StatusCode != http.StatusPreconditionFailed {
+		t.Errorf("stale version: expected 412, got %d", resp.StatusCode)
+	}
+}
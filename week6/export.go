@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// exportFlush - через сколько записей выгрузка отправляет накопленное клиенту
+const exportFlush = 1000
+
+// exportTypes - форматы выгрузки и загрузки и их Content-Type, первый - тот, что отдаём
+var exportTypes = map[string][]string{
+	"csv":    {"text/csv", "application/csv"},
+	"ndjson": {"application/x-ndjson", "application/ndjson", "application/jsonl"},
+	"json":   {"application/json"},
+}
+
+// exportFormat - формат выгрузки из ?format= или Accept, пусто - обычная страница списка.
+// application/json в Accept шлёт любой клиент, поэтому json-массив - только через ?format=json
+func exportFormat(r *http.Request) (string, error) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		if _, ok := exportTypes[format]; !ok {
+			return "", errors.New("unknown format " + format)
+		}
+		return format, nil
+	}
+	for _, accept := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, _, err := mime.ParseMediaType(strings.TrimSpace(accept))
+		if err != nil {
+			continue
+		}
+		if format := formatOf(mediaType); format != "" && format != "json" {
+			return format, nil
+		}
+	}
+	return "", nil
+}
+
+// formatOf - формат по Content-Type, пусто - не знаем такого
+func formatOf(mediaType string) string {
+	for format, types := range exportTypes {
+		if contains(types, mediaType) {
+			return format
+		}
+	}
+	return ""
+}
+
+// Export - GET /$table?format=csv|ndjson|json (или Accept: text/csv, application/x-ndjson):
+// все записи, подходящие под фильтры списка, одним потоком. Записи не копятся в памяти, а пишутся
+// по мере чтения из базы. limit работает, только если указан явно, include не поддерживается.
+// В csv первая строка - имена колонок, NULL - пустое поле, двоичные данные - base64
+func (h *Handler) Export(w http.ResponseWriter, r *http.Request, t *Table, lq *ListQuery, format string) error {
+	if len(lq.Include) > 0 {
+		return badRequest(errors.New("include is not supported for export"))
+	}
+	if r.URL.Query().Get("limit") == "" {
+		lq.Limit = math.MaxInt
+	}
+	query, params := lq.SQL(h.Dialect, t)
+	rows, err := h.DB.Query(query, params...)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", exportTypes[format][0])
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", t.Name+"."+format))
+	flusher, _ := w.(http.Flusher)
+	n := 0
+	// flush каждые exportFlush записей отправляет клиенту то, что накопилось, pending - в своём буфере
+	flush := func(pending func()) {
+		if n++; n%exportFlush == 0 && flusher != nil {
+			if pending != nil {
+				pending()
+			}
+			flusher.Flush()
+		}
+	}
+
+	switch format {
+	case "csv":
+		cw := csv.NewWriter(w)
+		var line []string
+		err = scanRows(t, rows, func(columns []string, item map[string]interface{}) error {
+			if line == nil {
+				line = make([]string, len(columns))
+				if err := cw.Write(columns); err != nil {
+					return err
+				}
+			}
+			for i, name := range columns {
+				line[i] = csvValue(item[name])
+			}
+			if err := cw.Write(line); err != nil {
+				return err
+			}
+			flush(cw.Flush)
+			return nil
+		})
+		if err == nil && line == nil {
+			// записей нет, но заголовок всё равно нужен
+			err = cw.Write(exportColumns(t, lq))
+		}
+		cw.Flush()
+		if err == nil {
+			err = cw.Error()
+		}
+	case "ndjson":
+		enc := json.NewEncoder(w)
+		err = scanRows(t, rows, func(columns []string, item map[string]interface{}) error {
+			flush(nil)
+			return enc.Encode(item)
+		})
+	case "json":
+		sep := "["
+		err = scanRows(t, rows, func(columns []string, item map[string]interface{}) error {
+			b, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprint(w, sep, string(b)); err != nil {
+				return err
+			}
+			sep = ",\n"
+			flush(nil)
+			return nil
+		})
+		if err == nil {
+			if sep == "[" {
+				fmt.Fprint(w, sep)
+			}
+			_, err = fmt.Fprintln(w, "]")
+		}
+	}
+	return err
+}
+
+// exportColumns - колонки выгрузки, когда их не у кого спросить: выбранные в ?fields= или все видимые
+func exportColumns(t *Table, lq *ListQuery) []string {
+	columns := lq.Fields
+	if len(columns) == 0 {
+		columns = t.Columns
+	}
+	names := make([]string, len(columns))
+	for i, c := range columns {
+		names[i] = c.Name
+	}
+	return names
+}
+
+// csvValue - значение записи так, как его потом поймёт загрузка (см. Import)
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return base64.StdEncoding.EncodeToString(v)
+	case json.RawMessage:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+
+	cases := []struct {
+		query       string
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"format=csv", "", "text/csv",
+			"id,title,description,updated\n" +
+				"1,database/sql,Рассказать про базы данных,rvasily\n" +
+				"2,memcache,Рассказать про мемкеш с примером использования,\n"},
+		{"fields=id,updated&updated[null]=false", "application/json, text/csv;q=0.9", "text/csv",
+			"id,updated\n1,rvasily\n"},
+		{"fields=id,updated&sort=-id", "application/x-ndjson", "application/x-ndjson",
+			`{"id":2,"updated":null}` + "\n" + `{"id":1,"updated":"rvasily"}` + "\n"},
+		{"format=json&fields=id,title", "", "application/json",
+			`[{"id":1,"title":"database/sql"},` + "\n" + `{"id":2,"title":"memcache"}]` + "\n"},
+		{"format=json&id=100500", "", "application/json", "[]\n"},
+		{"format=csv&id=100500&fields=id,title", "", "text/csv", "id,title\n"},
+	}
+	for _, c := range cases {
+		resp, body := doRequest(t, http.MethodGet, ts.URL+"/items?"+c.query, map[string]string{"Accept": c.accept}, nil, nil)
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != c.contentType || body != c.body {
+			t.Errorf("[%s %s] expected %s\n%s\ngot %d %s\n%s", c.query, c.accept, c.contentType, c.body,
+				resp.StatusCode, resp.Header.Get("Content-Type"), body)
+		}
+	}
+
+	// без ?format= и с обычным Accept - страница списка, как раньше
+	resp, body := doRequest(t, http.MethodGet, ts.URL+"/items?fields=id", map[string]string{"Accept": "application/json"}, nil, nil)
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(body, `{"response":`) {
+		t.Errorf("plain list: got %d %s", resp.StatusCode, body)
+	}
+
+	// выгрузка - вся таблица, а не страница, если limit не указан явно. maxLimit страницы к ней не относится
+	for i := 0; i < maxLimit; i++ {
+		if _, err := db.Exec(`INSERT INTO items (title, description) VALUES (?, '')`, "item"+strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for query, expected := range map[string]int{
+		"format=ndjson":                                   maxLimit + 2,
+		"format=ndjson&limit=3&offset=100":                2,
+		"format=ndjson&limit=" + strconv.Itoa(maxLimit+1): maxLimit + 1,
+	} {
+		_, body = doRequest(t, http.MethodGet, ts.URL+"/items?"+query, nil, nil, nil)
+		lines := strings.Split(strings.TrimSpace(body), "\n")
+		if len(lines) != expected {
+			t.Errorf("[%s] expected %d records, got %d", query, expected, len(lines))
+		}
+		for _, line := range lines {
+			if !json.Valid([]byte(line)) {
+				t.Errorf("[%s] bad json line %q", query, line)
+			}
+		}
+	}
+
+	// а страница списка - не больше maxLimit
+	list := struct{ Response struct{ Records []CR } }{}
+	doRequest(t, http.MethodGet, ts.URL+"/items?limit="+strconv.Itoa(maxLimit+1), nil, nil, &list)
+	if len(list.Response.Records) != maxLimit {
+		t.Errorf("expected %d records on a page, got %d", maxLimit, len(list.Response.Records))
+	}
+
+	for query, msg := range map[string]string{
+		"format=xml":              "unknown format xml",
+		"format=csv&include=user": "include is not supported for export",
+	} {
+		resp, body = doRequest(t, http.MethodGet, ts.URL+"/products?"+query, nil, nil, nil)
+		if resp.StatusCode != http.StatusBadRequest || !strings.Contains(body, msg) {
+			t.Errorf("[%s] expected 400 %q, got %d %s", query, msg, resp.StatusCode, body)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+)
+
+const (
+	// importBatch - сколько записей вставляется в одной транзакции
+	importBatch = 500
+	// importMaxErrors - сколько ошибок попадает в отчёт, остальные только считаются
+	importMaxErrors = 100
+	// importMaxLine - самая длинная строка ndjson
+	importMaxLine = 1 << 20
+)
+
+// ImportReport - итог загрузки: сколько вставлено, сколько нет и почему (номер строки во входных данных)
+type ImportReport struct {
+	Inserted int           `json:"inserted"`
+	Failed   int           `json:"failed"`
+	Errors   []ImportError `json:"errors"`
+}
+
+type ImportError struct {
+	Line  int    `json:"line"`
+	Error string `json:"error"`
+}
+
+func (rep *ImportReport) fail(line int, err error) {
+	rep.Failed++
+	if len(rep.Errors) < importMaxErrors {
+		_, msg := errorStatus(err)
+		rep.Errors = append(rep.Errors, ImportError{Line: line, Error: msg})
+	}
+}
+
+// importRow - проверенная запись, готовая к вставке
+type importRow struct {
+	line   int
+	cols   []string
+	params []interface{}
+}
+
+// lineError - входные данные дальше не разобрать: загрузка останавливается, ошибка уходит в отчёт
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e lineError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.err)
+}
+
+var errUnsupportedType = ApiError{http.StatusUnsupportedMediaType, errors.New("expected text/csv or application/x-ndjson")}
+
+// POST /$table/_import - загрузка csv (первая строка - имена колонок) или ndjson (объект на строку),
+// формат - из ?format= или Content-Type. Данные читаются потоком, значения приводятся по схеме, как у PUT,
+// пустое поле csv - NULL, а в NOT NULL колонке - default, если он есть. Записи вставляются пачками
+// по importBatch в транзакции, если в пачке ошибка - её записи вставляются по одной, чтобы плохие не мешали хорошим.
+// В ответе - ImportReport
+func (h *Handler) Import(w http.ResponseWriter, r *http.Request) error {
+	name, _, _ := splitPath(r.URL.Path)
+	t, err := h.table(r, name, OpCreate)
+	if err != nil {
+		return err
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		format = formatOf(mediaType)
+	}
+	read := map[string]func(io.Reader, *Table, func(int, Record, error) error) error{
+		"csv":    readCSV,
+		"ndjson": readNDJSON,
+	}[format]
+	if read == nil {
+		return errUnsupportedType
+	}
+
+	report := &ImportReport{Errors: []ImportError{}}
+	batch := make([]importRow, 0, importBatch)
+	flush := func() error {
+		err := h.importBatch(t, batch, report)
+		batch = batch[:0]
+		return err
+	}
+	err = read(r.Body, t, func(line int, rec Record, err error) error {
+		var row importRow
+		if err == nil {
+			row.cols, row.params, err = rec.Values(t, true)
+		}
+		if err != nil {
+			report.fail(line, err)
+			return nil
+		}
+		row.line = line
+		if batch = append(batch, row); len(batch) == importBatch {
+			return flush()
+		}
+		return nil
+	})
+	var le lineError
+	if errors.As(err, &le) {
+		report.fail(le.line, badRequest(le.err))
+		err = nil
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return err
+	}
+	if report.Inserted+report.Failed == 0 {
+		return errEmptyRequest
+	}
+	return sendResponse(w, report)
+}
+
+// importBatch вставляет пачку в одной транзакции, при ошибке - по одной, записывая в отчёт, какие не вставились.
+// Ошибка - только если дело не в записях (500)
+func (h *Handler) importBatch(t *Table, rows []importRow, report *ImportReport) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	var changes []Change
+	err := h.inTx(func(tx *sql.Tx) error {
+		ids := make([]interface{}, 0, len(rows))
+		for _, row := range rows {
+			id, err := h.insert(tx, t, row.cols, row.params)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		var err error
+		changes, err = h.changesOf(tx, t, OpCreate, ids, nil)
+		return err
+	})
+	if err == nil {
+		h.publish(changes...)
+		report.Inserted += len(rows)
+		return nil
+	}
+	var (
+		ids   []interface{}
+		fatal error
+	)
+	for _, row := range rows {
+		id, err := h.insert(h.DB, t, row.cols, row.params)
+		if err != nil {
+			if status, _ := errorStatus(err); status == http.StatusInternalServerError {
+				fatal = err
+				break
+			}
+			report.fail(row.line, err)
+			continue
+		}
+		report.Inserted++
+		ids = append(ids, id)
+	}
+	// вставленные записи уже в базе, даже если дальше база сломалась
+	if changes, err = h.changesOf(h.DB, t, OpCreate, ids, nil); err != nil {
+		return err
+	}
+	h.publish(changes...)
+	return fatal
+}
+
+// readCSV отдаёт в add записи csv. Колонки - из первой строки, все значения - строки, как из формы
+func readCSV(body io.Reader, t *Table, add func(line int, rec Record, err error) error) error {
+	cr := csv.NewReader(body)
+	cr.FieldsPerRecord = -1
+	cr.ReuseRecord = true
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return csvError(err)
+	}
+	header = append([]string(nil), header...)
+	for {
+		values, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return csvError(err)
+		}
+		line, _ := cr.FieldPos(0)
+		if len(values) != len(header) {
+			err = errors.New("expected " + strconv.Itoa(len(header)) + " fields, got " + strconv.Itoa(len(values)))
+			if err := add(line, Record{}, badRequest(err)); err != nil {
+				return err
+			}
+			continue
+		}
+		rec := Record{Fields: make(map[string]interface{}, len(header)), Form: true}
+		for i, name := range header {
+			// пусто в NOT NULL колонке с default - поля нет, будет default
+			c := t.Column(name)
+			switch {
+			case c == nil || values[i] != "":
+				rec.Fields[name] = values[i]
+			case c.Nullable:
+				rec.Fields[name] = nil
+			case c.Default == nil:
+				rec.Fields[name] = values[i]
+			}
+		}
+		if err := add(line, rec, nil); err != nil {
+			return err
+		}
+	}
+}
+
+func csvError(err error) error {
+	var pe *csv.ParseError
+	if errors.As(err, &pe) {
+		return lineError{pe.Line, pe.Err}
+	}
+	return err
+}
+
+// readNDJSON отдаёт в add объекты из строк ndjson, пустые строки пропускаются
+func readNDJSON(body io.Reader, t *Table, add func(line int, rec Record, err error) error) error {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 64*1024), importMaxLine)
+	line := 0
+	for sc.Scan() {
+		line++
+		data := bytes.TrimSpace(sc.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		var fields map[string]interface{}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		err := dec.Decode(&fields)
+		if err == nil && fields == nil {
+			err = errors.New("not an object")
+		}
+		if err != nil {
+			err = badRequest(errors.New("bad json: " + err.Error()))
+		}
+		if err := add(line, Record{Fields: fields}, err); err != nil {
+			return err
+		}
+	}
+	if err := sc.Err(); err == bufio.ErrTooLong {
+		return lineError{line + 1, errors.New("line is longer than " + strconv.Itoa(importMaxLine) + " bytes")}
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestImport(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+
+	// значения приводятся по схеме, ошибки - по строкам, хорошие записи вставляются
+	report := struct{ Response ImportReport }{}
+	resp, body := doRequest(t, http.MethodPost, ts.URL+"/products/_import", map[string]string{"Content-Type": "text/csv; charset=utf-8"}, strings.NewReader(`name,price,weight,released,active,image,meta,user_id
+lamp,10.50,1.5,2017-01-02,true,aGVsbG8=,"{""a"":1}",1
+chair,abc,,,,,,
+sofa,5,,,false,,,100500
+lamp,7,,,,,,
+table
+very long name,1,,,,,,
+desk,3,,2017-13-40,,,,
+`), &report)
+	expected := ImportReport{
+		Inserted: 1,
+		Failed:   6,
+		Errors: []ImportError{
+			{3, "field price have invalid type"},
+			{6, "expected 8 fields, got 1"},
+			{7, "field name is longer than 10"},
+			{8, "field released have invalid type"},
+			{4, "foreign key violation"},
+			{5, "duplicate value for field name"},
+		},
+	}
+	if resp.StatusCode != http.StatusOK || !reflect.DeepEqual(report.Response, expected) {
+		t.Errorf("csv import: expected %+v, got %d %s", expected, resp.StatusCode, body)
+	}
+
+	// выгрузка того, что загрузили, - те же значения
+	_, body = doRequest(t, http.MethodGet, ts.URL+"/products?format=csv", nil, nil, nil)
+	exported := "id,name,price,weight,released,created,active,image,meta,user_id\n" +
+		`1,lamp,10.5,1.5,2017-01-02,,true,aGVsbG8=,"{""a"":1}",1` + "\n"
+	if body != exported {
+		t.Errorf("export after import: expected\n%s\ngot\n%s", exported, body)
+	}
+
+	report.Response = ImportReport{}
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/items/_import", map[string]string{"Content-Type": "application/x-ndjson"}, strings.NewReader(`{"title": "n1", "description": "d1"}
+
+[1]
+{"title": 5, "description": "bad"}
+{"title": "n2", "description": "d2", "updated": null}
+`), &report)
+	if resp.StatusCode != http.StatusOK || report.Response.Inserted != 2 || report.Response.Failed != 2 ||
+		report.Response.Errors[0].Line != 3 || !strings.HasPrefix(report.Response.Errors[0].Error, "bad json: ") ||
+		report.Response.Errors[1] != (ImportError{4, "field title have invalid type"}) {
+		t.Errorf("ndjson import: got %d %s", resp.StatusCode, body)
+	}
+
+	// оборванная кавычка - дальше не разобрать, то, что было до неё, вставляется
+	report.Response = ImportReport{}
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/items/_import?format=csv", map[string]string{"Content-Type": "text/plain"}, strings.NewReader("title,description\nq1,d1\n\"q2,d2\n"), &report)
+	if resp.StatusCode != http.StatusOK || report.Response.Inserted != 1 || report.Response.Failed != 1 || report.Response.Errors[0].Line != 3 {
+		t.Errorf("broken csv: got %d %s", resp.StatusCode, body)
+	}
+
+	// больше одной пачки, плохая запись - в середине второй
+	var lines []string
+	for i := 0; i < importBatch*2+100; i++ {
+		lines = append(lines, fmt.Sprintf(`{"name": "p%d", "price": %d}`, i%(importBatch+200), i))
+	}
+	report.Response = ImportReport{}
+	resp, body = doRequest(t, http.MethodPost, ts.URL+"/products/_import", map[string]string{"Content-Type": "application/x-ndjson"}, strings.NewReader(strings.Join(lines, "\n")), &report)
+	if resp.StatusCode != http.StatusOK || report.Response.Inserted != importBatch+200 || report.Response.Failed != importBatch-100 ||
+		len(report.Response.Errors) != importMaxErrors || report.Response.Errors[0] != (ImportError{importBatch + 201, "duplicate value for field name"}) {
+		t.Errorf("batches: got %d %+v", resp.StatusCode, report.Response)
+	}
+	var count int
+	db.QueryRow(`SELECT COUNT(*) FROM products`).Scan(&count)
+	if count != importBatch+201 {
+		t.Errorf("expected %d products, got %d", importBatch+201, count)
+	}
+
+	for _, c := range []struct {
+		method, path, contentType, data string
+		status                          int
+		msg                             string
+	}{
+		{http.MethodPost, "/items/_import", "application/xml", "<items/>", http.StatusUnsupportedMediaType, "expected text/csv or application/x-ndjson"},
+		{http.MethodPost, "/items/_import", "text/csv", "", http.StatusBadRequest, "empty request"},
+		{http.MethodPost, "/orders/_import", "text/csv", "title\nx\n", http.StatusNotFound, "unknown table"},
+		{http.MethodGet, "/items/_import", "", "", http.StatusMethodNotAllowed, "unknown method"},
+	} {
+		resp, body := doRequest(t, c.method, ts.URL+c.path, map[string]string{"Content-Type": c.contentType}, strings.NewReader(c.data), nil)
+		if resp.StatusCode != c.status || !strings.Contains(body, c.msg) {
+			t.Errorf("[%s %s %s] expected %d %q, got %d %s", c.method, c.path, c.contentType, c.status, c.msg, resp.StatusCode, body)
+		}
+	}
+}
@@ -3,17 +3,21 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
 	"bytes"
 	"encoding/json"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
 )
 
 // CaseResponse
@@ -26,14 +30,73 @@ type Case struct {
 	Status int
 	Result interface{}
 	Body   interface{}
+	Header map[string]string // заголовки запроса, например X-API-Key
 }
 
 var (
 	client = &http.Client{Timeout: time.Second}
 )
 
-func PrepareTestApis(db *sql.DB) {
-	qs := []string{
+// doRequest - запрос к тестовому серверу в обход runCases. body - io.Reader уходит как есть,
+// остальное - json'ом. Ответ возвращается как есть и, если передан result, разбирается в него как json
+func doRequest(t *testing.T, method, url string, header map[string]string, body, result interface{}) (*http.Response, string) {
+	var reqBody io.Reader
+	switch b := body.(type) {
+	case nil:
+	case io.Reader:
+		reqBody = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			t.Fatalf("cant pack json: %v", err)
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, _ := http.NewRequest(method, url, reqBody)
+	if _, ok := body.(io.Reader); !ok && body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("request error: %v", err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	if result != nil && len(data) > 0 {
+		if err := json.Unmarshal(data, result); err != nil {
+			t.Fatalf("cant unpack json %q: %v", data, err)
+		}
+	}
+	return resp, string(data)
+}
+
+// testDB - база для тестов. По умолчанию sqlite во временном файле с проверкой внешних ключей, докер не нужен.
+// DB_EXPLORER_DRIVER=mysql или postgres гоняет те же тесты на DB_EXPLORER_DSN (для mysql по умолчанию DSN из main.go)
+func testDB(t *testing.T) *sql.DB {
+	driver, dsn := os.Getenv("DB_EXPLORER_DRIVER"), os.Getenv("DB_EXPLORER_DSN")
+	switch {
+	case driver == "":
+		driver, dsn = "sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_foreign_keys=1"
+	case driver == "mysql" && dsn == "":
+		dsn = DSN
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		t.Fatalf("cant open %s: %v", driver, err)
+	}
+	err = db.Ping()
+	if err != nil {
+		t.Fatalf("cant connect to %s: %v", driver, err)
+	}
+	return db
+}
+
+// testSchemas - те же таблицы, что в sample_db.sql, для каждого диалекта
+var testSchemas = map[string][]string{
+	"mysql": {
 		`DROP TABLE IF EXISTS items;`,
 
 		`CREATE TABLE items (
@@ -62,9 +125,76 @@ func PrepareTestApis(db *sql.DB) {
 
 		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
 (1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
-	}
+	},
+	"sqlite": {
+		`DROP TABLE IF EXISTS items;`,
 
-	for _, q := range qs {
+		`CREATE TABLE items (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO items (id, title, description, updated) VALUES
+(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
+(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
+
+		`DROP TABLE IF EXISTS users;`,
+
+		`CREATE TABLE users (
+  user_id INTEGER PRIMARY KEY AUTOINCREMENT,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
+(1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+	},
+	"postgres": {
+		`DROP TABLE IF EXISTS items;`,
+
+		`CREATE TABLE items (
+  id serial PRIMARY KEY,
+  title varchar(255) NOT NULL,
+  description text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO items (id, title, description, updated) VALUES
+(1,	'database/sql',	'Рассказать про базы данных',	'rvasily'),
+(2,	'memcache',	'Рассказать про мемкеш с примером использования',	NULL);`,
+
+		// id вставлены руками, последовательность надо догнать
+		`SELECT setval('items_id_seq', 2);`,
+
+		`DROP TABLE IF EXISTS users;`,
+
+		`CREATE TABLE users (
+  user_id serial PRIMARY KEY,
+  login varchar(255) NOT NULL,
+  password varchar(255) NOT NULL,
+  email varchar(255) NOT NULL,
+  info text NOT NULL,
+  updated varchar(255) DEFAULT NULL
+);`,
+
+		`INSERT INTO users (user_id, login, password, email, info, updated) VALUES
+(1,	'rvasily',	'love',	'rvasily@example.com',	'none',	NULL);`,
+
+		`SELECT setval('users_user_id_seq', 1);`,
+	},
+}
+
+func PrepareTestApis(db *sql.DB) {
+	dialect, err := DialectFor(db)
+	if err != nil {
+		panic(err)
+	}
+	for _, q := range testSchemas[dialect.Name()] {
 		_, err := db.Exec(q)
 		if err != nil {
 			panic(err)
@@ -86,11 +216,7 @@ func CleanupTestApis(db *sql.DB) {
 }
 
 func TestApis(t *testing.T) {
-	db, err := sql.Open("mysql", DSN)
-	err = db.Ping()
-	if err != nil {
-		panic(err)
-	}
+	db := testDB(t)
 	CleanupTestApis(db)
 	PrepareTestApis(db)
 
@@ -525,9 +651,16 @@ func runCases(t *testing.T, ts *httptest.Server, db *sql.DB, cases []Case) {
 				panic(err)
 			}
 			reqBody := bytes.NewReader(data)
-			req, err = http.NewRequest(item.Method, ts.URL+item.Path, reqBody)
+			url := ts.URL + item.Path
+			if item.Query != "" {
+				url += "?" + item.Query
+			}
+			req, err = http.NewRequest(item.Method, url, reqBody)
 			req.Header.Add("Content-Type", "application/json")
 		}
+		for k, v := range item.Header {
+			req.Header.Set(k, v)
+		}
 
 		resp, err := client.Do(req)
 		if err != nil {
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// операции над таблицей в политике
+const (
+	OpRead   = "read"
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// Policy - кто и что может делать с таблицами. Загружается из json-файла:
+//
+//	{
+//	  "anonymous": "guest",
+//	  "principals": [
+//	    {"name": "admin", "api_keys": ["secret"], "tables": {"*": {"ops": ["read", "create", "update", "delete"]}}},
+//	    {"name": "rvasily", "tokens": ["token"], "vars": {"user_id": 1}, "tables": {
+//	      "users": {"ops": ["read", "update"], "hidden": ["password"], "masked": ["email"], "rows": {"user_id": "$user_id"}}
+//	    }}
+//	  ]
+//	}
+//
+// Ключ приходит в заголовке X-API-Key, токен - в Authorization: Bearer. Таблиц, которых нет в политике
+// принципала, для него не существует - 404, операции, которых нет в ops, - 403
+type Policy struct {
+	// Anonymous - принципал для запросов без ключа и токена, пусто - таким 401
+	Anonymous  string       `json:"anonymous"`
+	Principals []*Principal `json:"principals"`
+}
+
+type Principal struct {
+	Name    string   `json:"name"`
+	APIKeys []string `json:"api_keys"`
+	Tokens  []string `json:"tokens"`
+	// Vars - значения для rows: "$user_id" заменяется на vars.user_id
+	Vars map[string]interface{} `json:"vars"`
+	// Tables - права по таблицам, "*" - для всех, которых нет отдельно
+	Tables map[string]*TablePolicy `json:"tables"`
+}
+
+type TablePolicy struct {
+	Ops []string `json:"ops"`
+	// Hidden - колонок нет ни в ответах, ни в фильтрах
+	Hidden []string `json:"hidden"`
+	// Masked - колонки отдаются замаскированными: r*****y@example.com, фильтровать по ним нельзя
+	Masked []string `json:"masked"`
+	// Rows - видны и меняются только строки с такими значениями колонок
+	Rows map[string]interface{} `json:"rows"`
+}
+
+const principalKey ctxKey = 1
+
+type ctxKey int
+
+var (
+	errUnauthorized = ApiError{http.StatusUnauthorized, errors.New("unauthorized")}
+	errForbidden    = ApiError{http.StatusForbidden, errors.New("forbidden")}
+)
+
+// LoadPolicy читает политику из файла
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	// числа в vars и rows остаются json.Number: 1000000 не превращается в "1e+06"
+	dec.UseNumber()
+	if err := dec.Decode(p); err != nil {
+		return nil, fmt.Errorf("policy %s: %s", path, err)
+	}
+	names := make(map[string]bool)
+	for _, pr := range p.Principals {
+		if pr.Name == "" || names[pr.Name] {
+			return nil, fmt.Errorf("policy %s: principal name %q is empty or duplicated", path, pr.Name)
+		}
+		names[pr.Name] = true
+	}
+	if p.Anonymous != "" && !names[p.Anonymous] {
+		return nil, fmt.Errorf("policy %s: unknown anonymous principal %s", path, p.Anonymous)
+	}
+	return p, nil
+}
+
+// Authenticate - принципал запроса по ключу или токену
+func (p *Policy) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get("X-API-Key")
+	token := ""
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if key == "" && token == "" {
+		if p.Anonymous == "" {
+			return nil, errUnauthorized
+		}
+		return p.principal(p.Anonymous), nil
+	}
+	for _, pr := range p.Principals {
+		if key != "" && anyEqual(pr.APIKeys, key) || token != "" && anyEqual(pr.Tokens, token) {
+			return pr, nil
+		}
+	}
+	return nil, errUnauthorized
+}
+
+func (p *Policy) principal(name string) *Principal {
+	for _, pr := range p.Principals {
+		if pr.Name == name {
+			return pr
+		}
+	}
+	return nil
+}
+
+// anyEqual сравнивает секреты за одинаковое время, чтобы ключ нельзя было подобрать по таймингам
+func anyEqual(secrets []string, s string) bool {
+	found := false
+	for _, secret := range secrets {
+		if subtle.ConstantTimeCompare([]byte(secret), []byte(s)) == 1 {
+			found = true
+		}
+	}
+	return found
+}
+
+// table - права на таблицу, nil - таблицы для принципала нет
+func (pr *Principal) table(name string) *TablePolicy {
+	if tp, ok := pr.Tables[name]; ok {
+		return tp
+	}
+	return pr.Tables["*"]
+}
+
+// View - таблица такой, какой её видит принципал для операции op: без скрытых колонок,
+// с пометкой замаскированных и с условиями rows. nil-принципал (политики нет) видит всё
+func (pr *Principal) View(t *Table, op string) (*Table, error) {
+	if pr == nil {
+		return t, nil
+	}
+	tp := pr.table(t.Name)
+	if tp == nil {
+		return nil, errUnknownTable
+	}
+	if !contains(tp.Ops, op) {
+		return nil, errForbidden
+	}
+
+	view := *t
+	view.Columns = nil
+	for _, c := range t.Columns {
+		switch {
+		case contains(tp.Hidden, c.Name):
+			view.hidden = append(view.hidden, c)
+		case contains(tp.Masked, c.Name):
+			masked := *c
+			masked.Masked = true
+			view.Columns = append(view.Columns, &masked)
+		default:
+			view.Columns = append(view.Columns, c)
+		}
+	}
+	if view.PK() == nil {
+		// без первичного ключа записи не найти, это ошибка политики
+		view.PrimaryKey = ""
+	}
+
+	for name, v := range tp.Rows {
+		c := t.Column(name)
+		if c == nil {
+			return nil, fmt.Errorf("policy of %s: unknown column %s in rows of %s", pr.Name, name, t.Name)
+		}
+		if s, ok := v.(string); ok && strings.HasPrefix(s, "$") {
+			if v, ok = pr.Vars[s[1:]]; !ok {
+				return nil, fmt.Errorf("policy of %s: unknown var %s", pr.Name, s)
+			}
+		}
+		if f, ok := v.(float64); ok {
+			// политика собрана в коде, а не прочитана LoadPolicy
+			v = json.Number(strconv.FormatFloat(f, 'f', -1, 64))
+		}
+		val, err := c.Convert(v)
+		if err != nil {
+			return nil, fmt.Errorf("policy of %s: rows of %s: %s", pr.Name, t.Name, err)
+		}
+		view.filters = append(view.filters, Filter{Column: c, Op: "eq", Values: []interface{}{val}})
+	}
+	return &view, nil
+}
+
+// Readable - таблицы, которые принципал может читать
+func (pr *Principal) Readable(s *Schema) []string {
+	if pr == nil {
+		return s.Names
+	}
+	names := make([]string, 0, len(s.Names))
+	for _, name := range s.Names {
+		if tp := pr.table(name); tp != nil && contains(tp.Ops, OpRead) {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// project - запись из базы такой, какой её видит таблица-view: без скрытых колонок, с маской.
+// false - записи нет или она не проходит условия политики на строки
+func (t *Table) project(rec map[string]interface{}) (map[string]interface{}, bool) {
+	if rec == nil {
+		return nil, false
+	}
+	for _, f := range t.filters {
+		if fmt.Sprint(rec[f.Column.Name]) != fmt.Sprint(f.Values[0]) {
+			return nil, false
+		}
+	}
+	view := make(map[string]interface{}, len(t.Columns))
+	for _, c := range t.Columns {
+		v, ok := rec[c.Name]
+		if !ok {
+			continue
+		}
+		if c.Masked {
+			v = mask(v)
+		}
+		view[c.Name] = v
+	}
+	return view, true
+}
+
+// visible - изменение такое, каким его видит таблица-view. false - ни до, ни после запись не видна
+func (t *Table) visible(c Change) (Change, bool) {
+	before, okBefore := t.project(c.Before)
+	after, okAfter := t.project(c.After)
+	c.Before, c.After = before, after
+	return c, okBefore || okAfter
+}
+
+func withPrincipal(r *http.Request, pr *Principal) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalKey, pr))
+}
+
+// principalFrom - принципал, которого ServeHTTP положил в контекст, nil - политики нет
+func principalFrom(r *http.Request) *Principal {
+	pr, _ := r.Context().Value(principalKey).(*Principal)
+	return pr
+}
+
+// mask прячет середину значения: rvasily@example.com - r*****y@example.com, love - l**e
+func mask(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "***"
+	}
+	local, domain := s, ""
+	if i := strings.LastIndex(s, "@"); i > 0 {
+		local, domain = s[:i], s[i:]
+	}
+	runes := []rune(local)
+	if len(runes) <= 2 {
+		return strings.Repeat("*", len(runes)) + domain
+	}
+	return string(runes[0]) + strings.Repeat("*", len(runes)-2) + string(runes[len(runes)-1]) + domain
+}
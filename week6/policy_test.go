@@ -0,0 +1,386 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testPolicy = `{
+  "anonymous": "guest",
+  "principals": [
+    {"name": "admin", "api_keys": ["admin-key"], "tables": {"*": {"ops": ["read", "create", "update", "delete"]}}},
+    {"name": "rvasily", "tokens": ["rv-token"], "vars": {"user_id": 1}, "tables": {
+      "users": {"ops": ["read", "update"], "hidden": ["password"], "masked": ["email"], "rows": {"user_id": "$user_id"}},
+      "products": {"ops": ["read", "create", "update"], "rows": {"user_id": "$user_id"}},
+      "items": {"ops": ["read"]}
+    }},
+    {"name": "guest", "tables": {"items": {"ops": ["read"]}}}
+  ]
+}`
+
+func writePolicy(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPolicy(t *testing.T) {
+	for _, data := range []string{
+		`{"principals": [`,
+		`{"principals": [{"name": "a"}, {"name": "a"}]}`,
+		`{"principals": [{"api_keys": ["key"]}]}`,
+		`{"anonymous": "guest", "principals": [{"name": "admin"}]}`,
+	} {
+		if _, err := LoadPolicy(writePolicy(t, data)); err == nil {
+			t.Errorf("expected error for %s", data)
+		}
+	}
+	p, err := LoadPolicy(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Principals) != 3 || p.Anonymous != "guest" {
+		t.Errorf("bad policy: %+v", p)
+	}
+}
+
+func TestMask(t *testing.T) {
+	cases := []struct {
+		in, out interface{}
+	}{
+		{"rvasily@example.com", "r*****y@example.com"},
+		{"love", "l**e"},
+		{"ab", "**"},
+		{"я@почта.рф", "*@почта.рф"},
+		{nil, nil},
+		{42, "***"},
+	}
+	for _, c := range cases {
+		if out := mask(c.in); out != c.out {
+			t.Errorf("mask(%v): expected %v, got %v", c.in, c.out, out)
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+	p, err := LoadPolicy(writePolicy(t, testPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Policy = p
+
+	for _, q := range []string{
+		`INSERT INTO users (user_id, login, password, email, info) VALUES (2, 'ann', 'pass', 'ann@example.com', '')`,
+		`INSERT INTO products (id, name, price, user_id) VALUES (1, 'lamp', 10, 1), (2, 'chair', 20, 2)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	admin := map[string]string{"X-API-Key": "admin-key"}
+	rvasily := map[string]string{"Authorization": "Bearer rv-token"}
+	rvasilyMasked := CR{
+		"user_id": 1,
+		"login":   "rvasily",
+		"email":   "r*****y@example.com",
+		"info":    "none",
+		"updated": nil,
+	}
+	cases := []Case{
+		// без ключа - гость, ему видны только items
+		Case{
+			Path: "/",
+			Result: CR{
+				"response": CR{
+					"tables": []string{"items"},
+				},
+			},
+		},
+		Case{
+			Path:   "/users",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+			},
+		},
+		Case{
+			Path:   "/items/",
+			Method: http.MethodPut,
+			Status: http.StatusForbidden,
+			Body:   CR{"title": "guest", "description": "guest"},
+			Result: CR{
+				"error": "forbidden",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Status: http.StatusUnauthorized,
+			Header: map[string]string{"X-API-Key": "wrong"},
+			Result: CR{
+				"error": "unauthorized",
+			},
+		},
+
+		Case{
+			Path:   "/",
+			Header: rvasily,
+			Result: CR{
+				"response": CR{
+					"tables": []string{"items", "products", "users"},
+				},
+			},
+		},
+		// свои строки, без пароля, с замаскированной почтой
+		Case{
+			Path:   "/users",
+			Header: rvasily,
+			Result: CR{
+				"response": CR{
+					"records": []CR{rvasilyMasked},
+				},
+			},
+		},
+		Case{
+			Path:   "/users/2",
+			Header: rvasily,
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:   "/users",
+			Query:  "email[like]=rv%25",
+			Header: rvasily,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field email",
+			},
+		},
+		Case{
+			Path:   "/users",
+			Query:  "password=love",
+			Header: rvasily,
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field password",
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodDelete,
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error": "forbidden",
+			},
+		},
+		// пароль скрыт, поменять его нельзя - поле пропускается, как неизвестное
+		Case{
+			Path:   "/users/1",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Status: http.StatusBadRequest,
+			Body:   CR{"password": "hacked"},
+			Result: CR{
+				"error": "empty request",
+			},
+		},
+
+		// новая запись получает user_id из политики, чужой указать нельзя
+		Case{
+			Path:   "/products/",
+			Method: http.MethodPut,
+			Header: rvasily,
+			Body:   CR{"name": "desk", "price": 5},
+			Result: CR{
+				"response": CR{
+					"id": 3,
+				},
+			},
+		},
+		Case{
+			Path:   "/products/",
+			Method: http.MethodPut,
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Body:   CR{"name": "sofa", "price": 5, "user_id": 2},
+			Result: CR{
+				"error": "forbidden",
+			},
+		},
+		Case{
+			Path:   "/products/1",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Body:   CR{"user_id": 2},
+			Result: CR{
+				"error": "forbidden",
+			},
+		},
+		Case{
+			Path:   "/products/2",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Body:   CR{"price": 1},
+			Result: CR{
+				"response": CR{
+					"updated": 0,
+				},
+			},
+		},
+		Case{
+			Path:   "/products",
+			Query:  "price[gt]=0",
+			Method: http.MethodPatch,
+			Header: rvasily,
+			Body:   CR{"active": false},
+			Result: CR{
+				"response": CR{
+					"updated": 2,
+				},
+			},
+		},
+		Case{
+			Path:   "/products",
+			Method: http.MethodPatch,
+			Header: rvasily,
+			Status: http.StatusBadRequest,
+			Body:   CR{"active": false},
+			Result: CR{
+				"error": "filter is required",
+			},
+		},
+		Case{
+			Path:   "/users/1/products",
+			Query:  "fields=id,user_id,active",
+			Header: rvasily,
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "user_id": 1, "active": false},
+						CR{"id": 3, "user_id": 1, "active": false},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/products/1/user",
+			Header: rvasily,
+			Result: CR{
+				"response": CR{
+					"record": rvasilyMasked,
+				},
+			},
+		},
+		Case{
+			Path:   "/_batch",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Body: []CR{
+				CR{"op": "update", "table": "products", "id": 1, "data": CR{"price": 7}},
+				CR{"op": "delete", "table": "products", "id": 1},
+			},
+			Result: CR{
+				"error": "operation 1: forbidden",
+			},
+		},
+		Case{
+			Path:   "/_schema/refresh",
+			Method: http.MethodPost,
+			Header: rvasily,
+			Status: http.StatusForbidden,
+			Result: CR{
+				"error": "forbidden",
+			},
+		},
+
+		// админу видно всё
+		Case{
+			Path:   "/products",
+			Query:  "fields=id,user_id,active",
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1, "user_id": 1, "active": false},
+						CR{"id": 2, "user_id": 2, "active": true},
+						CR{"id": 3, "user_id": 1, "active": false},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/users/2",
+			Header: admin,
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"user_id":  2,
+						"login":    "ann",
+						"password": "pass",
+						"email":    "ann@example.com",
+						"info":     "",
+						"updated":  nil,
+					},
+				},
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+}
+
+// большие id в vars не должны превращаться в "1e+06"
+func TestPolicyLargeVar(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+	p, err := LoadPolicy(writePolicy(t, `{"principals": [
+    {"name": "big", "tokens": ["big-token"], "vars": {"user_id": 1000000}, "tables": {
+      "users": {"ops": ["read"], "hidden": ["password"], "rows": {"user_id": "$user_id"}}
+    }}
+  ]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Policy = p
+
+	if _, err := db.Exec(`INSERT INTO users (user_id, login, password, email, info) VALUES (1000000, 'big', 'pass', 'big@example.com', '')`); err != nil {
+		t.Fatal(err)
+	}
+
+	big := map[string]string{"Authorization": "Bearer big-token"}
+	cases := []Case{
+		Case{
+			Path:   "/users",
+			Header: big,
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"user_id": 1000000, "login": "big", "email": "big@example.com", "info": "", "updated": nil},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/users/1000000",
+			Header: big,
+			Result: CR{
+				"response": CR{
+					"record": CR{"user_id": 1000000, "login": "big", "email": "big@example.com", "info": "", "updated": nil},
+				},
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+}
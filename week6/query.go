@@ -0,0 +1,248 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ListQuery - параметры GET /$table:
+//
+//	?limit=5&offset=7         - страница, limit по-умолчанию 5, offset 0
+//	?fields=id,title          - только эти колонки
+//	?sort=-updated,id         - сортировка, минус - по убыванию
+//	?name=ann                 - колонка равна значению
+//	?age[gte]=18              - сравнение: eq, ne, gt, gte, lt, lte, like
+//	?id[in]=1,2,3             - одно из значений
+//	?updated[null]=true       - IS NULL, false - IS NOT NULL
+//	?include=user,products    - добавить связанные записи, см. Relation
+//	?format=csv               - выгрузить все подходящие записи, см. Export
+//
+// Имена колонок сверяются со схемой, значения уходят в запрос только плейсхолдерами
+type ListQuery struct {
+	Fields  []*Column
+	Filters []Filter
+	Sort    []Sort
+	Include []*Relation
+	Limit   int
+	Offset  int
+}
+
+type Filter struct {
+	Column *Column
+	Op     string
+	Values []interface{}
+}
+
+type Sort struct {
+	Column *Column
+	Desc   bool
+}
+
+const (
+	defaultLimit  = 5
+	defaultOffset = 0
+	// maxLimit - больше записей на странице списка не отдаём, сколько бы ни попросили.
+	// к выгрузке не относится, она не копит записи в памяти
+	maxLimit = 100
+)
+
+// filterOps - операторы фильтра в SQL, in и null собираются отдельно
+var filterOps = map[string]string{
+	"eq":   "=",
+	"ne":   "<>",
+	"gt":   ">",
+	"gte":  ">=",
+	"lt":   "<",
+	"lte":  "<=",
+	"like": "LIKE",
+	"in":   "IN",
+	"null": "IS NULL",
+}
+
+// ParseListQuery разбирает параметры списка. limit и offset, которые не число, заменяются
+// значениями по умолчанию, как и раньше; всё остальное непонятное - ошибка
+func ParseListQuery(t *Table, q url.Values) (*ListQuery, error) {
+	lq := &ListQuery{
+		Limit:  queryInt(q, "limit", defaultLimit),
+		Offset: queryInt(q, "offset", defaultOffset),
+	}
+
+	if fields := q.Get("fields"); fields != "" {
+		for _, name := range strings.Split(fields, ",") {
+			c := t.Column(strings.TrimSpace(name))
+			if c == nil {
+				return nil, errors.New("unknown field " + name)
+			}
+			lq.Fields = append(lq.Fields, c)
+		}
+	}
+
+	if order := q.Get("sort"); order != "" {
+		for _, name := range strings.Split(order, ",") {
+			name = strings.TrimSpace(name)
+			s := Sort{Desc: strings.HasPrefix(name, "-")}
+			name = strings.TrimPrefix(name, "-")
+			// по замаскированной колонке сортировать нельзя, порядок выдаёт значения
+			if s.Column = t.Column(name); s.Column == nil || s.Column.Masked {
+				return nil, errors.New("unknown field " + name)
+			}
+			lq.Sort = append(lq.Sort, s)
+		}
+	}
+
+	include, err := parseInclude(t, q)
+	if err != nil {
+		return nil, err
+	}
+	lq.Include = include
+
+	for key, values := range q {
+		switch key {
+		case "limit", "offset", "fields", "sort", "include", "format":
+			continue
+		}
+		f, err := parseFilter(t, key, values)
+		if err != nil {
+			return nil, err
+		}
+		lq.Filters = append(lq.Filters, f)
+	}
+	// порядок параметров в url.Values случайный, а запрос должен быть одинаковым
+	sort.Slice(lq.Filters, func(i, j int) bool {
+		a, b := lq.Filters[i], lq.Filters[j]
+		return a.Column.Name < b.Column.Name || a.Column.Name == b.Column.Name && a.Op < b.Op
+	})
+	// условия политики на строки - всегда последними
+	lq.Filters = append(lq.Filters, t.filters...)
+	return lq, nil
+}
+
+func queryInt(q url.Values, name string, def int) int {
+	n, err := strconv.Atoi(q.Get(name))
+	if err != nil || n < 0 {
+		return def
+	}
+	return n
+}
+
+// parseFilter - name или name[op] и его значения
+func parseFilter(t *Table, key string, values []string) (Filter, error) {
+	name, op := key, "eq"
+	if i := strings.Index(key, "["); i > 0 && strings.HasSuffix(key, "]") {
+		name, op = key[:i], key[i+1:len(key)-1]
+	}
+	f := Filter{Column: t.Column(name), Op: op}
+	if f.Column == nil || f.Column.Masked {
+		return f, errors.New("unknown field " + name)
+	}
+	if _, ok := filterOps[op]; !ok {
+		return f, errors.New("unknown operator " + op + " for field " + name)
+	}
+
+	switch op {
+	case "null":
+		isNull, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return f, errors.New("field " + name + "[null] must be true or false")
+		}
+		if !isNull {
+			f.Op = "notnull"
+		}
+		return f, nil
+	case "in":
+		// ?id[in]=1,2 и ?id[in]=1&id[in]=2 - одно и то же
+		var split []string
+		for _, v := range values {
+			split = append(split, strings.Split(v, ",")...)
+		}
+		values = split
+	case "like":
+		// шаблон - всегда строка, даже для числовой колонки
+		f.Values = []interface{}{values[0]}
+		return f, nil
+	default:
+		// несколько одинаковых условий не имеют смысла, берём первое, как r.URL.Query().Get
+		values = values[:1]
+	}
+	for _, v := range values {
+		val, err := f.Column.ConvertString(v)
+		if err != nil {
+			return f, err
+		}
+		f.Values = append(f.Values, val)
+	}
+	return f, nil
+}
+
+// Where - условие без слова WHERE и его параметры. Нумерация плейсхолдеров начинается с first
+func (lq *ListQuery) Where(d Dialect, first int) (string, []interface{}) {
+	var (
+		conds  []string
+		params []interface{}
+	)
+	next := func() string {
+		return d.Placeholder(first + len(params))
+	}
+	for _, f := range lq.Filters {
+		col := d.Quote(f.Column.Name)
+		switch f.Op {
+		case "null":
+			conds = append(conds, col+" IS NULL")
+		case "notnull":
+			conds = append(conds, col+" IS NOT NULL")
+		case "in":
+			placeholders := make([]string, len(f.Values))
+			for i, v := range f.Values {
+				placeholders[i] = next()
+				params = append(params, v)
+			}
+			conds = append(conds, col+" IN ("+strings.Join(placeholders, ",")+")")
+		default:
+			conds = append(conds, col+" "+filterOps[f.Op]+" "+next())
+			params = append(params, f.Values[0])
+		}
+	}
+	return strings.Join(conds, " AND "), params
+}
+
+// SQL - SELECT по таблице со всеми условиями, сортировкой и страницей
+func (lq *ListQuery) SQL(d Dialect, t *Table) (string, []interface{}) {
+	fields := "*"
+	if len(lq.Fields) > 0 {
+		quoted := make([]string, len(lq.Fields))
+		for i, c := range lq.Fields {
+			quoted[i] = d.Quote(c.Name)
+		}
+		fields = strings.Join(quoted, ",")
+	}
+	query := "SELECT " + fields + " FROM " + d.Quote(t.Name)
+
+	where, params := lq.Where(d, 1)
+	if where != "" {
+		query += " WHERE " + where
+	}
+
+	// без сортировки страницы не стабильны, по умолчанию - по первичному ключу
+	by := lq.Sort
+	if len(by) == 0 && t.PK() != nil {
+		by = []Sort{{Column: t.PK()}}
+	}
+	if len(by) > 0 {
+		order := make([]string, len(by))
+		for i, s := range by {
+			order[i] = d.Quote(s.Column.Name)
+			if s.Desc {
+				order[i] += " DESC"
+			}
+		}
+		query += " ORDER BY " + strings.Join(order, ",")
+	}
+
+	limit := d.Placeholder(len(params) + 1)
+	offset := d.Placeholder(len(params) + 2)
+	params = append(params, lq.Limit, lq.Offset)
+	return query + " " + d.Limit(limit, offset), params
+}
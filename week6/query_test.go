@@ -0,0 +1,173 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+)
+
+// testUsersTable - users из testSchemas, как её видит LoadSchema
+func testUsersTable() *Table {
+	t := &Table{Name: "users", PrimaryKey: "user_id"}
+	for _, c := range []Column{
+		{Name: "user_id", Type: "int(11)", PrimaryKey: true, AutoIncrement: true},
+		{Name: "login", Type: "varchar(255)"},
+		{Name: "updated", Type: "varchar(255)", Nullable: true},
+	} {
+		c := c
+		c.Kind, c.Size = kindOf(c.Type)
+		t.Columns = append(t.Columns, &c)
+	}
+	return t
+}
+
+func TestListQuerySQL(t *testing.T) {
+	users := testUsersTable()
+	q, _ := url.ParseQuery("login[like]=rv%25&user_id[in]=1,2&updated[null]=false&user_id[gte]=1&sort=-login,user_id&fields=user_id,login&limit=10&offset=20")
+	lq, err := ParseListQuery(users, q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query, params := lq.SQL(Postgres{}, users)
+	expected := `SELECT "user_id","login" FROM "users"` +
+		` WHERE "login" LIKE $1 AND "updated" IS NOT NULL AND "user_id" >= $2 AND "user_id" IN ($3,$4)` +
+		` ORDER BY "login" DESC,"user_id" LIMIT $5 OFFSET $6`
+	if query != expected {
+		t.Errorf("bad query:\n got %s\nwant %s", query, expected)
+	}
+	expectedParams := []interface{}{"rv%", int64(1), int64(1), int64(2), 10, 20}
+	if !reflect.DeepEqual(params, expectedParams) {
+		t.Errorf("bad params: %#v", params)
+	}
+
+	// без параметров - первая страница по первичному ключу
+	lq, _ = ParseListQuery(users, url.Values{})
+	query, params = lq.SQL(MySQL{}, users)
+	if query != "SELECT * FROM `users` ORDER BY `user_id` LIMIT ? OFFSET ?" || !reflect.DeepEqual(params, []interface{}{5, 0}) {
+		t.Errorf("bad default query: %s %v", query, params)
+	}
+}
+
+func TestListQueryErrors(t *testing.T) {
+	users := testUsersTable()
+	cases := map[string]string{
+		"fields=user_id,password": "unknown field password",
+		"sort=-password":          "unknown field password",
+		"password=love":           "unknown field password",
+		"login[regexp]=.*":        "unknown operator regexp for field login",
+		"user_id[gt]=1 OR 1=1":    "field user_id have invalid type",
+		"user_id[in]=1,x":         "field user_id have invalid type",
+		"updated[null]=maybe":     "field updated[null] must be true or false",
+		"login%3B+DROP+TABLE=1":   "unknown field login; DROP TABLE",
+		"%60login%60%5Beq%5D=ann": "unknown field `login`",
+	}
+	for raw, msg := range cases {
+		q, _ := url.ParseQuery(raw)
+		_, err := ParseListQuery(users, q)
+		if err == nil || err.Error() != msg {
+			t.Errorf("[%s] expected %q, got %v", raw, msg, err)
+		}
+	}
+}
+
+func TestListFilters(t *testing.T) {
+	db := testDB(t)
+	CleanupTestApis(db)
+	PrepareTestApis(db)
+	defer CleanupTestApis(db)
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	cases := []Case{
+		// offset за концом таблицы - пустая страница, а не вся таблица
+		Case{
+			Path:  "/items",
+			Query: "limit=5&offset=10",
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=id,title&sort=-id",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 2, "title": "memcache"},
+						CR{"id": 1, "title": "database/sql"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=id&updated[null]=true",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 2},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=id&title[like]=data%25&id[in]=1,2",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=title&id[gt]=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"title": "memcache"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/items",
+			Query: "fields=id&title=memcache",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 2},
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "sort=title%3BDROP%20TABLE%20items",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown field title;DROP TABLE items",
+			},
+		},
+		Case{
+			Path:   "/items",
+			Query:  "id[gte]=abc",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field id have invalid type",
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+}
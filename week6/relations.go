@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Relation - связь таблицы с другой по внешнему ключу. Строится для обеих сторон:
+// products.user_id -> users даёт products.user (одна запись) и users.products (список)
+type Relation struct {
+	Name string `json:"name"`
+	// Many - связанных записей много: users.products
+	Many bool `json:"many"`
+	// Table - связанная таблица
+	Table string `json:"table"`
+	// Column - колонка в этой таблице, RefColumn - равная ей колонка в связанной
+	Column    string `json:"column"`
+	RefColumn string `json:"ref_column"`
+}
+
+// includeBatch - сколько значений подставлять в один IN, у sqlite лимит на число параметров
+const includeBatch = 500
+
+// buildRelations раскладывает внешние ключи схемы по таблицам с обеих сторон.
+// Имя связи к одной записи - колонка без _id (author_id - author), списка - таблица (products).
+// Если таблица ссылается на одну и ту же несколько раз, список называется table_column: messages_sender
+func buildRelations(s *Schema) {
+	for _, name := range s.Names {
+		t := s.Tables[name]
+		refs := make(map[string]int)
+		for _, c := range t.Columns {
+			if c.ForeignKey != nil {
+				refs[c.ForeignKey.Table]++
+			}
+		}
+		for _, c := range t.Columns {
+			fk := c.ForeignKey
+			if fk == nil || s.Tables[fk.Table] == nil {
+				continue
+			}
+			one := strings.TrimSuffix(c.Name, "_id")
+			if one == c.Name || t.Column(one) != nil {
+				one = c.Name + "_ref"
+			}
+			t.addRelation(&Relation{Name: one, Table: fk.Table, Column: c.Name, RefColumn: fk.Column})
+
+			many := t.Name
+			if refs[fk.Table] > 1 {
+				many = t.Name + "_" + strings.TrimSuffix(c.Name, "_id")
+			}
+			s.Tables[fk.Table].addRelation(&Relation{Name: many, Many: true, Table: t.Name, Column: fk.Column, RefColumn: c.Name})
+		}
+	}
+}
+
+func (t *Table) addRelation(rel *Relation) {
+	if t.Relations == nil {
+		t.Relations = make(map[string]*Relation)
+	}
+	t.Relations[rel.Name] = rel
+}
+
+// parseInclude - связи из ?include=user,products
+func parseInclude(t *Table, q url.Values) ([]*Relation, error) {
+	var rels []*Relation
+	for _, v := range q["include"] {
+		for _, name := range strings.Split(v, ",") {
+			rel := t.Relations[strings.TrimSpace(name)]
+			if rel == nil {
+				return nil, errors.New("unknown relation " + name)
+			}
+			rels = append(rels, rel)
+		}
+	}
+	return rels, nil
+}
+
+// include добавляет в записи связанные: одну запись или список под именем связи.
+// На каждую связь - один запрос с IN по всем записям сразу (кусками по includeBatch), а не запрос на запись
+func (h *Handler) include(r *http.Request, t *Table, records []map[string]interface{}, rels []*Relation) error {
+	for _, rel := range rels {
+		if len(records) > 0 {
+			if _, ok := records[0][rel.Column]; !ok {
+				return badRequest(errors.New("field " + rel.Column + " is required for include " + rel.Name))
+			}
+		}
+		target, err := h.table(r, rel.Table, OpRead)
+		if err != nil {
+			return err
+		}
+
+		var values []interface{}
+		seen := make(map[string]bool)
+		for _, rec := range records {
+			v := rec[rel.Column]
+			if v == nil || seen[fmt.Sprint(v)] {
+				continue
+			}
+			seen[fmt.Sprint(v)] = true
+			values = append(values, v)
+		}
+
+		related := make(map[string][]map[string]interface{})
+		for len(values) > 0 {
+			n := len(values)
+			if n > includeBatch {
+				n = includeBatch
+			}
+			found, err := h.selectIn(target, rel.RefColumn, values[:n])
+			if err != nil {
+				return err
+			}
+			for _, rec := range found {
+				key := fmt.Sprint(rec[rel.RefColumn])
+				related[key] = append(related[key], rec)
+			}
+			values = values[n:]
+		}
+
+		for _, rec := range records {
+			found := related[fmt.Sprint(rec[rel.Column])]
+			if rec[rel.Column] == nil {
+				found = nil
+			}
+			switch {
+			case rel.Many && found == nil:
+				rec[rel.Name] = []map[string]interface{}{}
+			case rel.Many:
+				rec[rel.Name] = found
+			case len(found) > 0:
+				rec[rel.Name] = found[0]
+			default:
+				rec[rel.Name] = nil
+			}
+		}
+	}
+	return nil
+}
+
+// selectIn - записи таблицы, у которых column - одно из values (и которые видны по политике)
+func (h *Handler) selectIn(t *Table, column string, values []interface{}) ([]map[string]interface{}, error) {
+	d := h.Dialect
+	placeholders := make([]string, len(values))
+	for i := range values {
+		placeholders[i] = d.Placeholder(i + 1)
+	}
+	query := "SELECT * FROM " + d.Quote(t.Name) + " WHERE " + d.Quote(column) + " IN (" + strings.Join(placeholders, ",") + ")"
+	if len(t.filters) > 0 {
+		scope, params := (&ListQuery{Filters: t.filters}).Where(d, len(values)+1)
+		query += " AND " + scope
+		values = append(values, params...)
+	}
+	if t.PrimaryKey != "" {
+		query += " ORDER BY " + d.Quote(t.PrimaryKey)
+	}
+	rows, err := h.DB.Query(query, values...)
+	if err != nil {
+		return nil, err
+	}
+	return GetResult(t, rows)
+}
+
+// GET /$table/$id/$relation - связанные записи: /users/1/products - товары пользователя
+// (с теми же фильтрами, что и список), /products/1/user - его пользователь
+func (h *Handler) GetRelated(w http.ResponseWriter, r *http.Request, t *Table, base, name string) error {
+	rel := t.Relations[name]
+	if rel == nil {
+		return ApiError{http.StatusNotFound, errors.New("unknown relation " + name)}
+	}
+	target, err := h.table(r, rel.Table, OpRead)
+	if err != nil {
+		return err
+	}
+	pk, id, err := recordID(t, base)
+	if err != nil {
+		return err
+	}
+
+	// значение связи у самой записи, заодно проверка, что она есть
+	d := h.Dialect
+	var value interface{}
+	where, params := h.recordWhere(t, pk, id, 1)
+	err = h.DB.QueryRow("SELECT "+d.Quote(rel.Column)+" FROM "+d.Quote(t.Name)+" WHERE "+where, params...).Scan(&value)
+	if err != nil {
+		return err
+	}
+
+	q := r.URL.Query()
+	if !rel.Many {
+		if value == nil {
+			return sendResponse(w, map[string]interface{}{"record": nil})
+		}
+		found, err := h.selectIn(target, rel.RefColumn, []interface{}{value})
+		if err != nil {
+			return err
+		}
+		if len(found) == 0 {
+			return errRecordNotFound
+		}
+		rels, err := parseInclude(target, q)
+		if err != nil {
+			return badRequest(err)
+		}
+		if err := h.include(r, target, found[:1], rels); err != nil {
+			return err
+		}
+		return sendResponse(w, map[string]interface{}{"record": found[0]})
+	}
+
+	lq, err := ParseListQuery(target, q)
+	if err != nil {
+		return badRequest(err)
+	}
+	// фильтр по связи - такой же, как ?user_id=1
+	lq.Filters = append(lq.Filters, Filter{Column: target.Column(rel.RefColumn), Op: "eq", Values: []interface{}{value}})
+	return h.list(w, r, target, lq)
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+)
+
+func TestBuildRelations(t *testing.T) {
+	users := &Table{Name: "users", PrimaryKey: "id", Columns: []*Column{{Name: "id", PrimaryKey: true}}}
+	messages := &Table{Name: "messages", PrimaryKey: "id", Columns: []*Column{
+		{Name: "id", PrimaryKey: true},
+		{Name: "sender_id", ForeignKey: &ForeignKey{Table: "users", Column: "id"}},
+		{Name: "receiver_id", ForeignKey: &ForeignKey{Table: "users", Column: "id"}},
+		{Name: "owner", ForeignKey: &ForeignKey{Table: "users", Column: "id"}},
+	}}
+	s := &Schema{
+		Tables: map[string]*Table{"users": users, "messages": messages},
+		Names:  []string{"messages", "users"},
+	}
+	buildRelations(s)
+
+	var names []string
+	for name := range messages.Relations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) != 3 || names[0] != "owner_ref" || names[1] != "receiver" || names[2] != "sender" {
+		t.Errorf("bad messages relations: %v", names)
+	}
+	if rel := messages.Relations["sender"]; rel.Many || rel.Column != "sender_id" || rel.RefColumn != "id" {
+		t.Errorf("bad sender: %+v", rel)
+	}
+
+	// на users ссылаются трижды - списки различаются по колонке
+	for _, name := range []string{"messages_sender", "messages_receiver", "messages_owner"} {
+		rel := users.Relations[name]
+		if rel == nil || !rel.Many || rel.Table != "messages" || rel.Column != "id" {
+			t.Errorf("bad %s: %+v", name, rel)
+		}
+	}
+}
+
+func TestRelations(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+
+	for _, q := range []string{
+		`INSERT INTO users (user_id, login, password, email, info) VALUES (2, 'ann', 'pass', 'ann@example.com', '')`,
+		`INSERT INTO products (id, name, price, user_id) VALUES (1, 'lamp', 10, 1), (2, 'chair', 20, 1), (3, 'table', 30, NULL)`,
+	} {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rvasily := CR{
+		"user_id":  1,
+		"login":    "rvasily",
+		"password": "love",
+		"email":    "rvasily@example.com",
+		"info":     "none",
+		"updated":  nil,
+	}
+	cases := []Case{
+		Case{
+			Path:  "/products/1",
+			Query: "include=user",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":       1,
+						"name":     "lamp",
+						"price":    10,
+						"weight":   nil,
+						"released": nil,
+						"created":  nil,
+						"active":   true,
+						"image":    nil,
+						"meta":     nil,
+						"user_id":  1,
+						"user":     rvasily,
+					},
+				},
+			},
+		},
+		// у каждого пользователя - свои товары, у кого нет - пустой список
+		Case{
+			Path:  "/users",
+			Query: "fields=user_id,login&include=products",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"user_id": 1,
+							"login":   "rvasily",
+							"products": []CR{
+								CR{"id": 1, "name": "lamp", "price": 10, "weight": nil, "released": nil, "created": nil,
+									"active": true, "image": nil, "meta": nil, "user_id": 1},
+								CR{"id": 2, "name": "chair", "price": 20, "weight": nil, "released": nil, "created": nil,
+									"active": true, "image": nil, "meta": nil, "user_id": 1},
+							},
+						},
+						CR{
+							"user_id":  2,
+							"login":    "ann",
+							"products": []CR{},
+						},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/products",
+			Query: "fields=id,user_id&include=user&sort=-id&limit=2",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 3, "user_id": nil, "user": nil},
+						CR{"id": 2, "user_id": 1, "user": rvasily},
+					},
+				},
+			},
+		},
+		// вложенный список - те же фильтры и сортировка, что у обычного
+		Case{
+			Path:  "/users/1/products",
+			Query: "fields=id,name&sort=-price",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 2, "name": "chair"},
+						CR{"id": 1, "name": "lamp"},
+					},
+				},
+			},
+		},
+		Case{
+			Path:  "/users/1/products",
+			Query: "fields=id&price[lt]=15",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{"id": 1},
+					},
+				},
+			},
+		},
+		Case{
+			Path: "/users/2/products",
+			Result: CR{
+				"response": CR{
+					"records": []CR{},
+				},
+			},
+		},
+		Case{
+			Path: "/products/1/user",
+			Result: CR{
+				"response": CR{
+					"record": rvasily,
+				},
+			},
+		},
+		Case{
+			Path: "/products/3/user",
+			Result: CR{
+				"response": CR{
+					"record": nil,
+				},
+			},
+		},
+
+		// ошибки
+		Case{
+			Path:   "/users/100500/products",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+		Case{
+			Path:   "/users/1/orders",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown relation orders",
+			},
+		},
+		Case{
+			Path:   "/users/1",
+			Query:  "include=orders",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "unknown relation orders",
+			},
+		},
+		Case{
+			Path:   "/products",
+			Query:  "fields=id&include=user",
+			Status: http.StatusBadRequest,
+			Result: CR{
+				"error": "field user_id is required for include user",
+			},
+		},
+		Case{
+			Path:   "/users/1/products",
+			Method: http.MethodDelete,
+			Status: http.StatusMethodNotAllowed,
+			Result: CR{
+				"error": "unknown method",
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+}
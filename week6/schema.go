@@ -0,0 +1,427 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Schema - таблицы базы, загружаются один раз при старте и по запросу POST /_schema/refresh.
+// Все запросы проверяются по ней, в базу за описанием таблиц никто больше не ходит
+type Schema struct {
+	Tables map[string]*Table `json:"tables"`
+	// имена таблиц по алфавиту, для GET /
+	Names []string `json:"-"`
+}
+
+type Table struct {
+	Name    string    `json:"name"`
+	Columns []*Column `json:"columns"`
+	// имя колонки первичного ключа, пусто - таблица только на чтение списком
+	PrimaryKey string `json:"primary_key,omitempty"`
+	// связи по внешним ключам, своим и чужим, по имени связи
+	Relations map[string]*Relation `json:"relations,omitempty"`
+
+	// у таблицы, какой её видит принципал (Principal.View): скрытые колонки и условия на строки
+	hidden  []*Column
+	filters []Filter
+}
+
+// Column - колонка таблицы. Первую часть заполняет Dialect, Kind и Size - LoadSchema по типу
+type Column struct {
+	Name string `json:"name"`
+	// тип в нижнем регистре и в записи mysql: int, varchar(255), text
+	Type          string  `json:"type"`
+	Nullable      bool    `json:"nullable"`
+	Default       *string `json:"default"`
+	PrimaryKey    bool    `json:"primary_key"`
+	AutoIncrement bool    `json:"auto_increment"`
+	Unique        bool    `json:"unique"`
+	// на что ссылается колонка, nil - ни на что
+	ForeignKey *ForeignKey `json:"foreign_key,omitempty"`
+
+	// int, float, decimal, bool, string, datetime, date, time, bytes, json
+	Kind string `json:"kind"`
+	// длина для char и varchar, 0 - без ограничения
+	Size int `json:"size,omitempty"`
+	// Masked - значение отдаётся замаскированным, см. Principal.View
+	Masked bool `json:"masked,omitempty"`
+}
+
+// ForeignKey - колонка ссылается на колонку другой таблицы
+type ForeignKey struct {
+	Table  string `json:"table"`
+	Column string `json:"column"`
+}
+
+// LoadSchema читает описание всех таблиц через диалект
+func LoadSchema(db *sql.DB, d Dialect) (*Schema, error) {
+	names, err := d.Tables(db)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{
+		Tables: make(map[string]*Table, len(names)),
+		Names:  names,
+	}
+	for _, name := range names {
+		columns, err := d.Columns(db, name)
+		if err != nil {
+			return nil, fmt.Errorf("columns of %s: %s", name, err)
+		}
+		fks, err := d.ForeignKeys(db, name)
+		if err != nil {
+			return nil, fmt.Errorf("foreign keys of %s: %s", name, err)
+		}
+		t := &Table{Name: name}
+		for i := range columns {
+			c := &columns[i]
+			c.Kind, c.Size = kindOf(c.Type)
+			if fk, ok := fks[c.Name]; ok {
+				c.ForeignKey = &fk
+			}
+			if c.PrimaryKey && t.PrimaryKey == "" {
+				t.PrimaryKey = c.Name
+			}
+			t.Columns = append(t.Columns, c)
+		}
+		s.Tables[name] = t
+	}
+	for _, t := range s.Tables {
+		for _, c := range t.Columns {
+			if fk := c.ForeignKey; fk != nil && fk.Column == "" && s.Tables[fk.Table] != nil {
+				fk.Column = s.Tables[fk.Table].PrimaryKey
+			}
+		}
+	}
+	buildRelations(s)
+	return s, nil
+}
+
+// Column - колонка по имени, nil если такой нет
+func (t *Table) Column(name string) *Column {
+	for _, c := range t.Columns {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// PK - колонка первичного ключа, nil если ключа нет
+func (t *Table) PK() *Column {
+	return t.Column(t.PrimaryKey)
+}
+
+// kinds - базовый тип без размера и unsigned в вид значения
+var kinds = map[string]string{
+	"tinyint": "int", "smallint": "int", "mediumint": "int", "int": "int", "integer": "int", "bigint": "int",
+	"serial": "int", "bigserial": "int", "int2": "int", "int4": "int", "int8": "int", "year": "int",
+	"float": "float", "double": "float", "real": "float", "float4": "float", "float8": "float",
+	"decimal": "decimal", "numeric": "decimal", "money": "decimal",
+	"bool": "bool", "boolean": "bool", "bit": "bool",
+	"char": "string", "varchar": "string", "text": "string", "tinytext": "string", "mediumtext": "string",
+	"longtext": "string", "enum": "string", "set": "string", "uuid": "string", "clob": "string",
+	"datetime": "datetime", "timestamp": "datetime", "timestamptz": "datetime", "date": "date", "time": "time",
+	"blob": "bytes", "tinyblob": "bytes", "mediumblob": "bytes", "longblob": "bytes",
+	"binary": "bytes", "varbinary": "bytes", "bytea": "bytes",
+	"json": "json", "jsonb": "json",
+}
+
+// kindOf - вид значения колонки по её типу: int(11) unsigned - int, varchar(100) - string длиной до 100
+func kindOf(sqlType string) (kind string, size int) {
+	base, args := sqlType, ""
+	if i := strings.Index(sqlType, "("); i >= 0 {
+		base = sqlType[:i]
+		if j := strings.Index(sqlType[i:], ")"); j > 0 {
+			args = sqlType[i+1 : i+j]
+		}
+	}
+	full := strings.TrimSpace(base)
+	// "timestamp with time zone", "double precision", "bigint unsigned"
+	base = full
+	if i := strings.Index(base, " "); i > 0 {
+		base = base[:i]
+	}
+	kind, ok := kinds[base]
+	if !ok {
+		// sqlite разрешает любое имя типа, дальше как у него: INT где-то внутри - целое и так далее
+		switch {
+		case strings.Contains(full, "int"):
+			kind = "int"
+		case strings.Contains(full, "char"), strings.Contains(full, "clob"), strings.Contains(full, "text"):
+			kind = "string"
+		case strings.Contains(full, "real"), strings.Contains(full, "floa"), strings.Contains(full, "doub"):
+			kind = "float"
+		default:
+			kind = "string"
+		}
+	}
+	// tinyint(1) и bit(1) в mysql - это bool
+	if (base == "tinyint" || base == "bit") && args == "1" {
+		return "bool", 0
+	}
+	if base == "bit" {
+		return "int", 0
+	}
+	if kind == "string" && (strings.HasSuffix(base, "char") || base == "character") {
+		size, _ = strconv.Atoi(args)
+	}
+	return kind, size
+}
+
+// GoType - во что значение колонки превращается в go
+func (c *Column) GoType() string {
+	t := map[string]string{
+		"int":      "int64",
+		"float":    "float64",
+		"decimal":  "json.Number",
+		"bool":     "bool",
+		"datetime": "time.Time",
+		"date":     "time.Time",
+		"time":     "string",
+		"bytes":    "[]byte",
+		"json":     "json.RawMessage",
+	}[c.Kind]
+	if t == "" {
+		t = "string"
+	}
+	if c.Nullable {
+		return "*" + t
+	}
+	return t
+}
+
+// Zero - значение для NOT NULL колонки без default, которую не передали при вставке
+func (c *Column) Zero() interface{} {
+	switch c.Kind {
+	case "int", "float", "decimal":
+		return 0
+	case "bool":
+		return false
+	case "bytes":
+		return []byte{}
+	case "json":
+		return "null"
+	case "datetime", "date":
+		return time.Time{}
+	}
+	return ""
+}
+
+// ----------------
+
+// errInvalidType - значение не подходит колонке. Текст ошибки у всех один, как раньше
+func (c *Column) errInvalidType() error {
+	return errors.New("field " + c.Name + " have invalid type")
+}
+
+// dateLayouts - в каком виде принимаем даты, первым - в каком отдаём
+var dateLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02"}
+
+// Convert проверяет значение из json (распакованного с UseNumber) и приводит его к тому,
+// что можно отдать драйверу
+func (c *Column) Convert(v interface{}) (interface{}, error) {
+	if v == nil {
+		if !c.Nullable {
+			return nil, c.errInvalidType()
+		}
+		return nil, nil
+	}
+	switch c.Kind {
+	case "int":
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, c.errInvalidType()
+		}
+		i, err := n.Int64()
+		if err != nil {
+			return nil, c.errInvalidType()
+		}
+		return i, nil
+	case "float":
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, c.errInvalidType()
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, c.errInvalidType()
+		}
+		return f, nil
+	case "decimal":
+		// число строкой не теряет точность, "12.50" тоже можно
+		s := ""
+		switch v := v.(type) {
+		case json.Number:
+			s = v.String()
+		case string:
+			s = v
+		default:
+			return nil, c.errInvalidType()
+		}
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return nil, c.errInvalidType()
+		}
+		return s, nil
+	case "bool":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, c.errInvalidType()
+		}
+		return b, nil
+	case "bytes":
+		s, ok := v.(string)
+		if !ok {
+			return nil, c.errInvalidType()
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			return nil, c.errInvalidType()
+		}
+		return b, nil
+	case "json":
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, c.errInvalidType()
+		}
+		return string(b), nil
+	}
+
+	s, ok := v.(string)
+	if !ok {
+		return nil, c.errInvalidType()
+	}
+	switch c.Kind {
+	case "datetime", "date":
+		return c.parseTime(s)
+	case "time":
+		if _, err := time.Parse("15:04:05", s); err != nil {
+			return nil, c.errInvalidType()
+		}
+	}
+	if c.Size > 0 && utf8.RuneCountInString(s) > c.Size {
+		return nil, errors.New("field " + c.Name + " is longer than " + strconv.Itoa(c.Size))
+	}
+	return s, nil
+}
+
+func (c *Column) parseTime(s string) (interface{}, error) {
+	for _, layout := range dateLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		if c.Kind == "date" {
+			// дата без времени и зоны, строкой её понимают все драйверы
+			return t.Format("2006-01-02"), nil
+		}
+		return t, nil
+	}
+	return nil, c.errInvalidType()
+}
+
+// ConvertString - значение из формы или адреса, там всё строки
+func (c *Column) ConvertString(s string) (interface{}, error) {
+	switch c.Kind {
+	case "int", "float", "decimal":
+		return c.Convert(json.Number(s))
+	case "bool":
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, c.errInvalidType()
+		}
+		return b, nil
+	case "json":
+		var v interface{}
+		if err := json.Unmarshal([]byte(s), &v); err != nil {
+			return nil, c.errInvalidType()
+		}
+		return s, nil
+	}
+	return c.Convert(s)
+}
+
+// Decode - значение из базы в то, что уйдёт в json. Драйверы отдают одно и то же по-разному:
+// mysql почти всё байтами, sqlite и postgres - числа и время уже готовыми
+func (c *Column) Decode(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if b, ok := v.([]byte); ok && c.Kind != "bytes" {
+		v = string(b)
+	}
+	switch c.Kind {
+	case "int":
+		switch v := v.(type) {
+		case string:
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return i
+			}
+			if u, err := strconv.ParseUint(v, 10, 64); err == nil {
+				return u
+			}
+		case bool:
+			if v {
+				return 1
+			}
+			return 0
+		}
+	case "float":
+		if s, ok := v.(string); ok {
+			if f, err := strconv.ParseFloat(s, 64); err == nil {
+				return f
+			}
+		}
+	case "decimal":
+		switch v := v.(type) {
+		case string:
+			return json.Number(v)
+		case float64:
+			return json.Number(strconv.FormatFloat(v, 'f', -1, 64))
+		case int64:
+			return json.Number(strconv.FormatInt(v, 10))
+		}
+	case "bool":
+		switch v := v.(type) {
+		case int64:
+			return v != 0
+		case string:
+			if b, err := strconv.ParseBool(v); err == nil {
+				return b
+			}
+			// bit(1) в mysql приходит байтом
+			return v != "" && v != "\x00"
+		}
+	case "datetime", "date":
+		if t, ok := v.(time.Time); ok {
+			if c.Kind == "date" {
+				return t.Format("2006-01-02")
+			}
+			return t.Format(dateLayouts[0])
+		}
+	case "bytes":
+		if s, ok := v.(string); ok {
+			return []byte(s)
+		}
+	case "json":
+		if s, ok := v.(string); ok && json.Valid([]byte(s)) {
+			return json.RawMessage(s)
+		}
+	case "string", "time":
+		switch v := v.(type) {
+		case string:
+			return v
+		case time.Time:
+			return v.Format("15:04:05")
+		}
+		return fmt.Sprint(v)
+	}
+	return v
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestKindOf(t *testing.T) {
+	cases := []struct {
+		sqlType string
+		kind    string
+		size    int
+	}{
+		{"int(11)", "int", 0},
+		{"bigint(20) unsigned", "int", 0},
+		{"tinyint(1)", "bool", 0},
+		{"bit(1)", "bool", 0},
+		{"boolean", "bool", 0},
+		{"varchar(100)", "string", 100},
+		{"char(2)", "string", 2},
+		{"text", "string", 0},
+		{"decimal(10,2)", "decimal", 0},
+		{"double", "float", 0},
+		{"float", "float", 0},
+		{"date", "date", 0},
+		{"datetime", "datetime", 0},
+		{"timestamptz", "datetime", 0},
+		{"time", "time", 0},
+		{"longblob", "bytes", 0},
+		{"bytea", "bytes", 0},
+		{"jsonb", "json", 0},
+		// sqlite понимает что угодно
+		{"unsigned big int", "int", 0},
+		{"nvarchar(30)", "string", 30},
+	}
+	for _, c := range cases {
+		kind, size := kindOf(c.sqlType)
+		if kind != c.kind || size != c.size {
+			t.Errorf("[%s] expected %s %d, got %s %d", c.sqlType, c.kind, c.size, kind, size)
+		}
+	}
+}
+
+// testTypedSchema - таблица со всеми видами колонок, только для sqlite
+var testTypedSchema = []string{
+	`DROP TABLE IF EXISTS products;`,
+
+	`CREATE TABLE products (
+  id INTEGER PRIMARY KEY AUTOINCREMENT,
+  name varchar(10) NOT NULL UNIQUE,
+  price decimal(10,2) NOT NULL,
+  weight float DEFAULT NULL,
+  released date DEFAULT NULL,
+  created datetime DEFAULT NULL,
+  active boolean NOT NULL DEFAULT 1,
+  image blob DEFAULT NULL,
+  meta json DEFAULT NULL,
+  user_id INTEGER DEFAULT NULL REFERENCES users
+);`,
+}
+
+func prepareTyped(t *testing.T) (*Handler, *httptest.Server, func()) {
+	db := testDB(t)
+	d, _ := DialectFor(db)
+	if d.Name() != "sqlite" {
+		t.Skip("typed columns are checked on sqlite only")
+	}
+	PrepareTestApis(db)
+	for _, q := range testTypedSchema {
+		if _, err := db.Exec(q); err != nil {
+			t.Fatal(err)
+		}
+	}
+	handler, err := NewDbExplorer(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	return handler, ts, func() {
+		ts.Close()
+		db.Exec(`DROP TABLE IF EXISTS products;`)
+		CleanupTestApis(db)
+	}
+}
+
+func TestSchemaModel(t *testing.T) {
+	handler, _, cleanup := prepareTyped(t)
+	defer cleanup()
+
+	products := handler.Schema().Tables["products"]
+	if products == nil || products.PrimaryKey != "id" {
+		t.Fatalf("bad products table: %+v", products)
+	}
+	name := products.Column("name")
+	if name.Kind != "string" || name.Size != 10 || !name.Unique || name.Nullable {
+		t.Errorf("bad name column: %+v", name)
+	}
+	active := products.Column("active")
+	if active.Kind != "bool" || active.Default == nil || *active.Default != "1" || active.GoType() != "bool" {
+		t.Errorf("bad active column: %+v", active)
+	}
+	if released := products.Column("released"); released.GoType() != "*time.Time" {
+		t.Errorf("bad released type: %s", released.GoType())
+	}
+	// REFERENCES users без колонки - ссылка на первичный ключ
+	fk := products.Column("user_id").ForeignKey
+	if fk == nil || *fk != (ForeignKey{Table: "users", Column: "user_id"}) {
+		t.Errorf("bad foreign key: %+v", fk)
+	}
+}
+
+func TestTypedColumns(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+
+	cases := []Case{
+		Case{
+			Path:   "/products/",
+			Method: http.MethodPut,
+			Body: CR{
+				"name":     "lamp",
+				"price":    "12.50",
+				"weight":   1.25,
+				"released": "2024-05-17",
+				"created":  "2024-05-17T12:30:00Z",
+				"active":   false,
+				"image":    "AQID",
+				"meta":     CR{"color": "red"},
+				"user_id":  1,
+			},
+			Result: CR{
+				"response": CR{
+					"id": 1,
+				},
+			},
+		},
+		Case{
+			Path: "/products/1",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":       1,
+						"name":     "lamp",
+						"price":    12.5,
+						"weight":   1.25,
+						"released": "2024-05-17",
+						"created":  "2024-05-17T12:30:00Z",
+						"active":   false,
+						"image":    "AQID",
+						"meta":     CR{"color": "red"},
+						"user_id":  1,
+					},
+				},
+			},
+		},
+		// не переданные колонки получают default или NULL
+		Case{
+			Path:   "/products/",
+			Method: http.MethodPut,
+			Body: CR{
+				"name":  "table",
+				"price": 100,
+			},
+			Result: CR{
+				"response": CR{
+					"id": 2,
+				},
+			},
+		},
+		Case{
+			Path: "/products/2",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"id":       2,
+						"name":     "table",
+						"price":    100,
+						"weight":   nil,
+						"released": nil,
+						"created":  nil,
+						"active":   true,
+						"image":    nil,
+						"meta":     nil,
+						"user_id":  nil,
+					},
+				},
+			},
+		},
+		Case{
+			Path:   "/products/2",
+			Method: http.MethodPost,
+			Body: CR{
+				"active":   false,
+				"released": "2024-06-01 10:00:00",
+			},
+			Result: CR{
+				"response": CR{
+					"updated": 1,
+				},
+			},
+		},
+		Case{
+			Path:   "/products/2",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"name": "very long name",
+			},
+			Result: CR{
+				"error": "field name is longer than 10",
+			},
+		},
+		Case{
+			Path:   "/products/2",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"price": "cheap",
+			},
+			Result: CR{
+				"error": "field price have invalid type",
+			},
+		},
+		Case{
+			Path:   "/products/2",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"active": "yes",
+			},
+			Result: CR{
+				"error": "field active have invalid type",
+			},
+		},
+		Case{
+			Path:   "/products/2",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"released": "17.05.2024",
+			},
+			Result: CR{
+				"error": "field released have invalid type",
+			},
+		},
+		Case{
+			Path:   "/products/2",
+			Method: http.MethodPost,
+			Status: http.StatusBadRequest,
+			Body: CR{
+				"weight":  1.5,
+				"user_id": 1.5,
+			},
+			Result: CR{
+				"error": "field user_id have invalid type",
+			},
+		},
+		Case{
+			Path:  "/products",
+			Query: "limit=1&offset=1",
+			Result: CR{
+				"response": CR{
+					"records": []CR{
+						CR{
+							"id":       2,
+							"name":     "table",
+							"price":    100,
+							"weight":   nil,
+							"released": "2024-06-01",
+							"created":  nil,
+							"active":   false,
+							"image":    nil,
+							"meta":     nil,
+							"user_id":  nil,
+						},
+					},
+				},
+			},
+		},
+		// id не того типа - такой записи нет
+		Case{
+			Path:   "/products/abc",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "record not found",
+			},
+		},
+	}
+
+	runCases(t, ts, handler.DB, cases)
+}
+
+func TestSchemaRefresh(t *testing.T) {
+	handler, ts, cleanup := prepareTyped(t)
+	defer cleanup()
+	db := handler.DB
+
+	if _, err := db.Exec(`CREATE TABLE tags (tag varchar(20) PRIMARY KEY);`); err != nil {
+		t.Fatal(err)
+	}
+	defer db.Exec(`DROP TABLE IF EXISTS tags;`)
+
+	cases := []Case{
+		// схема загружена до создания таблицы
+		Case{
+			Path:   "/tags",
+			Status: http.StatusNotFound,
+			Result: CR{
+				"error": "unknown table",
+			},
+		},
+		Case{
+			Path:   "/_schema/refresh",
+			Method: http.MethodPost,
+			Result: CR{
+				"response": CR{
+					"tables": []string{"items", "products", "tags", "users"},
+				},
+			},
+		},
+		// ключ не из автоинкремента возвращается как есть
+		Case{
+			Path:   "/tags/",
+			Method: http.MethodPut,
+			Body: CR{
+				"tag": "golang",
+			},
+			Result: CR{
+				"response": CR{
+					"tag": "golang",
+				},
+			},
+		},
+		Case{
+			Path: "/tags/golang",
+			Result: CR{
+				"response": CR{
+					"record": CR{
+						"tag": "golang",
+					},
+				},
+			},
+		},
+	}
+	runCases(t, ts, db, cases)
+
+	// GET /_schema отдаёт ту же модель, что и Schema()
+	var schema struct {
+		Response struct {
+			Tables map[string]*Table `json:"tables"`
+		} `json:"response"`
+	}
+	doRequest(t, http.MethodGet, ts.URL+"/_schema", nil, nil, &schema)
+	tag := schema.Response.Tables["tags"]
+	if tag == nil || tag.PrimaryKey != "tag" || tag.Columns[0].Kind != "string" || tag.Columns[0].Size != 20 {
+		t.Errorf("bad schema of tags: %+v", tag)
+	}
+}